@@ -0,0 +1,144 @@
+// auth package implements SIP digest authentication (RFC 3261 - 22., RFC 2617, RFC 8760)
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/util"
+)
+
+// Digest algorithms (RFC 2617 - 3.2.1., RFC 8760 - 2.)
+const (
+	MD5           = "MD5"
+	MD5Sess       = "MD5-sess"
+	SHA256        = "SHA-256"
+	SHA256Sess    = "SHA-256-sess"
+	SHA512256     = "SHA-512-256"
+	SHA512256Sess = "SHA-512-256-sess"
+)
+
+// Quality of protection values
+const (
+	QopAuth    = "auth"
+	QopAuthInt = "auth-int"
+)
+
+// User holds the credentials of the user.
+// HA1 = H(username:realm:password) can be given instead of the password,
+// it should be computed with the hash function of the challenge algorithm.
+type User struct {
+	Username string
+	Password string
+	HA1      string
+}
+
+// CredentialsProvider provides the user credentials to answer the challenge of the realm.
+type CredentialsProvider interface {
+	Credentials(realm string) (*User, bool)
+}
+
+// CredentialsFunc is an adapter to use the function as CredentialsProvider.
+type CredentialsFunc func(realm string) (*User, bool)
+
+func (f CredentialsFunc) Credentials(realm string) (*User, bool) {
+	return f(realm)
+}
+
+// StaticCredentials returns CredentialsProvider that answers challenges of any realm with the same user.
+func StaticCredentials(username, password string) CredentialsProvider {
+	return CredentialsFunc(func(realm string) (*User, bool) {
+		return &User{Username: username, Password: password}, true
+	})
+}
+
+// IsSupportedAlgorithm returns true if the digest algorithm is supported, empty algorithm means MD5.
+func IsSupportedAlgorithm(algorithm string) bool {
+	_, ok := hashFunc(algorithm)
+	return ok
+}
+
+func hashFunc(algorithm string) (func() hash.Hash, bool) {
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToLower(algorithm), "-sess")) {
+	case "", MD5:
+		return md5.New, true
+	case SHA256:
+		return sha256.New, true
+	case SHA512256:
+		return sha512.New512_256, true
+	default:
+		return nil, false
+	}
+}
+
+func isSessAlgorithm(algorithm string) bool {
+	return strings.HasSuffix(strings.ToLower(algorithm), "-sess")
+}
+
+// HA1 computes H(username:realm:password) with the hash function of the algorithm.
+func HA1(algorithm, username, realm, password string) (string, error) {
+	newHash, ok := hashFunc(algorithm)
+	if !ok {
+		return "", fmt.Errorf("unsupported digest algorithm %s", algorithm)
+	}
+
+	return hexHash(newHash, username+":"+realm+":"+password), nil
+}
+
+// DigestResponse computes the digest 'response' value of the credentials (RFC 2617 - 3.2.2.1., RFC 8760 - 2.),
+// all the other values of the credentials should be filled.
+func DigestResponse(credentials *sip.Credentials, user *User, method sip.RequestMethod, body string) (string, error) {
+	newHash, ok := hashFunc(credentials.Algorithm)
+	if !ok {
+		return "", fmt.Errorf("unsupported digest algorithm %s", credentials.Algorithm)
+	}
+
+	ha1 := user.HA1
+	if ha1 == "" {
+		ha1 = hexHash(newHash, user.Username+":"+credentials.Realm+":"+user.Password)
+	}
+	if isSessAlgorithm(credentials.Algorithm) {
+		ha1 = hexHash(newHash, ha1+":"+credentials.Nonce+":"+credentials.Cnonce)
+	}
+
+	var ha2 string
+	switch strings.ToLower(credentials.Qop) {
+	case "", QopAuth:
+		ha2 = hexHash(newHash, string(method)+":"+credentials.Uri)
+	case QopAuthInt:
+		ha2 = hexHash(newHash, string(method)+":"+credentials.Uri+":"+hexHash(newHash, body))
+	default:
+		return "", fmt.Errorf("unsupported qop %s", credentials.Qop)
+	}
+
+	if credentials.Qop == "" {
+		return hexHash(newHash, ha1+":"+credentials.Nonce+":"+ha2), nil
+	}
+
+	return hexHash(newHash, strings.Join([]string{
+		ha1,
+		credentials.Nonce,
+		fmt.Sprintf("%08x", credentials.NonceCount),
+		credentials.Cnonce,
+		credentials.Qop,
+		ha2,
+	}, ":")), nil
+}
+
+func hexHash(newHash func() hash.Hash, data string) string {
+	h := newHash()
+	h.Write([]byte(data))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newNonce generates random string for nonce and cnonce values.
+func newNonce() string {
+	return util.RandString(32)
+}
@@ -0,0 +1,69 @@
+package auth_test
+
+import (
+	"github.com/masterclock/gosip/auth"
+	"github.com/masterclock/gosip/sip"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Digest", func() {
+	user := &auth.User{Username: "Mufasa", Password: "Circle Of Life"}
+
+	It("should compute MD5 response (RFC 2617 - 3.5.)", func() {
+		response, err := auth.DigestResponse(&sip.Credentials{
+			Username:   "Mufasa",
+			Realm:      "testrealm@host.com",
+			Nonce:      "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+			Uri:        "/dir/index.html",
+			Qop:        "auth",
+			NonceCount: 1,
+			Cnonce:     "0a4f113b",
+		}, user, "GET", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response).To(Equal("6629fae49393a05397450978507c4ef1"))
+	})
+
+	Context("with RFC 7616 - 3.9.1. example", func() {
+		credentials := &sip.Credentials{
+			Username:   "Mufasa",
+			Realm:      "http-auth@example.org",
+			Nonce:      "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v",
+			Uri:        "/dir/index.html",
+			Qop:        "auth",
+			NonceCount: 1,
+			Cnonce:     "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+		}
+		user := &auth.User{Username: "Mufasa", Password: "Circle of Life"}
+
+		It("should compute MD5 response", func() {
+			credentials.Algorithm = auth.MD5
+			response, err := auth.DigestResponse(credentials, user, "GET", "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response).To(Equal("8ca523f5e9506fed4657c9700eebdbec"))
+		})
+
+		It("should compute SHA-256 response", func() {
+			credentials.Algorithm = auth.SHA256
+			response, err := auth.DigestResponse(credentials, user, "GET", "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response).To(Equal("753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"))
+		})
+
+		It("should compute the same response from HA1", func() {
+			credentials.Algorithm = auth.SHA512256Sess
+			expected, err := auth.DigestResponse(credentials, user, "GET", "")
+			Expect(err).ToNot(HaveOccurred())
+			ha1, err := auth.HA1(auth.SHA512256, user.Username, credentials.Realm, user.Password)
+			Expect(err).ToNot(HaveOccurred())
+			response, err := auth.DigestResponse(credentials, &auth.User{Username: user.Username, HA1: ha1}, "GET", "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response).To(Equal(expected))
+		})
+	})
+
+	It("should fail on unsupported algorithm", func() {
+		_, err := auth.DigestResponse(&sip.Credentials{Algorithm: "SHA-1"}, user, "GET", "")
+		Expect(err).To(HaveOccurred())
+	})
+})
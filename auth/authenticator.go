@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/sip/parser"
+	"github.com/masterclock/gosip/timing"
+)
+
+// DefaultNonceExpiry is the default lifetime of the nonce issued by Authenticator.
+const DefaultNonceExpiry = 5 * time.Minute
+
+// UserStore provides users of the realm to verify their credentials.
+type UserStore interface {
+	User(realm, username string) (*User, bool)
+}
+
+// UserStoreFunc is an adapter to use the function as UserStore.
+type UserStoreFunc func(realm, username string) (*User, bool)
+
+func (f UserStoreFunc) User(realm, username string) (*User, bool) {
+	return f(realm, username)
+}
+
+// AuthenticatorConfig describes available options of Authenticator.
+type AuthenticatorConfig struct {
+	// Realm is the protection domain, required.
+	Realm string
+	// Users is the user store, required.
+	Users UserStore
+	// Secret is the key of HMAC signed nonces, random key is generated if empty.
+	Secret []byte
+	// NonceExpiry is the lifetime of the nonce, DefaultNonceExpiry if zero.
+	NonceExpiry time.Duration
+	// Algorithm is the digest algorithm, MD5 if empty.
+	Algorithm string
+	// Qop is the list of the offered qop values, auth if empty.
+	Qop []string
+	// Proxy enables proxy authentication: 407 with 'Proxy-Authenticate' header,
+	// otherwise 401 with 'WWW-Authenticate' header is used.
+	Proxy bool
+}
+
+// Authenticator verifies digest credentials of the incoming requests (RFC 3261 - 22.).
+// Nonces are signed with HMAC and carry their creation time, only the last nonce count
+// of the used nonces is kept till they expire.
+type Authenticator struct {
+	realm       string
+	users       UserStore
+	secret      []byte
+	nonceExpiry time.Duration
+	algorithm   string
+	qop         []string
+	proxy       bool
+	mu          *sync.Mutex
+	// last nonce count by nonce
+	nonceCounts map[string]*usedNonce
+}
+
+type usedNonce struct {
+	created time.Time
+	count   uint32
+}
+
+// NewAuthenticator creates Authenticator.
+func NewAuthenticator(config *AuthenticatorConfig) (*Authenticator, error) {
+	if config == nil || config.Realm == "" || config.Users == nil {
+		return nil, fmt.Errorf("realm and user store are required")
+	}
+	if !IsSupportedAlgorithm(config.Algorithm) {
+		return nil, fmt.Errorf("unsupported digest algorithm %s", config.Algorithm)
+	}
+
+	a := &Authenticator{
+		realm:       config.Realm,
+		users:       config.Users,
+		secret:      config.Secret,
+		nonceExpiry: config.NonceExpiry,
+		algorithm:   config.Algorithm,
+		qop:         config.Qop,
+		proxy:       config.Proxy,
+		mu:          new(sync.Mutex),
+		nonceCounts: make(map[string]*usedNonce),
+	}
+	if len(a.secret) == 0 {
+		a.secret = make([]byte, 32)
+		if _, err := rand.Read(a.secret); err != nil {
+			return nil, err
+		}
+	}
+	if a.nonceExpiry <= 0 {
+		a.nonceExpiry = DefaultNonceExpiry
+	}
+	if a.algorithm == "" {
+		a.algorithm = MD5
+	}
+	if len(a.qop) == 0 {
+		a.qop = []string{QopAuth}
+	}
+
+	return a, nil
+}
+
+// Realm returns the protection domain of the authenticator.
+func (a *Authenticator) Realm() string {
+	return a.realm
+}
+
+// Authenticate verifies credentials of the request.
+// Returns true if the request is authenticated, otherwise returns the response
+// with the challenge that should be sent to UAC.
+func (a *Authenticator) Authenticate(req sip.Request) (sip.Response, bool) {
+	credentials := a.credentials(req)
+	if credentials == nil {
+		return a.Challenge(req, false), false
+	}
+	if !strings.EqualFold(credentials.Scheme, "Digest") ||
+		!strings.EqualFold(algorithmOrMD5(credentials.Algorithm), a.algorithm) ||
+		!a.offers(credentials.Qop) ||
+		!isRequestUri(credentials.Uri, req) {
+		return a.Challenge(req, false), false
+	}
+	created, valid := a.verifyNonce(credentials.Nonce)
+	if !valid {
+		return a.Challenge(req, false), false
+	}
+	user, ok := a.users.User(a.realm, credentials.Username)
+	if !ok || user == nil {
+		return a.Challenge(req, false), false
+	}
+	response, err := DigestResponse(credentials, user, req.Method(), req.Body())
+	if err != nil || subtle.ConstantTimeCompare([]byte(response), []byte(strings.ToLower(credentials.Response))) != 1 {
+		return a.Challenge(req, false), false
+	}
+	// RFC 2617 - 3.2.1. - valid digest with the expired nonce, UAC can retry without asking the user
+	if timing.Now().Sub(created) > a.nonceExpiry {
+		return a.Challenge(req, true), false
+	}
+	// RFC 2617 - 3.2.2. - repeated nonce count is a replay, UAC gets the new nonce
+	if credentials.Qop != "" && !a.useNonceCount(credentials.Nonce, created, credentials.NonceCount) {
+		return a.Challenge(req, true), false
+	}
+
+	return nil, true
+}
+
+// Challenge builds 401 Unauthorized or 407 Proxy Authentication Required response with the new nonce.
+func (a *Authenticator) Challenge(req sip.Request, stale bool) sip.Response {
+	challenge := sip.Challenge{
+		Scheme:    "Digest",
+		Realm:     a.realm,
+		Nonce:     a.newNonce(),
+		Stale:     stale,
+		Algorithm: a.algorithm,
+		Qop:       a.qop,
+	}
+
+	var res sip.Response
+	if a.proxy {
+		res = sip.NewResponseFromRequest(req, 407, "Proxy Authentication Required", "")
+		res.AppendHeader(&sip.ProxyAuthenticateHeader{Challenge: challenge})
+	} else {
+		res = sip.NewResponseFromRequest(req, 401, "Unauthorized", "")
+		res.AppendHeader(&sip.WWWAuthenticateHeader{Challenge: challenge})
+	}
+
+	return res
+}
+
+// credentials returns credentials of the realm sent in the request.
+func (a *Authenticator) credentials(req sip.Request) *sip.Credentials {
+	headerName := "Authorization"
+	if a.proxy {
+		headerName = "Proxy-Authorization"
+	}
+
+	return credentialsOf(req, headerName, a.realm)
+}
+
+func (a *Authenticator) offers(qop string) bool {
+	for _, q := range a.qop {
+		if strings.EqualFold(q, qop) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newNonce returns nonce with the creation time signed with HMAC: hex(time) + hex(HMAC(time:realm)).
+func (a *Authenticator) newNonce() string {
+	ts := make([]byte, 8)
+	binary.BigEndian.PutUint64(ts, uint64(timing.Now().UnixNano()))
+
+	return hex.EncodeToString(ts) + hex.EncodeToString(a.sign(ts))
+}
+
+// verifyNonce checks signature of the nonce and returns its creation time.
+func (a *Authenticator) verifyNonce(nonce string) (created time.Time, valid bool) {
+	data, err := hex.DecodeString(nonce)
+	if err != nil || len(data) != 8+sha256.Size {
+		return time.Time{}, false
+	}
+	ts, mac := data[:8], data[8:]
+	if !hmac.Equal(mac, a.sign(ts)) {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, int64(binary.BigEndian.Uint64(ts))), true
+}
+
+// useNonceCount remembers nonce count of the nonce, returns false if it is not greater than the last one.
+// Expired nonces are forgotten when the new nonce is used.
+func (a *Authenticator) useNonceCount(nonce string, created time.Time, count uint32) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	used, ok := a.nonceCounts[nonce]
+	if !ok {
+		now := timing.Now()
+		for key, used := range a.nonceCounts {
+			if now.Sub(used.created) > a.nonceExpiry {
+				delete(a.nonceCounts, key)
+			}
+		}
+		used = &usedNonce{created: created}
+		a.nonceCounts[nonce] = used
+	}
+	if count <= used.count {
+		return false
+	}
+	used.count = count
+
+	return true
+}
+
+func (a *Authenticator) sign(ts []byte) []byte {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write(ts)
+	mac.Write([]byte(":" + a.realm))
+
+	return mac.Sum(nil)
+}
+
+// isRequestUri returns true if the digest uri identifies the Request-URI,
+// so the credentials can not be replayed with another request (RFC 2617 - 3.2.2.5.).
+func isRequestUri(digestUri string, req sip.Request) bool {
+	if digestUri == req.Recipient().String() {
+		return true
+	}
+	uri, err := parser.ParseUri(digestUri)
+	if err != nil {
+		return false
+	}
+
+	return uri.Equals(req.Recipient())
+}
+
+func algorithmOrMD5(algorithm string) string {
+	if algorithm == "" {
+		return MD5
+	}
+
+	return algorithm
+}
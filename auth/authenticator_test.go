@@ -0,0 +1,178 @@
+package auth_test
+
+import (
+	"time"
+
+	"github.com/masterclock/gosip/auth"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/testutils"
+	"github.com/masterclock/gosip/timing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Authenticator", func() {
+	var (
+		authenticator *auth.Authenticator
+		config        *auth.AuthenticatorConfig
+		register      sip.Request
+	)
+
+	users := auth.UserStoreFunc(func(realm, username string) (*auth.User, bool) {
+		if username != "bob" {
+			return nil, false
+		}
+		return &auth.User{Username: "bob", Password: "zanzibar"}, true
+	})
+
+	BeforeEach(func() {
+		config = &auth.AuthenticatorConfig{Realm: "biloxi.com", Users: users}
+		register = testutils.Request([]string{
+			"REGISTER sip:registrar.biloxi.com SIP/2.0",
+			"Via: SIP/2.0/UDP bobspc.biloxi.com:5060;branch=z9hG4bKnashds7",
+			"Max-Forwards: 70",
+			"From: Bob <sip:bob@biloxi.com>;tag=456248",
+			"To: Bob <sip:bob@biloxi.com>",
+			"Call-ID: 843817637684230@998sdasdh09",
+			"CSeq: 1826 REGISTER",
+			"Contact: <sip:bob@192.0.2.4>",
+			"Content-Length: 0",
+			"",
+			"",
+		})
+	})
+	JustBeforeEach(func() {
+		var err error
+		authenticator, err = auth.NewAuthenticator(config)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should challenge request without credentials", func() {
+		res, ok := authenticator.Authenticate(register)
+		Expect(ok).To(BeFalse())
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(401)))
+		hdrs := res.GetHeaders("WWW-Authenticate")
+		Expect(hdrs).To(HaveLen(1))
+		challenge := hdrs[0].(*sip.WWWAuthenticateHeader).Challenge
+		Expect(challenge.Realm).To(Equal("biloxi.com"))
+		Expect(challenge.Algorithm).To(Equal(auth.MD5))
+		Expect(challenge.Qop).To(Equal([]string{"auth"}))
+		Expect(challenge.Nonce).ToNot(BeEmpty())
+		Expect(challenge.Stale).To(BeFalse())
+	})
+
+	It("should authenticate request answering the challenge", func() {
+		res, _ := authenticator.Authenticate(register)
+		req, err := auth.NewClient(auth.StaticCredentials("bob", "zanzibar")).Authorize(register, res)
+		Expect(err).ToNot(HaveOccurred())
+
+		res, ok := authenticator.Authenticate(req)
+		Expect(ok).To(BeTrue())
+		Expect(res).To(BeNil())
+	})
+
+	It("should challenge again on wrong password or unknown user", func() {
+		res, _ := authenticator.Authenticate(register)
+		req, err := auth.NewClient(auth.StaticCredentials("bob", "wrong")).Authorize(register, res)
+		Expect(err).ToNot(HaveOccurred())
+		res, ok := authenticator.Authenticate(req)
+		Expect(ok).To(BeFalse())
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(401)))
+
+		req, err = auth.NewClient(auth.StaticCredentials("alice", "zanzibar")).Authorize(register, res)
+		Expect(err).ToNot(HaveOccurred())
+		_, ok = authenticator.Authenticate(req)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should reject nonce signed by another authenticator", func() {
+		other, err := auth.NewAuthenticator(config)
+		Expect(err).ToNot(HaveOccurred())
+		res, _ := other.Authenticate(register)
+		req, err := auth.NewClient(auth.StaticCredentials("bob", "zanzibar")).Authorize(register, res)
+		Expect(err).ToNot(HaveOccurred())
+
+		res, ok := authenticator.Authenticate(req)
+		Expect(ok).To(BeFalse())
+		challenge := res.GetHeaders("WWW-Authenticate")[0].(*sip.WWWAuthenticateHeader).Challenge
+		Expect(challenge.Stale).To(BeFalse())
+	})
+
+	It("should reject credentials for another Request-URI", func() {
+		res, _ := authenticator.Authenticate(register)
+		req, err := auth.NewClient(auth.StaticCredentials("bob", "zanzibar")).Authorize(register, res)
+		Expect(err).ToNot(HaveOccurred())
+		req.SetRecipient(&sip.SipUri{Host: "attacker.biloxi.com"})
+
+		res, ok := authenticator.Authenticate(req)
+		Expect(ok).To(BeFalse())
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(401)))
+	})
+
+	It("should reject repeated nonce count with stale=true", func() {
+		res, _ := authenticator.Authenticate(register)
+		client := auth.NewClient(auth.StaticCredentials("bob", "zanzibar"))
+		req, err := client.Authorize(register, res)
+		Expect(err).ToNot(HaveOccurred())
+		_, ok := authenticator.Authenticate(req)
+		Expect(ok).To(BeTrue())
+
+		replayed, ok := authenticator.Authenticate(req)
+		Expect(ok).To(BeFalse())
+		Expect(replayed.StatusCode()).To(Equal(sip.StatusCode(401)))
+		challenge := replayed.GetHeaders("WWW-Authenticate")[0].(*sip.WWWAuthenticateHeader).Challenge
+		Expect(challenge.Stale).To(BeTrue())
+
+		req, err = client.Authorize(register, res)
+		Expect(err).ToNot(HaveOccurred())
+		_, ok = authenticator.Authenticate(req)
+		Expect(ok).To(BeTrue())
+	})
+
+	Context("when nonce expires", func() {
+		BeforeEach(func() {
+			timing.MockMode = true
+			config.NonceExpiry = time.Minute
+		})
+		AfterEach(func() {
+			timing.MockMode = false
+		})
+
+		It("should challenge with stale=true", func() {
+			res, _ := authenticator.Authenticate(register)
+			req, err := auth.NewClient(auth.StaticCredentials("bob", "zanzibar")).Authorize(register, res)
+			Expect(err).ToNot(HaveOccurred())
+
+			timing.Elapse(time.Minute + time.Second)
+			res, ok := authenticator.Authenticate(req)
+			Expect(ok).To(BeFalse())
+			challenge := res.GetHeaders("WWW-Authenticate")[0].(*sip.WWWAuthenticateHeader).Challenge
+			Expect(challenge.Stale).To(BeTrue())
+		})
+	})
+
+	Context("with HA1 user store and proxy authentication", func() {
+		BeforeEach(func() {
+			ha1, err := auth.HA1(auth.SHA256, "bob", "biloxi.com", "zanzibar")
+			Expect(err).ToNot(HaveOccurred())
+			config.Users = auth.UserStoreFunc(func(realm, username string) (*auth.User, bool) {
+				return &auth.User{Username: username, HA1: ha1}, true
+			})
+			config.Algorithm = auth.SHA256
+			config.Qop = []string{auth.QopAuthInt}
+			config.Proxy = true
+		})
+
+		It("should authenticate request answering the challenge", func() {
+			res, ok := authenticator.Authenticate(register)
+			Expect(ok).To(BeFalse())
+			Expect(res.StatusCode()).To(Equal(sip.StatusCode(407)))
+			Expect(res.GetHeaders("Proxy-Authenticate")).To(HaveLen(1))
+
+			req, err := auth.NewClient(auth.StaticCredentials("bob", "zanzibar")).Authorize(register, res)
+			Expect(err).ToNot(HaveOccurred())
+			_, ok = authenticator.Authenticate(req)
+			Expect(ok).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/masterclock/gosip/sip"
+)
+
+// Client answers digest challenges of 401 Unauthorized and 407 Proxy Authentication Required responses
+// (RFC 3261 - 22.2., 22.3.), it keeps track of the nonce counts between the requests.
+type Client struct {
+	credentials CredentialsProvider
+	mu          *sync.Mutex
+	// last nonce and nonce count by realm
+	nonces map[string]*nonceCount
+}
+
+type nonceCount struct {
+	nonce string
+	count uint32
+}
+
+// NewClient creates digest authentication client with the credentials provider.
+func NewClient(credentials CredentialsProvider) *Client {
+	return &Client{
+		credentials: credentials,
+		mu:          new(sync.Mutex),
+		nonces:      make(map[string]*nonceCount),
+	}
+}
+
+// Authorize returns copy of the request with credentials answering the challenges of the response.
+// CSeq of the request is incremented and the top Via gets new branch, so the request can be sent in new transaction.
+// Returns error if no challenge can be answered or the credentials were already rejected for the realm.
+func (client *Client) Authorize(req sip.Request, res sip.Response) (sip.Request, error) {
+	next := req.Clone().(sip.Request)
+	if cseq, ok := next.CSeq(); ok {
+		cseq.SeqNo++
+	}
+	if viaHop, ok := next.ViaHop(); ok {
+		if viaHop.Params == nil {
+			viaHop.Params = sip.NewParams()
+		}
+		viaHop.Params.Add("branch", sip.String{Str: sip.GenerateBranch()})
+	}
+
+	// RFC 8760 - 2.4. - challenges of the realm are listed in order of preference,
+	// only the first supported one is answered
+	answered := make(map[string]bool)
+	for _, header := range res.GetHeaders("WWW-Authenticate") {
+		if h, ok := header.(*sip.WWWAuthenticateHeader); ok && !answered["Authorization:"+h.Realm] {
+			credentials, err := client.answer(req, &h.Challenge, "Authorization")
+			if err != nil {
+				return nil, err
+			}
+			if credentials != nil {
+				setCredentials(next, &sip.AuthorizationHeader{Credentials: *credentials})
+				answered["Authorization:"+h.Realm] = true
+			}
+		}
+	}
+	for _, header := range res.GetHeaders("Proxy-Authenticate") {
+		if h, ok := header.(*sip.ProxyAuthenticateHeader); ok && !answered["Proxy-Authorization:"+h.Realm] {
+			credentials, err := client.answer(req, &h.Challenge, "Proxy-Authorization")
+			if err != nil {
+				return nil, err
+			}
+			if credentials != nil {
+				setCredentials(next, &sip.ProxyAuthorizationHeader{Credentials: *credentials})
+				answered["Proxy-Authorization:"+h.Realm] = true
+			}
+		}
+	}
+	if len(answered) == 0 {
+		return nil, fmt.Errorf("no supported challenge found in %s", res.Short())
+	}
+
+	return next, nil
+}
+
+// answer computes credentials for the challenge,
+// returns nil credentials if the challenge is not supported.
+func (client *Client) answer(req sip.Request, challenge *sip.Challenge, headerName string) (*sip.Credentials, error) {
+	if !strings.EqualFold(challenge.Scheme, "Digest") || !IsSupportedAlgorithm(challenge.Algorithm) {
+		return nil, nil
+	}
+	var qop string
+	switch {
+	case challenge.HasQop(QopAuth):
+		qop = QopAuth
+	case challenge.HasQop(QopAuthInt):
+		qop = QopAuthInt
+	case len(challenge.Qop) > 0:
+		return nil, nil
+	}
+
+	// RFC 3261 - 22.2. - the same credentials would be rejected again unless the nonce is stale
+	if sent := credentialsOf(req, headerName, challenge.Realm); sent != nil && !challenge.Stale {
+		return nil, fmt.Errorf("credentials of %s for realm '%s' are rejected", sent.Username, challenge.Realm)
+	}
+
+	user, ok := client.credentials.Credentials(challenge.Realm)
+	if !ok || user == nil {
+		return nil, fmt.Errorf("no credentials for realm '%s'", challenge.Realm)
+	}
+
+	credentials := &sip.Credentials{
+		Scheme:    "Digest",
+		Username:  user.Username,
+		Realm:     challenge.Realm,
+		Nonce:     challenge.Nonce,
+		Uri:       req.Recipient().String(),
+		Algorithm: challenge.Algorithm,
+		Opaque:    challenge.Opaque,
+		Qop:       qop,
+	}
+	if qop != "" {
+		credentials.Cnonce = newNonce()
+		credentials.NonceCount = client.nextNonceCount(challenge.Realm, challenge.Nonce)
+	}
+
+	response, err := DigestResponse(credentials, user, req.Method(), req.Body())
+	if err != nil {
+		return nil, err
+	}
+	credentials.Response = response
+
+	return credentials, nil
+}
+
+// nextNonceCount returns incremented count of the requests sent with the nonce (RFC 2617 - 3.2.2.).
+func (client *Client) nextNonceCount(realm, nonce string) uint32 {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	nc, ok := client.nonces[realm]
+	if !ok || nc.nonce != nonce {
+		nc = &nonceCount{nonce: nonce}
+		client.nonces[realm] = nc
+	}
+	nc.count++
+
+	return nc.count
+}
+
+// credentialsOf returns credentials of the realm sent in the request headers with the given name.
+func credentialsOf(req sip.Request, headerName string, realm string) *sip.Credentials {
+	for _, header := range req.GetHeaders(headerName) {
+		switch h := header.(type) {
+		case *sip.AuthorizationHeader:
+			if h.Realm == realm {
+				return &h.Credentials
+			}
+		case *sip.ProxyAuthorizationHeader:
+			if h.Realm == realm {
+				return &h.Credentials
+			}
+		}
+	}
+
+	return nil
+}
+
+// setCredentials replaces credentials of the same realm in the request.
+func setCredentials(req sip.Request, header sip.Header) {
+	realm := realmOf(header)
+	hdrs := req.GetHeaders(header.Name())
+	req.RemoveHeader(header.Name())
+	for _, h := range hdrs {
+		if realmOf(h) != realm {
+			req.AppendHeader(h)
+		}
+	}
+	req.AppendHeader(header)
+}
+
+func realmOf(header sip.Header) string {
+	switch h := header.(type) {
+	case *sip.AuthorizationHeader:
+		return h.Realm
+	case *sip.ProxyAuthorizationHeader:
+		return h.Realm
+	default:
+		return ""
+	}
+}
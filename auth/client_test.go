@@ -0,0 +1,176 @@
+package auth_test
+
+import (
+	"fmt"
+
+	"github.com/masterclock/gosip/auth"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/testutils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		client   *auth.Client
+		register sip.Request
+	)
+
+	challenge := func(statusCode sip.StatusCode, headers ...string) sip.Response {
+		lines := []string{
+			fmt.Sprintf("SIP/2.0 %d Reason", statusCode),
+			"Via: SIP/2.0/UDP bobspc.biloxi.com:5060;branch=z9hG4bKnashds7",
+			"From: Bob <sip:bob@biloxi.com>;tag=456248",
+			"To: Bob <sip:bob@biloxi.com>;tag=2493k59kd",
+			"Call-ID: 843817637684230@998sdasdh09",
+			"CSeq: 1826 REGISTER",
+		}
+		lines = append(lines, headers...)
+		return testutils.Response(append(lines, "Content-Length: 0", "", ""))
+	}
+
+	BeforeEach(func() {
+		client = auth.NewClient(auth.CredentialsFunc(func(realm string) (*auth.User, bool) {
+			if realm != "biloxi.com" {
+				return nil, false
+			}
+			return &auth.User{Username: "bob", Password: "zanzibar"}, true
+		}))
+		register = testutils.Request([]string{
+			"REGISTER sips:ss2.biloxi.example.com SIP/2.0",
+			"Via: SIP/2.0/UDP bobspc.biloxi.com:5060;branch=z9hG4bKnashds7",
+			"Max-Forwards: 70",
+			"From: Bob <sip:bob@biloxi.com>;tag=456248",
+			"To: Bob <sip:bob@biloxi.com>",
+			"Call-ID: 843817637684230@998sdasdh09",
+			"CSeq: 1826 REGISTER",
+			"Contact: <sips:bob@client.biloxi.example.com>",
+			"Content-Length: 0",
+			"",
+			"",
+		})
+	})
+
+	Context("when 401 Unauthorized arrives", func() {
+		var res sip.Response
+		BeforeEach(func() {
+			res = challenge(401, `WWW-Authenticate: Digest realm="biloxi.com", qop="auth,auth-int", `+
+				`nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", opaque="5ccc069c403ebaf9f0171e9517f40e41", algorithm=SHA-256`)
+		})
+
+		It("should build request with credentials in new transaction", func() {
+			req, err := client.Authorize(register, res)
+			Expect(err).ToNot(HaveOccurred())
+
+			cseq, _ := req.CSeq()
+			Expect(cseq.SeqNo).To(Equal(uint32(1827)))
+			viaHop, _ := req.ViaHop()
+			branch, _ := viaHop.Params.Get("branch")
+			Expect(branch.String()).ToNot(Equal("z9hG4bKnashds7"))
+			origCSeq, _ := register.CSeq()
+			Expect(origCSeq.SeqNo).To(Equal(uint32(1826)))
+
+			hdrs := req.GetHeaders("Authorization")
+			Expect(hdrs).To(HaveLen(1))
+			credentials := hdrs[0].(*sip.AuthorizationHeader).Credentials
+			Expect(credentials.Username).To(Equal("bob"))
+			Expect(credentials.Realm).To(Equal("biloxi.com"))
+			Expect(credentials.Uri).To(Equal("sips:ss2.biloxi.example.com"))
+			Expect(credentials.Qop).To(Equal("auth"))
+			Expect(credentials.Algorithm).To(Equal("SHA-256"))
+			Expect(credentials.Opaque).To(Equal("5ccc069c403ebaf9f0171e9517f40e41"))
+			Expect(credentials.NonceCount).To(Equal(uint32(1)))
+			Expect(credentials.Cnonce).ToNot(BeEmpty())
+
+			response, err := auth.DigestResponse(&credentials, &auth.User{Username: "bob", Password: "zanzibar"},
+				sip.REGISTER, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(credentials.Response).To(Equal(response))
+		})
+
+		It("should increment nonce count for the same nonce", func() {
+			_, err := client.Authorize(register, res)
+			Expect(err).ToNot(HaveOccurred())
+			req, err := client.Authorize(register, res)
+			Expect(err).ToNot(HaveOccurred())
+			hdrs := req.GetHeaders("Authorization")
+			Expect(hdrs[0].(*sip.AuthorizationHeader).NonceCount).To(Equal(uint32(2)))
+		})
+
+		It("should fail when the credentials are rejected", func() {
+			req, err := client.Authorize(register, res)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = client.Authorize(req, res)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should answer again when the nonce is stale", func() {
+			req, err := client.Authorize(register, res)
+			Expect(err).ToNot(HaveOccurred())
+			stale := challenge(401, `WWW-Authenticate: Digest realm="biloxi.com", nonce="a5d8b7c9", stale=TRUE`)
+			req, err = client.Authorize(req, stale)
+			Expect(err).ToNot(HaveOccurred())
+			hdrs := req.GetHeaders("Authorization")
+			Expect(hdrs).To(HaveLen(1))
+			credentials := hdrs[0].(*sip.AuthorizationHeader).Credentials
+			Expect(credentials.Nonce).To(Equal("a5d8b7c9"))
+			Expect(credentials.Qop).To(BeEmpty())
+			Expect(credentials.NonceCount).To(BeZero())
+		})
+	})
+
+	It("should compute digest response", func() {
+		res := challenge(401, `WWW-Authenticate: Digest realm="biloxi.com", nonce="84a4cc6f3082121f32b42a2187831a9e"`)
+		req, err := client.Authorize(register, res)
+		Expect(err).ToNot(HaveOccurred())
+		hdrs := req.GetHeaders("Authorization")
+		Expect(hdrs).To(HaveLen(1))
+		// MD5(MD5(bob:biloxi.com:zanzibar):nonce:MD5(REGISTER:sips:ss2.biloxi.example.com))
+		Expect(hdrs[0].(*sip.AuthorizationHeader).Response).To(Equal("e39c730ac337d0ddb5b465c6753f0f1f"))
+	})
+
+	It("should answer only the first supported challenge of the realm", func() {
+		res := challenge(401,
+			`WWW-Authenticate: Digest realm="biloxi.com", nonce="a1", qop="auth", algorithm=SHA-1`,
+			`WWW-Authenticate: Digest realm="biloxi.com", nonce="a2", qop="auth", algorithm=SHA-256`,
+			`WWW-Authenticate: Digest realm="biloxi.com", nonce="a3", qop="auth", algorithm=MD5`,
+		)
+		req, err := client.Authorize(register, res)
+		Expect(err).ToNot(HaveOccurred())
+		hdrs := req.GetHeaders("Authorization")
+		Expect(hdrs).To(HaveLen(1))
+		credentials := hdrs[0].(*sip.AuthorizationHeader).Credentials
+		Expect(credentials.Algorithm).To(Equal("SHA-256"))
+		Expect(credentials.Nonce).To(Equal("a2"))
+		Expect(credentials.NonceCount).To(Equal(uint32(1)))
+
+		// nonce count of the unanswered challenge is not used up
+		res = challenge(401, `WWW-Authenticate: Digest realm="biloxi.com", nonce="a3", qop="auth", algorithm=MD5`)
+		req, err = client.Authorize(register, res)
+		Expect(err).ToNot(HaveOccurred())
+		hdrs = req.GetHeaders("Authorization")
+		Expect(hdrs[0].(*sip.AuthorizationHeader).NonceCount).To(Equal(uint32(1)))
+	})
+
+	It("should answer proxy challenge with Proxy-Authorization", func() {
+		res := challenge(407, `Proxy-Authenticate: Digest realm="biloxi.com", nonce="f84f1cec41e6cbe5aea9c8e88d359", qop="auth-int", algorithm=MD5-sess`)
+		req, err := client.Authorize(register, res)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(req.GetHeaders("Authorization")).To(BeEmpty())
+		hdrs := req.GetHeaders("Proxy-Authorization")
+		Expect(hdrs).To(HaveLen(1))
+		Expect(hdrs[0].(*sip.ProxyAuthorizationHeader).Qop).To(Equal("auth-int"))
+	})
+
+	It("should fail without credentials for the realm", func() {
+		res := challenge(401, `WWW-Authenticate: Digest realm="atlanta.com", nonce="84a4cc6f3082121f32b42a2187831a9e"`)
+		_, err := client.Authorize(register, res)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should fail on unsupported algorithm", func() {
+		res := challenge(401, `WWW-Authenticate: Digest realm="biloxi.com", nonce="84a4cc6f3082121f32b42a2187831a9e", algorithm=SHA-1`)
+		_, err := client.Authorize(register, res)
+		Expect(err).To(HaveOccurred())
+	})
+})
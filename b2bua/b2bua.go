@@ -0,0 +1,272 @@
+// b2bua package implements SIP back-to-back user agent (RFC 3261 - 6., RFC 7092 - 3.)
+package b2bua
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/masterclock/gosip"
+	"github.com/masterclock/gosip/log"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/transaction"
+)
+
+// Router returns target of the callee leg for INVITE received from the caller.
+// Returned sip.Rejection answers the INVITE with the status, any other error is answered with 500.
+type Router func(invite sip.Request) (sip.Uri, error)
+
+// RequestHook may rewrite request relayed to the leg, e.g. copy headers of the received request or change SDP.
+// Received request is nil when the request is originated by B2BUA itself, e.g. BYE sent by Call.Hangup.
+type RequestHook func(leg *Leg, req sip.Request, received sip.Request)
+
+// ResponseHook may rewrite response relayed to the leg, e.g. copy headers of the received response or change SDP.
+// Received response is nil when the response is originated by B2BUA itself, e.g. 408 on timeout.
+type ResponseHook func(leg *Leg, res sip.Response, received sip.Response)
+
+// Config describes available options of B2BUA.
+type Config struct {
+	// Router chooses the callee, required.
+	Router Router
+	// Contact is the local target of B2BUA on both legs, required.
+	Contact sip.ContactUri
+	// RewriteRequest is called for each request relayed to the leg, may be nil.
+	RewriteRequest RequestHook
+	// RewriteResponse is called for each response relayed to the leg, may be nil.
+	RewriteResponse ResponseHook
+}
+
+// B2BUA answers INVITE of the caller as UAS and places another call to the callee as UAC.
+// Messages of one leg are translated to the other one: provisional and final responses,
+// ACK, CANCEL, BYE and re-INVITE. Each leg has own dialog, so Call-ID, tags and CSeq
+// are never passed from one leg to the other.
+type B2BUA struct {
+	srv             *gosip.Server
+	router          Router
+	contact         sip.ContactUri
+	rewriteRequest  RequestHook
+	rewriteResponse ResponseHook
+
+	mu *sync.Mutex
+	// INVITE requests sent to the legs by key of the received INVITE server transaction, used to match CANCEL
+	invites map[transaction.TxKey]*relayedInvite
+	// legs by dialog ID
+	legs map[string]*Leg
+}
+
+// relayedInvite is INVITE sent to the leg waiting for the final response.
+type relayedInvite struct {
+	req      sip.Request
+	canceled bool
+}
+
+// NewB2BUA creates B2BUA handling INVITE, ACK, CANCEL and BYE requests of the server.
+func NewB2BUA(srv *gosip.Server, config *Config) (*B2BUA, error) {
+	if config == nil || config.Router == nil || config.Contact == nil {
+		return nil, fmt.Errorf("router and contact are required to create B2BUA")
+	}
+
+	b := &B2BUA{
+		srv:             srv,
+		router:          config.Router,
+		contact:         config.Contact.Clone().(sip.ContactUri),
+		rewriteRequest:  config.RewriteRequest,
+		rewriteResponse: config.RewriteResponse,
+		mu:              new(sync.Mutex),
+		invites:         make(map[transaction.TxKey]*relayedInvite),
+		legs:            make(map[string]*Leg),
+	}
+
+	handlers := map[sip.RequestMethod]gosip.RequestHandler{
+		sip.INVITE: b.handleInvite,
+		sip.ACK:    b.handleAck,
+		sip.CANCEL: b.handleCancel,
+		sip.BYE:    b.handleBye,
+	}
+	for method, handler := range handlers {
+		if err := srv.OnRequest(method, handler); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+func (b *B2BUA) String() string {
+	return fmt.Sprintf("B2BUA %s", b.contact)
+}
+
+// Calls returns the answered calls.
+func (b *B2BUA) Calls() []*Call {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	calls := make([]*Call, 0)
+	added := make(map[*Call]bool)
+	for _, leg := range b.legs {
+		if !added[leg.call] {
+			added[leg.call] = true
+			calls = append(calls, leg.call)
+		}
+	}
+
+	return calls
+}
+
+func (b *B2BUA) handleInvite(req sip.Request) {
+	if leg, ok := b.matchLeg(req); ok {
+		leg.call.reinvite(leg, req)
+		return
+	}
+	if to, ok := req.To(); ok && sip.Tag(to.Params) != "" {
+		b.respond(req, 481, "Call/Transaction Does Not Exist")
+		return
+	}
+	if maxForwards, ok := req.MaxForwards(); ok && *maxForwards == 0 {
+		b.respond(req, 483, "Too Many Hops")
+		return
+	}
+
+	target, err := b.router(req)
+	if err != nil {
+		if rejection, ok := err.(*sip.Rejection); ok {
+			b.respond(req, rejection.StatusCode, rejection.Reason)
+			return
+		}
+		log.Errorf("%s failed to route %s: %s", b, req.Short(), err)
+		b.respond(req, 500, "Server Internal Error")
+		return
+	}
+	if target == nil {
+		b.respond(req, 480, "Temporarily Unavailable")
+		return
+	}
+
+	call, err := newCall(b, req, target)
+	if err != nil {
+		log.Warnf("%s failed to create call on %s: %s", b, req.Short(), err)
+		b.respond(req, 400, "Bad Request")
+		return
+	}
+	call.start()
+}
+
+func (b *B2BUA) handleAck(req sip.Request) {
+	leg, ok := b.matchLeg(req)
+	if !ok {
+		log.Debugf("%s discards not matched %s", b, req.Short())
+		return
+	}
+	if err := leg.Dialog().ReceiveRequest(req); err != nil {
+		log.Debugf("%s discards %s: %s", b, req.Short(), err)
+		return
+	}
+
+	select {
+	case leg.acks <- req:
+	default:
+		// ACK retransmission or ACK is not expected
+	}
+}
+
+// handleCancel cancels INVITE relayed to the other leg, CANCEL is already answered by the transaction layer.
+func (b *B2BUA) handleCancel(req sip.Request) {
+	key, err := transaction.MakeCanceledServerTxKey(req)
+	if err != nil {
+		return
+	}
+
+	b.mu.Lock()
+	invite, ok := b.invites[key]
+	if ok {
+		invite.canceled = true
+	}
+	b.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if err := b.srv.CancelRequest(invite.req); err != nil {
+		log.Debugf("%s failed to cancel %s: %s", b, invite.req.Short(), err)
+	}
+}
+
+func (b *B2BUA) handleBye(req sip.Request) {
+	leg, ok := b.matchLeg(req)
+	if !ok {
+		b.respond(req, 481, "Call/Transaction Does Not Exist")
+		return
+	}
+	if err := leg.Dialog().ReceiveRequest(req); err != nil {
+		log.Warnf("%s failed to receive %s: %s", b, req.Short(), err)
+		b.respond(req, 500, "Server Internal Error")
+		return
+	}
+
+	b.respond(req, 200, "OK")
+	leg.call.hangup(req)
+}
+
+// matchLeg returns leg of the request received inside the dialog.
+func (b *B2BUA) matchLeg(req sip.Request) (*Leg, bool) {
+	id, err := sip.MakeDialogIDFromMessage(req)
+	if err != nil {
+		return nil, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	leg, ok := b.legs[id]
+	return leg, ok
+}
+
+func (b *B2BUA) putLeg(leg *Leg) {
+	b.mu.Lock()
+	b.legs[leg.Dialog().ID()] = leg
+	b.mu.Unlock()
+}
+
+func (b *B2BUA) removeLeg(leg *Leg) {
+	dlg := leg.Dialog()
+	if dlg == nil {
+		return
+	}
+
+	b.mu.Lock()
+	if current, ok := b.legs[dlg.ID()]; ok && current == leg {
+		delete(b.legs, dlg.ID())
+	}
+	b.mu.Unlock()
+}
+
+func (b *B2BUA) putInvite(received sip.Request, req sip.Request) (transaction.TxKey, bool) {
+	key, err := transaction.MakeServerTxKey(received)
+	if err != nil {
+		return "", false
+	}
+
+	b.mu.Lock()
+	b.invites[key] = &relayedInvite{req: req}
+	b.mu.Unlock()
+
+	return key, true
+}
+
+func (b *B2BUA) removeInvite(key transaction.TxKey) (canceled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if invite, ok := b.invites[key]; ok {
+		canceled = invite.canceled
+		delete(b.invites, key)
+	}
+
+	return canceled
+}
+
+func (b *B2BUA) respond(req sip.Request, statusCode sip.StatusCode, reason string) {
+	res := sip.NewResponseFromRequest(req, statusCode, reason, "")
+	if _, err := b.srv.Respond(res); err != nil {
+		log.Debugf("%s failed to respond with %s: %s", b, res.Short(), err)
+	}
+}
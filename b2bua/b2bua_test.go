@@ -0,0 +1,233 @@
+package b2bua_test
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/masterclock/gosip"
+	"github.com/masterclock/gosip/b2bua"
+	"github.com/masterclock/gosip/dialog"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/testutils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("B2BUA", func() {
+	var (
+		caller, server, callee *gosip.Server
+		b                      *b2bua.B2BUA
+		callerReqs, calleeReqs chan sip.Request
+	)
+
+	const offer = "v=0\r\nc=IN IP4 10.0.0.1\r\n"
+	const answer = "v=0\r\nc=IN IP4 10.0.0.2\r\n"
+
+	invite := func() sip.Request {
+		return testutils.Request([]string{
+			"INVITE sip:bob@127.0.0.1:5102 SIP/2.0",
+			"From: <sip:alice@example.com>;tag=alice",
+			"To: <sip:bob@example.com>",
+			"Call-ID: b2bua-call",
+			"CSeq: 1 INVITE",
+			"Contact: <sip:alice@127.0.0.1:5100>",
+			"Max-Forwards: 70",
+			"Content-Type: application/sdp",
+			fmt.Sprintf("Content-Length: %d", len(offer)),
+			"",
+			offer,
+		})
+	}
+
+	respond := func(srv *gosip.Server, req sip.Request, statusCode sip.StatusCode, reason string, body string) sip.Response {
+		res := sip.NewResponseFromRequest(req, statusCode, reason, body)
+		if to, _ := res.To(); !to.Params.Has("tag") {
+			to.Params.Add("tag", sip.String{Str: "bob"})
+		}
+		user, port := "bob", sip.Port(5104)
+		if srv == caller {
+			user, port = "alice", sip.Port(5100)
+		}
+		res.AppendHeader(&sip.ContactHeader{
+			Address: &sip.SipUri{User: sip.String{Str: user}, Host: "127.0.0.1", Port: &port},
+			Params:  sip.NewParams(),
+		})
+		if body != "" {
+			res.AppendHeader(&sip.GenericHeader{HeaderName: "Content-Type", Contents: "application/sdp"})
+		}
+		_, err := srv.Respond(res)
+		Expect(err).ToNot(HaveOccurred())
+		return res
+	}
+
+	// UDP socket of the previous test may be released with a delay after shutdown
+	listen := func(addr string) *gosip.Server {
+		srv := gosip.NewServer(&gosip.ServerConfig{HostAddr: "127.0.0.1"})
+		Eventually(func() error { return srv.Listen("udp", addr) }).Should(Succeed())
+		return srv
+	}
+
+	nextRequest := func(reqs chan sip.Request, method sip.RequestMethod) sip.Request {
+		select {
+		case req := <-reqs:
+			Expect(req.Method()).To(Equal(method))
+			return req
+		case <-time.After(time.Second):
+			Fail(fmt.Sprintf("%s was not received", method))
+		}
+		return nil
+	}
+
+	nextResponse := func(responses <-chan sip.Response, statusCode sip.StatusCode) sip.Response {
+		for {
+			select {
+			case res := <-responses:
+				if res.StatusCode() == 100 {
+					continue
+				}
+				Expect(res.StatusCode()).To(Equal(statusCode))
+				return res
+			case <-time.After(time.Second):
+				Fail(fmt.Sprintf("%d was not received", statusCode))
+				return nil
+			}
+		}
+	}
+
+	BeforeEach(func() {
+		callerReqs = make(chan sip.Request, 10)
+		calleeReqs = make(chan sip.Request, 10)
+
+		caller = listen("127.0.0.1:5100")
+		server = listen("127.0.0.1:5102")
+		callee = listen("127.0.0.1:5104")
+
+		for _, method := range []sip.RequestMethod{sip.INVITE, sip.ACK, sip.CANCEL, sip.BYE} {
+			Expect(caller.OnRequest(method, func(req sip.Request) { callerReqs <- req })).To(Succeed())
+			Expect(callee.OnRequest(method, func(req sip.Request) { calleeReqs <- req })).To(Succeed())
+		}
+
+		port := sip.Port(5102)
+		var err error
+		b, err = b2bua.NewB2BUA(server, &b2bua.Config{
+			Router: func(invite sip.Request) (sip.Uri, error) {
+				port := sip.Port(5104)
+				return &sip.SipUri{User: sip.String{Str: "bob"}, Host: "127.0.0.1", Port: &port}, nil
+			},
+			Contact: &sip.SipUri{User: sip.String{Str: "b2bua"}, Host: "127.0.0.1", Port: &port},
+			RewriteRequest: func(leg *b2bua.Leg, req sip.Request, received sip.Request) {
+				if !leg.IsCaller() && req.IsInvite() {
+					req.SetBody(strings.Replace(req.Body(), "10.0.0.1", "192.0.2.1", 1), true)
+				}
+			},
+			RewriteResponse: func(leg *b2bua.Leg, res sip.Response, received sip.Response) {
+				if leg.IsCaller() {
+					res.AppendHeader(&sip.GenericHeader{HeaderName: "X-Leg", Contents: "caller"})
+				}
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		caller.Shutdown()
+		server.Shutdown()
+		callee.Shutdown()
+	})
+
+	It("should relay call setup, re-INVITE and BYE between the legs", func(done Done) {
+		inv := invite()
+		responses, err := caller.Request(inv)
+		Expect(err).ToNot(HaveOccurred())
+
+		By("INVITE is placed to the callee in the new dialog")
+		calleeInv := nextRequest(calleeReqs, sip.INVITE)
+		callID, _ := calleeInv.CallID()
+		Expect(string(*callID)).ToNot(Equal("b2bua-call"))
+		from, _ := calleeInv.From()
+		Expect(from.Address.String()).To(Equal("sip:alice@example.com"))
+		Expect(from.Params.Has("tag")).To(BeTrue())
+		Expect(from.Params.Equals(sip.NewParams().Add("tag", sip.String{Str: "alice"}))).To(BeFalse())
+		Expect(calleeInv.Body()).To(Equal("v=0\r\nc=IN IP4 192.0.2.1\r\n"))
+		Expect(calleeInv.GetHeaders("Content-Type")).To(HaveLen(1))
+
+		By("responses are relayed to the caller")
+		respond(callee, calleeInv, 180, "Ringing", "")
+		res := nextResponse(responses, 180)
+		Expect(res.GetHeaders("X-Leg")).To(HaveLen(1))
+		calleeRes := respond(callee, calleeInv, 200, "OK", answer)
+		res = nextResponse(responses, 200)
+		Expect(res.Body()).To(Equal(answer))
+		contact, _ := res.Contact()
+		Expect(contact.Address.String()).To(Equal("sip:b2bua@127.0.0.1:5102"))
+
+		callerDlg, err := dialog.NewClientDialog(inv, res)
+		Expect(err).ToNot(HaveOccurred())
+		calleeDlg, err := dialog.NewServerDialog(calleeInv, calleeRes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(callerDlg.RemoteTag()).ToNot(Equal("bob"))
+
+		By("ACK is relayed to the callee")
+		ack, err := callerDlg.NewRequest(sip.ACK)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(caller.Ack(ack)).To(Succeed())
+		calleeAck := nextRequest(calleeReqs, sip.ACK)
+		callID, _ = calleeAck.CallID()
+		Expect(*callID).To(Equal(calleeDlg.CallID()))
+		Expect(b.Calls()).To(HaveLen(1))
+
+		By("re-INVITE of the callee is relayed to the caller")
+		reinvite, err := calleeDlg.NewRequest(sip.INVITE)
+		Expect(err).ToNot(HaveOccurred())
+		responses, err = callee.Request(reinvite)
+		Expect(err).ToNot(HaveOccurred())
+		callerReinv := nextRequest(callerReqs, sip.INVITE)
+		Expect(callerDlg.ReceiveRequest(callerReinv)).To(Succeed())
+		cseq, _ := callerReinv.CSeq()
+		Expect(cseq.SeqNo).To(Equal(uint32(1)))
+		respond(caller, callerReinv, 200, "OK", offer)
+		res = nextResponse(responses, 200)
+		Expect(res.Body()).To(Equal(offer))
+
+		ack, err = calleeDlg.NewRequest(sip.ACK)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(callee.Ack(ack)).To(Succeed())
+		nextRequest(callerReqs, sip.ACK)
+
+		By("BYE of the caller ends the callee leg")
+		bye, err := callerDlg.NewRequest(sip.BYE)
+		Expect(err).ToNot(HaveOccurred())
+		responses, err = caller.Request(bye)
+		Expect(err).ToNot(HaveOccurred())
+		nextResponse(responses, 200)
+		calleeBye := nextRequest(calleeReqs, sip.BYE)
+		respond(callee, calleeBye, 200, "OK", "")
+		Eventually(b.Calls).Should(BeEmpty())
+		close(done)
+	}, 5)
+
+	It("should cancel the callee leg when the caller cancels", func(done Done) {
+		inv := invite()
+		responses, err := caller.Request(inv)
+		Expect(err).ToNot(HaveOccurred())
+		calleeInv := nextRequest(calleeReqs, sip.INVITE)
+		respond(callee, calleeInv, 180, "Ringing", "")
+		nextResponse(responses, 180)
+
+		Expect(caller.CancelRequest(inv)).To(Succeed())
+		nextResponse(responses, 487)
+		nextRequest(calleeReqs, sip.CANCEL)
+		Expect(b.Calls()).To(BeEmpty())
+		close(done)
+	}, 5)
+
+	It("should relay final error response of the callee", func(done Done) {
+		responses, err := caller.Request(invite())
+		Expect(err).ToNot(HaveOccurred())
+		calleeInv := nextRequest(calleeReqs, sip.INVITE)
+		respond(callee, calleeInv, 486, "Busy Here", "")
+		res := nextResponse(responses, 486)
+		Expect(res.GetHeaders("X-Leg")).To(HaveLen(1))
+		close(done)
+	}, 5)
+})
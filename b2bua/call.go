@@ -0,0 +1,465 @@
+package b2bua
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/masterclock/gosip/dialog"
+	"github.com/masterclock/gosip/log"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/timing"
+	"github.com/masterclock/gosip/transaction"
+	"github.com/masterclock/gosip/util"
+)
+
+// ackTimeout limits waiting for ACK on 2xx response relayed to the leg (RFC 3261 - 13.3.1.4.).
+const ackTimeout = 64 * transaction.T1
+
+// Leg is one of two call parts, B2BUA is UAS on the caller leg and UAC on the callee leg.
+type Leg struct {
+	call   *Call
+	caller bool
+
+	mu *sync.RWMutex
+	// dialog is nil until the call is answered
+	dialog *dialog.Dialog
+	// ACK requests received on the leg
+	acks chan sip.Request
+}
+
+func newLeg(call *Call, caller bool) *Leg {
+	return &Leg{
+		call:   call,
+		caller: caller,
+		mu:     new(sync.RWMutex),
+		acks:   make(chan sip.Request, 1),
+	}
+}
+
+func (leg *Leg) String() string {
+	if leg.caller {
+		return fmt.Sprintf("%s caller leg", leg.call)
+	}
+
+	return fmt.Sprintf("%s callee leg", leg.call)
+}
+
+func (leg *Leg) Call() *Call {
+	return leg.call
+}
+
+// IsCaller returns true for the leg of the caller.
+func (leg *Leg) IsCaller() bool {
+	return leg.caller
+}
+
+// Peer returns the other leg of the call.
+func (leg *Leg) Peer() *Leg {
+	if leg.caller {
+		return leg.call.callee
+	}
+
+	return leg.call.caller
+}
+
+// Dialog returns dialog of the leg, nil if the call is not answered yet.
+func (leg *Leg) Dialog() *dialog.Dialog {
+	leg.mu.RLock()
+	defer leg.mu.RUnlock()
+
+	return leg.dialog
+}
+
+func (leg *Leg) setDialog(dlg *dialog.Dialog) {
+	leg.mu.Lock()
+	leg.dialog = dlg
+	leg.mu.Unlock()
+}
+
+// Call is the pair of legs glued by B2BUA.
+type Call struct {
+	b2bua *B2BUA
+	// INVITE received from the caller
+	invite sip.Request
+	target sip.Uri
+	caller *Leg
+	callee *Leg
+	// To tag of the responses sent to the caller
+	callerTag string
+
+	mu *sync.Mutex
+	// re-INVITE is relayed between the legs
+	reinviting bool
+	done       chan struct{}
+	hangupOnce *sync.Once
+}
+
+func newCall(b *B2BUA, invite sip.Request, target sip.Uri) (*Call, error) {
+	for _, name := range []string{"From", "To", "Call-ID", "CSeq"} {
+		if len(invite.GetHeaders(name)) == 0 {
+			return nil, fmt.Errorf("missing '%s' header in %s", name, invite.Short())
+		}
+	}
+
+	call := &Call{
+		b2bua:      b,
+		invite:     invite,
+		target:     target.Clone(),
+		callerTag:  util.RandString(10),
+		mu:         new(sync.Mutex),
+		done:       make(chan struct{}),
+		hangupOnce: new(sync.Once),
+	}
+	call.caller = newLeg(call, true)
+	call.callee = newLeg(call, false)
+
+	return call, nil
+}
+
+func (call *Call) String() string {
+	callID, _ := call.invite.CallID()
+	return fmt.Sprintf("Call %s -> %s", callID, call.target)
+}
+
+// Caller returns the leg of the caller, B2BUA acts as UAS on it.
+func (call *Call) Caller() *Leg {
+	return call.caller
+}
+
+// Callee returns the leg of the callee, B2BUA acts as UAC on it.
+func (call *Call) Callee() *Leg {
+	return call.callee
+}
+
+// Done returns chan closed when the call is terminated.
+func (call *Call) Done() <-chan struct{} {
+	return call.done
+}
+
+// Hangup terminates both legs, BYE is sent on the answered legs.
+func (call *Call) Hangup() {
+	call.hangup(nil)
+}
+
+// start relays INVITE of the caller to the callee.
+func (call *Call) start() {
+	req := call.newInvite()
+	call.b2bua.rewriteRequestTo(call.callee, req, call.invite)
+	call.relayInvite(call.caller, call.invite, call.callee, req)
+}
+
+// reinvite relays INVITE received inside the dialog of the leg to the peer leg.
+func (call *Call) reinvite(leg *Leg, req sip.Request) {
+	b := call.b2bua
+	if err := leg.Dialog().ReceiveRequest(req); err != nil {
+		log.Warnf("%s failed to receive %s: %s", leg, req.Short(), err)
+		b.respond(req, 500, "Server Internal Error")
+		return
+	}
+
+	call.mu.Lock()
+	if call.reinviting {
+		call.mu.Unlock()
+		// RFC 3261 - 14.2. - only one INVITE transaction is allowed at a time
+		b.respond(req, 491, "Request Pending")
+		return
+	}
+	call.reinviting = true
+	call.mu.Unlock()
+	defer func() {
+		call.mu.Lock()
+		call.reinviting = false
+		call.mu.Unlock()
+	}()
+
+	peer := leg.Peer()
+	out, err := peer.Dialog().NewRequest(sip.INVITE)
+	if err != nil {
+		log.Warnf("%s failed to relay %s: %s", peer, req.Short(), err)
+		b.respond(req, 481, "Call/Transaction Does Not Exist")
+		return
+	}
+	copyBody(req, out)
+	b.rewriteRequestTo(peer, out, req)
+
+	call.relayInvite(leg, req, peer, out)
+}
+
+// relayInvite sends INVITE to the leg and relays its responses on INVITE received from the peer leg.
+// 2xx response establishes dialogs of the initial INVITE, ACK of the peer leg is relayed then.
+func (call *Call) relayInvite(from *Leg, received sip.Request, to *Leg, req sip.Request) {
+	b := call.b2bua
+	key, tracked := b.putInvite(received, req)
+
+	responses, err := b.srv.Request(req)
+	if err != nil {
+		if tracked {
+			b.removeInvite(key)
+		}
+		log.Warnf("%s failed to send %s: %s", to, req.Short(), err)
+		call.respondTo(from, received, call.newResponse(from, received, 503, "Service Unavailable", nil))
+		call.endAttempt(to)
+		return
+	}
+
+	var final sip.Response
+	for res := range responses {
+		if res.StatusCode() == 100 {
+			continue
+		}
+		if dlg := to.Dialog(); dlg != nil {
+			dlg.ReceiveResponse(req, res)
+		}
+		if res.IsProvisional() {
+			call.respondTo(from, received, call.newResponse(from, received, res.StatusCode(), res.Reason(), res))
+			continue
+		}
+		final = res
+		break
+	}
+	go func() {
+		for range responses {
+		}
+	}()
+
+	canceled := false
+	if tracked {
+		canceled = b.removeInvite(key)
+	}
+
+	switch {
+	case final == nil:
+		log.Warnf("%s got no final response on %s", to, req.Short())
+		call.respondTo(from, received, call.newResponse(from, received, 408, "Request Timeout", nil))
+		call.endAttempt(to)
+	case !final.IsSuccess():
+		call.respondTo(from, received, call.newResponse(from, received, final.StatusCode(), final.Reason(), final))
+		call.endAttempt(to)
+	default:
+		call.answer(from, received, to, req, final, canceled)
+	}
+}
+
+// endAttempt hangs up the call if the INVITE sent to the leg failed to establish or refresh the dialog.
+func (call *Call) endAttempt(leg *Leg) {
+	if dlg := leg.Dialog(); dlg == nil || dlg.State() == dialog.Terminated {
+		call.hangup(nil)
+	}
+}
+
+// answer relays 2xx response on INVITE sent to the leg and waits for ACK of the peer leg to relay it.
+func (call *Call) answer(from *Leg, received sip.Request, to *Leg, req sip.Request, final sip.Response, canceled bool) {
+	b := call.b2bua
+	if to.Dialog() == nil {
+		dlg, err := dialog.NewClientDialog(req, final)
+		if err != nil {
+			log.Warnf("%s failed to create dialog: %s", to, err)
+			call.respondTo(from, received, call.newResponse(from, received, 502, "Bad Gateway", nil))
+			call.hangup(nil)
+			return
+		}
+		to.setDialog(dlg)
+		b.putLeg(to)
+	}
+
+	res := call.newResponse(from, received, final.StatusCode(), final.Reason(), final)
+	if canceled {
+		// RFC 3261 - 15. - the callee answered after CANCEL, so the answer is acknowledged and the call is hung up
+		call.ack(to, nil)
+		call.hangup(nil)
+		return
+	}
+	if from.Dialog() == nil {
+		dlg, err := dialog.NewServerDialog(received, res)
+		if err != nil {
+			log.Warnf("%s failed to create dialog: %s", from, err)
+			call.ack(to, nil)
+			call.hangup(nil)
+			return
+		}
+		from.setDialog(dlg)
+		b.putLeg(from)
+	}
+
+	// drop ACK retransmissions left from the previous INVITE
+	select {
+	case <-from.acks:
+	default:
+	}
+	if err := call.respondTo(from, received, res); err != nil {
+		call.ack(to, nil)
+		call.hangup(nil)
+		return
+	}
+
+	select {
+	case ack := <-from.acks:
+		call.ack(to, ack)
+	case <-timing.After(ackTimeout):
+		log.Warnf("%s got no ACK on %s", from, res.Short())
+		call.ack(to, nil)
+		call.hangup(nil)
+	case <-call.done:
+	}
+}
+
+// ack sends ACK on 2xx response to the leg, body of the received ACK is relayed.
+func (call *Call) ack(leg *Leg, received sip.Request) {
+	b := call.b2bua
+	ack, err := leg.Dialog().NewRequest(sip.ACK)
+	if err != nil {
+		log.Warnf("%s failed to create ACK: %s", leg, err)
+		return
+	}
+	if received != nil {
+		copyBody(received, ack)
+	}
+	b.rewriteRequestTo(leg, ack, received)
+
+	if err := b.srv.Ack(ack); err != nil {
+		log.Warnf("%s failed to send %s: %s", leg, ack.Short(), err)
+	}
+}
+
+// hangup terminates both legs once, answered legs are ended with BYE.
+func (call *Call) hangup(received sip.Request) {
+	call.hangupOnce.Do(func() {
+		log.Debugf("%s hangs up", call)
+		for _, leg := range []*Leg{call.caller, call.callee} {
+			call.bye(leg, received)
+			call.b2bua.removeLeg(leg)
+		}
+		close(call.done)
+	})
+}
+
+func (call *Call) bye(leg *Leg, received sip.Request) {
+	b := call.b2bua
+	dlg := leg.Dialog()
+	if dlg == nil {
+		return
+	}
+	if dlg.State() != dialog.Confirmed {
+		dlg.Terminate()
+		return
+	}
+
+	bye, err := dlg.NewRequest(sip.BYE)
+	if err != nil {
+		log.Warnf("%s failed to create BYE: %s", leg, err)
+		dlg.Terminate()
+		return
+	}
+	b.rewriteRequestTo(leg, bye, received)
+
+	responses, err := b.srv.Request(bye)
+	if err != nil {
+		log.Warnf("%s failed to send %s: %s", leg, bye.Short(), err)
+		dlg.Terminate()
+		return
+	}
+	go func() {
+		for res := range responses {
+			dlg.ReceiveResponse(bye, res)
+		}
+		dlg.Terminate()
+	}()
+}
+
+// newInvite creates INVITE to the callee from INVITE of the caller.
+func (call *Call) newInvite() sip.Request {
+	from, _ := call.invite.From()
+	to, _ := call.invite.To()
+	callID := sip.CallID(util.RandString(32))
+	maxForwards := sip.MaxForwards(70)
+	if value, ok := call.invite.MaxForwards(); ok {
+		maxForwards = *value - 1
+	}
+
+	req := sip.NewRequest(
+		sip.INVITE,
+		call.target.Clone(),
+		"SIP/2.0",
+		[]sip.Header{
+			&sip.FromHeader{
+				DisplayName: from.DisplayName,
+				Address:     from.Address.Clone(),
+				Params:      sip.NewParams().Add("tag", sip.String{Str: util.RandString(10)}),
+			},
+			&sip.ToHeader{
+				DisplayName: to.DisplayName,
+				Address:     to.Address.Clone(),
+				Params:      sip.NewParams(),
+			},
+			&callID,
+			&sip.CSeq{SeqNo: 1, MethodName: sip.INVITE},
+			&maxForwards,
+			&sip.ContactHeader{Address: call.b2bua.contact.Clone().(sip.ContactUri), Params: sip.NewParams()},
+		},
+		"",
+	)
+	copyBody(call.invite, req)
+
+	return req
+}
+
+// newResponse creates response to be relayed to the leg on the request received from it.
+func (call *Call) newResponse(
+	leg *Leg,
+	req sip.Request,
+	statusCode sip.StatusCode,
+	reason string,
+	received sip.Response,
+) sip.Response {
+	res := sip.NewResponseFromRequest(req, statusCode, reason, "")
+	if received != nil {
+		copyBody(received, res)
+	}
+	if to, ok := res.To(); ok && sip.Tag(to.Params) == "" {
+		if to.Params == nil {
+			to.Params = sip.NewParams()
+		}
+		to.Params.Add("tag", sip.String{Str: call.callerTag})
+	}
+	if req.IsInvite() && statusCode < 300 {
+		res.AppendHeader(&sip.ContactHeader{Address: call.b2bua.contact.Clone().(sip.ContactUri), Params: sip.NewParams()})
+	}
+	call.b2bua.rewriteResponseTo(leg, res, received)
+
+	return res
+}
+
+// respondTo sends response on the request received from the leg.
+func (call *Call) respondTo(leg *Leg, req sip.Request, res sip.Response) error {
+	if dlg := leg.Dialog(); dlg != nil {
+		dlg.SendResponse(req, res)
+	}
+	if _, err := call.b2bua.srv.Respond(res); err != nil {
+		log.Debugf("%s failed to respond with %s: %s", leg, res.Short(), err)
+		return err
+	}
+
+	return nil
+}
+
+func (b *B2BUA) rewriteRequestTo(leg *Leg, req sip.Request, received sip.Request) {
+	if b.rewriteRequest != nil {
+		b.rewriteRequest(leg, req, received)
+	}
+}
+
+func (b *B2BUA) rewriteResponseTo(leg *Leg, res sip.Response, received sip.Response) {
+	if b.rewriteResponse != nil {
+		b.rewriteResponse(leg, res, received)
+	}
+}
+
+// copyBody copies body of the message with its 'Content-Type' header, e.g. SDP offer or answer.
+func copyBody(from sip.Message, to sip.Message) {
+	if from.Body() == "" {
+		return
+	}
+	to.RemoveHeader("Content-Type")
+	sip.CopyHeaders("Content-Type", from, to)
+	to.SetBody(from.Body(), true)
+}
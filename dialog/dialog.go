@@ -0,0 +1,620 @@
+// dialog package implements SIP dialogs (RFC 3261 - 12.)
+package dialog
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/util"
+)
+
+// State is a dialog state.
+type State int
+
+const (
+	// initial state of the dialog that is not set up yet
+	stateNone State = iota - 1
+	Early
+	Confirmed
+	Terminated
+)
+
+func (state State) String() string {
+	switch state {
+	case Early:
+		return "Early"
+	case Confirmed:
+		return "Confirmed"
+	case Terminated:
+		return "Terminated"
+	default:
+		return "Unknown"
+	}
+}
+
+// Dialog is a peer-to-peer SIP relationship between two UAs (RFC 3261 - 12.).
+type Dialog struct {
+	id           string
+	callID       sip.CallID
+	localTag     string
+	remoteTag    string
+	local        *sip.Address
+	remote       *sip.Address
+	localTarget  sip.Uri
+	remoteTarget sip.Uri
+	routeSet     []*sip.Address
+	localSeq     uint32
+	remoteSeq    uint32
+	// sequence number of the last INVITE sent inside the dialog, used by ACK
+	inviteSeq uint32
+	// RSeq of the last processed reliable provisional response (RFC 3262 - 4.)
+	rseq   uint32
+	secure bool
+	state  State
+	states chan State
+	// called once when dialog terminates
+	onTerminate func(dlg *Dialog)
+	mu          *sync.RWMutex
+}
+
+// NewClientDialog creates dialog on the UAC side from the sent request
+// and the received dialog creating response (RFC 3261 - 12.1.2.).
+func NewClientDialog(req sip.Request, res sip.Response) (*Dialog, error) {
+	callID, ok := req.CallID()
+	if !ok {
+		return nil, fmt.Errorf("missing 'Call-ID' header in %s", req.Short())
+	}
+	from, ok := req.From()
+	if !ok {
+		return nil, fmt.Errorf("missing 'From' header in %s", req.Short())
+	}
+	to, ok := res.To()
+	if !ok {
+		return nil, fmt.Errorf("missing 'To' header in %s", res.Short())
+	}
+	cseq, ok := req.CSeq()
+	if !ok {
+		return nil, fmt.Errorf("missing 'CSeq' header in %s", req.Short())
+	}
+	contact, ok := res.Contact()
+	if !ok {
+		return nil, fmt.Errorf("missing 'Contact' header in %s", res.Short())
+	}
+	routeSet := recordRoutes(res)
+	// route set is taken in reverse order on the UAC side
+	for i, j := 0, len(routeSet)-1; i < j; i, j = i+1, j-1 {
+		routeSet[i], routeSet[j] = routeSet[j], routeSet[i]
+	}
+
+	dlg := newDialog(*callID, sip.Tag(from.Params), sip.Tag(to.Params))
+	dlg.local = headerAddress(from.DisplayName, from.Address, from.Params)
+	dlg.remote = headerAddress(to.DisplayName, to.Address, to.Params)
+	if localContact, ok := req.Contact(); ok {
+		dlg.localTarget = localContact.Address.Clone()
+	}
+	dlg.remoteTarget = contact.Address.Clone()
+	dlg.routeSet = routeSet
+	dlg.localSeq = cseq.SeqNo
+	dlg.inviteSeq = cseq.SeqNo
+	dlg.secure = isSips(req.Recipient())
+	dlg.init(res)
+
+	return dlg, nil
+}
+
+// NewServerDialog creates dialog on the UAS side from the received request
+// and the dialog creating response (RFC 3261 - 12.1.1.).
+func NewServerDialog(req sip.Request, res sip.Response) (*Dialog, error) {
+	callID, ok := req.CallID()
+	if !ok {
+		return nil, fmt.Errorf("missing 'Call-ID' header in %s", req.Short())
+	}
+	from, ok := req.From()
+	if !ok {
+		return nil, fmt.Errorf("missing 'From' header in %s", req.Short())
+	}
+	to, ok := res.To()
+	if !ok {
+		return nil, fmt.Errorf("missing 'To' header in %s", res.Short())
+	}
+	cseq, ok := req.CSeq()
+	if !ok {
+		return nil, fmt.Errorf("missing 'CSeq' header in %s", req.Short())
+	}
+	contact, ok := req.Contact()
+	if !ok {
+		return nil, fmt.Errorf("missing 'Contact' header in %s", req.Short())
+	}
+	routeSet := recordRoutes(req)
+
+	dlg := newDialog(*callID, sip.Tag(to.Params), sip.Tag(from.Params))
+	dlg.local = headerAddress(to.DisplayName, to.Address, to.Params)
+	dlg.remote = headerAddress(from.DisplayName, from.Address, from.Params)
+	if localContact, ok := res.Contact(); ok {
+		dlg.localTarget = localContact.Address.Clone()
+	}
+	dlg.remoteTarget = contact.Address.Clone()
+	dlg.routeSet = routeSet
+	dlg.remoteSeq = cseq.SeqNo
+	dlg.secure = isSips(req.Recipient()) && isSecureTransport(req.Transport())
+	dlg.init(res)
+
+	return dlg, nil
+}
+
+// NewSubscriberDialog creates dialog on the subscriber side from the sent SUBSCRIBE (or REFER)
+// request and NOTIFY request that arrived before the final response (RFC 6665 - 4.1.2.4.).
+func NewSubscriberDialog(subscribe sip.Request, notify sip.Request) (*Dialog, error) {
+	callID, ok := notify.CallID()
+	if !ok {
+		return nil, fmt.Errorf("missing 'Call-ID' header in %s", notify.Short())
+	}
+	from, ok := subscribe.From()
+	if !ok {
+		return nil, fmt.Errorf("missing 'From' header in %s", subscribe.Short())
+	}
+	notifier, ok := notify.From()
+	if !ok {
+		return nil, fmt.Errorf("missing 'From' header in %s", notify.Short())
+	}
+	cseq, ok := subscribe.CSeq()
+	if !ok {
+		return nil, fmt.Errorf("missing 'CSeq' header in %s", subscribe.Short())
+	}
+	notifyCSeq, ok := notify.CSeq()
+	if !ok {
+		return nil, fmt.Errorf("missing 'CSeq' header in %s", notify.Short())
+	}
+	contact, ok := notify.Contact()
+	if !ok {
+		return nil, fmt.Errorf("missing 'Contact' header in %s", notify.Short())
+	}
+	// NOTIFY is a request received by the subscriber, so route set is taken as on the UAS side
+	routeSet := recordRoutes(notify)
+
+	dlg := newDialog(*callID, sip.Tag(from.Params), sip.Tag(notifier.Params))
+	dlg.local = headerAddress(from.DisplayName, from.Address, from.Params)
+	dlg.remote = headerAddress(notifier.DisplayName, notifier.Address, notifier.Params)
+	if localContact, ok := subscribe.Contact(); ok {
+		dlg.localTarget = localContact.Address.Clone()
+	}
+	dlg.remoteTarget = contact.Address.Clone()
+	dlg.routeSet = routeSet
+	dlg.localSeq = cseq.SeqNo
+	dlg.remoteSeq = notifyCSeq.SeqNo
+	dlg.secure = isSips(subscribe.Recipient())
+	dlg.setState(Confirmed)
+
+	return dlg, nil
+}
+
+func newDialog(callID sip.CallID, localTag, remoteTag string) *Dialog {
+	return &Dialog{
+		id:        sip.MakeDialogID(string(callID), localTag, remoteTag),
+		callID:    callID,
+		localTag:  localTag,
+		remoteTag: remoteTag,
+		state:     stateNone,
+		// each state is entered only once, so notifications never block
+		states: make(chan State, 3),
+		mu:     new(sync.RWMutex),
+	}
+}
+
+// init sets the initial state by the dialog creating response.
+func (dlg *Dialog) init(res sip.Response) {
+	if rseq, ok := res.RSeq(); ok && isReliableProvisional(res) {
+		dlg.rseq = uint32(*rseq)
+	}
+	if res.IsSuccess() {
+		dlg.setState(Confirmed)
+	} else {
+		dlg.setState(Early)
+	}
+}
+
+func (dlg *Dialog) String() string {
+	if dlg == nil {
+		return "Dialog <nil>"
+	}
+
+	return fmt.Sprintf("Dialog %s", dlg.id)
+}
+
+// ID returns dialog ID built from Call-ID, local and remote tags.
+func (dlg *Dialog) ID() string {
+	return dlg.id
+}
+
+func (dlg *Dialog) CallID() sip.CallID {
+	return dlg.callID
+}
+
+func (dlg *Dialog) LocalTag() string {
+	return dlg.localTag
+}
+
+func (dlg *Dialog) RemoteTag() string {
+	return dlg.remoteTag
+}
+
+// Local returns local party address without tag.
+func (dlg *Dialog) Local() *sip.Address {
+	return dlg.local.Clone()
+}
+
+// Remote returns remote party address without tag.
+func (dlg *Dialog) Remote() *sip.Address {
+	return dlg.remote.Clone()
+}
+
+// LocalTarget returns local Contact URI, may be nil.
+func (dlg *Dialog) LocalTarget() sip.Uri {
+	dlg.mu.RLock()
+	defer dlg.mu.RUnlock()
+
+	if dlg.localTarget == nil {
+		return nil
+	}
+
+	return dlg.localTarget.Clone()
+}
+
+// RemoteTarget returns remote Contact URI.
+func (dlg *Dialog) RemoteTarget() sip.Uri {
+	dlg.mu.RLock()
+	defer dlg.mu.RUnlock()
+
+	return dlg.remoteTarget.Clone()
+}
+
+// RouteSet returns ordered list of routes to the remote target.
+func (dlg *Dialog) RouteSet() []*sip.Address {
+	dlg.mu.RLock()
+	defer dlg.mu.RUnlock()
+
+	routeSet := make([]*sip.Address, len(dlg.routeSet))
+	for i, route := range dlg.routeSet {
+		routeSet[i] = route.Clone()
+	}
+
+	return routeSet
+}
+
+// LocalSeq returns CSeq number of the last request sent inside the dialog, 0 if empty.
+func (dlg *Dialog) LocalSeq() uint32 {
+	dlg.mu.RLock()
+	defer dlg.mu.RUnlock()
+
+	return dlg.localSeq
+}
+
+// RemoteSeq returns CSeq number of the last request received inside the dialog, 0 if empty.
+func (dlg *Dialog) RemoteSeq() uint32 {
+	dlg.mu.RLock()
+	defer dlg.mu.RUnlock()
+
+	return dlg.remoteSeq
+}
+
+// Secure returns true if dialog requests must be sent over secure transport.
+func (dlg *Dialog) Secure() bool {
+	return dlg.secure
+}
+
+func (dlg *Dialog) State() State {
+	dlg.mu.RLock()
+	defer dlg.mu.RUnlock()
+
+	return dlg.state
+}
+
+// States returns channel of dialog state changes, it is closed after Terminated state.
+func (dlg *Dialog) States() <-chan State {
+	return dlg.states
+}
+
+// Terminate switches dialog to Terminated state.
+func (dlg *Dialog) Terminate() {
+	dlg.setState(Terminated)
+}
+
+func (dlg *Dialog) setState(state State) {
+	dlg.mu.Lock()
+	// states are switched only forward
+	if state <= dlg.state {
+		dlg.mu.Unlock()
+		return
+	}
+	dlg.state = state
+	dlg.states <- state
+	onTerminate := dlg.onTerminate
+	if state == Terminated {
+		close(dlg.states)
+	}
+	dlg.mu.Unlock()
+
+	if state == Terminated && onTerminate != nil {
+		onTerminate(dlg)
+	}
+}
+
+// NewRequest builds request inside the dialog (RFC 3261 - 12.2.1.1.).
+// CSeq number is incremented for all methods except ACK and CANCEL.
+// Via header is left to the transport layer.
+func (dlg *Dialog) NewRequest(method sip.RequestMethod) (sip.Request, error) {
+	dlg.mu.Lock()
+	defer dlg.mu.Unlock()
+
+	if dlg.state == Terminated {
+		return nil, fmt.Errorf("%s is terminated", dlg)
+	}
+
+	var seqNo uint32
+	switch method {
+	case sip.ACK:
+		if dlg.inviteSeq == 0 {
+			return nil, fmt.Errorf("%s has no INVITE to acknowledge", dlg)
+		}
+		seqNo = dlg.inviteSeq
+	case sip.CANCEL:
+		seqNo = dlg.localSeq
+	default:
+		if dlg.localSeq == 0 {
+			// local sequence number is empty on the UAS side
+			dlg.localSeq = 1
+		} else {
+			dlg.localSeq++
+		}
+		seqNo = dlg.localSeq
+		if method == sip.INVITE {
+			dlg.inviteSeq = seqNo
+		}
+	}
+
+	hdrs := make([]sip.Header, 0)
+	if len(dlg.routeSet) > 0 {
+		routes := make([]*sip.Address, len(dlg.routeSet))
+		for i, route := range dlg.routeSet {
+			routes[i] = route.Clone()
+		}
+		hdrs = append(hdrs, &sip.RouteHeader{Addresses: routes})
+	}
+	maxForwards := sip.MaxForwards(70)
+	hdrs = append(hdrs,
+		&sip.FromHeader{
+			DisplayName: dlg.local.DisplayName,
+			Address:     dlg.local.Uri.Clone(),
+			Params:      sip.NewParams().Add("tag", sip.String{Str: dlg.localTag}),
+		},
+		&sip.ToHeader{
+			DisplayName: dlg.remote.DisplayName,
+			Address:     dlg.remote.Uri.Clone(),
+			Params:      remoteParams(dlg.remoteTag),
+		},
+		&dlg.callID,
+		&sip.CSeq{SeqNo: seqNo, MethodName: method},
+		maxForwards,
+	)
+	if dlg.localTarget != nil && isTargetRefresh(method) {
+		hdrs = append(hdrs, &sip.ContactHeader{Address: dlg.localTarget.Clone().(sip.ContactUri)})
+	}
+
+	req := sip.NewRequest(method, dlg.remoteTarget.Clone(), "SIP/2.0", hdrs, "")
+	// RFC 3261 - 12.2.1.1. - the first route becomes Request-URI if it is a strict router
+	sip.RewriteStrictRoute(req)
+
+	return req, nil
+}
+
+// NewPrack builds PRACK request for the reliable provisional response received
+// in the early dialog (RFC 3262 - 7.1.). CSeq number is incremented as by NewRequest.
+func (dlg *Dialog) NewPrack(res sip.Response) (sip.Request, error) {
+	if !isReliableProvisional(res) {
+		return nil, fmt.Errorf("%s is not reliable provisional response", res.Short())
+	}
+	rseq, ok := res.RSeq()
+	if !ok {
+		return nil, fmt.Errorf("missing 'RSeq' header in %s", res.Short())
+	}
+	cseq, ok := res.CSeq()
+	if !ok {
+		return nil, fmt.Errorf("missing 'CSeq' header in %s", res.Short())
+	}
+
+	prack, err := dlg.NewRequest(sip.PRACK)
+	if err != nil {
+		return nil, err
+	}
+	prack.AppendHeader(&sip.RAck{RSeq: uint32(*rseq), CSeq: cseq.SeqNo, MethodName: cseq.MethodName})
+
+	return prack, nil
+}
+
+// ReceiveRequest processes request received inside the dialog (RFC 3261 - 12.2.2.).
+// Returns error if the request is out of order, in this case 500 response should be sent.
+func (dlg *Dialog) ReceiveRequest(req sip.Request) error {
+	cseq, ok := req.CSeq()
+	if !ok {
+		return fmt.Errorf("missing 'CSeq' header in %s", req.Short())
+	}
+
+	dlg.mu.Lock()
+	if dlg.state == Terminated {
+		dlg.mu.Unlock()
+		return fmt.Errorf("%s is terminated", dlg)
+	}
+	// ACK and CANCEL repeat the sequence number of the request
+	if req.Method() != sip.ACK && req.Method() != sip.CANCEL {
+		if dlg.remoteSeq != 0 && cseq.SeqNo <= dlg.remoteSeq {
+			dlg.mu.Unlock()
+			return fmt.Errorf("%s received out of order request %s: CSeq %d is not greater than %d",
+				dlg, req.Short(), cseq.SeqNo, dlg.remoteSeq)
+		}
+		dlg.remoteSeq = cseq.SeqNo
+	}
+	if isTargetRefresh(req.Method()) {
+		if contact, ok := req.Contact(); ok {
+			dlg.remoteTarget = contact.Address.Clone()
+		}
+	}
+	dlg.mu.Unlock()
+
+	if req.Method() == sip.BYE {
+		dlg.Terminate()
+	}
+
+	return nil
+}
+
+// ReceiveResponse processes response to the request sent inside the dialog
+// or to the dialog creating request on the UAC side (RFC 3261 - 12.2.1.2., 12.3.).
+func (dlg *Dialog) ReceiveResponse(req sip.Request, res sip.Response) {
+	if res.StatusCode() == 100 {
+		return
+	}
+	// reliable provisional response is processed once, e.g. when PRACK is built by Registry.NewPrack
+	if rseq, ok := res.RSeq(); ok && isReliableProvisional(res) {
+		dlg.mu.Lock()
+		processed := dlg.rseq != 0 && uint32(*rseq) <= dlg.rseq
+		if !processed {
+			dlg.rseq = uint32(*rseq)
+		}
+		dlg.mu.Unlock()
+		if processed {
+			return
+		}
+	}
+
+	switch {
+	case res.StatusCode() == 481 || res.StatusCode() == 408:
+		dlg.Terminate()
+		return
+	case req.Method() == sip.BYE && !res.IsProvisional():
+		dlg.Terminate()
+		return
+	}
+
+	dlg.mu.Lock()
+	state := dlg.state
+	if res.IsSuccess() || res.IsProvisional() {
+		if isTargetRefresh(req.Method()) {
+			if contact, ok := res.Contact(); ok {
+				dlg.remoteTarget = contact.Address.Clone()
+			}
+		}
+		// route set is recomputed when early dialog gets confirmed
+		if state == Early && res.IsSuccess() {
+			routeSet := recordRoutes(res)
+			for i, j := 0, len(routeSet)-1; i < j; i, j = i+1, j-1 {
+				routeSet[i], routeSet[j] = routeSet[j], routeSet[i]
+			}
+			dlg.routeSet = routeSet
+		}
+	}
+	dlg.mu.Unlock()
+
+	switch {
+	case res.IsSuccess():
+		dlg.setState(Confirmed)
+	case state == Early && !res.IsProvisional() && isDialogCreating(req.Method()):
+		dlg.Terminate()
+	}
+}
+
+// SendResponse processes response sent by UAS inside the dialog.
+func (dlg *Dialog) SendResponse(req sip.Request, res sip.Response) {
+	switch {
+	case res.IsSuccess():
+		if req.Method() == sip.BYE {
+			dlg.Terminate()
+			return
+		}
+		dlg.setState(Confirmed)
+	case !res.IsProvisional() && dlg.State() == Early && isDialogCreating(req.Method()):
+		dlg.Terminate()
+	}
+}
+
+// recordRoutes returns copy of all 'Record-Route' header values of the message in the order of appearance.
+func recordRoutes(msg sip.Message) []*sip.Address {
+	routes := sip.RecordRoutes(msg)
+	for i, route := range routes {
+		routes[i] = route.Clone()
+	}
+
+	return routes
+}
+
+func headerAddress(displayName sip.MaybeString, uri sip.Uri, params sip.Params) *sip.Address {
+	addr := &sip.Address{DisplayName: displayName, Uri: uri.Clone(), Params: sip.NewParams()}
+	if params != nil {
+		for _, key := range params.Keys() {
+			if key == "tag" {
+				continue
+			}
+			val, _ := params.Get(key)
+			addr.Params.Add(key, val)
+		}
+	}
+
+	return addr
+}
+
+func remoteParams(tag string) sip.Params {
+	params := sip.NewParams()
+	if tag != "" {
+		params.Add("tag", sip.String{Str: tag})
+	}
+
+	return params
+}
+
+// newTag generates random tag for To and From headers.
+func newTag() string {
+	return util.RandString(10)
+}
+
+func isSips(uri sip.Uri) bool {
+	if sipUri, ok := uri.(*sip.SipUri); ok {
+		return sipUri.IsEncrypted
+	}
+
+	return false
+}
+
+func isSecureTransport(transport string) bool {
+	switch strings.ToUpper(transport) {
+	case "TLS", "WSS":
+		return true
+	default:
+		return false
+	}
+}
+
+// isReliableProvisional returns true if the provisional response requires 100rel extension (RFC 3262 - 3.).
+func isReliableProvisional(res sip.Response) bool {
+	return res.IsProvisional() && res.StatusCode() > 100 && sip.HasOptionTag(res, "Require", "100rel")
+}
+
+// isDialogCreating returns true for methods that create dialogs.
+func isDialogCreating(method sip.RequestMethod) bool {
+	switch method {
+	case sip.INVITE, sip.SUBSCRIBE, sip.REFER:
+		return true
+	default:
+		return false
+	}
+}
+
+// isTargetRefresh returns true for methods that can update remote target (RFC 3261 - 12.2., RFC 6665 - 4.3.).
+func isTargetRefresh(method sip.RequestMethod) bool {
+	switch method {
+	case sip.INVITE, "UPDATE", sip.SUBSCRIBE, sip.NOTIFY, sip.REFER:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,367 @@
+package dialog_test
+
+import (
+	"fmt"
+
+	"github.com/masterclock/gosip/dialog"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/testutils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dialog", func() {
+	var registry *dialog.Registry
+
+	invite := func() sip.Request {
+		return testutils.Request([]string{
+			"INVITE sip:bob@biloxi.com SIP/2.0",
+			"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds",
+			"To: \"Bob\" <sip:bob@biloxi.com>",
+			"From: \"Alice\" <sip:alice@atlanta.com>;tag=1928301774",
+			"Call-ID: a84b4c76e66710",
+			"CSeq: 314159 INVITE",
+			"Contact: <sip:alice@pc33.atlanta.com>",
+			"Content-Length: 0",
+			"",
+			"",
+		})
+	}
+	response := func(statusCode sip.StatusCode, toTag string) sip.Response {
+		return testutils.Response([]string{
+			fmt.Sprintf("SIP/2.0 %d Reason", statusCode),
+			"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds",
+			"Record-Route: <sip:p2.biloxi.com;lr>",
+			"Record-Route: <sip:p1.atlanta.com;lr>",
+			"To: \"Bob\" <sip:bob@biloxi.com>;tag=" + toTag,
+			"From: \"Alice\" <sip:alice@atlanta.com>;tag=1928301774",
+			"Call-ID: a84b4c76e66710",
+			"CSeq: 314159 INVITE",
+			"Contact: <sip:bob@192.0.2.4>",
+			"Content-Length: 0",
+			"",
+			"",
+		})
+	}
+
+	BeforeEach(func() {
+		registry = dialog.NewRegistry()
+	})
+
+	Context("on the UAC side", func() {
+		var (
+			req sip.Request
+			dlg *dialog.Dialog
+		)
+
+		BeforeEach(func() {
+			var err error
+			req = invite()
+			registry.SendRequest(req)
+			dlg, err = registry.ReceiveResponse(req, response(100, ""))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dlg).To(BeNil())
+			dlg, err = registry.ReceiveResponse(req, response(180, "a6c85cf"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dlg).ToNot(BeNil())
+		})
+
+		It("should create early dialog by provisional response with To tag", func() {
+			Expect(dlg.State()).To(Equal(dialog.Early))
+			Expect(<-dlg.States()).To(Equal(dialog.Early))
+			Expect(dlg.ID()).To(Equal(sip.MakeDialogID("a84b4c76e66710", "1928301774", "a6c85cf")))
+			Expect(dlg.LocalTag()).To(Equal("1928301774"))
+			Expect(dlg.RemoteTag()).To(Equal("a6c85cf"))
+			Expect(dlg.RemoteTarget().String()).To(Equal("sip:bob@192.0.2.4"))
+			Expect(dlg.LocalSeq()).To(Equal(uint32(314159)))
+			Expect(dlg.RemoteSeq()).To(BeZero())
+			Expect(dlg.Secure()).To(BeFalse())
+			routes := dlg.RouteSet()
+			Expect(routes).To(HaveLen(2))
+			Expect(routes[0].Uri.String()).To(Equal("sip:p1.atlanta.com;lr"))
+			Expect(routes[1].Uri.String()).To(Equal("sip:p2.biloxi.com;lr"))
+		})
+
+		It("should terminate early dialog by non-2xx final response", func() {
+			_, err := registry.ReceiveResponse(req, response(486, "b8d96e"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dlg.State()).To(Equal(dialog.Terminated))
+			_, ok := registry.Get(dlg.ID())
+			Expect(ok).To(BeFalse())
+		})
+
+		It("should build PRACK on reliable provisional response in early dialog", func() {
+			update, err := dlg.NewRequest(sip.RequestMethod("UPDATE"))
+			Expect(err).ToNot(HaveOccurred())
+			cseq, _ := update.CSeq()
+			Expect(cseq.SeqNo).To(Equal(uint32(314160)))
+
+			res := response(183, "a6c85cf")
+			res.AppendHeader(&sip.GenericHeader{HeaderName: "Require", Contents: "100rel"})
+			rseq := sip.RSeq(1)
+			res.AppendHeader(&rseq)
+			prack, err := registry.NewPrack(req, res)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(prack.Method()).To(Equal(sip.PRACK))
+			Expect(prack.Recipient().String()).To(Equal("sip:bob@192.0.2.4"))
+			cseq, _ = prack.CSeq()
+			Expect(cseq.String()).To(Equal("CSeq: 314161 PRACK"))
+			rack, _ := prack.RAck()
+			Expect(rack.String()).To(Equal("RAck: 1 314159 INVITE"))
+			Expect(dlg.LocalSeq()).To(Equal(uint32(314161)))
+
+			_, err = dlg.NewPrack(response(180, "a6c85cf"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should keep non-SIP URIs of the dialog addresses", func() {
+			req := invite()
+			req.RemoveHeader("From")
+			req.AppendHeader(&sip.FromHeader{
+				Address: &sip.TelUri{Number: "+12125551212"},
+				Params:  sip.NewParams().Add("tag", sip.String{Str: "1928301775"}),
+			})
+			res := sip.NewResponseFromRequest(req, 200, "OK", "")
+			to, _ := res.To()
+			to.Params.Add("tag", sip.String{Str: "a6c85cf"})
+			res.AppendHeader(&sip.ContactHeader{Address: &sip.SipUri{User: sip.String{Str: "bob"}, Host: "192.0.2.4"}})
+
+			dlg, err := registry.ReceiveResponse(req, res)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dlg.Local().String()).To(Equal("<tel:+12125551212>"))
+			bye, err := dlg.NewRequest(sip.BYE)
+			Expect(err).ToNot(HaveOccurred())
+			from, _ := bye.From()
+			Expect(from.String()).To(Equal("From: <tel:+12125551212>;tag=1928301775"))
+		})
+
+		It("should process reliable provisional response once", func() {
+			reliable := func(contact string) sip.Response {
+				return testutils.Response([]string{
+					"SIP/2.0 183 Session Progress",
+					"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds",
+					"To: \"Bob\" <sip:bob@biloxi.com>;tag=a6c85cf",
+					"From: \"Alice\" <sip:alice@atlanta.com>;tag=1928301774",
+					"Call-ID: a84b4c76e66710",
+					"CSeq: 314159 INVITE",
+					"Contact: <" + contact + ">",
+					"Require: 100rel",
+					"RSeq: 1",
+					"Content-Length: 0",
+					"",
+					"",
+				})
+			}
+			_, err := registry.NewPrack(req, reliable("sip:bob@192.0.2.5"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dlg.RemoteTarget().String()).To(Equal("sip:bob@192.0.2.5"))
+
+			_, err = registry.ReceiveResponse(req, reliable("sip:bob@192.0.2.6"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dlg.RemoteTarget().String()).To(Equal("sip:bob@192.0.2.5"))
+		})
+
+		Context("when 2xx response arrives", func() {
+			BeforeEach(func() {
+				confirmed, err := registry.ReceiveResponse(req, response(200, "a6c85cf"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(confirmed).To(Equal(dlg))
+			})
+
+			It("should confirm dialog", func() {
+				Expect(dlg.State()).To(Equal(dialog.Confirmed))
+				Expect(<-dlg.States()).To(Equal(dialog.Early))
+				Expect(<-dlg.States()).To(Equal(dialog.Confirmed))
+			})
+
+			It("should build in-dialog requests", func() {
+				bye, err := dlg.NewRequest(sip.BYE)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(bye.Recipient().String()).To(Equal("sip:bob@192.0.2.4"))
+				Expect(bye.GetHeaders("Route")[0].String()).To(Equal(
+					"Route: <sip:p1.atlanta.com;lr>, <sip:p2.biloxi.com;lr>"))
+				from, _ := bye.From()
+				Expect(from.String()).To(Equal("From: \"Alice\" <sip:alice@atlanta.com>;tag=1928301774"))
+				to, _ := bye.To()
+				Expect(to.String()).To(Equal("To: \"Bob\" <sip:bob@biloxi.com>;tag=a6c85cf"))
+				callID, _ := bye.CallID()
+				Expect(string(*callID)).To(Equal("a84b4c76e66710"))
+				cseq, _ := bye.CSeq()
+				Expect(cseq.String()).To(Equal("CSeq: 314160 BYE"))
+
+				ack, err := dlg.NewRequest(sip.ACK)
+				Expect(err).ToNot(HaveOccurred())
+				cseq, _ = ack.CSeq()
+				Expect(cseq.String()).To(Equal("CSeq: 314159 ACK"))
+			})
+
+			It("should terminate dialog when BYE is answered", func() {
+				bye, err := dlg.NewRequest(sip.BYE)
+				Expect(err).ToNot(HaveOccurred())
+				res := sip.NewResponseFromRequest(bye, 200, "OK", "")
+				_, err = registry.ReceiveResponse(bye, res)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(dlg.State()).To(Equal(dialog.Terminated))
+				_, err = dlg.NewRequest(sip.RequestMethod("INFO"))
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("on the UAS side", func() {
+		var (
+			req sip.Request
+			res sip.Response
+			dlg *dialog.Dialog
+		)
+
+		BeforeEach(func() {
+			var err error
+			req = invite()
+			req.PrependHeader(&sip.RecordRouteHeader{Addresses: []*sip.Address{{
+				Uri:    &sip.SipUri{Host: "p1.atlanta.com", UriParams: sip.NewParams().Add("lr", nil)},
+				Params: sip.NewParams(),
+			}}})
+			res = sip.NewResponseFromRequest(req, 200, "OK", "")
+			res.AppendHeader(&sip.ContactHeader{Address: &sip.SipUri{User: sip.String{Str: "bob"}, Host: "192.0.2.4"}})
+			Expect(registry.ReceiveRequest(req)).To(BeNil())
+			dlg, err = registry.SendResponse(req, res)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dlg).ToNot(BeNil())
+		})
+
+		It("should add To tag and create confirmed dialog", func() {
+			to, _ := res.To()
+			tag, ok := to.Params.Get("tag")
+			Expect(ok).To(BeTrue())
+			Expect(dlg.LocalTag()).To(Equal(tag.String()))
+			Expect(dlg.RemoteTag()).To(Equal("1928301774"))
+			Expect(dlg.State()).To(Equal(dialog.Confirmed))
+			Expect(dlg.RemoteTarget().String()).To(Equal("sip:alice@pc33.atlanta.com"))
+			Expect(dlg.LocalTarget().String()).To(Equal("sip:bob@192.0.2.4"))
+			Expect(dlg.RemoteSeq()).To(Equal(uint32(314159)))
+			Expect(dlg.RouteSet()).To(HaveLen(1))
+		})
+
+		Context("when in-dialog requests arrive", func() {
+			request := func(method sip.RequestMethod, seqNo string) sip.Request {
+				return testutils.Request([]string{
+					string(method) + " sip:bob@192.0.2.4 SIP/2.0",
+					"Via: SIP/2.0/UDP pc33.atlanta.com;branch=" + sip.GenerateBranch(),
+					"To: \"Bob\" <sip:bob@biloxi.com>;tag=" + dlg.LocalTag(),
+					"From: \"Alice\" <sip:alice@atlanta.com>;tag=1928301774",
+					"Call-ID: a84b4c76e66710",
+					"CSeq: " + seqNo + " " + string(method),
+					"Content-Length: 0",
+					"",
+					"",
+				})
+			}
+
+			It("should reject out of order request", func() {
+				matched, err := registry.ReceiveRequest(request("INFO", "314159"))
+				Expect(err).To(HaveOccurred())
+				Expect(matched).To(Equal(dlg))
+			})
+
+			It("should accept ACK with INVITE sequence number", func() {
+				matched, err := registry.ReceiveRequest(request(sip.ACK, "314159"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(matched).To(Equal(dlg))
+			})
+
+			It("should terminate dialog by BYE", func() {
+				matched, err := registry.ReceiveRequest(request(sip.BYE, "314160"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(matched).To(Equal(dlg))
+				Expect(dlg.RemoteSeq()).To(Equal(uint32(314160)))
+				Expect(<-dlg.States()).To(Equal(dialog.Confirmed))
+				Expect(<-dlg.States()).To(Equal(dialog.Terminated))
+				_, ok := <-dlg.States()
+				Expect(ok).To(BeFalse())
+				_, err = registry.ReceiveRequest(request(sip.BYE, "314161"))
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("should start local sequence number for requests sent by UAS", func() {
+				bye, err := dlg.NewRequest(sip.BYE)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(bye.Recipient().String()).To(Equal("sip:alice@pc33.atlanta.com"))
+				cseq, _ := bye.CSeq()
+				Expect(cseq.SeqNo).To(Equal(uint32(1)))
+				to, _ := bye.To()
+				Expect(to.String()).To(Equal("To: \"Alice\" <sip:alice@atlanta.com>;tag=1928301774"))
+			})
+		})
+	})
+
+	Context("with strict router in the route set", func() {
+		It("should put the first route into Request-URI", func() {
+			req := invite()
+			res := response(200, "a6c85cf")
+			res.RemoveHeader("Record-Route")
+			res.AppendHeader(&sip.RecordRouteHeader{Addresses: []*sip.Address{
+				{Uri: &sip.SipUri{Host: "p2.biloxi.com"}, Params: sip.NewParams()},
+				{Uri: &sip.SipUri{Host: "p1.atlanta.com"}, Params: sip.NewParams()},
+			}})
+			dlg, err := dialog.NewClientDialog(req, res)
+			Expect(err).ToNot(HaveOccurred())
+			info, err := dlg.NewRequest(sip.RequestMethod("INFO"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Recipient().String()).To(Equal("sip:p1.atlanta.com"))
+			Expect(info.GetHeaders("Route")[0].String()).To(Equal(
+				"Route: <sip:p2.biloxi.com>, <sip:bob@192.0.2.4>"))
+		})
+	})
+
+	Context("when NOTIFY arrives before response to SUBSCRIBE", func() {
+		It("should create subscriber dialog", func() {
+			subscribe := testutils.Request([]string{
+				"SUBSCRIBE sip:bob@biloxi.com SIP/2.0",
+				"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds",
+				"To: <sip:bob@biloxi.com>",
+				"From: <sip:alice@atlanta.com>;tag=1928301774",
+				"Call-ID: a84b4c76e66710",
+				"CSeq: 1 SUBSCRIBE",
+				"Contact: <sip:alice@pc33.atlanta.com>",
+				"Event: presence",
+				"",
+				"",
+			})
+			notify := testutils.Request([]string{
+				"NOTIFY sip:alice@pc33.atlanta.com SIP/2.0",
+				"Via: SIP/2.0/UDP 192.0.2.4;branch=z9hG4bK776asdhds",
+				"To: <sip:alice@atlanta.com>;tag=1928301774",
+				"From: <sip:bob@biloxi.com>;tag=a6c85cf",
+				"Call-ID: a84b4c76e66710",
+				"CSeq: 1 NOTIFY",
+				"Contact: <sip:bob@192.0.2.4>",
+				"Event: presence",
+				"",
+				"",
+			})
+			registry.SendRequest(subscribe)
+			dlg, err := registry.ReceiveRequest(notify)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dlg).ToNot(BeNil())
+			Expect(dlg.State()).To(Equal(dialog.Confirmed))
+			Expect(dlg.RemoteTarget().String()).To(Equal("sip:bob@192.0.2.4"))
+			Expect(dlg.RemoteSeq()).To(Equal(uint32(1)))
+
+			res := testutils.Response([]string{
+				"SIP/2.0 202 Accepted",
+				"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds",
+				"To: <sip:bob@biloxi.com>;tag=a6c85cf",
+				"From: <sip:alice@atlanta.com>;tag=1928301774",
+				"Call-ID: a84b4c76e66710",
+				"CSeq: 1 SUBSCRIBE",
+				"Contact: <sip:bob@192.0.2.4>",
+				"",
+				"",
+			})
+			matched, err := registry.ReceiveResponse(subscribe, res)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(matched).To(Equal(dlg))
+		})
+	})
+})
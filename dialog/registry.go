@@ -0,0 +1,351 @@
+package dialog
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/masterclock/gosip/sip"
+)
+
+// Registry keeps track of the UA dialogs.
+// Dialogs are created by the dialog creating requests (INVITE, SUBSCRIBE, REFER) and responses,
+// or by NOTIFY requests that arrive before the response to SUBSCRIBE.
+// Terminated dialogs are removed automatically.
+type Registry struct {
+	dialogs map[string]*Dialog
+	// sent subscription requests waiting for NOTIFY, indexed by Call-ID and local tag
+	subscriptions map[string]sip.Request
+	mu            *sync.RWMutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		dialogs:       make(map[string]*Dialog),
+		subscriptions: make(map[string]sip.Request),
+		mu:            new(sync.RWMutex),
+	}
+}
+
+// Get returns dialog by ID.
+func (r *Registry) Get(id string) (*Dialog, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	dlg, ok := r.dialogs[id]
+	return dlg, ok
+}
+
+// Dialogs returns all tracked dialogs.
+func (r *Registry) Dialogs() []*Dialog {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	dialogs := make([]*Dialog, 0, len(r.dialogs))
+	for _, dlg := range r.dialogs {
+		dialogs = append(dialogs, dlg)
+	}
+
+	return dialogs
+}
+
+// Put starts tracking of the dialog.
+func (r *Registry) Put(dlg *Dialog) {
+	r.mu.Lock()
+	r.dialogs[dlg.ID()] = dlg
+	r.mu.Unlock()
+
+	dlg.mu.Lock()
+	dlg.onTerminate = r.remove
+	terminated := dlg.state == Terminated
+	dlg.mu.Unlock()
+
+	if terminated {
+		r.remove(dlg)
+	}
+}
+
+func (r *Registry) remove(dlg *Dialog) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if current, ok := r.dialogs[dlg.ID()]; ok && current == dlg {
+		delete(r.dialogs, dlg.ID())
+	}
+}
+
+// MatchRequest returns dialog of the request received by the UA.
+func (r *Registry) MatchRequest(req sip.Request) (*Dialog, bool) {
+	id, err := sip.MakeDialogIDFromMessage(req)
+	if err != nil {
+		return nil, false
+	}
+
+	return r.Get(id)
+}
+
+// MatchResponse returns dialog of the response received by the UA.
+func (r *Registry) MatchResponse(res sip.Response) (*Dialog, bool) {
+	id, err := responseDialogID(res)
+	if err != nil {
+		return nil, false
+	}
+
+	return r.Get(id)
+}
+
+// SendRequest should be called for all requests sent outside of dialogs,
+// it remembers subscriptions that can be established by NOTIFY (RFC 6665 - 4.1.2.4.).
+func (r *Registry) SendRequest(req sip.Request) {
+	if req.Method() != sip.SUBSCRIBE && req.Method() != sip.REFER {
+		return
+	}
+	key, ok := subscriptionKey(req)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	r.subscriptions[key] = req
+	r.mu.Unlock()
+}
+
+// ReceiveResponse processes response received by UAC.
+// Returns dialog that was created or updated by the response or nil
+// if the response does not belong to any dialog.
+func (r *Registry) ReceiveResponse(req sip.Request, res sip.Response) (*Dialog, error) {
+	if !res.IsProvisional() && req.Method() != sip.INVITE {
+		// final response ends waiting for NOTIFY
+		if key, ok := subscriptionKey(req); ok {
+			r.mu.Lock()
+			delete(r.subscriptions, key)
+			r.mu.Unlock()
+		}
+	}
+
+	creating := isDialogCreating(req.Method()) && !hasToTag(req)
+	// non-2xx final response terminates all early dialogs of the request
+	if creating && !res.IsProvisional() && !res.IsSuccess() {
+		r.terminateEarly(req)
+	}
+
+	if dlg, ok := r.MatchResponse(res); ok {
+		dlg.ReceiveResponse(req, res)
+		return dlg, nil
+	}
+
+	if !creating || !hasToTag(res) || !createsDialog(req, res) {
+		return nil, nil
+	}
+
+	dlg, err := NewClientDialog(req, res)
+	if err != nil {
+		return nil, err
+	}
+	r.Put(dlg)
+
+	return dlg, nil
+}
+
+// NewPrack builds PRACK request for the reliable provisional response on INVITE
+// in the early dialog created or updated by the response, see Dialog.NewPrack.
+// It can be used as transaction.LayerConfig.Prack, so PRACK requests sent by
+// the transaction layer share CSeq numbers with the other requests of the dialog.
+// The response is processed here, passing it to ReceiveResponse again has no effect.
+func (r *Registry) NewPrack(invite sip.Request, res sip.Response) (sip.Request, error) {
+	dlg, err := r.ReceiveResponse(invite, res)
+	if err != nil {
+		return nil, err
+	}
+	if dlg == nil {
+		return nil, fmt.Errorf("%s does not establish dialog", res.Short())
+	}
+
+	return dlg.NewPrack(res)
+}
+
+// SendResponse processes response that is going to be sent by UAS.
+// Missing To tag is added to the dialog creating response.
+// Returns dialog that was created or updated by the response or nil
+// if the response does not belong to any dialog.
+func (r *Registry) SendResponse(req sip.Request, res sip.Response) (*Dialog, error) {
+	if hasToTag(req) {
+		dlg, ok := r.MatchRequest(req)
+		if !ok {
+			return nil, nil
+		}
+		dlg.SendResponse(req, res)
+		return dlg, nil
+	}
+
+	if !isDialogCreating(req.Method()) {
+		return nil, nil
+	}
+	if !createsDialog(req, res) {
+		// final error response terminates early dialog created by the request
+		if id, err := sip.MakeDialogIDFromMessage(res); err == nil && !res.IsProvisional() {
+			if dlg, ok := r.Get(id); ok {
+				dlg.SendResponse(req, res)
+			}
+		}
+		return nil, nil
+	}
+
+	to, ok := res.To()
+	if !ok {
+		return nil, fmt.Errorf("missing 'To' header in %s", res.Short())
+	}
+	if to.Params == nil {
+		to.Params = sip.NewParams()
+	}
+	if !to.Params.Has("tag") {
+		to.Params.Add("tag", sip.String{Str: newTag()})
+	}
+
+	callID, ok := req.CallID()
+	if !ok {
+		return nil, fmt.Errorf("missing 'Call-ID' header in %s", req.Short())
+	}
+	from, ok := req.From()
+	if !ok {
+		return nil, fmt.Errorf("missing 'From' header in %s", req.Short())
+	}
+	if dlg, ok := r.Get(sip.MakeDialogID(string(*callID), sip.Tag(to.Params), sip.Tag(from.Params))); ok {
+		dlg.SendResponse(req, res)
+		return dlg, nil
+	}
+
+	dlg, err := NewServerDialog(req, res)
+	if err != nil {
+		return nil, err
+	}
+	r.Put(dlg)
+
+	return dlg, nil
+}
+
+// ReceiveRequest processes request received by UA.
+// Returns dialog of the in-dialog request or dialog created by NOTIFY,
+// nil if the request is sent outside of dialog.
+// Returns error if the in-dialog request does not match any dialog (481 response should be sent)
+// or it is out of order (500 response should be sent).
+func (r *Registry) ReceiveRequest(req sip.Request) (*Dialog, error) {
+	if !hasToTag(req) {
+		return nil, nil
+	}
+
+	if dlg, ok := r.MatchRequest(req); ok {
+		if err := dlg.ReceiveRequest(req); err != nil {
+			return dlg, err
+		}
+		return dlg, nil
+	}
+
+	if req.Method() == sip.NOTIFY {
+		if key, ok := notifySubscriptionKey(req); ok {
+			r.mu.RLock()
+			subscribe, ok := r.subscriptions[key]
+			r.mu.RUnlock()
+			if ok {
+				dlg, err := NewSubscriberDialog(subscribe, req)
+				if err != nil {
+					return nil, err
+				}
+				r.Put(dlg)
+				return dlg, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("request %s does not match any dialog", req.Short())
+}
+
+// terminateEarly terminates early dialogs created by the request on the UAC side.
+func (r *Registry) terminateEarly(req sip.Request) {
+	callID, ok := req.CallID()
+	if !ok {
+		return
+	}
+	from, ok := req.From()
+	if !ok {
+		return
+	}
+	localTag := sip.Tag(from.Params)
+
+	for _, dlg := range r.Dialogs() {
+		if dlg.CallID() == *callID && dlg.LocalTag() == localTag && dlg.State() == Early {
+			dlg.Terminate()
+		}
+	}
+}
+
+// createsDialog returns true if the response to the dialog creating request establishes dialog.
+func createsDialog(req sip.Request, res sip.Response) bool {
+	if res.IsSuccess() {
+		return true
+	}
+	// only INVITE creates early dialogs by provisional responses with To tag
+	if req.Method() != sip.INVITE || !res.IsProvisional() || res.StatusCode() == 100 {
+		return false
+	}
+
+	return true
+}
+
+// responseDialogID returns dialog ID as it is seen by UAC.
+func responseDialogID(res sip.Response) (string, error) {
+	callID, ok := res.CallID()
+	if !ok {
+		return "", fmt.Errorf("missing 'Call-ID' header")
+	}
+	to, ok := res.To()
+	if !ok {
+		return "", fmt.Errorf("missing 'To' header")
+	}
+	from, ok := res.From()
+	if !ok {
+		return "", fmt.Errorf("missing 'From' header")
+	}
+	toTag := sip.Tag(to.Params)
+	if toTag == "" {
+		return "", fmt.Errorf("missing tag param in 'To' header")
+	}
+
+	return sip.MakeDialogID(string(*callID), sip.Tag(from.Params), toTag), nil
+}
+
+func subscriptionKey(req sip.Request) (string, bool) {
+	callID, ok := req.CallID()
+	if !ok {
+		return "", false
+	}
+	from, ok := req.From()
+	if !ok {
+		return "", false
+	}
+
+	return string(*callID) + "__" + sip.Tag(from.Params), true
+}
+
+// notifySubscriptionKey returns key of the subscription established by NOTIFY,
+// local tag of the subscriber is in the 'To' header.
+func notifySubscriptionKey(req sip.Request) (string, bool) {
+	callID, ok := req.CallID()
+	if !ok {
+		return "", false
+	}
+	to, ok := req.To()
+	if !ok {
+		return "", false
+	}
+
+	return string(*callID) + "__" + sip.Tag(to.Params), true
+}
+
+func hasToTag(msg sip.Message) bool {
+	to, ok := msg.To()
+	if !ok {
+		return false
+	}
+
+	return sip.Tag(to.Params) != ""
+}
@@ -0,0 +1,541 @@
+package event_test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/masterclock/gosip"
+	"github.com/masterclock/gosip/dialog"
+	"github.com/masterclock/gosip/event"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/sip/parser"
+	"github.com/masterclock/gosip/testutils"
+	"github.com/masterclock/gosip/timing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Event", func() {
+	var (
+		subscriberSrv, notifierSrv, peer *gosip.Server
+		notifier                         *event.Notifier
+		subscriber                       *event.Subscriber
+		status                           atomic.Value
+		peerReqs                         chan sip.Request
+	)
+
+	uri := func(user string, port sip.Port) *sip.SipUri {
+		return &sip.SipUri{User: sip.String{Str: user}, Host: "127.0.0.1", Port: &port}
+	}
+
+	// UDP socket of the previous test may be released with a delay after shutdown
+	listen := func(addr string) *gosip.Server {
+		srv := gosip.NewServer(&gosip.ServerConfig{HostAddr: "127.0.0.1"})
+		Eventually(func() error { return srv.Listen("udp", addr) }).Should(Succeed())
+		return srv
+	}
+
+	nextNotification := func(sub *event.ClientSubscription) sip.Request {
+		select {
+		case req, ok := <-sub.Notifications():
+			Expect(ok).To(BeTrue(), "notifications of %s are closed", sub)
+			return req
+		case <-time.After(time.Second):
+			Fail(fmt.Sprintf("NOTIFY of %s was not received", sub))
+		}
+		return nil
+	}
+
+	nextRequest := func(method sip.RequestMethod) sip.Request {
+		select {
+		case req := <-peerReqs:
+			Expect(req.Method()).To(Equal(method))
+			return req
+		case <-time.After(time.Second):
+			Fail(fmt.Sprintf("%s was not received", method))
+		}
+		return nil
+	}
+
+	finalResponse := func(responses <-chan sip.Response) sip.Response {
+		for {
+			select {
+			case res := <-responses:
+				if res.IsProvisional() {
+					continue
+				}
+				return res
+			case <-time.After(time.Second):
+				Fail("final response was not received")
+				return nil
+			}
+		}
+	}
+
+	subStateOf := func(req sip.Request) *sip.SubscriptionStateHeader {
+		subState, ok := req.SubscriptionState()
+		Expect(ok).To(BeTrue(), "missing Subscription-State in %s", req.Short())
+		return subState
+	}
+
+	expiresOf := func(subState *sip.SubscriptionStateHeader) time.Duration {
+		expires, ok := subState.Expires()
+		Expect(ok).To(BeTrue(), "missing expires param in %s", subState)
+		return expires
+	}
+
+	statusOf := func(notify sip.Request) sip.Response {
+		contentType, ok := notify.ContentType()
+		Expect(ok).To(BeTrue(), "missing Content-Type in %s", notify.Short())
+		Expect(contentType.Is(sip.SipFragContentType)).To(BeTrue())
+		frag, err := parser.ParseSipFrag(notify.Body())
+		Expect(err).ToNot(HaveOccurred())
+		res, ok := frag.(sip.Response)
+		Expect(ok).To(BeTrue(), "%s is not status line", frag.StartLine())
+		return res
+	}
+
+	referRequest := func(hdrs ...string) []string {
+		return append([]string{
+			"REFER sip:bob@127.0.0.1:5108 SIP/2.0",
+			"From: <sip:carol@example.com>;tag=carol",
+			"To: <sip:bob@example.com>",
+			"Call-ID: event-refer",
+			"CSeq: 7 REFER",
+			"Contact: <sip:carol@127.0.0.1:5110>",
+			"Max-Forwards: 70",
+		}, append(hdrs, "", "")...)
+	}
+
+	acceptRefer := func(dlg func() *dialog.Dialog) <-chan *event.Transfer {
+		transfers := make(chan *event.Transfer, 1)
+		Expect(notifierSrv.OnRequest(sip.REFER, func(req sip.Request) {
+			defer GinkgoRecover()
+			var referDialog *dialog.Dialog
+			if dlg != nil {
+				referDialog = dlg()
+			}
+			transfer, err := notifier.AcceptRefer(req, referDialog)
+			if err == nil {
+				transfers <- transfer
+			}
+		})).To(Succeed())
+		return transfers
+	}
+
+	subscribeConfig := func(eventType string, expires time.Duration) *event.SubscribeConfig {
+		return &event.SubscribeConfig{
+			Target:  uri("bob", 5108),
+			From:    &sip.Address{Uri: &sip.SipUri{User: sip.String{Str: "alice"}, Host: "example.com"}},
+			Event:   eventType,
+			Expires: expires,
+			Accept:  []string{"application/pidf+xml"},
+		}
+	}
+
+	// set once, goroutines of the previous servers can still read it
+	timing.MockMode = true
+
+	BeforeEach(func() {
+		peerReqs = make(chan sip.Request, 10)
+		status.Store("open")
+
+		subscriberSrv = listen("127.0.0.1:5106")
+		notifierSrv = listen("127.0.0.1:5108")
+		peer = listen("127.0.0.1:5110")
+		for _, method := range []sip.RequestMethod{sip.SUBSCRIBE, sip.NOTIFY} {
+			Expect(peer.OnRequest(method, func(req sip.Request) { peerReqs <- req })).To(Succeed())
+		}
+
+		presence := event.Presence(func(sub *event.ServerSubscription) (string, error) {
+			return fmt.Sprintf(`<presence status="%s"/>`, status.Load()), nil
+		})
+		presence.MinExpires = 60 * time.Second
+		presence.MaxExpires = 600 * time.Second
+		presence.Authorize = func(sub *event.ServerSubscription, req sip.Request) (sip.SubscriptionState, error) {
+			if from, ok := req.From(); ok && from.Address.String() == "sip:mallory@example.com" {
+				return "", &sip.Rejection{StatusCode: 403, Reason: "Forbidden"}
+			}
+			return sip.SubscriptionActive, nil
+		}
+		packages, err := event.NewRegistry(presence, event.MessageSummary(nil))
+		Expect(err).ToNot(HaveOccurred())
+
+		notifier, err = event.NewNotifier(notifierSrv, &event.NotifierConfig{
+			Contact:  uri("bob", 5108),
+			Packages: packages,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		subscriber, err = event.NewSubscriber(subscriberSrv, uri("alice", 5106))
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		subscriberSrv.Shutdown()
+		notifierSrv.Shutdown()
+		peer.Shutdown()
+	})
+
+	It("should reject duplicated event package", func() {
+		_, err := event.NewRegistry(event.Dialog(nil), event.Dialog(nil))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should notify subscriber on creation, state change, refresh and unsubscription", func(done Done) {
+		sub, err := subscriber.Subscribe(subscribeConfig("presence", time.Hour))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sub.Expires()).To(Equal(600 * time.Second))
+
+		notify := nextNotification(sub)
+		Expect(notify.Body()).To(Equal(`<presence status="open"/>`))
+		contentType, ok := notify.ContentType()
+		Expect(ok).To(BeTrue())
+		Expect(contentType.Is("application/pidf+xml")).To(BeTrue())
+		Expect(subStateOf(notify).State).To(Equal(sip.SubscriptionActive))
+		Expect(expiresOf(subStateOf(notify))).To(Equal(600 * time.Second))
+		Expect(sub.State()).To(Equal(sip.SubscriptionActive))
+		Expect(notifier.Subscriptions()).To(HaveLen(1))
+
+		status.Store("closed")
+		notifier.Notify("presence", uri("bob", 5108))
+		Expect(nextNotification(sub).Body()).To(Equal(`<presence status="closed"/>`))
+
+		Expect(sub.Refresh()).To(Succeed())
+		Expect(subStateOf(nextNotification(sub)).State).To(Equal(sip.SubscriptionActive))
+
+		Expect(sub.Unsubscribe()).To(Succeed())
+		notify = nextNotification(sub)
+		Expect(subStateOf(notify).State).To(Equal(sip.SubscriptionTerminated))
+		Eventually(sub.Done()).Should(BeClosed())
+		Expect(sub.Notifications()).To(BeClosed())
+		Expect(sub.Err()).ToNot(HaveOccurred())
+		Eventually(notifier.Subscriptions).Should(BeEmpty())
+		Expect(subscriber.Subscriptions()).To(BeEmpty())
+
+		close(done)
+	}, 3)
+
+	It("should reject unknown event package with 489 listing supported packages", func(done Done) {
+		_, err := subscriber.Subscribe(subscribeConfig("dialog", time.Hour))
+		Expect(err).To(HaveOccurred())
+		subErr, ok := err.(*event.SubscribeError)
+		Expect(ok).To(BeTrue())
+		Expect(subErr.Response.StatusCode()).To(Equal(sip.StatusCode(489)))
+		allowEvents, ok := subErr.Response.AllowEvents()
+		Expect(ok).To(BeTrue())
+		Expect(allowEvents.EventTypes).To(Equal([]string{"presence", "message-summary"}))
+
+		close(done)
+	}, 3)
+
+	It("should reject unauthorized subscriber", func(done Done) {
+		config := subscribeConfig("presence", time.Hour)
+		config.From = &sip.Address{Uri: &sip.SipUri{User: sip.String{Str: "mallory"}, Host: "example.com"}}
+		_, err := subscriber.Subscribe(config)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*event.SubscribeError).Response.StatusCode()).To(Equal(sip.StatusCode(403)))
+		Expect(notifier.Subscriptions()).To(BeEmpty())
+
+		close(done)
+	}, 3)
+
+	It("should raise too brief expiration up to Min-Expires", func(done Done) {
+		sub, err := subscriber.Subscribe(subscribeConfig("presence", 10*time.Second))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sub.Expires()).To(Equal(60 * time.Second))
+		Expect(expiresOf(subStateOf(nextNotification(sub)))).To(Equal(60 * time.Second))
+
+		close(done)
+	}, 3)
+
+	It("should refresh subscription at the half of expiration", func(done Done) {
+		sub, err := subscriber.Subscribe(subscribeConfig("presence", 120*time.Second))
+		Expect(err).ToNot(HaveOccurred())
+		nextNotification(sub)
+		// NOTIFY requests of the subscription are serialized,
+		// so the initial one is answered before the transaction timers are elapsed
+		Expect(notifier.Subscriptions()[0].Notify()).To(Succeed())
+		nextNotification(sub)
+
+		timing.Elapse(60 * time.Second)
+		Expect(subStateOf(nextNotification(sub)).State).To(Equal(sip.SubscriptionActive))
+		Eventually(sub.Expires).Should(Equal(120 * time.Second))
+		Expect(notifier.Subscriptions()[0].Expires()).To(Equal(120 * time.Second))
+
+		close(done)
+	}, 3)
+
+	It("should terminate expired subscription with timeout reason", func(done Done) {
+		responses, err := peer.Request(testutils.Request([]string{
+			"SUBSCRIBE sip:bob@127.0.0.1:5108 SIP/2.0",
+			"From: <sip:carol@example.com>;tag=carol",
+			"To: <sip:bob@example.com>",
+			"Call-ID: event-expired",
+			"CSeq: 1 SUBSCRIBE",
+			"Contact: <sip:carol@127.0.0.1:5110>",
+			"Max-Forwards: 70",
+			"Event: presence",
+			"Expires: 60",
+			"",
+			"",
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		res := finalResponse(responses)
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(200)))
+		expires, ok := res.Expires()
+		Expect(ok).To(BeTrue())
+		Expect(*expires).To(Equal(sip.Expires(60)))
+
+		notify := nextRequest(sip.NOTIFY)
+		Expect(subStateOf(notify).State).To(Equal(sip.SubscriptionActive))
+		_, err = peer.Respond(sip.NewResponseFromRequest(notify, 200, "OK", ""))
+		Expect(err).ToNot(HaveOccurred())
+
+		sub := notifier.Subscriptions()[0]
+		time.Sleep(100 * time.Millisecond)
+		timing.Elapse(60 * time.Second)
+		notify = nextRequest(sip.NOTIFY)
+		Expect(subStateOf(notify).State).To(Equal(sip.SubscriptionTerminated))
+		Expect(subStateOf(notify).Reason()).To(Equal("timeout"))
+		_, err = peer.Respond(sip.NewResponseFromRequest(notify, 200, "OK", ""))
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(sub.Done()).Should(BeClosed())
+		Expect(notifier.Subscriptions()).To(BeEmpty())
+
+		close(done)
+	}, 3)
+
+	It("should accept subscriptions of the forked SUBSCRIBE", func(done Done) {
+		forks := make(chan *event.ClientSubscription, 1)
+		config := subscribeConfig("message-summary", time.Hour)
+		config.Target = uri("bob", 5110)
+		config.OnFork = func(sub *event.ClientSubscription) { forks <- sub }
+
+		notifyFrom := func(subscribe sip.Request, tag string, eventType string, seqNo int) sip.Response {
+			callID, _ := subscribe.CallID()
+			from, _ := subscribe.From()
+			fromTag, _ := from.Params.Get("tag")
+			responses, err := peer.Request(testutils.Request([]string{
+				"NOTIFY sip:alice@127.0.0.1:5106 SIP/2.0",
+				"From: <sip:bob@example.com>;tag=" + tag,
+				fmt.Sprintf("To: <sip:alice@example.com>;tag=%s", fromTag),
+				callID.String(),
+				fmt.Sprintf("CSeq: %d NOTIFY", seqNo),
+				"Contact: <sip:bob@127.0.0.1:5110>",
+				"Max-Forwards: 70",
+				"Event: " + eventType,
+				"Subscription-State: active;expires=3600",
+				"",
+				"",
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			return finalResponse(responses)
+		}
+
+		subs := make(chan *event.ClientSubscription, 1)
+		go func() {
+			defer GinkgoRecover()
+			sub, err := subscriber.Subscribe(config)
+			Expect(err).ToNot(HaveOccurred())
+			subs <- sub
+		}()
+
+		subscribe := nextRequest(sip.SUBSCRIBE)
+		res := sip.NewResponseFromRequest(subscribe, 200, "OK", "")
+		to, _ := res.To()
+		to.Params.Add("tag", sip.String{Str: "bob-1"})
+		res.AppendHeader(&sip.ContactHeader{Address: uri("bob", 5110)})
+		seconds := sip.Expires(3600)
+		res.AppendHeader(&seconds)
+		_, err := peer.Respond(res)
+		Expect(err).ToNot(HaveOccurred())
+
+		var sub *event.ClientSubscription
+		Eventually(subs).Should(Receive(&sub))
+		Expect(sub.Dialog().RemoteTag()).To(Equal("bob-1"))
+
+		Expect(notifyFrom(subscribe, "bob-1", "message-summary", 1).StatusCode()).To(Equal(sip.StatusCode(200)))
+		Expect(nextNotification(sub).Method()).To(Equal(sip.NOTIFY))
+		Expect(notifyFrom(subscribe, "bob-2", "message-summary", 1).StatusCode()).To(Equal(sip.StatusCode(200)))
+
+		var fork *event.ClientSubscription
+		Eventually(forks).Should(Receive(&fork))
+		Expect(fork.Dialog().RemoteTag()).To(Equal("bob-2"))
+		Expect(nextNotification(fork).Method()).To(Equal(sip.NOTIFY))
+		Expect(fork.State()).To(Equal(sip.SubscriptionActive))
+		Expect(subscriber.Subscriptions()).To(HaveLen(2))
+
+		Expect(notifyFrom(subscribe, "bob-1", "presence", 2).StatusCode()).To(Equal(sip.StatusCode(489)))
+		Expect(notifyFrom(subscribe, "bob-3", "presence", 1).StatusCode()).To(Equal(sip.StatusCode(489)))
+		time.Sleep(100 * time.Millisecond)
+		timing.Elapse(64 * 500 * time.Millisecond)
+		Eventually(func() sip.StatusCode {
+			return notifyFrom(subscribe, "bob-3", "message-summary", 1).StatusCode()
+		}).Should(Equal(sip.StatusCode(481)))
+
+		close(done)
+	}, 3)
+
+	It("should report progress of the referred request in NOTIFY of REFER", func(done Done) {
+		transfers := acceptRefer(nil)
+		responses, err := peer.Request(testutils.Request(referRequest("Refer-To: <sip:dave@example.com>")))
+		Expect(err).ToNot(HaveOccurred())
+		res := finalResponse(responses)
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(202)))
+		_, ok := res.ReferSub()
+		Expect(ok).To(BeFalse())
+
+		notify := nextRequest(sip.NOTIFY)
+		evt, ok := notify.Event()
+		Expect(ok).To(BeTrue())
+		Expect(evt.EventType).To(Equal("refer"))
+		Expect(evt.ID()).To(Equal("7"))
+		Expect(subStateOf(notify).State).To(Equal(sip.SubscriptionActive))
+		Expect(statusOf(notify).StatusCode()).To(Equal(sip.StatusCode(100)))
+
+		// AcceptRefer does not wait for the answer on NOTIFY with 100 Trying
+		var transfer *event.Transfer
+		Eventually(transfers).Should(Receive(&transfer))
+		Expect(transfer.ReferTo().Address.String()).To(Equal("sip:dave@example.com"))
+		_, err = peer.Respond(sip.NewResponseFromRequest(notify, 200, "OK", ""))
+		Expect(err).ToNot(HaveOccurred())
+
+		referred := make(chan sip.Response, 2)
+		referred <- sip.NewResponse("SIP/2.0", 180, "Ringing", nil, "")
+		referred <- sip.NewResponse("SIP/2.0", 486, "Busy Here", nil, "")
+		errs := make(chan error, 1)
+		go func() { errs <- transfer.Follow(referred) }()
+
+		notify = nextRequest(sip.NOTIFY)
+		Expect(subStateOf(notify).State).To(Equal(sip.SubscriptionActive))
+		Expect(statusOf(notify).StatusCode()).To(Equal(sip.StatusCode(180)))
+		_, err = peer.Respond(sip.NewResponseFromRequest(notify, 200, "OK", ""))
+		Expect(err).ToNot(HaveOccurred())
+
+		notify = nextRequest(sip.NOTIFY)
+		Expect(subStateOf(notify).State).To(Equal(sip.SubscriptionTerminated))
+		Expect(subStateOf(notify).Reason()).To(Equal("noresource"))
+		Expect(statusOf(notify).StartLine()).To(Equal("SIP/2.0 486 Busy Here"))
+		_, err = peer.Respond(sip.NewResponseFromRequest(notify, 200, "OK", ""))
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(errs).Should(Receive(BeNil()))
+		Eventually(transfer.Subscription().Done()).Should(BeClosed())
+		Expect(notifier.Subscriptions()).To(BeEmpty())
+
+		close(done)
+	}, 3)
+
+	It("should notify 100 Trying before the status reported at once", func(done Done) {
+		errs := make(chan error, 1)
+		Expect(notifierSrv.OnRequest(sip.REFER, func(req sip.Request) {
+			defer GinkgoRecover()
+			transfer, err := notifier.AcceptRefer(req, nil)
+			Expect(err).ToNot(HaveOccurred())
+			go func() { errs <- transfer.Notify(200, "OK") }()
+		})).To(Succeed())
+		responses, err := peer.Request(testutils.Request(referRequest("Refer-To: <sip:dave@example.com>")))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(finalResponse(responses).StatusCode()).To(Equal(sip.StatusCode(202)))
+
+		notify := nextRequest(sip.NOTIFY)
+		Expect(subStateOf(notify).State).To(Equal(sip.SubscriptionActive))
+		Expect(statusOf(notify).StatusCode()).To(Equal(sip.StatusCode(100)))
+		_, err = peer.Respond(sip.NewResponseFromRequest(notify, 200, "OK", ""))
+		Expect(err).ToNot(HaveOccurred())
+
+		notify = nextRequest(sip.NOTIFY)
+		Expect(subStateOf(notify).State).To(Equal(sip.SubscriptionTerminated))
+		Expect(statusOf(notify).StatusCode()).To(Equal(sip.StatusCode(200)))
+		_, err = peer.Respond(sip.NewResponseFromRequest(notify, 200, "OK", ""))
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(errs).Should(Receive(BeNil()))
+
+		close(done)
+	}, 3)
+
+	It("should not subscribe to REFER with Refer-Sub: false", func(done Done) {
+		transfers := acceptRefer(nil)
+		responses, err := peer.Request(testutils.Request(referRequest(
+			"Refer-To: <sip:dave@example.com>",
+			"Refer-Sub: false",
+		)))
+		Expect(err).ToNot(HaveOccurred())
+		res := finalResponse(responses)
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(202)))
+		referSub, ok := res.ReferSub()
+		Expect(ok).To(BeTrue())
+		Expect(bool(*referSub)).To(BeFalse())
+
+		var transfer *event.Transfer
+		Eventually(transfers).Should(Receive(&transfer))
+		Expect(transfer.Subscription()).To(BeNil())
+		Expect(transfer.Notify(200, "OK")).To(Succeed())
+		Consistently(peerReqs, 200*time.Millisecond).ShouldNot(Receive())
+		Expect(notifier.Subscriptions()).To(BeEmpty())
+
+		close(done)
+	}, 3)
+
+	It("should reject REFER without single Refer-To", func(done Done) {
+		acceptRefer(nil)
+		responses, err := peer.Request(testutils.Request(referRequest(
+			"Refer-To: <sip:dave@example.com>",
+			"Refer-To: <sip:erin@example.com>",
+		)))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(finalResponse(responses).StatusCode()).To(Equal(sip.StatusCode(400)))
+		Expect(notifier.Subscriptions()).To(BeEmpty())
+
+		close(done)
+	}, 3)
+
+	It("should refer inside dialog of another subscription", func(done Done) {
+		sub, err := subscriber.Subscribe(subscribeConfig("presence", time.Hour))
+		Expect(err).ToNot(HaveOccurred())
+		nextNotification(sub)
+		presenceDialog := notifier.Subscriptions()[0].Dialog()
+		transfers := acceptRefer(func() *dialog.Dialog { return presenceDialog })
+
+		referSub, err := subscriber.Refer(&event.ReferConfig{
+			Dialog:     sub.Dialog(),
+			ReferTo:    &sip.ReferToHeader{Address: uri("carol", 5110), Params: sip.NewParams()},
+			ReferredBy: &sip.ReferredByHeader{Address: uri("alice", 5106), Params: sip.NewParams()},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(referSub.Dialog()).To(BeIdenticalTo(sub.Dialog()))
+		Expect(statusOf(nextNotification(referSub)).StatusCode()).To(Equal(sip.StatusCode(100)))
+
+		var transfer *event.Transfer
+		Eventually(transfers).Should(Receive(&transfer))
+		referredBy, ok := transfer.Request().ReferredBy()
+		Expect(ok).To(BeTrue())
+		Expect(referredBy.Address.String()).To(Equal(uri("alice", 5106).String()))
+		Expect(notifier.Subscriptions()).To(HaveLen(2))
+		Expect(subscriber.Subscriptions()).To(HaveLen(2))
+
+		referred := make(chan sip.Response)
+		errs := make(chan error, 1)
+		go func() { errs <- transfer.Follow(referred) }()
+		referred <- sip.NewResponse("SIP/2.0", 180, "Ringing", nil, "")
+		Expect(statusOf(nextNotification(referSub)).StatusCode()).To(Equal(sip.StatusCode(180)))
+		referred <- sip.NewResponse("SIP/2.0", 200, "OK", nil, "")
+		notify := nextNotification(referSub)
+		Expect(subStateOf(notify).State).To(Equal(sip.SubscriptionTerminated))
+		Expect(statusOf(notify).StatusCode()).To(Equal(sip.StatusCode(200)))
+		Eventually(errs).Should(Receive(BeNil()))
+		Eventually(referSub.Done()).Should(BeClosed())
+
+		// the dialog outlives the implicit subscription
+		Eventually(notifier.Subscriptions).Should(HaveLen(1))
+		Expect(subscriber.Subscriptions()).To(HaveLen(1))
+		Expect(sub.Dialog().State()).To(Equal(dialog.Confirmed))
+		Expect(presenceDialog.State()).To(Equal(dialog.Confirmed))
+		status.Store("closed")
+		notifier.Notify("presence", uri("bob", 5108))
+		Expect(nextNotification(sub).Body()).To(Equal(`<presence status="closed"/>`))
+		Expect(sub.State()).To(Equal(sip.SubscriptionActive))
+
+		close(done)
+	}, 3)
+})
@@ -0,0 +1,541 @@
+package event
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/masterclock/gosip"
+	"github.com/masterclock/gosip/dialog"
+	"github.com/masterclock/gosip/log"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/timing"
+	"github.com/masterclock/gosip/util"
+)
+
+// NotifierConfig describes available options of the notifier.
+type NotifierConfig struct {
+	// Contact is the local target of the subscription dialogs, required.
+	Contact sip.ContactUri
+	// Packages are the supported event packages, required.
+	Packages *Registry
+}
+
+// Notifier accepts subscriptions to the event packages and sends NOTIFY requests
+// on creation, refresh and termination of the subscriptions (RFC 6665 - 4.2.).
+type Notifier struct {
+	srv      *gosip.Server
+	contact  sip.ContactUri
+	packages *Registry
+
+	mu *sync.Mutex
+	// subscriptions by dialog ID and event
+	subscriptions map[string]*ServerSubscription
+}
+
+// NewNotifier creates notifier handling SUBSCRIBE requests of the server.
+func NewNotifier(srv *gosip.Server, config *NotifierConfig) (*Notifier, error) {
+	if config == nil || config.Contact == nil || config.Packages == nil {
+		return nil, fmt.Errorf("contact and packages are required to create notifier")
+	}
+
+	n := &Notifier{
+		srv:           srv,
+		contact:       config.Contact.Clone().(sip.ContactUri),
+		packages:      config.Packages,
+		mu:            new(sync.Mutex),
+		subscriptions: make(map[string]*ServerSubscription),
+	}
+	if err := srv.OnRequest(sip.SUBSCRIBE, n.handleSubscribe); err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+func (n *Notifier) String() string {
+	return fmt.Sprintf("Notifier %s", n.contact)
+}
+
+// Packages returns the supported event packages.
+func (n *Notifier) Packages() *Registry {
+	return n.packages
+}
+
+// Subscriptions returns the active and pending subscriptions.
+func (n *Notifier) Subscriptions() []*ServerSubscription {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	subs := make([]*ServerSubscription, 0, len(n.subscriptions))
+	for _, sub := range n.subscriptions {
+		subs = append(subs, sub)
+	}
+
+	return subs
+}
+
+// Notify sends the current state to all subscriptions of the event type to the resource,
+// e.g. when presence of the user changes.
+func (n *Notifier) Notify(eventType string, resource sip.Uri) {
+	for _, sub := range n.Subscriptions() {
+		if sub.event.EventType != eventType || !sub.resource.Equals(resource) {
+			continue
+		}
+		if err := sub.Notify(); err != nil {
+			log.Warnf("%s failed to notify %s: %s", n, sub, err)
+		}
+	}
+}
+
+func (n *Notifier) handleSubscribe(req sip.Request) {
+	event, ok := req.Event()
+	if !ok {
+		n.respond(req, 400, "Missing Event Header")
+		return
+	}
+	if to, ok := req.To(); ok && sip.Tag(to.Params) != "" {
+		// implicit subscriptions of REFER are refreshed as well, so their package is not registered
+		n.refresh(req, event)
+		return
+	}
+	pkg, ok := n.packages.Get(event.EventType)
+	if !ok {
+		res := sip.NewResponseFromRequest(req, 489, "Bad Event", "")
+		res.AppendHeader(&sip.AllowEventsHeader{EventTypes: n.packages.EventTypes()})
+		n.send(res)
+		return
+	}
+
+	expires, ok := n.expiresOf(req, pkg)
+	if !ok {
+		return
+	}
+
+	sub := &ServerSubscription{
+		notifier:   n,
+		pkg:        pkg,
+		event:      event.Clone().(*sip.EventHeader),
+		resource:   req.Recipient().Clone(),
+		ownsDialog: true,
+		state:      sip.SubscriptionActive,
+		mu:         new(sync.Mutex),
+		notifyMu:   new(sync.Mutex),
+		done:       make(chan struct{}),
+	}
+	if pkg.Authorize != nil {
+		state, err := pkg.Authorize(sub, req)
+		if err != nil {
+			if rejection, ok := err.(*sip.Rejection); ok {
+				n.respond(req, rejection.StatusCode, rejection.Reason)
+				return
+			}
+			log.Errorf("%s failed to authorize %s: %s", n, req.Short(), err)
+			n.respond(req, 500, "Server Internal Error")
+			return
+		}
+		if state == sip.SubscriptionTerminated {
+			n.respond(req, 403, "Forbidden")
+			return
+		}
+		sub.state = state
+	}
+
+	res := n.newResponse(req, expires)
+	if to, ok := res.To(); ok {
+		if to.Params == nil {
+			to.Params = sip.NewParams()
+		}
+		to.Params.Add("tag", sip.String{Str: util.RandString(10)})
+	}
+	dlg, err := dialog.NewServerDialog(req, res)
+	if err != nil {
+		log.Warnf("%s failed to create dialog on %s: %s", n, req.Short(), err)
+		n.respond(req, 400, "Bad Request")
+		return
+	}
+	sub.dialog = dlg
+
+	if expires == 0 {
+		// fetch of the current state (RFC 6665 - 4.4.3.)
+		n.send(res)
+		if err := sub.Terminate("timeout"); err != nil {
+			log.Debugf("%s failed to notify %s: %s", n, sub, err)
+		}
+		return
+	}
+
+	sub.setExpires(expires)
+	n.mu.Lock()
+	n.subscriptions[subscriptionKey(dlg.ID(), sub.event)] = sub
+	n.mu.Unlock()
+	n.send(res)
+	log.Debugf("%s created %s", n, sub)
+
+	if err := sub.Notify(); err != nil {
+		log.Debugf("%s failed to notify %s: %s", n, sub, err)
+	}
+}
+
+// refresh handles SUBSCRIBE sent inside the dialog of the subscription,
+// zero expiration removes the subscription (RFC 6665 - 4.2.1.2., 4.2.1.3.).
+func (n *Notifier) refresh(req sip.Request, event *sip.EventHeader) {
+	sub, ok := n.match(req, event)
+	if !ok {
+		n.respond(req, 481, "Subscription Does Not Exist")
+		return
+	}
+	if err := sub.dialog.ReceiveRequest(req); err != nil {
+		log.Warnf("%s failed to receive %s: %s", n, req.Short(), err)
+		n.respond(req, 500, "Server Internal Error")
+		return
+	}
+	expires, ok := n.expiresOf(req, sub.pkg)
+	if !ok {
+		return
+	}
+
+	res := n.newResponse(req, expires)
+	sub.dialog.SendResponse(req, res)
+	n.send(res)
+
+	if expires == 0 {
+		if err := sub.Terminate("timeout"); err != nil {
+			log.Debugf("%s failed to notify %s: %s", n, sub, err)
+		}
+		return
+	}
+
+	sub.setExpires(expires)
+	log.Debugf("%s refreshed %s", n, sub)
+	if err := sub.Notify(); err != nil {
+		log.Debugf("%s failed to notify %s: %s", n, sub, err)
+	}
+}
+
+// expiresOf returns duration of the subscription requested by SUBSCRIBE, too long one is reduced.
+// Too brief duration is answered with 423 (RFC 6665 - 4.2.1.1.).
+func (n *Notifier) expiresOf(req sip.Request, pkg *Package) (time.Duration, bool) {
+	expires := pkg.defaultExpires()
+	if hdr, ok := req.Expires(); ok {
+		expires = hdr.Duration()
+	}
+
+	if expires > 0 && pkg.MinExpires > 0 && expires < pkg.MinExpires {
+		res := sip.NewResponseFromRequest(req, 423, "Interval Too Brief", "")
+		minExpires := sip.MinExpires(pkg.MinExpires / time.Second)
+		res.AppendHeader(&minExpires)
+		n.send(res)
+		return 0, false
+	}
+	if pkg.MaxExpires > 0 && expires > pkg.MaxExpires {
+		expires = pkg.MaxExpires
+	}
+
+	return expires, true
+}
+
+// newResponse builds 2xx response on SUBSCRIBE with the granted expiration (RFC 6665 - 4.2.1.1.).
+func (n *Notifier) newResponse(req sip.Request, expires time.Duration) sip.Response {
+	res := sip.NewResponseFromRequest(req, 200, "OK", "")
+	res.AppendHeader(&sip.ContactHeader{Address: n.contact.Clone().(sip.ContactUri)})
+	seconds := sip.Expires(expires / time.Second)
+	res.AppendHeader(&seconds)
+
+	return res
+}
+
+// match returns subscription of the request received inside the dialog.
+func (n *Notifier) match(req sip.Request, event *sip.EventHeader) (*ServerSubscription, bool) {
+	id, err := sip.MakeDialogIDFromMessage(req)
+	if err != nil {
+		return nil, false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	sub, ok := n.subscriptions[subscriptionKey(id, event)]
+	return sub, ok
+}
+
+// ownsDialog returns true if the dialog was created by a subscription of the notifier.
+func (n *Notifier) ownsDialog(dlg *dialog.Dialog) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, sub := range n.subscriptions {
+		if sub.dialog == dlg && sub.ownsDialog {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remove forgets the subscription, dialog is terminated with the last subscription of it
+// unless the dialog belongs to the application, e.g. INVITE dialog of REFER.
+func (n *Notifier) remove(sub *ServerSubscription) {
+	n.mu.Lock()
+	key := subscriptionKey(sub.dialog.ID(), sub.event)
+	if current, ok := n.subscriptions[key]; ok && current == sub {
+		delete(n.subscriptions, key)
+	}
+	shared := false
+	for _, other := range n.subscriptions {
+		if other.dialog == sub.dialog {
+			shared = true
+			break
+		}
+	}
+	n.mu.Unlock()
+
+	if !shared && sub.ownsDialog {
+		sub.dialog.Terminate()
+	}
+}
+
+func (n *Notifier) respond(req sip.Request, statusCode sip.StatusCode, reason string) {
+	n.send(sip.NewResponseFromRequest(req, statusCode, reason, ""))
+}
+
+func (n *Notifier) send(res sip.Response) {
+	if _, err := n.srv.Respond(res); err != nil {
+		log.Debugf("%s failed to respond with %s: %s", n, res.Short(), err)
+	}
+}
+
+// ServerSubscription is the subscription accepted by the notifier.
+type ServerSubscription struct {
+	notifier *Notifier
+	pkg      *Package
+	event    *sip.EventHeader
+	// Request-URI of the initial SUBSCRIBE
+	resource sip.Uri
+	dialog   *dialog.Dialog
+	// dialog is created by the subscription, so it is terminated along with it
+	ownsDialog bool
+	// transfer of the implicit subscription of REFER
+	transfer *Transfer
+
+	mu        *sync.Mutex
+	state     sip.SubscriptionState
+	expiresAt time.Time
+	timer     timing.Timer
+	// NOTIFY requests are sent one by one, so the subscriber receives the state changes in order
+	notifyMu *sync.Mutex
+	done     chan struct{}
+}
+
+func (sub *ServerSubscription) String() string {
+	return fmt.Sprintf("Subscription %s to %s", sub.event.EventType, sub.resource)
+}
+
+// Package returns the event package of the subscription.
+func (sub *ServerSubscription) Package() *Package {
+	return sub.pkg
+}
+
+// Event returns 'Event' header of the subscription.
+func (sub *ServerSubscription) Event() *sip.EventHeader {
+	return sub.event
+}
+
+// Resource returns the subscribed resource, i.e. Request-URI of the initial SUBSCRIBE.
+func (sub *ServerSubscription) Resource() sip.Uri {
+	return sub.resource
+}
+
+// Dialog returns dialog of the subscription.
+func (sub *ServerSubscription) Dialog() *dialog.Dialog {
+	return sub.dialog
+}
+
+func (sub *ServerSubscription) State() sip.SubscriptionState {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	return sub.state
+}
+
+// Expires returns the remaining duration of the subscription.
+func (sub *ServerSubscription) Expires() time.Duration {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.state == sip.SubscriptionTerminated {
+		return 0
+	}
+	if left := sub.expiresAt.Sub(timing.Now()); left > 0 {
+		return left
+	}
+
+	return 0
+}
+
+// Done returns chan closed when the subscription is terminated.
+func (sub *ServerSubscription) Done() <-chan struct{} {
+	return sub.done
+}
+
+// Notify sends NOTIFY with the current state of the resource.
+// Subscription is removed if the subscriber fails to accept NOTIFY (RFC 6665 - 4.2.2.).
+func (sub *ServerSubscription) Notify() error {
+	sub.notifyMu.Lock()
+	defer sub.notifyMu.Unlock()
+
+	return sub.notify()
+}
+
+// notify is Notify of the caller holding notifyMu.
+func (sub *ServerSubscription) notify() error {
+	sub.mu.Lock()
+	state := sub.state
+	sub.mu.Unlock()
+	if state == sip.SubscriptionTerminated {
+		return fmt.Errorf("%s is terminated", sub)
+	}
+
+	if err := sub.sendNotify(state, ""); err != nil {
+		sub.remove()
+		return err
+	}
+
+	return nil
+}
+
+// Activate makes the pending subscription active, e.g. when the subscriber gets authorized.
+func (sub *ServerSubscription) Activate() error {
+	sub.mu.Lock()
+	if sub.state != sip.SubscriptionPending {
+		sub.mu.Unlock()
+		return fmt.Errorf("%s is not pending", sub)
+	}
+	sub.state = sip.SubscriptionActive
+	sub.mu.Unlock()
+
+	return sub.Notify()
+}
+
+// Terminate sends the final NOTIFY with the reason, e.g. 'rejected', 'noresource' or 'deactivated'.
+func (sub *ServerSubscription) Terminate(reason string) error {
+	sub.notifyMu.Lock()
+	defer sub.notifyMu.Unlock()
+
+	return sub.terminate(reason)
+}
+
+// terminate is Terminate of the caller holding notifyMu.
+func (sub *ServerSubscription) terminate(reason string) error {
+	sub.mu.Lock()
+	terminated := sub.state == sip.SubscriptionTerminated
+	sub.state = sip.SubscriptionTerminated
+	sub.mu.Unlock()
+	if terminated {
+		return fmt.Errorf("%s is terminated", sub)
+	}
+
+	defer sub.remove()
+	return sub.sendNotify(sip.SubscriptionTerminated, reason)
+}
+
+func (sub *ServerSubscription) setExpires(expires time.Duration) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	sub.expiresAt = timing.Now().Add(expires)
+	if sub.timer != nil {
+		sub.timer.Stop()
+	}
+	sub.timer = timing.AfterFunc(expires, func() {
+		log.Debugf("%s expired", sub)
+		if err := sub.Terminate("timeout"); err != nil {
+			log.Debugf("%s failed to terminate %s: %s", sub.notifier, sub, err)
+		}
+	})
+}
+
+// remove forgets the terminated subscription without NOTIFY.
+func (sub *ServerSubscription) remove() {
+	sub.mu.Lock()
+	sub.state = sip.SubscriptionTerminated
+	if sub.timer != nil {
+		sub.timer.Stop()
+	}
+	select {
+	case <-sub.done:
+		sub.mu.Unlock()
+		return
+	default:
+		close(sub.done)
+	}
+	sub.mu.Unlock()
+
+	sub.notifier.remove(sub)
+	log.Debugf("%s removed %s", sub.notifier, sub)
+}
+
+// sendNotify sends NOTIFY with the state of the subscription and the resource (RFC 6665 - 4.2.2.)
+// and waits for the final response.
+func (sub *ServerSubscription) sendNotify(state sip.SubscriptionState, reason string) error {
+	req, err := sub.dialog.NewRequest(sip.NOTIFY)
+	if err != nil {
+		return err
+	}
+	req.AppendHeader(sub.event.Clone())
+
+	params := sip.NewParams()
+	switch state {
+	case sip.SubscriptionTerminated:
+		if reason != "" {
+			params.Add("reason", sip.String{Str: reason})
+		}
+	default:
+		params.Add("expires", sip.String{Str: fmt.Sprintf("%d", sub.Expires()/time.Second)})
+	}
+	req.AppendHeader(&sip.SubscriptionStateHeader{State: state, Params: params})
+
+	if sub.pkg.State != nil {
+		body, err := sub.pkg.State(sub)
+		if err != nil {
+			return fmt.Errorf("failed to get state of %s: %s", sub, err)
+		}
+		if body != "" {
+			if sub.pkg.ContentType != "" {
+				req.AppendHeader(sip.NewContentTypeHeader(sub.pkg.ContentType))
+			}
+			req.SetBody(body, true)
+		}
+	}
+
+	res, err := request(sub.notifier.srv, req)
+	if err != nil {
+		return err
+	}
+	sub.dialog.ReceiveResponse(req, res)
+	if !res.IsSuccess() {
+		return fmt.Errorf("subscriber responded with %d %s", res.StatusCode(), res.Reason())
+	}
+
+	return nil
+}
+
+// request sends the request and waits for the final response.
+func request(srv *gosip.Server, req sip.Request) (sip.Response, error) {
+	responses, err := srv.Request(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for res := range responses {
+		if !res.IsProvisional() {
+			return res, nil
+		}
+	}
+
+	return nil, fmt.Errorf("transaction of %s terminated without final response", req.Short())
+}
+
+func subscriptionKey(dialogID string, event *sip.EventHeader) string {
+	return dialogID + "__" + event.EventType + ";" + event.ID()
+}
@@ -0,0 +1,129 @@
+// event package implements SIP-specific event notification (RFC 6665):
+// subscriptions of the notifier and the subscriber to the pluggable event packages.
+package event
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/masterclock/gosip/sip"
+)
+
+// DefaultExpires is the subscription duration of the packages without own default.
+const DefaultExpires = 3600 * time.Second
+
+// AuthorizeFunc decides whether the new subscription is active or pending.
+// Returned sip.Rejection answers SUBSCRIBE with the status, any other error is answered with 500.
+type AuthorizeFunc func(sub *ServerSubscription, req sip.Request) (sip.SubscriptionState, error)
+
+// StateFunc returns the current state of the subscribed resource sent in NOTIFY body.
+// Empty state is sent as NOTIFY without body.
+type StateFunc func(sub *ServerSubscription) (string, error)
+
+// Package describes the event package (RFC 6665 - 7.), e.g. presence, dialog or message-summary.
+type Package struct {
+	// Name is the event type of the package, e.g. 'presence' or 'presence.winfo', required.
+	Name string
+	// ContentType is the media type of NOTIFY bodies, e.g. 'application/pidf+xml'.
+	ContentType string
+	// DefaultExpires is the duration of SUBSCRIBE without 'Expires' header, defaults to DefaultExpires.
+	DefaultExpires time.Duration
+	// MinExpires rejects too brief subscriptions with 423, zero means no limit.
+	MinExpires time.Duration
+	// MaxExpires reduces too long subscriptions, zero means no limit.
+	MaxExpires time.Duration
+	// Authorize is called for each new subscription, nil accepts all of them as active.
+	Authorize AuthorizeFunc
+	// State is called for each NOTIFY, nil sends NOTIFY without body.
+	State StateFunc
+}
+
+func (pkg *Package) String() string {
+	return fmt.Sprintf("Event package %s", pkg.Name)
+}
+
+func (pkg *Package) defaultExpires() time.Duration {
+	if pkg.DefaultExpires > 0 {
+		return pkg.DefaultExpires
+	}
+
+	return DefaultExpires
+}
+
+// Presence returns presence event package with PIDF bodies (RFC 3856).
+func Presence(state StateFunc) *Package {
+	return &Package{Name: "presence", ContentType: "application/pidf+xml", State: state}
+}
+
+// Dialog returns dialog event package with dialog-info bodies (RFC 4235).
+func Dialog(state StateFunc) *Package {
+	return &Package{Name: "dialog", ContentType: "application/dialog-info+xml", State: state}
+}
+
+// MessageSummary returns message waiting indication event package (RFC 3842).
+func MessageSummary(state StateFunc) *Package {
+	return &Package{Name: "message-summary", ContentType: "application/simple-message-summary", State: state}
+}
+
+// Registry holds the event packages supported by the notifier.
+type Registry struct {
+	mu       *sync.RWMutex
+	packages map[string]*Package
+	// names of the packages in order of registration
+	names []string
+}
+
+// NewRegistry creates registry of the packages.
+func NewRegistry(packages ...*Package) (*Registry, error) {
+	r := &Registry{
+		mu:       new(sync.RWMutex),
+		packages: make(map[string]*Package),
+		names:    make([]string, 0),
+	}
+	for _, pkg := range packages {
+		if err := r.Register(pkg); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Register adds the package, event type must be unique.
+func (r *Registry) Register(pkg *Package) error {
+	if pkg == nil || pkg.Name == "" {
+		return fmt.Errorf("event package must have a name")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.packages[pkg.Name]; ok {
+		return fmt.Errorf("%s is already registered", pkg)
+	}
+	r.packages[pkg.Name] = pkg
+	r.names = append(r.names, pkg.Name)
+
+	return nil
+}
+
+// Get returns the package of the event type.
+func (r *Registry) Get(eventType string) (*Package, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	pkg, ok := r.packages[eventType]
+	return pkg, ok
+}
+
+// EventTypes returns names of the registered packages, e.g. for 'Allow-Events' header.
+func (r *Registry) EventTypes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, len(r.names))
+	copy(names, r.names)
+
+	return names
+}
@@ -0,0 +1,295 @@
+package event
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/masterclock/gosip/dialog"
+	"github.com/masterclock/gosip/log"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/util"
+)
+
+// referPackage is the event package of the implicit subscriptions created by REFER (RFC 3515 - 2.4.4.).
+// NOTIFY bodies report the status line of the response on the referred request.
+var referPackage = &Package{
+	Name:        "refer",
+	ContentType: sip.SipFragContentType,
+	State: func(sub *ServerSubscription) (string, error) {
+		if sub.transfer == nil {
+			return "", fmt.Errorf("%s has no transfer", sub)
+		}
+
+		return sub.transfer.frag(), nil
+	},
+}
+
+// Transfer is REFER accepted by the notifier, the recipient of REFER sends the referred request
+// and reports its progress with Notify or Follow (RFC 3515 - 2.4.).
+type Transfer struct {
+	request sip.Request
+	referTo *sip.ReferToHeader
+	// implicit subscription, nil if it is suppressed by 'Refer-Sub: false' (RFC 4488 - 4.)
+	sub *ServerSubscription
+
+	mu         *sync.Mutex
+	statusCode sip.StatusCode
+	reason     string
+}
+
+// AcceptRefer answers REFER with 202 and creates the implicit subscription which is notified
+// with '100 Trying' in background, without waiting for the answer on NOTIFY. Statuses reported by
+// the transfer are notified after it. REFER received inside the existing dialog, e.g. INVITE dialog,
+// is passed with it, otherwise the new dialog is created. REFER without single 'Refer-To' header
+// is answered with 400.
+func (n *Notifier) AcceptRefer(req sip.Request, dlg *dialog.Dialog) (*Transfer, error) {
+	referTo, ok := req.ReferTo()
+	if !ok || len(req.GetHeaders("Refer-To")) != 1 {
+		n.respond(req, 400, "Bad Refer-To Header")
+		return nil, fmt.Errorf("%s must have single 'Refer-To' header", req.Short())
+	}
+	cseq, ok := req.CSeq()
+	if !ok {
+		n.respond(req, 400, "Bad Request")
+		return nil, fmt.Errorf("missing 'CSeq' header in %s", req.Short())
+	}
+
+	transfer := &Transfer{
+		request:    req,
+		referTo:    referTo,
+		mu:         new(sync.Mutex),
+		statusCode: 100,
+		reason:     "Trying",
+	}
+	referSub, ok := req.ReferSub()
+	subscribe := !ok || bool(*referSub)
+
+	res := sip.NewResponseFromRequest(req, 202, "Accepted", "")
+	res.AppendHeader(&sip.ContactHeader{Address: n.contact.Clone().(sip.ContactUri)})
+	if !subscribe {
+		noSub := sip.ReferSub(false)
+		res.AppendHeader(&noSub)
+	}
+
+	ownsDialog := false
+	if dlg != nil {
+		if err := dlg.ReceiveRequest(req); err != nil {
+			n.respond(req, 500, "Server Internal Error")
+			return nil, err
+		}
+		ownsDialog = n.ownsDialog(dlg)
+		dlg.SendResponse(req, res)
+	} else {
+		if to, ok := res.To(); ok && sip.Tag(to.Params) == "" {
+			if to.Params == nil {
+				to.Params = sip.NewParams()
+			}
+			to.Params.Add("tag", sip.String{Str: util.RandString(10)})
+		}
+		if subscribe {
+			var err error
+			if dlg, err = dialog.NewServerDialog(req, res); err != nil {
+				n.respond(req, 400, "Bad Request")
+				return nil, err
+			}
+			ownsDialog = true
+		}
+	}
+
+	if !subscribe {
+		n.send(res)
+		log.Debugf("%s accepted %s without subscription", n, req.Short())
+		return transfer, nil
+	}
+
+	sub := &ServerSubscription{
+		notifier: n,
+		pkg:      referPackage,
+		// each REFER of the dialog creates own subscription (RFC 3515 - 2.4.6.)
+		event: &sip.EventHeader{
+			EventType: referPackage.Name,
+			Params:    sip.NewParams().Add("id", sip.String{Str: fmt.Sprintf("%d", cseq.SeqNo)}),
+		},
+		resource:   req.Recipient().Clone(),
+		dialog:     dlg,
+		ownsDialog: ownsDialog,
+		transfer:   transfer,
+		state:      sip.SubscriptionActive,
+		mu:         new(sync.Mutex),
+		notifyMu:   new(sync.Mutex),
+		done:       make(chan struct{}),
+	}
+	transfer.sub = sub
+
+	sub.setExpires(referPackage.defaultExpires())
+	n.mu.Lock()
+	n.subscriptions[subscriptionKey(dlg.ID(), sub.event)] = sub
+	n.mu.Unlock()
+	n.send(res)
+	log.Debugf("%s created %s", n, sub)
+
+	// REFER handler is not blocked by the transaction of NOTIFY, the lock is taken here,
+	// so the initial NOTIFY precedes the statuses reported by the application
+	sub.notifyMu.Lock()
+	go func() {
+		defer sub.notifyMu.Unlock()
+		if err := sub.notify(); err != nil {
+			log.Debugf("%s failed to notify %s: %s", n, sub, err)
+		}
+	}()
+
+	return transfer, nil
+}
+
+func (t *Transfer) String() string {
+	return fmt.Sprintf("Transfer to %s", t.referTo.Address)
+}
+
+// Request returns the accepted REFER.
+func (t *Transfer) Request() sip.Request {
+	return t.request
+}
+
+// ReferTo returns 'Refer-To' header of REFER, i.e. the target of the referred request.
+func (t *Transfer) ReferTo() *sip.ReferToHeader {
+	return t.referTo
+}
+
+// Subscription returns the implicit subscription of REFER, nil if the referrer suppressed it.
+func (t *Transfer) Subscription() *ServerSubscription {
+	return t.sub
+}
+
+// Notify reports the status of the referred request to the referrer. Final status terminates
+// the implicit subscription (RFC 3515 - 2.4.5.), without subscription the status is only stored.
+func (t *Transfer) Notify(statusCode sip.StatusCode, reason string) error {
+	if t.sub == nil {
+		t.setStatus(statusCode, reason)
+		return nil
+	}
+
+	// status is changed under the lock of NOTIFY, so the pending NOTIFY carries the previous one
+	t.sub.notifyMu.Lock()
+	defer t.sub.notifyMu.Unlock()
+	t.setStatus(statusCode, reason)
+	if statusCode >= 200 {
+		return t.sub.terminate("noresource")
+	}
+
+	return t.sub.notify()
+}
+
+func (t *Transfer) setStatus(statusCode sip.StatusCode, reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.statusCode = statusCode
+	t.reason = reason
+}
+
+// Follow reports the responses on the referred request, e.g. returned by Server.Request,
+// till the final one. Transaction terminated without final response is reported as 408.
+func (t *Transfer) Follow(responses <-chan sip.Response) error {
+	var err error
+	for res := range responses {
+		if res.StatusCode() == 100 {
+			// already reported on accepting of REFER
+			continue
+		}
+		if notifyErr := t.Notify(res.StatusCode(), res.Reason()); notifyErr != nil && err == nil {
+			err = notifyErr
+		}
+		if !res.IsProvisional() {
+			return err
+		}
+	}
+
+	if notifyErr := t.Notify(408, "Request Timeout"); notifyErr != nil && err == nil {
+		err = notifyErr
+	}
+
+	return err
+}
+
+func (t *Transfer) frag() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return sip.StatusFrag(t.statusCode, t.reason)
+}
+
+// ReferConfig describes REFER sent by Subscriber.Refer.
+type ReferConfig struct {
+	// Dialog is the dialog of REFER, e.g. INVITE dialog of the transferred call, required.
+	Dialog *dialog.Dialog
+	// ReferTo is the target of the referred request, required.
+	ReferTo *sip.ReferToHeader
+	// ReferredBy identifies the referrer to the target (RFC 3892), may be nil.
+	ReferredBy *sip.ReferredByHeader
+	// NoSubscription asks the recipient not to create the implicit subscription (RFC 4488).
+	NoSubscription bool
+}
+
+// Refer sends REFER inside the dialog and waits for the final response (RFC 3515 - 2.4.1.).
+// Returns the implicit subscription notified with the progress of the referred request, NOTIFY bodies
+// are message/sipfrag parsed with parser.ParseSipFrag. Subscription is nil if the recipient accepted
+// 'Refer-Sub: false'. Rejected REFER is returned as SubscribeError.
+func (s *Subscriber) Refer(config *ReferConfig) (*ClientSubscription, error) {
+	if config == nil || config.Dialog == nil || config.ReferTo == nil {
+		return nil, fmt.Errorf("dialog and refer-to are required to refer")
+	}
+
+	dlg := config.Dialog
+	req, err := dlg.NewRequest(sip.REFER)
+	if err != nil {
+		return nil, err
+	}
+	req.AppendHeader(config.ReferTo.Clone())
+	if config.ReferredBy != nil {
+		req.AppendHeader(config.ReferredBy.Clone())
+	}
+	if config.NoSubscription {
+		noSub := sip.ReferSub(false)
+		req.AppendHeader(&noSub)
+	}
+
+	cseq, _ := req.CSeq()
+	event := &sip.EventHeader{
+		EventType: referPackage.Name,
+		Params:    sip.NewParams().Add("id", sip.String{Str: fmt.Sprintf("%d", cseq.SeqNo)}),
+	}
+	// subscription is known before sending, since NOTIFY may outrun 2xx response
+	sub := newClientSubscription(s, dlg, event, DefaultExpires, []string{sip.SipFragContentType})
+	sub.ownsDialog = s.ownsDialog(dlg)
+	s.mu.Lock()
+	s.subscriptions[subscriptionKey(dlg.ID(), sub.event)] = sub
+	s.mu.Unlock()
+
+	res, err := request(s.srv, req)
+	if err != nil {
+		sub.terminate(err, nil)
+		return nil, err
+	}
+	if !res.IsSuccess() {
+		err := &SubscribeError{Response: res}
+		sub.terminate(err, nil)
+		return nil, err
+	}
+	dlg.ReceiveResponse(req, res)
+
+	if referSub, ok := res.ReferSub(); ok && !bool(*referSub) {
+		sub.terminate(nil, nil)
+		log.Debugf("%s referred to %s without subscription", s, config.ReferTo.Address)
+		return nil, nil
+	}
+
+	expires := DefaultExpires
+	if hdr, ok := res.Expires(); ok {
+		expires = hdr.Duration()
+	}
+	sub.setExpires(expires)
+	sub.awaitNotify()
+	log.Debugf("%s established %s", s, sub)
+
+	return sub, nil
+}
@@ -0,0 +1,758 @@
+package event
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masterclock/gosip"
+	"github.com/masterclock/gosip/dialog"
+	"github.com/masterclock/gosip/log"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/timing"
+	"github.com/masterclock/gosip/transaction"
+	"github.com/masterclock/gosip/util"
+)
+
+const (
+	// forkTimeout limits waiting for NOTIFY requests of the forked SUBSCRIBE
+	// and for NOTIFY after 2xx response (RFC 6665 - 4.1.2.4.).
+	forkTimeout = 64 * transaction.T1
+	// maxSubscribeAttempts limits SUBSCRIBE requests with raised expiration on 423 response.
+	maxSubscribeAttempts = 3
+)
+
+// SubscribeConfig describes the subscription created by Subscriber.Subscribe.
+type SubscribeConfig struct {
+	// Target is Request-URI of SUBSCRIBE, i.e. the subscribed resource, required.
+	Target sip.Uri
+	// From is the address of the subscriber, required.
+	From *sip.Address
+	// To is the address of the resource, defaults to Target if it is SIP URI.
+	To *sip.Address
+	// Event is the event package, e.g. 'presence', required.
+	Event string
+	// ID distinguishes subscriptions to the same package in one dialog, may be empty.
+	ID string
+	// Expires is the requested duration of the subscription, defaults to DefaultExpires.
+	Expires time.Duration
+	// Accept lists media types of NOTIFY bodies, e.g. 'application/pidf+xml', may be empty.
+	Accept []string
+	// OnFork is called for each subscription established by NOTIFY of another notifier
+	// when SUBSCRIBE forks, such subscriptions are unsubscribed if it is nil.
+	OnFork func(sub *ClientSubscription)
+}
+
+// SubscribeError is returned by Subscribe when the notifier rejects SUBSCRIBE,
+// e.g. 489 response lists the supported packages in 'Allow-Events' header.
+type SubscribeError struct {
+	Response sip.Response
+}
+
+func (err *SubscribeError) Error() string {
+	return fmt.Sprintf("notifier responded with %d %s", err.Response.StatusCode(), err.Response.Reason())
+}
+
+// Subscriber sends SUBSCRIBE requests and accepts NOTIFY requests of the subscriptions (RFC 6665 - 4.1.).
+// One SUBSCRIBE may establish several subscriptions when it forks, each of them has own dialog.
+type Subscriber struct {
+	srv     *gosip.Server
+	contact sip.ContactUri
+
+	mu *sync.Mutex
+	// SUBSCRIBE requests which may still establish subscriptions, by Call-ID and local tag
+	attempts map[string]*subscribeAttempt
+	// subscriptions by dialog ID and event
+	subscriptions map[string]*ClientSubscription
+}
+
+// subscribeAttempt is SUBSCRIBE waiting for the final response and NOTIFY requests of the notifiers.
+type subscribeAttempt struct {
+	config  *SubscribeConfig
+	event   *sip.EventHeader
+	callID  sip.CallID
+	fromTag string
+	cseq    uint32
+	expires time.Duration
+	// the last sent SUBSCRIBE
+	req sip.Request
+	// 2xx response is received, so NOTIFY with a new tag comes from another notifier
+	established bool
+	// subscriptions established by NOTIFY before the final response
+	early []*ClientSubscription
+}
+
+// NewSubscriber creates subscriber handling NOTIFY requests of the server.
+// Contact is the local target of the subscription dialogs.
+func NewSubscriber(srv *gosip.Server, contact sip.ContactUri) (*Subscriber, error) {
+	if contact == nil {
+		return nil, fmt.Errorf("contact is required to create subscriber")
+	}
+
+	s := &Subscriber{
+		srv:           srv,
+		contact:       contact.Clone().(sip.ContactUri),
+		mu:            new(sync.Mutex),
+		attempts:      make(map[string]*subscribeAttempt),
+		subscriptions: make(map[string]*ClientSubscription),
+	}
+	if err := srv.OnRequest(sip.NOTIFY, s.handleNotify); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Subscriber) String() string {
+	return fmt.Sprintf("Subscriber %s", s.contact)
+}
+
+// Subscriptions returns the subscriptions which are not terminated yet.
+func (s *Subscriber) Subscriptions() []*ClientSubscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subs := make([]*ClientSubscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		subs = append(subs, sub)
+	}
+
+	return subs
+}
+
+// Subscribe sends SUBSCRIBE and waits for the final response, too brief expiration is raised
+// up to Min-Expires of 423 response. Returns subscription of 2xx response, the other subscriptions
+// established by NOTIFY requests of the forked SUBSCRIBE are passed to OnFork of the config.
+// Rejected SUBSCRIBE is returned as SubscribeError.
+func (s *Subscriber) Subscribe(config *SubscribeConfig) (*ClientSubscription, error) {
+	if config == nil || config.Target == nil || config.From == nil || config.Event == "" {
+		return nil, fmt.Errorf("target, from and event are required to subscribe")
+	}
+	cfg := *config
+	if cfg.To == nil {
+		uri, ok := cfg.Target.(*sip.SipUri)
+		if !ok {
+			return nil, fmt.Errorf("to is required to subscribe to %s", cfg.Target)
+		}
+		cfg.To = &sip.Address{Uri: uri}
+	}
+	if cfg.Expires <= 0 {
+		cfg.Expires = DefaultExpires
+	}
+
+	event := &sip.EventHeader{EventType: cfg.Event, Params: sip.NewParams()}
+	if cfg.ID != "" {
+		event.Params.Add("id", sip.String{Str: cfg.ID})
+	}
+	attempt := &subscribeAttempt{
+		config:  &cfg,
+		event:   event,
+		callID:  sip.CallID(util.RandString(32)),
+		fromTag: util.RandString(10),
+		expires: cfg.Expires,
+	}
+
+	s.mu.Lock()
+	s.attempts[attempt.key()] = attempt
+	s.mu.Unlock()
+
+	for i := 0; i < maxSubscribeAttempts; i++ {
+		req := attempt.newRequest(s.contact)
+		s.mu.Lock()
+		attempt.req = req
+		s.mu.Unlock()
+
+		res, err := request(s.srv, req)
+		if err != nil {
+			s.fail(attempt, err)
+			return nil, err
+		}
+
+		switch {
+		case res.IsSuccess():
+			return s.establish(attempt, req, res)
+		case res.StatusCode() == 423:
+			minExpires, ok := res.MinExpires()
+			if !ok || minExpires.Duration() <= attempt.expires {
+				err := fmt.Errorf("notifier responded with 423 and invalid 'Min-Expires'")
+				s.fail(attempt, err)
+				return nil, err
+			}
+			log.Debugf("%s raises expiration of %s up to %s", s, req.Short(), minExpires.Duration())
+			attempt.expires = minExpires.Duration()
+		default:
+			err := &SubscribeError{Response: res}
+			s.fail(attempt, err)
+			return nil, err
+		}
+	}
+
+	err := fmt.Errorf("notifier rejected expiration %s", attempt.expires)
+	s.fail(attempt, err)
+	return nil, err
+}
+
+// establish creates subscription of 2xx response, NOTIFY requests of the other notifiers
+// are accepted as forks till the fork timeout.
+func (s *Subscriber) establish(attempt *subscribeAttempt, req sip.Request, res sip.Response) (*ClientSubscription, error) {
+	expires := attempt.expires
+	if hdr, ok := res.Expires(); ok {
+		expires = hdr.Duration()
+	}
+
+	s.mu.Lock()
+	attempt.established = true
+	early := attempt.early
+	attempt.early = nil
+
+	var sub *ClientSubscription
+	if to, ok := res.To(); ok {
+		id := sip.MakeDialogID(string(attempt.callID), attempt.fromTag, sip.Tag(to.Params))
+		sub = s.subscriptions[subscriptionKey(id, attempt.event)]
+	}
+	if sub == nil {
+		dlg, err := dialog.NewClientDialog(req, res)
+		if err != nil {
+			s.mu.Unlock()
+			s.fail(attempt, err)
+			return nil, err
+		}
+		sub = newClientSubscription(s, dlg, attempt.event, attempt.expires, attempt.config.Accept)
+		s.subscriptions[subscriptionKey(dlg.ID(), sub.event)] = sub
+		sub.awaitNotify()
+	} else {
+		sub.dialog.ReceiveResponse(req, res)
+	}
+	s.mu.Unlock()
+
+	sub.setExpires(expires)
+	for _, fork := range early {
+		if fork != sub {
+			s.fork(attempt, fork)
+		}
+	}
+	timing.AfterFunc(forkTimeout, func() {
+		s.mu.Lock()
+		delete(s.attempts, attempt.key())
+		s.mu.Unlock()
+	})
+	log.Debugf("%s established %s", s, sub)
+
+	return sub, nil
+}
+
+// fail forgets the failed SUBSCRIBE, subscriptions established by NOTIFY requests are terminated.
+func (s *Subscriber) fail(attempt *subscribeAttempt, err error) {
+	s.mu.Lock()
+	delete(s.attempts, attempt.key())
+	early := attempt.early
+	attempt.early = nil
+	s.mu.Unlock()
+
+	for _, sub := range early {
+		sub.terminate(err, nil)
+	}
+}
+
+// fork passes the subscription of another notifier to OnFork or unsubscribes it.
+func (s *Subscriber) fork(attempt *subscribeAttempt, sub *ClientSubscription) {
+	if attempt.config.OnFork != nil {
+		go attempt.config.OnFork(sub)
+		return
+	}
+
+	log.Debugf("%s unsubscribes forked %s", s, sub)
+	go func() {
+		if err := sub.Unsubscribe(); err != nil {
+			log.Debugf("%s failed to unsubscribe %s: %s", s, sub, err)
+		}
+	}()
+}
+
+func (s *Subscriber) handleNotify(req sip.Request) {
+	event, ok := req.Event()
+	if !ok {
+		s.respond(req, 400, "Missing Event Header")
+		return
+	}
+	subState, ok := req.SubscriptionState()
+	if !ok {
+		s.respond(req, 400, "Missing Subscription-State Header")
+		return
+	}
+	id, err := sip.MakeDialogIDFromMessage(req)
+	if err != nil {
+		s.respond(req, 481, "Subscription Does Not Exist")
+		return
+	}
+
+	s.mu.Lock()
+	if sub, ok := s.subscriptions[subscriptionKey(id, event)]; ok {
+		s.mu.Unlock()
+		sub.receiveNotify(req, subState, false)
+		return
+	}
+	for _, sub := range s.subscriptions {
+		if sub.dialog.ID() == id {
+			// the dialog is known, but the event is not (RFC 6665 - 4.1.3.)
+			s.mu.Unlock()
+			s.respond(req, 489, "Bad Event")
+			return
+		}
+	}
+
+	to, _ := req.To()
+	callID, _ := req.CallID()
+	attempt, ok := s.attempts[string(*callID)+"__"+sip.Tag(to.Params)]
+	if !ok {
+		s.mu.Unlock()
+		s.respond(req, 481, "Subscription Does Not Exist")
+		return
+	}
+	if !attempt.event.Matches(event) {
+		s.mu.Unlock()
+		s.respond(req, 489, "Bad Event")
+		return
+	}
+	// NOTIFY arrived before 2xx response or from another notifier of the forked SUBSCRIBE
+	dlg, err := dialog.NewSubscriberDialog(attempt.req, req)
+	if err != nil {
+		s.mu.Unlock()
+		log.Warnf("%s failed to create dialog on %s: %s", s, req.Short(), err)
+		s.respond(req, 400, "Bad Request")
+		return
+	}
+	sub := newClientSubscription(s, dlg, attempt.event, attempt.expires, attempt.config.Accept)
+	s.subscriptions[subscriptionKey(dlg.ID(), sub.event)] = sub
+	forked := attempt.established
+	if !forked {
+		attempt.early = append(attempt.early, sub)
+	}
+	s.mu.Unlock()
+
+	if forked {
+		s.fork(attempt, sub)
+	}
+	sub.receiveNotify(req, subState, true)
+}
+
+// ownsDialog returns true if the dialog was created by a subscription of the subscriber.
+func (s *Subscriber) ownsDialog(dlg *dialog.Dialog) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sub := range s.subscriptions {
+		if sub.dialog == dlg && sub.ownsDialog {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remove forgets the subscription, dialog is terminated with the last subscription of it
+// unless the dialog belongs to the application, e.g. INVITE dialog of REFER.
+func (s *Subscriber) remove(sub *ClientSubscription) {
+	s.mu.Lock()
+	key := subscriptionKey(sub.dialog.ID(), sub.event)
+	if current, ok := s.subscriptions[key]; ok && current == sub {
+		delete(s.subscriptions, key)
+	}
+	shared := false
+	for _, other := range s.subscriptions {
+		if other.dialog == sub.dialog {
+			shared = true
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if !shared && sub.ownsDialog {
+		sub.dialog.Terminate()
+	}
+}
+
+func (s *Subscriber) respond(req sip.Request, statusCode sip.StatusCode, reason string) {
+	res := sip.NewResponseFromRequest(req, statusCode, reason, "")
+	if _, err := s.srv.Respond(res); err != nil {
+		log.Debugf("%s failed to respond with %s: %s", s, res.Short(), err)
+	}
+}
+
+func (attempt *subscribeAttempt) key() string {
+	return string(attempt.callID) + "__" + attempt.fromTag
+}
+
+func (attempt *subscribeAttempt) newRequest(contact sip.ContactUri) sip.Request {
+	attempt.cseq++
+
+	config := attempt.config
+	from := &sip.FromHeader{
+		DisplayName: config.From.DisplayName,
+		Address:     config.From.Uri.Clone(),
+		Params:      sip.NewParams().Add("tag", sip.String{Str: attempt.fromTag}),
+	}
+	to := &sip.ToHeader{
+		DisplayName: config.To.DisplayName,
+		Address:     config.To.Uri.Clone(),
+		Params:      sip.NewParams(),
+	}
+	maxForwards := sip.MaxForwards(70)
+	seconds := sip.Expires(attempt.expires / time.Second)
+	callID := attempt.callID
+
+	hdrs := []sip.Header{
+		from,
+		to,
+		&callID,
+		&sip.CSeq{SeqNo: attempt.cseq, MethodName: sip.SUBSCRIBE},
+		&maxForwards,
+		&sip.ContactHeader{Address: contact.Clone().(sip.ContactUri)},
+		attempt.event.Clone(),
+		&seconds,
+	}
+	if len(config.Accept) > 0 {
+		hdrs = append(hdrs, acceptHeader(config.Accept))
+	}
+
+	return sip.NewRequest(sip.SUBSCRIBE, config.Target.Clone(), "SIP/2.0", hdrs, "")
+}
+
+func acceptHeader(mediaTypes []string) *sip.AcceptHeader {
+	accept := &sip.AcceptHeader{MediaRanges: make([]*sip.MediaRange, 0, len(mediaTypes))}
+	for _, mediaType := range mediaTypes {
+		parts := strings.SplitN(mediaType, "/", 2)
+		mediaRange := &sip.MediaRange{Type: parts[0], SubType: "*", Params: sip.NewParams()}
+		if len(parts) == 2 {
+			mediaRange.SubType = parts[1]
+		}
+		accept.MediaRanges = append(accept.MediaRanges, mediaRange)
+	}
+
+	return accept
+}
+
+// ClientSubscription is the subscription established by the subscriber.
+// It is refreshed at the half of the expiration until it is terminated by the notifier,
+// unsubscribed or expired.
+type ClientSubscription struct {
+	subscriber *Subscriber
+	dialog     *dialog.Dialog
+	// dialog is created by the subscription, so it is terminated along with it
+	ownsDialog bool
+	event      *sip.EventHeader
+	// requested duration of the subscription
+	expires time.Duration
+	accept  []string
+
+	mu            *sync.Mutex
+	state         sip.SubscriptionState
+	reason        string
+	retryAfter    time.Duration
+	err           error
+	notified      bool
+	unsubscribing bool
+	expiresAt     time.Time
+	refreshTimer  timing.Timer
+	expireTimer   timing.Timer
+	// nmu guards sending to and closing of notifications chan
+	nmu           *sync.Mutex
+	notifications chan sip.Request
+	done          chan struct{}
+}
+
+func newClientSubscription(s *Subscriber, dlg *dialog.Dialog, event *sip.EventHeader, expires time.Duration, accept []string) *ClientSubscription {
+	return &ClientSubscription{
+		subscriber:    s,
+		dialog:        dlg,
+		ownsDialog:    true,
+		event:         event.Clone().(*sip.EventHeader),
+		expires:       expires,
+		accept:        accept,
+		mu:            new(sync.Mutex),
+		state:         sip.SubscriptionPending,
+		nmu:           new(sync.Mutex),
+		notifications: make(chan sip.Request, 1),
+		done:          make(chan struct{}),
+	}
+}
+
+func (sub *ClientSubscription) String() string {
+	return fmt.Sprintf("Subscription %s to %s", sub.event.EventType, sub.dialog.Remote().Uri)
+}
+
+// Dialog returns dialog of the subscription.
+func (sub *ClientSubscription) Dialog() *dialog.Dialog {
+	return sub.dialog
+}
+
+// Event returns 'Event' header of the subscription.
+func (sub *ClientSubscription) Event() *sip.EventHeader {
+	return sub.event
+}
+
+// State returns the state of the last NOTIFY, the subscription is pending until the first NOTIFY.
+func (sub *ClientSubscription) State() sip.SubscriptionState {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	return sub.state
+}
+
+// Reason returns reason of the subscription terminated by the notifier, e.g. 'timeout' or 'rejected'.
+func (sub *ClientSubscription) Reason() string {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	return sub.reason
+}
+
+// RetryAfter returns delay before the new subscription proposed by the notifier on termination.
+func (sub *ClientSubscription) RetryAfter() (time.Duration, bool) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	return sub.retryAfter, sub.retryAfter > 0
+}
+
+// Err returns error which terminated the subscription on the subscriber side, e.g. expiration.
+func (sub *ClientSubscription) Err() error {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	return sub.err
+}
+
+// Expires returns the remaining duration of the subscription.
+func (sub *ClientSubscription) Expires() time.Duration {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.state == sip.SubscriptionTerminated {
+		return 0
+	}
+	if left := sub.expiresAt.Sub(timing.Now()); left > 0 {
+		return left
+	}
+
+	return 0
+}
+
+// Notifications returns chan of NOTIFY requests of the subscription, it is closed when
+// the subscription is terminated. The last NOTIFY is discarded if the previous one is not read yet.
+func (sub *ClientSubscription) Notifications() <-chan sip.Request {
+	return sub.notifications
+}
+
+// Done returns chan closed when the subscription is terminated.
+func (sub *ClientSubscription) Done() <-chan struct{} {
+	return sub.done
+}
+
+// Refresh sends SUBSCRIBE with the requested expiration inside the dialog (RFC 6665 - 4.1.2.2.).
+// Subscription is terminated on 481 response, it stays valid till the expiration on other failures.
+func (sub *ClientSubscription) Refresh() error {
+	res, err := sub.send(sub.expires)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case res.IsSuccess():
+		expires := sub.expires
+		if hdr, ok := res.Expires(); ok {
+			expires = hdr.Duration()
+		}
+		sub.setExpires(expires)
+		return nil
+	case res.StatusCode() == 481:
+		err := &SubscribeError{Response: res}
+		sub.terminate(err, nil)
+		return err
+	default:
+		return &SubscribeError{Response: res}
+	}
+}
+
+// Unsubscribe sends SUBSCRIBE with zero expiration (RFC 6665 - 4.1.2.3.).
+// Subscription is terminated by the final NOTIFY of the notifier or locally if it is not received.
+func (sub *ClientSubscription) Unsubscribe() error {
+	sub.mu.Lock()
+	if sub.state == sip.SubscriptionTerminated {
+		sub.mu.Unlock()
+		return fmt.Errorf("%s is terminated", sub)
+	}
+	sub.unsubscribing = true
+	if sub.refreshTimer != nil {
+		sub.refreshTimer.Stop()
+	}
+	sub.mu.Unlock()
+
+	res, err := sub.send(0)
+	if err == nil && !res.IsSuccess() {
+		err = &SubscribeError{Response: res}
+	}
+	if err != nil {
+		sub.terminate(nil, nil)
+		return err
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.state != sip.SubscriptionTerminated {
+		if sub.expireTimer != nil {
+			sub.expireTimer.Stop()
+		}
+		sub.expireTimer = timing.AfterFunc(forkTimeout, func() {
+			sub.terminate(nil, nil)
+		})
+	}
+
+	return nil
+}
+
+// send sends SUBSCRIBE inside the dialog and waits for the final response.
+func (sub *ClientSubscription) send(expires time.Duration) (sip.Response, error) {
+	req, err := sub.dialog.NewRequest(sip.SUBSCRIBE)
+	if err != nil {
+		return nil, err
+	}
+	seconds := sip.Expires(expires / time.Second)
+	req.AppendHeader(sub.event.Clone())
+	req.AppendHeader(&seconds)
+	if len(sub.accept) > 0 {
+		req.AppendHeader(acceptHeader(sub.accept))
+	}
+
+	res, err := request(sub.subscriber.srv, req)
+	if err != nil {
+		return nil, err
+	}
+	if res.IsSuccess() {
+		// errors do not terminate the dialog of the subscription which is valid till the expiration
+		sub.dialog.ReceiveResponse(req, res)
+	}
+
+	return res, nil
+}
+
+// receiveNotify applies the state of NOTIFY received inside the dialog of the subscription (RFC 6665 - 4.1.3.).
+// Dialog created by NOTIFY already has its sequence number.
+func (sub *ClientSubscription) receiveNotify(req sip.Request, subState *sip.SubscriptionStateHeader, created bool) {
+	if !created {
+		if err := sub.dialog.ReceiveRequest(req); err != nil {
+			log.Warnf("%s failed to receive %s: %s", sub.subscriber, req.Short(), err)
+			sub.subscriber.respond(req, 500, "Server Internal Error")
+			return
+		}
+	}
+	sub.subscriber.respond(req, 200, "OK")
+
+	if subState.State == sip.SubscriptionTerminated {
+		sub.mu.Lock()
+		sub.reason = subState.Reason()
+		if retryAfter, ok := subState.RetryAfter(); ok {
+			sub.retryAfter = retryAfter
+		}
+		sub.mu.Unlock()
+		log.Debugf("%s terminated by notifier: %s", sub.subscriber, sub)
+		sub.terminate(nil, req)
+		return
+	}
+
+	sub.mu.Lock()
+	sub.notified = true
+	if subState.State == sip.SubscriptionActive || subState.State == sip.SubscriptionPending {
+		sub.state = subState.State
+	}
+	sub.mu.Unlock()
+	if expires, ok := subState.Expires(); ok {
+		sub.setExpires(expires)
+	}
+
+	sub.nmu.Lock()
+	defer sub.nmu.Unlock()
+	select {
+	case <-sub.done:
+		return
+	default:
+	}
+	select {
+	case sub.notifications <- req:
+	case <-sub.done:
+	}
+}
+
+// awaitNotify terminates the subscription established by 2xx response if NOTIFY does not follow.
+func (sub *ClientSubscription) awaitNotify() {
+	timing.AfterFunc(forkTimeout, func() {
+		sub.mu.Lock()
+		notified := sub.notified
+		sub.mu.Unlock()
+		if !notified {
+			sub.terminate(fmt.Errorf("%s got no NOTIFY within %s", sub, forkTimeout), nil)
+		}
+	})
+}
+
+// setExpires schedules refresh at the half of the expiration and termination on the expiration.
+func (sub *ClientSubscription) setExpires(expires time.Duration) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.state == sip.SubscriptionTerminated || sub.unsubscribing {
+		return
+	}
+	sub.expiresAt = timing.Now().Add(expires)
+	if sub.refreshTimer != nil {
+		sub.refreshTimer.Stop()
+	}
+	if sub.expireTimer != nil {
+		sub.expireTimer.Stop()
+	}
+	sub.refreshTimer = timing.AfterFunc(expires/2, func() {
+		if err := sub.Refresh(); err != nil {
+			log.Warnf("%s failed to refresh %s: %s", sub.subscriber, sub, err)
+		}
+	})
+	sub.expireTimer = timing.AfterFunc(expires, func() {
+		sub.terminate(fmt.Errorf("%s expired", sub), nil)
+	})
+}
+
+// terminate forgets the subscription, the final NOTIFY is passed to the notifications if there is room.
+func (sub *ClientSubscription) terminate(err error, final sip.Request) {
+	sub.mu.Lock()
+	select {
+	case <-sub.done:
+		sub.mu.Unlock()
+		return
+	default:
+	}
+	sub.state = sip.SubscriptionTerminated
+	sub.err = err
+	if sub.refreshTimer != nil {
+		sub.refreshTimer.Stop()
+	}
+	if sub.expireTimer != nil {
+		sub.expireTimer.Stop()
+	}
+	close(sub.done)
+	sub.mu.Unlock()
+
+	sub.nmu.Lock()
+	if final != nil {
+		select {
+		case sub.notifications <- final:
+		default:
+		}
+	}
+	close(sub.notifications)
+	sub.nmu.Unlock()
+
+	sub.subscriber.remove(sub)
+	if err != nil {
+		log.Debugf("%s terminated %s: %s", sub.subscriber, sub, err)
+	}
+}
@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"sync"
+
+	"github.com/masterclock/gosip/log"
+	"github.com/masterclock/gosip/sip"
+)
+
+// responseContext forwards the request to the targets and collects the responses (RFC 3261 - 16.7.).
+type responseContext struct {
+	proxy *Proxy
+	// origin is the received request, responses are sent on it
+	origin sip.Request
+	// req is the request to be forwarded after route preprocessing
+	req   sip.Request
+	route *Route
+
+	mu *sync.Mutex
+	// pending client transactions
+	branches map[sip.Request]bool
+	// final non-2xx responses of the branches
+	responses []sip.Response
+	// final response was forwarded upstream
+	final bool
+	// stop forwarding to the rest targets after 2xx, 6xx or CANCEL
+	stopped bool
+}
+
+func newResponseContext(p *Proxy, origin sip.Request, req sip.Request, route *Route) *responseContext {
+	return &responseContext{
+		proxy:     p,
+		origin:    origin,
+		req:       req,
+		route:     route,
+		mu:        new(sync.Mutex),
+		branches:  make(map[sip.Request]bool),
+		responses: make([]sip.Response, 0),
+	}
+}
+
+// forward forwards the request to the targets in parallel or one by one (RFC 3261 - 16.6.)
+// and sends the best response upstream when all branches are completed.
+func (ctx *responseContext) forward() {
+	if ctx.route.Parallel {
+		wg := new(sync.WaitGroup)
+		for _, target := range ctx.route.Targets {
+			wg.Add(1)
+			go func(target sip.Uri) {
+				defer wg.Done()
+				ctx.forwardTo(target)
+			}(target)
+		}
+		wg.Wait()
+	} else {
+		for _, target := range ctx.route.Targets {
+			if ctx.isStopped() {
+				break
+			}
+			ctx.forwardTo(target)
+		}
+	}
+
+	ctx.respondBest()
+}
+
+func (ctx *responseContext) forwardTo(target sip.Uri) {
+	p := ctx.proxy
+	fwd := p.forwardedRequest(ctx.req, target, ctx.route.RecordRoute, p.branch(ctx.req))
+
+	if ctx.isStopped() {
+		return
+	}
+	// the lock is not held while sending, resolving and dialing may take a while
+	responses, err := p.txl.Request(fwd)
+	if err != nil {
+		// RFC 3261 - 16.9. - transport error is handled as 503 response
+		log.Warnf("%s failed to forward %s to %s: %s", p, ctx.req.Short(), target, err)
+		ctx.receive(fwd, sip.NewResponseFromRequest(fwd, 503, "Service Unavailable", ""))
+		return
+	}
+	ctx.mu.Lock()
+	ctx.branches[fwd] = true
+	stopped := ctx.stopped
+	ctx.mu.Unlock()
+	// forwarding was stopped while sending, the new branch is canceled as the other pending ones
+	if stopped && ctx.origin.IsInvite() {
+		if err := p.txl.CancelRequest(fwd); err != nil {
+			log.Debugf("%s failed to cancel %s: %s", p, fwd.Short(), err)
+		}
+	}
+
+	var final bool
+	for res := range responses {
+		ctx.receive(fwd, res)
+		if !res.IsProvisional() {
+			final = true
+			break
+		}
+	}
+
+	ctx.mu.Lock()
+	delete(ctx.branches, fwd)
+	ctx.mu.Unlock()
+
+	if final {
+		// branch is completed, the transaction lives till Timer D or K fires on unreliable transport
+		go ctx.drain(fwd, responses)
+		return
+	}
+	// RFC 3261 - 16.8. - branch without final response is completed with 408
+	ctx.receive(fwd, sip.NewResponseFromRequest(fwd, 408, "Request Timeout", ""))
+}
+
+// drain reads responses of the completed branch till its transaction is deleted.
+// RFC 3261 - 16.7. - 9. - 2xx responses from the other forks are forwarded upstream too.
+func (ctx *responseContext) drain(branch sip.Request, responses <-chan sip.Response) {
+	for res := range responses {
+		if res.IsSuccess() {
+			ctx.receive(branch, res)
+		}
+	}
+}
+
+// receive processes response of the branch (RFC 3261 - 16.7.).
+func (ctx *responseContext) receive(branch sip.Request, res sip.Response) {
+	p := ctx.proxy
+	log.Debugf("%s received %s on %s", p, res.Short(), branch.Short())
+
+	switch {
+	case res.StatusCode() == 100:
+		return
+	case res.IsProvisional():
+		ctx.mu.Lock()
+		final := ctx.final
+		ctx.mu.Unlock()
+		if !final {
+			p.respond(ctx.upstream(res))
+		}
+	case res.IsSuccess():
+		ctx.mu.Lock()
+		ctx.final = true
+		ctx.stopped = true
+		ctx.mu.Unlock()
+		// RFC 3261 - 16.7. - 9. - all 2xx responses on INVITE are forwarded
+		p.respond(ctx.upstream(res))
+		if ctx.origin.IsInvite() {
+			ctx.cancel()
+		}
+	default:
+		ctx.mu.Lock()
+		ctx.responses = append(ctx.responses, res)
+		ctx.mu.Unlock()
+		// RFC 3261 - 16.7. - 5. - 6xx response terminates the search
+		if res.IsGlobalError() {
+			ctx.cancel()
+		}
+	}
+}
+
+// cancel stops forwarding and cancels pending INVITE branches (RFC 3261 - 16.10.).
+func (ctx *responseContext) cancel() {
+	ctx.mu.Lock()
+	ctx.stopped = true
+	branches := make([]sip.Request, 0, len(ctx.branches))
+	for branch := range ctx.branches {
+		branches = append(branches, branch)
+	}
+	ctx.mu.Unlock()
+
+	if !ctx.origin.IsInvite() {
+		return
+	}
+	for _, branch := range branches {
+		if err := ctx.proxy.txl.CancelRequest(branch); err != nil {
+			log.Debugf("%s failed to cancel %s: %s", ctx.proxy, branch.Short(), err)
+		}
+	}
+}
+
+func (ctx *responseContext) isStopped() bool {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	return ctx.stopped
+}
+
+// respondBest sends the best final response upstream if no 2xx response was forwarded (RFC 3261 - 16.7. - 6.).
+func (ctx *responseContext) respondBest() {
+	ctx.mu.Lock()
+	if ctx.final {
+		ctx.mu.Unlock()
+		return
+	}
+	ctx.final = true
+	responses := ctx.responses
+	ctx.mu.Unlock()
+
+	best := bestResponse(responses)
+	if best == nil {
+		ctx.proxy.respond(sip.NewResponseFromRequest(ctx.origin, 408, "Request Timeout", ""))
+		return
+	}
+
+	res := ctx.upstream(best)
+	// RFC 3261 - 16.7. - 7. - challenges of all 401 and 407 responses are aggregated
+	if res.StatusCode() == 401 || res.StatusCode() == 407 {
+		res.RemoveHeader("WWW-Authenticate")
+		res.RemoveHeader("Proxy-Authenticate")
+		for _, other := range responses {
+			if other.StatusCode() != 401 && other.StatusCode() != 407 {
+				continue
+			}
+			for _, name := range []string{"WWW-Authenticate", "Proxy-Authenticate"} {
+				for _, header := range other.GetHeaders(name) {
+					res.AppendHeader(header.Clone())
+				}
+			}
+		}
+	}
+	// RFC 3261 - 16.7. - 6. - 503 is not forwarded upstream
+	if res.StatusCode() == 503 {
+		res = sip.NewResponseFromRequest(ctx.origin, 500, "Server Internal Error", "")
+	}
+
+	ctx.proxy.respond(res)
+}
+
+// upstream returns copy of the branch response to be sent on the received request.
+func (ctx *responseContext) upstream(res sip.Response) sip.Response {
+	fwd := res.Clone().(sip.Response)
+	fwd.RemoveHeader("Via")
+	sip.CopyHeaders("Via", ctx.origin, fwd)
+	fwd.SetSource(ctx.origin.Destination())
+	fwd.SetDestination(ctx.origin.Source())
+
+	return fwd
+}
+
+// bestResponse chooses the final response to be sent upstream (RFC 3261 - 16.7. - 6.):
+// 6xx response if received, otherwise the response of the lowest class.
+func bestResponse(responses []sip.Response) sip.Response {
+	var best sip.Response
+	for _, res := range responses {
+		if res.IsGlobalError() {
+			return res
+		}
+		if best == nil || res.StatusCode()/100 < best.StatusCode()/100 {
+			best = res
+		}
+	}
+
+	return best
+}
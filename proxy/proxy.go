@@ -0,0 +1,473 @@
+// proxy package implements SIP stateful and stateless proxy (RFC 3261 - 16.)
+package proxy
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/masterclock/gosip/log"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/transaction"
+	"github.com/masterclock/gosip/transport"
+	"github.com/masterclock/gosip/util"
+)
+
+// DefaultMaxForwards is set to the forwarded request without Max-Forwards header (RFC 3261 - 16.6. - 3.).
+const DefaultMaxForwards = 70
+
+// Route is the routing decision for the request (RFC 3261 - 16.5.).
+type Route struct {
+	// Targets is the target set, the request is forwarded to each target in order.
+	Targets []sip.Uri
+	// Parallel forks the request to all targets at once, otherwise targets are tried one by one.
+	Parallel bool
+	// RecordRoute keeps the proxy on the path of the subsequent requests of the dialog.
+	RecordRoute bool
+}
+
+// Router decides where the request should be forwarded.
+// Returned sip.Rejection answers the request with the status, any other error is answered with 500.
+type Router func(req sip.Request) (*Route, error)
+
+// Config describes available options of the proxy.
+type Config struct {
+	// Router makes routing decisions, required.
+	Router Router
+	// Uri identifies the proxy in Record-Route and Route headers,
+	// defaults to SIP URI with the host of transport layer.
+	Uri *sip.SipUri
+	// Extensions lists supported option tags of Proxy-Require header.
+	Extensions []string
+}
+
+// Proxy forwards requests received by the transaction layer and relays the responses back.
+// Stateless proxy works on top of the transport layer, transaction layer is nil then.
+type Proxy struct {
+	txl        transaction.Layer
+	tpl        transport.Layer
+	router     Router
+	uri        *sip.SipUri
+	extensions []string
+	// contexts of INVITE requests being forwarded by server transaction key, used to match CANCEL
+	contexts map[transaction.TxKey]*responseContext
+	mu       *sync.Mutex
+	wg       *sync.WaitGroup
+	done     chan struct{}
+}
+
+// NewProxy creates proxy serving requests of the transaction layer.
+// Proxy is stopped when the transaction layer is canceled.
+func NewProxy(txl transaction.Layer, config *Config) (*Proxy, error) {
+	p, err := newProxy(txl.Transport(), config)
+	if err != nil {
+		return nil, err
+	}
+	p.txl = txl
+
+	go p.serve()
+
+	return p, nil
+}
+
+func newProxy(tpl transport.Layer, config *Config) (*Proxy, error) {
+	if config == nil || config.Router == nil {
+		return nil, fmt.Errorf("router is required to create proxy")
+	}
+
+	p := &Proxy{
+		tpl:        tpl,
+		router:     config.Router,
+		extensions: config.Extensions,
+		contexts:   make(map[transaction.TxKey]*responseContext),
+		mu:         new(sync.Mutex),
+		wg:         new(sync.WaitGroup),
+		done:       make(chan struct{}),
+	}
+	if config.Uri != nil {
+		p.uri = config.Uri.Clone().(*sip.SipUri)
+	} else {
+		p.uri = &sip.SipUri{Host: tpl.HostAddr(), UriParams: sip.NewParams(), Headers: sip.NewParams()}
+	}
+
+	return p, nil
+}
+
+func (p *Proxy) String() string {
+	return fmt.Sprintf("Proxy %s", p.uri)
+}
+
+// Done returns chan closed when the proxy is stopped.
+func (p *Proxy) Done() <-chan struct{} {
+	return p.done
+}
+
+func (p *Proxy) serve() {
+	defer func() {
+		p.wg.Wait()
+		close(p.done)
+	}()
+
+	requests, responses, errs := p.txl.Requests(), p.txl.Responses(), p.txl.Errors()
+	for requests != nil || responses != nil || errs != nil {
+		select {
+		case req, ok := <-requests:
+			if !ok {
+				requests = nil
+				continue
+			}
+			p.wg.Add(1)
+			go func() {
+				defer p.wg.Done()
+				p.handleRequest(req)
+			}()
+		case res, ok := <-responses:
+			if !ok {
+				responses = nil
+				continue
+			}
+			p.forwardStrayResponse(res)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			log.Debugf("GoSIP proxy received transaction error: %s", err)
+		}
+	}
+}
+
+func (p *Proxy) handleRequest(req sip.Request) {
+	log.Debugf("%s received %s", p, req.Short())
+
+	// RFC 3261 - 16.10. - CANCEL is answered by transaction layer, pending branches are canceled here
+	if req.IsCancel() {
+		p.cancelBranches(req)
+		return
+	}
+
+	if res := p.validate(req); res != nil {
+		if req.IsAck() {
+			return
+		}
+		p.respond(res)
+		return
+	}
+
+	origin := req
+	req = req.Clone().(sip.Request)
+	p.preprocessRoutes(req)
+
+	// RFC 3261 - 16.11. - ACK on 2xx is forwarded statelessly
+	if req.IsAck() {
+		fwd := p.forwardedRequest(req, req.Recipient(), false, p.branch(req))
+		if err := p.tpl.Send(fwd); err != nil {
+			log.Warnf("%s failed to forward %s: %s", p, req.Short(), err)
+		}
+		return
+	}
+
+	route, res := p.route(origin, req)
+	if res != nil {
+		p.respond(res)
+		return
+	}
+
+	ctx := newResponseContext(p, origin, req, route)
+	if origin.IsInvite() {
+		if key, err := transaction.MakeServerTxKey(origin); err == nil {
+			p.mu.Lock()
+			p.contexts[key] = ctx
+			p.mu.Unlock()
+			defer func() {
+				p.mu.Lock()
+				delete(p.contexts, key)
+				p.mu.Unlock()
+			}()
+		}
+	}
+
+	ctx.forward()
+}
+
+// validate checks the request before forwarding (RFC 3261 - 16.3.), returns response if the request is rejected.
+func (p *Proxy) validate(req sip.Request) sip.Response {
+	if maxForwards, ok := req.MaxForwards(); ok && *maxForwards == 0 {
+		return sip.NewResponseFromRequest(req, 483, "Too Many Hops", "")
+	}
+
+	if p.isLooped(req) {
+		return sip.NewResponseFromRequest(req, 482, "Loop Detected", "")
+	}
+
+	unsupported := make([]string, 0)
+	for _, option := range optionTags(req, "Proxy-Require") {
+		if !p.supports(option) {
+			unsupported = append(unsupported, option)
+		}
+	}
+	if len(unsupported) > 0 {
+		res := sip.NewResponseFromRequest(req, 420, "Bad Extension", "")
+		res.AppendHeader(&sip.UnsupportedHeader{Options: unsupported})
+		return res
+	}
+
+	return nil
+}
+
+func (p *Proxy) supports(option string) bool {
+	for _, extension := range p.extensions {
+		if strings.EqualFold(extension, option) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isLooped returns true if the request was already forwarded by the proxy with the same branch (RFC 3261 - 16.3. - 4.).
+// Request sent again with the changed Request-URI is a spiral and is not considered as a loop.
+func (p *Proxy) isLooped(req sip.Request) bool {
+	// stateless proxy has no transaction layer
+	prefix := sip.RFC3261BranchMagicCookie + loopHash(req, p.txl == nil) + "."
+	for _, header := range req.GetHeaders("Via") {
+		via, ok := header.(sip.ViaHeader)
+		if !ok {
+			continue
+		}
+		for _, hop := range via {
+			if hop.Host != p.tpl.HostAddr() || hop.Params == nil {
+				continue
+			}
+			if branch, ok := hop.Params.Get("branch"); ok && branch != nil && strings.HasPrefix(branch.String(), prefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// preprocessRoutes removes the proxy from the top of the route set (RFC 3261 - 16.4.).
+func (p *Proxy) preprocessRoutes(req sip.Request) {
+	routes := sip.Routes(req)
+	if len(routes) > 0 && p.isOwnUri(routes[0].Uri) {
+		sip.SetRoutes(req, cloneAddresses(routes[1:]))
+	}
+}
+
+// route determines the target set of the request (RFC 3261 - 16.5.), returns response if the request is rejected.
+func (p *Proxy) route(origin sip.Request, req sip.Request) (*Route, sip.Response) {
+	// request with the route set is forwarded to Request-URI by the next hop of the route set
+	if len(sip.Routes(req)) > 0 {
+		return &Route{Targets: []sip.Uri{req.Recipient()}}, nil
+	}
+
+	route, err := p.router(req)
+	if err != nil {
+		if rejection, ok := err.(*sip.Rejection); ok {
+			return nil, sip.NewResponseFromRequest(origin, rejection.StatusCode, rejection.Reason, "")
+		}
+		log.Errorf("%s failed to route %s: %s", p, req.Short(), err)
+		return nil, sip.NewResponseFromRequest(origin, 500, "Server Internal Error", "")
+	}
+	if route == nil || len(route.Targets) == 0 {
+		return nil, sip.NewResponseFromRequest(origin, 480, "Temporarily Unavailable", "")
+	}
+
+	return route, nil
+}
+
+// cancelBranches cancels pending branches of INVITE request matched by CANCEL (RFC 3261 - 16.10.).
+func (p *Proxy) cancelBranches(cancel sip.Request) {
+	key, err := transaction.MakeCanceledServerTxKey(cancel)
+	if err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	ctx, ok := p.contexts[key]
+	p.mu.Unlock()
+	if ok {
+		ctx.cancel()
+	}
+}
+
+// forwardedRequest creates copy of the request to be forwarded to the target (RFC 3261 - 16.6.).
+func (p *Proxy) forwardedRequest(req sip.Request, target sip.Uri, recordRoute bool, branch string) sip.Request {
+	fwd := req.Clone().(sip.Request)
+	fwd.SetRecipient(target.Clone())
+
+	maxForwards := sip.MaxForwards(DefaultMaxForwards)
+	if value, ok := req.MaxForwards(); ok {
+		maxForwards = *value - 1
+	}
+	fwd.RemoveHeader("Max-Forwards")
+	fwd.AppendHeader(&maxForwards)
+
+	if recordRoute {
+		uri := p.uri.Clone().(*sip.SipUri)
+		if uri.UriParams == nil {
+			uri.UriParams = sip.NewParams()
+		}
+		uri.UriParams.Add("lr", nil)
+		fwd.PrependHeader(&sip.RecordRouteHeader{
+			Addresses: []*sip.Address{{Uri: uri, Params: sip.NewParams()}},
+		})
+	}
+
+	sip.RewriteStrictRoute(fwd)
+
+	fwd.PrependHeader(sip.ViaHeader{
+		&sip.ViaHop{
+			ProtocolName:    "SIP",
+			ProtocolVersion: "2.0",
+			Transport:       "UDP",
+			Host:            p.tpl.HostAddr(),
+			Params:          sip.NewParams().Add("branch", sip.String{Str: branch}),
+		},
+	})
+
+	return fwd
+}
+
+// branch returns branch of the forwarded request, it starts with the hash used for loop detection (RFC 3261 - 16.6. - 8.).
+func (p *Proxy) branch(req sip.Request) string {
+	return sip.RFC3261BranchMagicCookie + loopHash(req, false) + "." + util.RandString(16)
+}
+
+// forwardStrayResponse forwards response not matched to any client transaction, e.g. 2xx retransmission (RFC 3261 - 16.7.).
+// Stateless proxy forwards all the responses this way (RFC 3261 - 16.11.).
+func (p *Proxy) forwardStrayResponse(res sip.Response) {
+	viaHop, ok := res.ViaHop()
+	if !ok || viaHop.Host != p.tpl.HostAddr() {
+		log.Debugf("%s discards stray %s", p, res.Short())
+		return
+	}
+
+	fwd := res.Clone().(sip.Response)
+	if !removeTopVia(fwd) {
+		return
+	}
+	if err := p.tpl.Send(fwd); err != nil {
+		log.Warnf("%s failed to forward %s: %s", p, res.Short(), err)
+	}
+}
+
+func (p *Proxy) respond(res sip.Response) {
+	// RFC 3261 - 16.11. - stateless proxy sends responses directly by the transport layer
+	if p.txl == nil {
+		if err := p.tpl.Send(res); err != nil {
+			log.Debugf("%s failed to respond with %s: %s", p, res.Short(), err)
+		}
+		return
+	}
+	if _, err := p.txl.Respond(res); err != nil {
+		log.Debugf("%s failed to respond with %s: %s", p, res.Short(), err)
+	}
+}
+
+func (p *Proxy) isOwnUri(uri sip.Uri) bool {
+	sipUri, ok := uri.(*sip.SipUri)
+	if !ok {
+		return false
+	}
+	if !strings.EqualFold(sipUri.Host, p.uri.Host) && !strings.EqualFold(sipUri.Host, p.tpl.HostAddr()) {
+		return false
+	}
+
+	return portOf(sipUri) == portOf(p.uri)
+}
+
+func portOf(uri *sip.SipUri) sip.Port {
+	if uri.Port != nil {
+		return *uri.Port
+	}
+	if uri.IsEncrypted {
+		return sip.DefaultPort("TLS")
+	}
+
+	return sip.DefaultPort("UDP")
+}
+
+// loopHash returns hash of the request fields which are changed when the request spirals (RFC 3261 - 16.6. - 8.).
+// Stateless proxy skips Proxy-Require and Proxy-Authorization, CANCEL and ACK get the branch of the INVITE
+// without them (RFC 3261 - 16.11.).
+func loopHash(req sip.Request, stateless bool) string {
+	parts := []string{req.Recipient().String()}
+	if from, ok := req.From(); ok {
+		parts = append(parts, sip.Tag(from.Params))
+	}
+	if to, ok := req.To(); ok {
+		// ACK on non-2xx response gets To tag of the response, it is skipped to keep branch of the INVITE
+		if req.IsAck() {
+			parts = append(parts, "")
+		} else {
+			parts = append(parts, sip.Tag(to.Params))
+		}
+	}
+	if callID, ok := req.CallID(); ok {
+		parts = append(parts, string(*callID))
+	}
+	if cseq, ok := req.CSeq(); ok {
+		parts = append(parts, fmt.Sprintf("%d", cseq.SeqNo))
+	}
+	if !stateless {
+		for _, name := range []string{"Proxy-Require", "Proxy-Authorization"} {
+			for _, header := range req.GetHeaders(name) {
+				parts = append(parts, header.String())
+			}
+		}
+	}
+
+	hash := md5.Sum([]byte(strings.Join(parts, "\n")))
+
+	return hex.EncodeToString(hash[:8])
+}
+
+// optionTags returns option tags listed in the headers with the name, e.g. Proxy-Require.
+func optionTags(msg sip.Message, headerName string) []string {
+	options := make([]string, 0)
+	for _, header := range msg.GetHeaders(headerName) {
+		switch header := header.(type) {
+		case *sip.ProxyRequireHeader:
+			options = append(options, header.Options...)
+		case *sip.GenericHeader:
+			for _, option := range strings.Split(header.Contents, ",") {
+				if option = strings.TrimSpace(option); option != "" {
+					options = append(options, option)
+				}
+			}
+		}
+	}
+
+	return options
+}
+
+// removeTopVia removes the top Via hop of the response, returns false if no hops are left.
+func removeTopVia(res sip.Response) bool {
+	hops := make([]*sip.ViaHop, 0)
+	for _, header := range res.GetHeaders("Via") {
+		if via, ok := header.(sip.ViaHeader); ok {
+			hops = append(hops, via...)
+		}
+	}
+	res.RemoveHeader("Via")
+	if len(hops) < 2 {
+		return false
+	}
+	res.PrependHeader(sip.ViaHeader(hops[1:]))
+
+	return true
+}
+
+func cloneAddresses(addresses []*sip.Address) []*sip.Address {
+	clones := make([]*sip.Address, 0, len(addresses))
+	for _, address := range addresses {
+		clones = append(clones, address.Clone())
+	}
+
+	return clones
+}
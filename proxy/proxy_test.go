@@ -0,0 +1,299 @@
+package proxy_test
+
+import (
+	"strings"
+	"time"
+
+	"github.com/masterclock/gosip/proxy"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/testutils"
+	"github.com/masterclock/gosip/transaction"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Proxy", func() {
+	var (
+		tpl   *testutils.MockTransportLayer
+		txl   transaction.Layer
+		p     *proxy.Proxy
+		out   chan sip.Message
+		route *proxy.Route
+	)
+
+	request := func(method sip.RequestMethod, headers ...string) sip.Request {
+		lines := []string{
+			string(method) + " sip:bob@example.com SIP/2.0",
+			"Via: SIP/2.0/UDP 10.0.0.1:5060;branch=" + sip.GenerateBranch(),
+			"Max-Forwards: 70",
+			"From: <sip:alice@example.com>;tag=alice",
+			"To: <sip:bob@example.com>",
+			"Call-ID: proxy-call",
+			"CSeq: 1 " + string(method),
+			"Contact: <sip:alice@10.0.0.1>",
+		}
+		lines = append(lines, headers...)
+		lines = append(lines, "Content-Length: 0", "", "")
+
+		return testutils.Request(lines)
+	}
+
+	response := func(req sip.Request, statusCode sip.StatusCode, reason string, headers ...sip.Header) sip.Response {
+		res := sip.NewResponseFromRequest(req, statusCode, reason, "")
+		to, _ := res.To()
+		to.Params.Add("tag", sip.String{Str: strings.TrimPrefix(req.Recipient().String(), "sip:")})
+		for _, header := range headers {
+			res.AppendHeader(header)
+		}
+		return res
+	}
+
+	target := func(host string) sip.Uri {
+		return &sip.SipUri{User: sip.String{Str: "bob"}, Host: host, UriParams: sip.NewParams(), Headers: sip.NewParams()}
+	}
+
+	next := func() sip.Message {
+		select {
+		case msg := <-out:
+			return msg
+		case <-time.After(time.Second):
+			Fail("message was not sent")
+		}
+		return nil
+	}
+
+	nextRequest := func(method sip.RequestMethod) sip.Request {
+		msg := next()
+		req, ok := msg.(sip.Request)
+		Expect(ok).To(BeTrue(), "unexpected "+msg.Short())
+		Expect(req.Method()).To(Equal(method))
+		return req
+	}
+
+	nextResponse := func(statusCode sip.StatusCode) sip.Response {
+		msg := next()
+		res, ok := msg.(sip.Response)
+		Expect(ok).To(BeTrue(), "unexpected "+msg.Short())
+		Expect(res.StatusCode()).To(Equal(statusCode))
+		return res
+	}
+
+	viaHops := func(msg sip.Message) []*sip.ViaHop {
+		hops := make([]*sip.ViaHop, 0)
+		for _, header := range msg.GetHeaders("Via") {
+			hops = append(hops, header.(sip.ViaHeader)...)
+		}
+		return hops
+	}
+
+	BeforeEach(func() {
+		tpl = testutils.NewMockTransportLayer()
+		txl = transaction.NewLayer(tpl, &transaction.LayerConfig{DisableTrying: true})
+		out = make(chan sip.Message, 10)
+		go func() {
+			for msg := range tpl.OutMsgs {
+				out <- msg
+			}
+		}()
+
+		route = &proxy.Route{Targets: []sip.Uri{target("192.0.2.10")}, RecordRoute: true}
+		var err error
+		p, err = proxy.NewProxy(txl, &proxy.Config{
+			Router: func(req sip.Request) (*proxy.Route, error) {
+				if req.Recipient().String() == "sip:unknown@example.com" {
+					return nil, &sip.Rejection{StatusCode: 404, Reason: "Not Found"}
+				}
+				return route, nil
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func(done Done) {
+		txl.Cancel()
+		<-txl.Done()
+		<-p.Done()
+		close(done)
+	}, 3)
+
+	It("should forward request and relay responses", func() {
+		invite := request(sip.INVITE)
+		tpl.InMsgs <- invite
+
+		fwd := nextRequest(sip.INVITE)
+		Expect(fwd.Recipient().String()).To(Equal("sip:bob@192.0.2.10"))
+		hops := viaHops(fwd)
+		Expect(hops).To(HaveLen(2))
+		Expect(hops[0].Host).To(Equal("127.0.0.1"))
+		branch, _ := hops[0].Params.Get("branch")
+		Expect(branch.String()).To(HavePrefix(sip.RFC3261BranchMagicCookie))
+		Expect(hops[1].Host).To(Equal("10.0.0.1"))
+		Expect(fwd.GetHeaders("Max-Forwards")[0].String()).To(Equal("Max-Forwards: 69"))
+		recordRoute, ok := fwd.RecordRoute()
+		Expect(ok).To(BeTrue())
+		Expect(recordRoute.String()).To(Equal("Record-Route: <sip:127.0.0.1;lr>"))
+
+		tpl.InMsgs <- response(fwd, 180, "Ringing")
+		res := nextResponse(180)
+		Expect(viaHops(res)).To(HaveLen(1))
+		Expect(viaHops(res)[0].Host).To(Equal("10.0.0.1"))
+
+		tpl.InMsgs <- response(fwd, 200, "OK")
+		res = nextResponse(200)
+		Expect(viaHops(res)).To(HaveLen(1))
+	})
+
+	It("should validate requests", func() {
+		By("Max-Forwards is exhausted")
+		req := request(sip.OPTIONS)
+		req.RemoveHeader("Max-Forwards")
+		maxForwards := sip.MaxForwards(0)
+		req.AppendHeader(&maxForwards)
+		tpl.InMsgs <- req
+		nextResponse(483)
+
+		By("Proxy-Require lists unsupported extension")
+		tpl.InMsgs <- request(sip.OPTIONS, "Proxy-Require: foo")
+		res := nextResponse(420)
+		Expect(res.GetHeaders("Unsupported")[0].String()).To(Equal("Unsupported: foo"))
+
+		By("router rejects the request")
+		req = request(sip.OPTIONS)
+		req.SetRecipient(&sip.SipUri{User: sip.String{Str: "unknown"}, Host: "example.com"})
+		tpl.InMsgs <- req
+		nextResponse(404)
+	})
+
+	It("should detect loop", func() {
+		route.Targets = []sip.Uri{target("example.com")}
+		tpl.InMsgs <- request(sip.OPTIONS)
+		fwd := nextRequest(sip.OPTIONS)
+
+		By("request with the same Request-URI is received again")
+		tpl.InMsgs <- fwd
+		loop := nextResponse(482)
+		Expect(viaHops(loop)).To(HaveLen(2))
+
+		tpl.InMsgs <- loop
+		res := nextResponse(482)
+		Expect(viaHops(res)).To(HaveLen(1))
+	})
+
+	It("should fork in parallel and cancel pending branches on 2xx", func() {
+		route.Targets = []sip.Uri{target("192.0.2.10"), target("192.0.2.11")}
+		route.Parallel = true
+		tpl.InMsgs <- request(sip.INVITE)
+
+		branches := make(map[string]sip.Request)
+		for i := 0; i < 2; i++ {
+			fwd := nextRequest(sip.INVITE)
+			branches[fwd.Recipient().String()] = fwd
+		}
+		first, second := branches["sip:bob@192.0.2.10"], branches["sip:bob@192.0.2.11"]
+		Expect(first).ToNot(BeNil())
+		Expect(second).ToNot(BeNil())
+
+		tpl.InMsgs <- response(second, 180, "Ringing")
+		nextResponse(180)
+
+		tpl.InMsgs <- response(first, 200, "OK")
+		var cancel sip.Request
+		for i := 0; i < 2; i++ {
+			switch msg := next().(type) {
+			case sip.Response:
+				Expect(msg.StatusCode()).To(Equal(sip.StatusCode(200)))
+			case sip.Request:
+				Expect(msg.Method()).To(Equal(sip.CANCEL))
+				cancel = msg
+			}
+		}
+		Expect(cancel).ToNot(BeNil())
+		Expect(cancel.Recipient().String()).To(Equal("sip:bob@192.0.2.11"))
+
+		tpl.InMsgs <- response(second, 487, "Request Terminated")
+		nextRequest(sip.ACK)
+		select {
+		case msg := <-out:
+			Fail("unexpected " + msg.Short())
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	It("should fork sequentially and aggregate challenges of the best response", func() {
+		route.Targets = []sip.Uri{target("192.0.2.10"), target("192.0.2.11"), target("192.0.2.12")}
+		tpl.InMsgs <- request(sip.OPTIONS)
+
+		fwd := nextRequest(sip.OPTIONS)
+		Expect(fwd.Recipient().String()).To(Equal("sip:bob@192.0.2.10"))
+		tpl.InMsgs <- response(fwd, 401, "Unauthorized", &sip.WWWAuthenticateHeader{
+			Challenge: sip.Challenge{Scheme: "Digest", Realm: "a.example.com", Nonce: "1"},
+		})
+
+		fwd = nextRequest(sip.OPTIONS)
+		Expect(fwd.Recipient().String()).To(Equal("sip:bob@192.0.2.11"))
+		tpl.InMsgs <- response(fwd, 407, "Proxy Authentication Required", &sip.ProxyAuthenticateHeader{
+			Challenge: sip.Challenge{Scheme: "Digest", Realm: "b.example.com", Nonce: "2"},
+		})
+
+		fwd = nextRequest(sip.OPTIONS)
+		Expect(fwd.Recipient().String()).To(Equal("sip:bob@192.0.2.12"))
+		tpl.InMsgs <- response(fwd, 503, "Service Unavailable")
+
+		res := nextResponse(401)
+		Expect(res.GetHeaders("WWW-Authenticate")).To(HaveLen(1))
+		Expect(res.GetHeaders("Proxy-Authenticate")).To(HaveLen(1))
+		Expect(viaHops(res)).To(HaveLen(1))
+	})
+
+	It("should fork sequentially over unreliable transport without waiting for completed branches", func() {
+		tpl.Unreliable = true
+		route.Targets = []sip.Uri{target("192.0.2.10"), target("192.0.2.11")}
+		tpl.InMsgs <- request(sip.INVITE)
+
+		fwd := nextRequest(sip.INVITE)
+		Expect(fwd.Recipient().String()).To(Equal("sip:bob@192.0.2.10"))
+		tpl.InMsgs <- response(fwd, 486, "Busy Here")
+
+		// ACK on 486 is sent by the client transaction which waits for Timer D
+		for {
+			req := next().(sip.Request)
+			if req.IsAck() {
+				continue
+			}
+			Expect(req.Method()).To(Equal(sip.INVITE))
+			Expect(req.Recipient().String()).To(Equal("sip:bob@192.0.2.11"))
+			fwd = req
+			break
+		}
+		tpl.InMsgs <- response(fwd, 200, "OK")
+		nextResponse(200)
+	})
+
+	It("should stop forking on 6xx", func() {
+		route.Targets = []sip.Uri{target("192.0.2.10"), target("192.0.2.11")}
+		route.Parallel = true
+		tpl.InMsgs <- request(sip.INVITE)
+
+		branches := make(map[string]sip.Request)
+		for i := 0; i < 2; i++ {
+			fwd := nextRequest(sip.INVITE)
+			branches[fwd.Recipient().String()] = fwd
+		}
+		first, second := branches["sip:bob@192.0.2.10"], branches["sip:bob@192.0.2.11"]
+
+		tpl.InMsgs <- response(second, 180, "Ringing")
+		nextResponse(180)
+
+		tpl.InMsgs <- response(first, 603, "Decline")
+		methods := make([]sip.RequestMethod, 0)
+		for i := 0; i < 2; i++ {
+			req, ok := next().(sip.Request)
+			Expect(ok).To(BeTrue())
+			methods = append(methods, req.Method())
+		}
+		Expect(methods).To(ConsistOf(sip.ACK, sip.CANCEL))
+
+		tpl.InMsgs <- response(second, 487, "Request Terminated")
+		nextRequest(sip.ACK)
+		nextResponse(603)
+	})
+})
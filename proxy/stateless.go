@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/masterclock/gosip/log"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/transport"
+)
+
+// NewStatelessProxy creates proxy forwarding messages of the transport layer
+// without transactions (RFC 3261 - 16.11.).
+// Each request is forwarded to the first target of the route only,
+// so Router must return the same route for retransmissions, ACK and CANCEL of the request.
+// Proxy is stopped when the transport layer is canceled.
+func NewStatelessProxy(tpl transport.Layer, config *Config) (*Proxy, error) {
+	p, err := newProxy(tpl, config)
+	if err != nil {
+		return nil, err
+	}
+
+	go p.serveStateless()
+
+	return p, nil
+}
+
+func (p *Proxy) serveStateless() {
+	defer func() {
+		p.wg.Wait()
+		close(p.done)
+	}()
+
+	messages, errs := p.tpl.Messages(), p.tpl.Errors()
+	for messages != nil || errs != nil {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				messages = nil
+				continue
+			}
+			switch msg := msg.(type) {
+			case sip.Request:
+				p.wg.Add(1)
+				go func() {
+					defer p.wg.Done()
+					p.handleStatelessRequest(msg)
+				}()
+			case sip.Response:
+				p.forwardStrayResponse(msg)
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			log.Debugf("GoSIP proxy received transport error: %s", err)
+		}
+	}
+}
+
+func (p *Proxy) handleStatelessRequest(req sip.Request) {
+	log.Debugf("%s received %s", p, req.Short())
+
+	if res := p.validate(req); res != nil {
+		if req.IsAck() {
+			return
+		}
+		p.respond(res)
+		return
+	}
+
+	origin := req
+	req = req.Clone().(sip.Request)
+	p.preprocessRoutes(req)
+
+	route, res := p.route(origin, req)
+	if res != nil {
+		if req.IsAck() {
+			return
+		}
+		p.respond(res)
+		return
+	}
+
+	// RFC 3261 - 16.11. - stateless proxy forwards the request to the single target
+	fwd := p.forwardedRequest(req, route.Targets[0], route.RecordRoute, statelessBranch(origin))
+	if err := p.tpl.Send(fwd); err != nil {
+		log.Warnf("%s failed to forward %s: %s", p, req.Short(), err)
+	}
+}
+
+// statelessBranch returns branch of the request forwarded statelessly (RFC 3261 - 16.11.).
+// The branch is computed from the top Via branch of the received request, so retransmissions get
+// the same branch, ACK on non-2xx response and CANCEL get the branch of the INVITE.
+// Fields which may differ between INVITE and its CANCEL or ACK, e.g. credentials, are not used.
+// It starts with the hash used for loop detection as the branch of the stateful proxy.
+func statelessBranch(req sip.Request) string {
+	var parts []string
+	if viaHop, ok := req.ViaHop(); ok && viaHop.Params != nil {
+		if branch, ok := viaHop.Params.Get("branch"); ok && branch != nil &&
+			strings.HasPrefix(branch.String(), sip.RFC3261BranchMagicCookie) {
+			parts = []string{branch.String()}
+		}
+	}
+	// request of RFC 2543 client is identified by the transaction fields (RFC 3261 - 17.2.3.),
+	// To tag is skipped since ACK has the tag of the response
+	if parts == nil {
+		parts = make([]string, 0)
+		if viaHop, ok := req.ViaHop(); ok {
+			parts = append(parts, viaHop.String())
+		}
+		if from, ok := req.From(); ok {
+			parts = append(parts, sip.Tag(from.Params))
+		}
+		if callID, ok := req.CallID(); ok {
+			parts = append(parts, string(*callID))
+		}
+		if cseq, ok := req.CSeq(); ok {
+			parts = append(parts, fmt.Sprintf("%d", cseq.SeqNo))
+		}
+		parts = append(parts, req.Recipient().String())
+	}
+
+	hash := md5.Sum([]byte(strings.Join(parts, "\n")))
+
+	return sip.RFC3261BranchMagicCookie + loopHash(req, true) + "." + hex.EncodeToString(hash[:8])
+}
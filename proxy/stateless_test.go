@@ -0,0 +1,178 @@
+package proxy_test
+
+import (
+	"time"
+
+	"github.com/masterclock/gosip/proxy"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/testutils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stateless Proxy", func() {
+	var (
+		tpl *testutils.MockTransportLayer
+		p   *proxy.Proxy
+		out chan sip.Message
+	)
+
+	request := func(method sip.RequestMethod, branch string, headers ...string) sip.Request {
+		lines := []string{
+			string(method) + " sip:bob@example.com SIP/2.0",
+			"Via: SIP/2.0/UDP 10.0.0.1:5060;branch=" + branch,
+			"Max-Forwards: 70",
+			"From: <sip:alice@example.com>;tag=alice",
+			"Call-ID: stateless-call",
+			"CSeq: 1 " + string(method),
+		}
+		lines = append(lines, headers...)
+		lines = append(lines, "Content-Length: 0", "", "")
+
+		return testutils.Request(lines)
+	}
+
+	next := func() sip.Message {
+		select {
+		case msg := <-out:
+			return msg
+		case <-time.After(time.Second):
+			Fail("message was not sent")
+		}
+		return nil
+	}
+
+	nextRequest := func(method sip.RequestMethod) sip.Request {
+		msg := next()
+		req, ok := msg.(sip.Request)
+		Expect(ok).To(BeTrue(), "unexpected "+msg.Short())
+		Expect(req.Method()).To(Equal(method))
+		return req
+	}
+
+	branchOf := func(msg sip.Message) string {
+		hop, ok := msg.ViaHop()
+		Expect(ok).To(BeTrue())
+		Expect(hop.Host).To(Equal("127.0.0.1"))
+		branch, ok := hop.Params.Get("branch")
+		Expect(ok).To(BeTrue())
+		return branch.String()
+	}
+
+	expectNothing := func() {
+		select {
+		case msg := <-out:
+			Fail("unexpected " + msg.Short())
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	BeforeEach(func() {
+		tpl = testutils.NewMockTransportLayer()
+		out = make(chan sip.Message, 10)
+		go func() {
+			for msg := range tpl.OutMsgs {
+				out <- msg
+			}
+		}()
+
+		var err error
+		p, err = proxy.NewStatelessProxy(tpl, &proxy.Config{
+			Router: func(req sip.Request) (*proxy.Route, error) {
+				target := &sip.SipUri{User: sip.String{Str: "bob"}, Host: "192.0.2.10"}
+				return &proxy.Route{Targets: []sip.Uri{target}}, nil
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func(done Done) {
+		tpl.Cancel()
+		<-p.Done()
+		close(done)
+	}, 3)
+
+	It("should forward requests with deterministic branch", func() {
+		branch := sip.GenerateBranch()
+		tpl.InMsgs <- request(sip.INVITE, branch, "To: <sip:bob@example.com>")
+		fwd := nextRequest(sip.INVITE)
+		Expect(fwd.Recipient().String()).To(Equal("sip:bob@192.0.2.10"))
+		Expect(fwd.GetHeaders("Via")).To(HaveLen(2))
+		Expect(fwd.GetHeaders("Max-Forwards")[0].String()).To(Equal("Max-Forwards: 69"))
+		expectNothing()
+
+		By("retransmission gets the same branch")
+		tpl.InMsgs <- request(sip.INVITE, branch, "To: <sip:bob@example.com>")
+		Expect(branchOf(nextRequest(sip.INVITE))).To(Equal(branchOf(fwd)))
+
+		By("CANCEL and ACK on non-2xx response get branch of the INVITE")
+		tpl.InMsgs <- request(sip.CANCEL, branch, "To: <sip:bob@example.com>")
+		Expect(branchOf(nextRequest(sip.CANCEL))).To(Equal(branchOf(fwd)))
+		tpl.InMsgs <- request(sip.ACK, branch, "To: <sip:bob@example.com>;tag=bob")
+		Expect(branchOf(nextRequest(sip.ACK))).To(Equal(branchOf(fwd)))
+
+		By("another transaction gets another branch")
+		tpl.InMsgs <- request(sip.INVITE, sip.GenerateBranch(), "To: <sip:bob@example.com>")
+		Expect(branchOf(nextRequest(sip.INVITE))).ToNot(Equal(branchOf(fwd)))
+	})
+
+	It("should give CANCEL and ACK branch of the INVITE with credentials", func() {
+		branch := sip.GenerateBranch()
+		tpl.InMsgs <- request(sip.INVITE, branch, "To: <sip:bob@example.com>",
+			`Proxy-Authorization: Digest username="alice", realm="example.com", nonce="1", uri="sip:bob@example.com", response="2"`)
+		fwd := nextRequest(sip.INVITE)
+
+		tpl.InMsgs <- request(sip.CANCEL, branch, "To: <sip:bob@example.com>")
+		Expect(branchOf(nextRequest(sip.CANCEL))).To(Equal(branchOf(fwd)))
+		tpl.InMsgs <- request(sip.ACK, branch, "To: <sip:bob@example.com>;tag=bob")
+		Expect(branchOf(nextRequest(sip.ACK))).To(Equal(branchOf(fwd)))
+	})
+
+	It("should give ACK of RFC 2543 client branch of the INVITE", func() {
+		tpl.InMsgs <- request(sip.INVITE, "2543-branch", "To: <sip:bob@example.com>")
+		fwd := nextRequest(sip.INVITE)
+
+		tpl.InMsgs <- request(sip.ACK, "2543-branch", "To: <sip:bob@example.com>;tag=bob")
+		Expect(branchOf(nextRequest(sip.ACK))).To(Equal(branchOf(fwd)))
+	})
+
+	It("should detect loop", func() {
+		req := request(sip.OPTIONS, sip.GenerateBranch(), "To: <sip:bob@example.com>")
+		req.SetRecipient(&sip.SipUri{User: sip.String{Str: "bob"}, Host: "192.0.2.10"})
+		tpl.InMsgs <- req
+		fwd := nextRequest(sip.OPTIONS)
+
+		By("request with the same Request-URI is received again")
+		tpl.InMsgs <- fwd
+		msg := next()
+		res, ok := msg.(sip.Response)
+		Expect(ok).To(BeTrue(), "unexpected "+msg.Short())
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(482)))
+	})
+
+	It("should forward responses without own Via", func() {
+		tpl.InMsgs <- request(sip.OPTIONS, sip.GenerateBranch(), "To: <sip:bob@example.com>")
+		fwd := nextRequest(sip.OPTIONS)
+
+		tpl.InMsgs <- sip.NewResponseFromRequest(fwd, 200, "OK", "")
+		msg := next()
+		res, ok := msg.(sip.Response)
+		Expect(ok).To(BeTrue(), "unexpected "+msg.Short())
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(200)))
+		Expect(res.GetHeaders("Via")).To(HaveLen(1))
+		hop, _ := res.ViaHop()
+		Expect(hop.Host).To(Equal("10.0.0.1"))
+
+		By("response on request of another proxy is discarded")
+		req := request(sip.OPTIONS, sip.GenerateBranch(), "To: <sip:bob@example.com>")
+		tpl.InMsgs <- sip.NewResponseFromRequest(req, 200, "OK", "")
+		expectNothing()
+	})
+
+	It("should respond to invalid requests", func() {
+		tpl.InMsgs <- request(sip.OPTIONS, sip.GenerateBranch(), "To: <sip:bob@example.com>", "Proxy-Require: foo")
+		msg := next()
+		res, ok := msg.(sip.Response)
+		Expect(ok).To(BeTrue(), "unexpected "+msg.Short())
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(420)))
+	})
+})
@@ -0,0 +1,157 @@
+package registrar
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/timing"
+)
+
+// Binding is a contact address bound to the address-of-record (RFC 3261 - 10.).
+type Binding struct {
+	// AOR is the canonical address-of-record.
+	AOR string
+	// Contact is the bound contact with all its params.
+	Contact *sip.ContactHeader
+	// Expires is the time the binding expires.
+	Expires time.Time
+	// CallID and CSeq of the last REGISTER request updated the binding.
+	CallID string
+	CSeq   uint32
+}
+
+// Key returns the key of the contact address inside the address-of-record.
+func (binding *Binding) Key() string {
+	return contactKey(binding.Contact.Address)
+}
+
+// Q returns preference of the contact (RFC 3261 - 20.10.), 1 if the 'q' param is missing or invalid.
+func (binding *Binding) Q() float64 {
+	if binding.Contact.Params != nil {
+		if q, ok := binding.Contact.Params.Get("q"); ok && q != nil {
+			if val, err := strconv.ParseFloat(q.String(), 64); err == nil {
+				return val
+			}
+		}
+	}
+
+	return 1
+}
+
+// ExpiresIn returns the duration left until the binding expires.
+func (binding *Binding) ExpiresIn() time.Duration {
+	left := binding.Expires.Sub(timing.Now())
+	if left < 0 {
+		return 0
+	}
+
+	return left
+}
+
+func (binding *Binding) String() string {
+	return fmt.Sprintf("Binding %s -> %s", binding.AOR, binding.Contact.Address)
+}
+
+// LocationStore keeps the bindings of the address-of-records (RFC 3261 - 10.2.).
+// Expired bindings should not be returned.
+type LocationStore interface {
+	// Bindings returns the actual bindings of the address-of-record.
+	Bindings(aor string) ([]*Binding, error)
+	// Put adds or updates the binding.
+	Put(binding *Binding) error
+	// Remove removes the binding of the contact address with the key.
+	Remove(aor string, key string) error
+}
+
+// MemoryStore is an in-memory LocationStore, the bindings are removed by timers when they expire.
+type MemoryStore struct {
+	mu       *sync.Mutex
+	bindings map[string]map[string]*memoryBinding
+}
+
+type memoryBinding struct {
+	*Binding
+	timer timing.Timer
+}
+
+// NewMemoryStore creates empty in-memory location store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mu:       new(sync.Mutex),
+		bindings: make(map[string]map[string]*memoryBinding),
+	}
+}
+
+func (store *MemoryStore) Bindings(aor string) ([]*Binding, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	bindings := make([]*Binding, 0, len(store.bindings[aor]))
+	for _, binding := range store.bindings[aor] {
+		if binding.ExpiresIn() > 0 {
+			bindings = append(bindings, binding.Binding)
+		}
+	}
+
+	return bindings, nil
+}
+
+func (store *MemoryStore) Put(binding *Binding) error {
+	key := binding.Key()
+	stored := &memoryBinding{Binding: binding}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.bindings[binding.AOR]; !ok {
+		store.bindings[binding.AOR] = make(map[string]*memoryBinding)
+	}
+	if old, ok := store.bindings[binding.AOR][key]; ok {
+		old.timer.Stop()
+	}
+	stored.timer = timing.AfterFunc(binding.ExpiresIn(), func() {
+		store.expire(stored)
+	})
+	store.bindings[binding.AOR][key] = stored
+
+	return nil
+}
+
+func (store *MemoryStore) Remove(aor string, key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if binding, ok := store.bindings[aor][key]; ok {
+		binding.timer.Stop()
+		store.delete(aor, key)
+	}
+
+	return nil
+}
+
+// expire removes the binding if it was not updated.
+func (store *MemoryStore) expire(binding *memoryBinding) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	key := binding.Key()
+	if stored, ok := store.bindings[binding.AOR][key]; ok && stored == binding {
+		store.delete(binding.AOR, key)
+	}
+}
+
+func (store *MemoryStore) delete(aor string, key string) {
+	delete(store.bindings[aor], key)
+	if len(store.bindings[aor]) == 0 {
+		delete(store.bindings, aor)
+	}
+}
+
+// contactKey returns the key of the contact address, contacts which are equal
+// according to URI comparison rules have the same key (RFC 3261 - 10.3. - step 6.).
+func contactKey(uri sip.ContactUri) string {
+	return sip.NormalizeUri(uri)
+}
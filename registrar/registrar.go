@@ -0,0 +1,386 @@
+// registrar package implements SIP registrar (RFC 3261 - 10.3.)
+package registrar
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masterclock/gosip"
+	"github.com/masterclock/gosip/log"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/timing"
+	"github.com/masterclock/gosip/util"
+)
+
+// Default registration intervals, used when Registrar is created without config.
+const (
+	DefaultExpires = 3600 * time.Second
+	MinExpires     = 60 * time.Second
+)
+
+// Config describes available options of the registrar.
+type Config struct {
+	// Store keeps the bindings, in-memory store is used if nil.
+	Store LocationStore
+	// Authenticator authenticates REGISTER requests, may be nil.
+	Authenticator gosip.Authenticator
+	// DefaultExpires is used if the contact has no expiration, default DefaultExpires.
+	DefaultExpires time.Duration
+	// MinExpires is the shortest allowed expiration, default MinExpires.
+	MinExpires time.Duration
+	// MaxExpires limits the expiration of the bindings, zero means no limit.
+	MaxExpires time.Duration
+}
+
+// Registrar accepts REGISTER requests of the server and keeps the bindings in the location store.
+type Registrar struct {
+	srv            *gosip.Server
+	store          LocationStore
+	defaultExpires time.Duration
+	minExpires     time.Duration
+	maxExpires     time.Duration
+	// mu serializes updates of the bindings
+	mu *sync.Mutex
+}
+
+// NewRegistrar creates registrar and registers it as REGISTER handler of the server.
+func NewRegistrar(srv *gosip.Server, config *Config) (*Registrar, error) {
+	if config == nil {
+		config = &Config{}
+	}
+
+	r := &Registrar{
+		srv:            srv,
+		store:          config.Store,
+		defaultExpires: config.DefaultExpires,
+		minExpires:     config.MinExpires,
+		maxExpires:     config.MaxExpires,
+		mu:             new(sync.Mutex),
+	}
+	if r.store == nil {
+		r.store = NewMemoryStore()
+	}
+	if r.defaultExpires <= 0 {
+		r.defaultExpires = DefaultExpires
+	}
+	if r.minExpires <= 0 {
+		r.minExpires = MinExpires
+	}
+
+	var handler gosip.RequestHandler = r.handleRegister
+	if config.Authenticator != nil {
+		handler = srv.WithAuth(config.Authenticator, handler)
+	}
+	if err := srv.OnRequest(sip.REGISTER, handler); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Store returns the location store of the registrar.
+func (r *Registrar) Store() LocationStore {
+	return r.store
+}
+
+// Lookup returns the actual bindings of the address-of-record ordered by preference.
+func (r *Registrar) Lookup(aor sip.Uri) ([]*Binding, error) {
+	bindings, err := r.store.Bindings(AOR(aor))
+	if err != nil {
+		return nil, err
+	}
+	sortBindings(bindings)
+
+	return bindings, nil
+}
+
+func (r *Registrar) handleRegister(req sip.Request) {
+	res := r.Process(req)
+	if _, err := r.srv.Respond(res); err != nil {
+		log.Errorf("GoSIP registrar failed to respond on %s: %s", req.Short(), err)
+	}
+}
+
+// Process updates the bindings by the REGISTER request (RFC 3261 - 10.3.)
+// and returns the response to be sent.
+func (r *Registrar) Process(req sip.Request) sip.Response {
+	to, ok := req.To()
+	if !ok || to.Address == nil {
+		return r.response(req, 400, "Bad Request", "missing 'To' header")
+	}
+	callID, ok := req.CallID()
+	if !ok {
+		return r.response(req, 400, "Bad Request", "missing 'Call-ID' header")
+	}
+	cseq, ok := req.CSeq()
+	if !ok {
+		return r.response(req, 400, "Bad Request", "missing 'CSeq' header")
+	}
+
+	expires, hasExpires, err := expiresOf(req)
+	if err != nil {
+		return r.response(req, 400, "Bad Request", err.Error())
+	}
+
+	aor := AOR(to.Address)
+	contacts := contactsOf(req)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bindings, err := r.store.Bindings(aor)
+	if err != nil {
+		log.Errorf("GoSIP registrar failed to get bindings of %s: %s", aor, err)
+		return r.response(req, 500, "Server Internal Error", "")
+	}
+	stored := make(map[string]*Binding, len(bindings))
+	for _, binding := range bindings {
+		stored[binding.Key()] = binding
+	}
+
+	// RFC 3261 - 10.3. - step 6. - wildcard removes all bindings
+	if len(contacts) > 0 && isWildcard(contacts[0]) {
+		if len(contacts) > 1 || !hasExpires || expires != 0 {
+			return r.response(req, 400, "Bad Request", "wildcard contact requires single contact and 'Expires: 0'")
+		}
+		for _, binding := range bindings {
+			if !isNewer(binding, string(*callID), cseq.SeqNo) {
+				return r.response(req, 500, "Server Internal Error", "out of order request")
+			}
+		}
+		for _, binding := range bindings {
+			if err := r.store.Remove(aor, binding.Key()); err != nil {
+				log.Errorf("GoSIP registrar failed to remove %s: %s", binding, err)
+				return r.response(req, 500, "Server Internal Error", "")
+			}
+		}
+
+		return r.ok(req, nil)
+	}
+
+	// validate all contacts before any update is made
+	updates := make([]*Binding, 0, len(contacts))
+	removes := make([]string, 0)
+	now := timing.Now()
+	for _, contact := range contacts {
+		if isWildcard(contact) {
+			return r.response(req, 400, "Bad Request", "wildcard contact requires single contact and 'Expires: 0'")
+		}
+
+		contactExpires := r.defaultExpires
+		if hasExpires {
+			contactExpires = expires
+		}
+		if val, ok, err := contactExpiresOf(contact); err != nil {
+			return r.response(req, 400, "Bad Request", err.Error())
+		} else if ok {
+			contactExpires = val
+		}
+
+		key := contactKey(contact.Address)
+		if binding, ok := stored[key]; ok && !isNewer(binding, string(*callID), cseq.SeqNo) {
+			return r.response(req, 500, "Server Internal Error", "out of order request")
+		}
+
+		if contactExpires == 0 {
+			if _, ok := stored[key]; ok {
+				removes = append(removes, key)
+			}
+			continue
+		}
+		// RFC 3261 - 10.3. - step 7. - interval too brief
+		if contactExpires < r.minExpires {
+			res := r.response(req, 423, "Interval Too Brief", "")
+			minExpires := sip.MinExpires(expiresSeconds(r.minExpires))
+			res.AppendHeader(&minExpires)
+			return res
+		}
+		if r.maxExpires > 0 && contactExpires > r.maxExpires {
+			contactExpires = r.maxExpires
+		}
+
+		bound := contact.Clone().(*sip.ContactHeader)
+		bound.Params = withoutParam(bound.Params, "expires")
+		updates = append(updates, &Binding{
+			AOR:     aor,
+			Contact: bound,
+			Expires: now.Add(contactExpires),
+			CallID:  string(*callID),
+			CSeq:    cseq.SeqNo,
+		})
+	}
+
+	for _, key := range removes {
+		if err := r.store.Remove(aor, key); err != nil {
+			log.Errorf("GoSIP registrar failed to remove %s of %s: %s", key, aor, err)
+			return r.response(req, 500, "Server Internal Error", "")
+		}
+	}
+	for _, binding := range updates {
+		if err := r.store.Put(binding); err != nil {
+			log.Errorf("GoSIP registrar failed to put %s: %s", binding, err)
+			return r.response(req, 500, "Server Internal Error", "")
+		}
+	}
+
+	bindings, err = r.store.Bindings(aor)
+	if err != nil {
+		log.Errorf("GoSIP registrar failed to get bindings of %s: %s", aor, err)
+		return r.response(req, 500, "Server Internal Error", "")
+	}
+
+	return r.ok(req, bindings)
+}
+
+// ok returns 200 OK with the current bindings (RFC 3261 - 10.3. - step 8.).
+func (r *Registrar) ok(req sip.Request, bindings []*Binding) sip.Response {
+	res := r.response(req, 200, "OK", "")
+	sortBindings(bindings)
+	for _, binding := range bindings {
+		contact := binding.Contact.Clone().(*sip.ContactHeader)
+		contact.Params = withoutParam(contact.Params, "expires")
+		contact.Params.Add("expires", sip.String{Str: fmt.Sprintf("%d", expiresSeconds(binding.ExpiresIn()))})
+		res.AppendHeader(contact)
+	}
+
+	return res
+}
+
+func (r *Registrar) response(req sip.Request, statusCode sip.StatusCode, reason string, cause string) sip.Response {
+	res := sip.NewResponseFromRequest(req, statusCode, reason, "")
+	if to, ok := res.To(); ok {
+		if to.Params == nil {
+			to.Params = sip.NewParams()
+		}
+		if _, ok := to.Params.Get("tag"); !ok {
+			to.Params.Add("tag", sip.String{Str: util.RandString(10)})
+		}
+	}
+	if cause != "" {
+		log.Debugf("GoSIP registrar rejects %s: %s", req.Short(), cause)
+	}
+
+	return res
+}
+
+// AOR returns the canonical address-of-record of the URI (RFC 3261 - 10.3. - step 5.),
+// i.e. sip:user@domain without port and params.
+func AOR(uri sip.Uri) string {
+	sipUri, ok := uri.(*sip.SipUri)
+	if !ok {
+		return sip.NormalizeUri(uri)
+	}
+
+	scheme := "sip"
+	if sipUri.IsEncrypted {
+		scheme = "sips"
+	}
+	aor := scheme + ":"
+	if user, ok := sipUri.User.(sip.String); ok && user.String() != "" {
+		aor += user.String() + "@"
+	}
+
+	return aor + strings.ToLower(sipUri.Host)
+}
+
+// expiresOf returns value of the 'Expires' header.
+func expiresOf(req sip.Request) (time.Duration, bool, error) {
+	if expires, ok := req.Expires(); ok {
+		return expires.Duration(), true, nil
+	}
+	// header failed to be parsed or added by the application is kept as generic one
+	hdrs := req.GetHeaders("Expires")
+	if len(hdrs) == 0 {
+		return 0, false, nil
+	}
+	header, ok := hdrs[0].(*sip.GenericHeader)
+	if !ok {
+		return 0, false, fmt.Errorf("invalid 'Expires' header: %s", hdrs[0])
+	}
+	expires, err := parseExpires(header.Contents)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid 'Expires' header: %s", err)
+	}
+
+	return expires, true, nil
+}
+
+// contactExpiresOf returns value of the 'expires' param of the contact.
+func contactExpiresOf(contact *sip.ContactHeader) (time.Duration, bool, error) {
+	if contact.Params == nil {
+		return 0, false, nil
+	}
+	val, ok := contact.Params.Get("expires")
+	if !ok || val == nil {
+		return 0, false, nil
+	}
+	expires, err := parseExpires(val.String())
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid 'expires' param of %s: %s", contact, err)
+	}
+
+	return expires, true, nil
+}
+
+func parseExpires(value string) (time.Duration, error) {
+	seconds, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func expiresSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// withoutParam returns copy of the params without the key.
+func withoutParam(params sip.Params, key string) sip.Params {
+	result := sip.NewParams()
+	if params == nil {
+		return result
+	}
+	for _, k := range params.Keys() {
+		if k != key {
+			val, _ := params.Get(k)
+			result.Add(k, val)
+		}
+	}
+
+	return result
+}
+
+func contactsOf(req sip.Request) []*sip.ContactHeader {
+	contacts := make([]*sip.ContactHeader, 0)
+	for _, hdr := range req.GetHeaders("Contact") {
+		if contact, ok := hdr.(*sip.ContactHeader); ok {
+			contacts = append(contacts, contact)
+		}
+	}
+
+	return contacts
+}
+
+func isWildcard(contact *sip.ContactHeader) bool {
+	return contact.Address != nil && contact.Address.IsWildcard()
+}
+
+// isNewer returns true if the request with Call-ID and CSeq can update the binding (RFC 3261 - 10.3. - step 7.).
+func isNewer(binding *Binding, callID string, cseq uint32) bool {
+	return binding.CallID != callID || cseq > binding.CSeq
+}
+
+// sortBindings sorts bindings by descending preference.
+func sortBindings(bindings []*Binding) {
+	sort.SliceStable(bindings, func(i, j int) bool {
+		if bindings[i].Q() != bindings[j].Q() {
+			return bindings[i].Q() > bindings[j].Q()
+		}
+		return bindings[i].Key() < bindings[j].Key()
+	})
+}
@@ -0,0 +1,238 @@
+package registrar_test
+
+import (
+	"time"
+
+	"github.com/masterclock/gosip"
+	"github.com/masterclock/gosip/registrar"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/testutils"
+	"github.com/masterclock/gosip/timing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registrar", func() {
+	var (
+		srv *gosip.Server
+		r   *registrar.Registrar
+	)
+
+	register := func(callID string, cseq string, headers ...string) sip.Request {
+		lines := []string{
+			"REGISTER sip:registrar.biloxi.com SIP/2.0",
+			"Via: SIP/2.0/UDP bobspc.biloxi.com:5060;branch=" + sip.GenerateBranch(),
+			"Max-Forwards: 70",
+			"From: Bob <sip:bob@biloxi.com>;tag=456248",
+			"To: Bob <sip:bob@biloxi.com>",
+			"Call-ID: " + callID,
+			"CSeq: " + cseq + " REGISTER",
+		}
+		lines = append(lines, headers...)
+		lines = append(lines, "Content-Length: 0", "", "")
+
+		return testutils.Request(lines)
+	}
+
+	contacts := func(res sip.Response) []string {
+		result := make([]string, 0)
+		for _, hdr := range res.GetHeaders("Contact") {
+			result = append(result, hdr.String())
+		}
+		return result
+	}
+
+	// set once, goroutines of the previous server can still read it
+	timing.MockMode = true
+
+	BeforeEach(func() {
+		srv = gosip.NewServer(nil)
+		var err error
+		r, err = registrar.NewRegistrar(srv, &registrar.Config{
+			MaxExpires: 7200 * time.Second,
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		srv.Shutdown()
+	})
+
+	It("should bind contacts and answer with the current bindings", func() {
+		res := r.Process(register("reg-1", "1",
+			"Contact: <sip:bob@192.0.2.4>;q=0.5",
+			"Contact: <sip:bob@192.0.2.5>;expires=600",
+			"Expires: 1800",
+		))
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(200)))
+		Expect(contacts(res)).To(Equal([]string{
+			"Contact: <sip:bob@192.0.2.5>;expires=600",
+			"Contact: <sip:bob@192.0.2.4>;q=0.5;expires=1800",
+		}))
+		to, _ := res.To()
+		Expect(to.Params.Has("tag")).To(BeTrue())
+
+		By("query without contacts returns bindings with the remaining time")
+		timing.Elapse(100 * time.Second)
+		res = r.Process(register("reg-1", "2"))
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(200)))
+		Expect(contacts(res)).To(Equal([]string{
+			"Contact: <sip:bob@192.0.2.5>;expires=500",
+			"Contact: <sip:bob@192.0.2.4>;q=0.5;expires=1700",
+		}))
+
+		bindings, err := r.Lookup(&sip.SipUri{User: sip.String{Str: "bob"}, Host: "BILOXI.com"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bindings).To(HaveLen(2))
+		Expect(bindings[0].Contact.Address.String()).To(Equal("sip:bob@192.0.2.5"))
+	})
+
+	It("should use default expiration and limit it with the maximum", func() {
+		res := r.Process(register("reg-1", "1",
+			"Contact: <sip:bob@192.0.2.4>",
+			"Contact: <sip:bob@192.0.2.5>;expires=86400",
+		))
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(200)))
+		Expect(contacts(res)).To(ConsistOf(
+			"Contact: <sip:bob@192.0.2.4>;expires=3600",
+			"Contact: <sip:bob@192.0.2.5>;expires=7200",
+		))
+	})
+
+	It("should reject too brief expiration with Min-Expires", func() {
+		res := r.Process(register("reg-1", "1",
+			"Contact: <sip:bob@192.0.2.4>",
+			"Contact: <sip:bob@192.0.2.5>;expires=30",
+		))
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(423)))
+		hdrs := res.GetHeaders("Min-Expires")
+		Expect(hdrs).To(HaveLen(1))
+		Expect(hdrs[0].String()).To(Equal("Min-Expires: 60"))
+
+		By("no bindings are updated")
+		bindings, err := r.Store().Bindings("sip:bob@biloxi.com")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bindings).To(BeEmpty())
+	})
+
+	It("should read generic Expires header", func() {
+		req := register("reg-1", "1", "Contact: <sip:bob@192.0.2.4>")
+		req.AppendHeader(&sip.GenericHeader{HeaderName: "Expires", Contents: "3600"})
+		res := r.Process(req)
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(200)))
+		Expect(contacts(res)).To(ConsistOf("Contact: <sip:bob@192.0.2.4>;expires=3600"))
+
+		By("invalid value kept by the parser is rejected")
+		res = r.Process(register("reg-1", "2", "Contact: <sip:bob@192.0.2.4>", "Expires: soon"))
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(400)))
+	})
+
+	It("should reject out of order requests of the same Call-ID", func() {
+		res := r.Process(register("reg-1", "5", "Contact: <sip:bob@192.0.2.4>"))
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(200)))
+
+		res = r.Process(register("reg-1", "5", "Contact: <sip:bob@192.0.2.4>;expires=0"))
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(500)))
+		res = r.Process(register("reg-1", "4", "Contact: *", "Expires: 0"))
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(500)))
+
+		By("request of another Call-ID updates the binding")
+		res = r.Process(register("reg-2", "1", "Contact: <sip:bob@192.0.2.4>;expires=120"))
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(200)))
+		Expect(contacts(res)).To(Equal([]string{"Contact: <sip:bob@192.0.2.4>;expires=120"}))
+	})
+
+	It("should remove bindings", func() {
+		res := r.Process(register("reg-1", "1",
+			"Contact: <sip:bob@192.0.2.4>",
+			"Contact: <sip:bob@192.0.2.5>",
+		))
+		Expect(contacts(res)).To(HaveLen(2))
+
+		res = r.Process(register("reg-1", "2", "Contact: <sip:bob@192.0.2.4>;expires=0"))
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(200)))
+		Expect(contacts(res)).To(Equal([]string{"Contact: <sip:bob@192.0.2.5>;expires=3600"}))
+
+		By("wildcard requires 'Expires: 0'")
+		res = r.Process(register("reg-1", "3", "Contact: *"))
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(400)))
+
+		res = r.Process(register("reg-1", "4", "Contact: *", "Expires: 0"))
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(200)))
+		Expect(contacts(res)).To(BeEmpty())
+	})
+
+	It("should match contacts by URI comparison rules", func() {
+		res := r.Process(register("reg-1", "1", "Contact: <sip:bob@Client.Biloxi.com;ob>"))
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(200)))
+
+		res = r.Process(register("reg-1", "2", "Contact: <sip:%62ob@client.biloxi.COM>;expires=0"))
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(200)))
+		Expect(contacts(res)).To(BeEmpty())
+
+		By("contacts with different transport are different bindings")
+		res = r.Process(register("reg-1", "3",
+			"Contact: <sip:bob@client.biloxi.com>",
+			"Contact: <sip:bob@client.biloxi.com;transport=tcp>",
+		))
+		Expect(contacts(res)).To(HaveLen(2))
+	})
+
+	It("should remove expired bindings", func() {
+		r.Process(register("reg-1", "1",
+			"Contact: <sip:bob@192.0.2.4>;expires=60",
+			"Contact: <sip:bob@192.0.2.5>;expires=120",
+		))
+
+		timing.Elapse(60 * time.Second)
+		bindings, err := r.Store().Bindings("sip:bob@biloxi.com")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bindings).To(HaveLen(1))
+		Expect(bindings[0].Contact.Address.String()).To(Equal("sip:bob@192.0.2.5"))
+
+		By("refresh postpones the expiration")
+		r.Process(register("reg-1", "2", "Contact: <sip:bob@192.0.2.5>;expires=120"))
+		timing.Elapse(60 * time.Second)
+		bindings, _ = r.Store().Bindings("sip:bob@biloxi.com")
+		Expect(bindings).To(HaveLen(1))
+
+		timing.Elapse(60 * time.Second)
+		bindings, _ = r.Store().Bindings("sip:bob@biloxi.com")
+		Expect(bindings).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Registrar on Server", func() {
+	It("should answer REGISTER requests", func(done Done) {
+		srv := gosip.NewServer(&gosip.ServerConfig{HostAddr: "127.0.0.1"})
+		defer srv.Shutdown()
+		Expect(srv.Listen("udp", "127.0.0.1:5090")).To(Succeed())
+		_, err := registrar.NewRegistrar(srv, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		ua := gosip.NewServer(&gosip.ServerConfig{HostAddr: "127.0.0.1"})
+		defer ua.Shutdown()
+		Expect(ua.Listen("udp", "127.0.0.1:5092")).To(Succeed())
+
+		req := testutils.Request([]string{
+			"REGISTER sip:127.0.0.1:5090 SIP/2.0",
+			"From: <sip:alice@example.com>;tag=1928301774",
+			"To: <sip:alice@example.com>",
+			"Call-ID: registrar-server-call",
+			"CSeq: 1 REGISTER",
+			"Contact: <sip:alice@127.0.0.1:5092>",
+			"Expires: 300",
+			"Content-Length: 0",
+			"",
+			"",
+		})
+		responses, err := ua.Request(req)
+		Expect(err).ToNot(HaveOccurred())
+
+		res := <-responses
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(200)))
+		contact, ok := res.Contact()
+		Expect(ok).To(BeTrue())
+		Expect(contact.String()).To(Equal("Contact: <sip:alice@127.0.0.1:5092>;expires=300"))
+		close(done)
+	}, 3)
+})
@@ -0,0 +1,420 @@
+package gosip
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/masterclock/gosip/auth"
+	"github.com/masterclock/gosip/log"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/timing"
+	"github.com/masterclock/gosip/util"
+)
+
+// Default registration values, used when options are omitted in RegistrationConfig.
+const (
+	DefaultRegistrationExpires = 3600 * time.Second
+	DefaultRetryInterval       = 30 * time.Second
+	DefaultMaxRetryInterval    = 1800 * time.Second
+)
+
+// maxRegisterAttempts limits REGISTER requests sent on 423 responses.
+const maxRegisterAttempts = 3
+
+// RegistrationStatus is the status of the registration.
+type RegistrationStatus int
+
+const (
+	Registered RegistrationStatus = iota + 1
+	RegistrationFailed
+	Unregistered
+)
+
+func (status RegistrationStatus) String() string {
+	switch status {
+	case Registered:
+		return "Registered"
+	case RegistrationFailed:
+		return "RegistrationFailed"
+	case Unregistered:
+		return "Unregistered"
+	default:
+		return "Unknown"
+	}
+}
+
+// RegistrationState describes the change of the registration.
+type RegistrationState struct {
+	Status RegistrationStatus
+	// Expires is the expiration granted by the registrar.
+	Expires time.Duration
+	// Response is the last response received from the registrar, may be nil.
+	Response sip.Response
+	// Err is the failure reason.
+	Err error
+}
+
+func (state RegistrationState) String() string {
+	if state.Err != nil {
+		return fmt.Sprintf("%s: %s", state.Status, state.Err)
+	}
+
+	return state.Status.String()
+}
+
+// RegistrationConfig describes the binding kept by the registration.
+type RegistrationConfig struct {
+	// Registrar is the Request-URI of REGISTER requests, required.
+	Registrar sip.Uri
+	// AOR is the address-of-record to bind, required.
+	AOR *sip.Address
+	// Contact is the address bound to the AOR, required.
+	Contact sip.ContactUri
+	// Expires is the requested expiration, default DefaultRegistrationExpires.
+	Expires time.Duration
+	// Credentials answer authentication challenges of the registrar, may be nil.
+	Credentials auth.CredentialsProvider
+	// RetryInterval is the base retry delay after a failure, default DefaultRetryInterval.
+	RetryInterval time.Duration
+	// MaxRetryInterval limits the retry delay, default DefaultMaxRetryInterval.
+	MaxRetryInterval time.Duration
+}
+
+// Registration keeps the binding registered with REGISTER requests (RFC 3261 - 10.2.).
+// The binding is refreshed at half of the granted expiration,
+// failed registration is retried with exponential backoff (RFC 5626 - 4.5.).
+type Registration struct {
+	srv              *Server
+	registrar        sip.Uri
+	aor              *sip.Address
+	contact          sip.ContactUri
+	expires          time.Duration
+	credentials      auth.CredentialsProvider
+	retryInterval    time.Duration
+	maxRetryInterval time.Duration
+
+	callID     sip.CallID
+	fromTag    string
+	cseq       uint32
+	registered bool
+	// last reported state
+	state RegistrationState
+
+	states   chan RegistrationState
+	cancel   chan struct{}
+	done     chan struct{}
+	stopOnce *sync.Once
+}
+
+// Register starts registration of the binding.
+// The registration is stopped by Unregister or Server.Shutdown, the binding is removed on stop.
+func (srv *Server) Register(config *RegistrationConfig) (*Registration, error) {
+	if config == nil || config.Registrar == nil || config.AOR == nil || config.Contact == nil {
+		return nil, fmt.Errorf("registrar, AOR and contact are required to register")
+	}
+	if srv.shuttingDown() {
+		return nil, fmt.Errorf("can not register through stopped server")
+	}
+
+	r := &Registration{
+		srv:              srv,
+		registrar:        config.Registrar.Clone(),
+		aor:              config.AOR.Clone(),
+		contact:          config.Contact.Clone().(sip.ContactUri),
+		expires:          config.Expires,
+		credentials:      config.Credentials,
+		retryInterval:    config.RetryInterval,
+		maxRetryInterval: config.MaxRetryInterval,
+		callID:           sip.CallID(util.RandString(32)),
+		fromTag:          util.RandString(10),
+		states:           make(chan RegistrationState, 1),
+		cancel:           make(chan struct{}),
+		done:             make(chan struct{}),
+		stopOnce:         new(sync.Once),
+	}
+	if r.expires <= 0 {
+		r.expires = DefaultRegistrationExpires
+	}
+	if r.retryInterval <= 0 {
+		r.retryInterval = DefaultRetryInterval
+	}
+	if r.maxRetryInterval <= 0 {
+		r.maxRetryInterval = DefaultMaxRetryInterval
+	}
+
+	srv.rmu.Lock()
+	srv.registrations[r] = true
+	srv.rmu.Unlock()
+
+	go r.serve()
+
+	return r, nil
+}
+
+// States returns chan of the registration state changes, it is closed when the registration is stopped.
+// Only the latest change is kept till it is read.
+func (r *Registration) States() <-chan RegistrationState {
+	return r.states
+}
+
+// Done returns chan closed when the registration is stopped.
+func (r *Registration) Done() <-chan struct{} {
+	return r.done
+}
+
+// Unregister stops refreshing and removes the binding from the registrar.
+func (r *Registration) Unregister() {
+	r.srv.rmu.Lock()
+	delete(r.srv.registrations, r)
+	r.srv.rmu.Unlock()
+
+	r.stop()
+}
+
+func (r *Registration) stop() {
+	r.stopOnce.Do(func() {
+		close(r.cancel)
+	})
+	<-r.done
+}
+
+func (r *Registration) String() string {
+	return fmt.Sprintf("Registration %s -> %s", r.aor.Uri, r.contact)
+}
+
+func (r *Registration) serve() {
+	defer close(r.done)
+	defer close(r.states)
+
+	failures := 0
+	for {
+		var delay time.Duration
+		res, expires, err := r.register()
+		if err != nil {
+			failures++
+			delay = r.retryDelay(failures)
+			r.registered = false
+			log.Warnf("%s failed, retry in %s: %s", r, delay, err)
+			r.notify(RegistrationState{Status: RegistrationFailed, Response: res, Err: err})
+		} else {
+			failures = 0
+			delay = expires / 2
+			r.registered = true
+			log.Debugf("%s registered for %s", r, expires)
+			r.notify(RegistrationState{Status: Registered, Expires: expires, Response: res})
+		}
+
+		select {
+		case <-r.cancel:
+			r.unregister()
+			return
+		case <-timing.After(delay):
+		}
+	}
+}
+
+// notify reports the change of the status or the granted expiration, refreshes are not reported.
+// It never blocks: the pending state which is not read yet is replaced by the new one.
+func (r *Registration) notify(state RegistrationState) {
+	if state.Status == r.state.Status && state.Expires == r.state.Expires {
+		return
+	}
+	r.state = state
+
+	for {
+		select {
+		case r.states <- state:
+			return
+		default:
+		}
+		select {
+		case <-r.states:
+		default:
+		}
+	}
+}
+
+func (r *Registration) unregister() {
+	state := RegistrationState{Status: Unregistered}
+	if r.registered {
+		res, err := r.send(0)
+		if err == nil && !res.IsSuccess() {
+			err = fmt.Errorf("registrar responded with %d %s", res.StatusCode(), res.Reason())
+		}
+		if err != nil {
+			log.Warnf("%s failed to unregister: %s", r, err)
+		}
+		state.Response = res
+		state.Err = err
+		r.registered = false
+	}
+
+	r.notify(state)
+}
+
+// retryDelay returns delay of the next attempt after the failures.
+func (r *Registration) retryDelay(failures int) time.Duration {
+	delay := r.retryInterval
+	for i := 1; i < failures && delay < r.maxRetryInterval; i++ {
+		delay *= 2
+	}
+	if delay > r.maxRetryInterval {
+		delay = r.maxRetryInterval
+	}
+
+	return delay
+}
+
+// register sends REGISTER and returns the granted expiration, zero grant is a failure.
+// Too brief interval is raised up to Min-Expires of the registrar (RFC 3261 - 10.2.8.).
+func (r *Registration) register() (sip.Response, time.Duration, error) {
+	for i := 0; i < maxRegisterAttempts; i++ {
+		res, err := r.send(r.expires)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		switch {
+		case res.IsSuccess():
+			expires := r.grantedExpires(res)
+			if expires == 0 {
+				// the binding is removed at once, refreshing it at half of the expiration would loop
+				return res, 0, fmt.Errorf("registrar granted zero expiration")
+			}
+			return res, expires, nil
+		case res.StatusCode() == 423:
+			minExpires, ok := minExpiresOf(res)
+			if !ok || minExpires <= r.expires {
+				return res, 0, fmt.Errorf("registrar responded with 423 and invalid 'Min-Expires'")
+			}
+			log.Debugf("%s raises expiration up to %s", r, minExpires)
+			r.expires = minExpires
+		default:
+			return res, 0, fmt.Errorf("registrar responded with %d %s", res.StatusCode(), res.Reason())
+		}
+	}
+
+	return nil, 0, fmt.Errorf("registrar rejected expiration %s", r.expires)
+}
+
+// send sends REGISTER with the expiration and waits for the final response.
+func (r *Registration) send(expires time.Duration) (sip.Response, error) {
+	req := r.newRequest(expires)
+
+	var responses <-chan sip.Response
+	if r.credentials != nil {
+		authReq, err := r.srv.RequestWithAuth(req, r.credentials)
+		if err != nil {
+			return nil, err
+		}
+		defer authReq.Close()
+		responses = authReq.Responses()
+	} else {
+		var err error
+		if responses, err = r.srv.Request(req); err != nil {
+			return nil, err
+		}
+	}
+
+	for res := range responses {
+		if cseq, ok := res.CSeq(); ok && cseq.SeqNo > r.cseq {
+			// challenged request is re-sent with the next CSeq
+			r.cseq = cseq.SeqNo
+		}
+		if !res.IsProvisional() {
+			return res, nil
+		}
+	}
+
+	return nil, fmt.Errorf("transaction of %s terminated without final response", req.Short())
+}
+
+func (r *Registration) newRequest(expires time.Duration) sip.Request {
+	r.cseq++
+
+	from := &sip.FromHeader{
+		DisplayName: r.aor.DisplayName,
+		Address:     r.aor.Uri.Clone(),
+		Params:      sip.NewParams().Add("tag", sip.String{Str: r.fromTag}),
+	}
+	to := &sip.ToHeader{
+		DisplayName: r.aor.DisplayName,
+		Address:     r.aor.Uri.Clone(),
+		Params:      sip.NewParams(),
+	}
+	contact := &sip.ContactHeader{
+		Address: r.contact.Clone().(sip.ContactUri),
+		Params:  sip.NewParams(),
+	}
+	maxForwards := sip.MaxForwards(70)
+	seconds := sip.Expires(expires / time.Second)
+	callID := r.callID
+
+	return sip.NewRequest(
+		sip.REGISTER,
+		r.registrar.Clone(),
+		"SIP/2.0",
+		[]sip.Header{
+			from,
+			to,
+			&callID,
+			&sip.CSeq{SeqNo: r.cseq, MethodName: sip.REGISTER},
+			&maxForwards,
+			contact,
+			&seconds,
+		},
+		"",
+	)
+}
+
+// grantedExpires returns expiration of the contact in 2xx response (RFC 3261 - 10.2.4.).
+func (r *Registration) grantedExpires(res sip.Response) time.Duration {
+	for _, hdr := range res.GetHeaders("Contact") {
+		contact, ok := hdr.(*sip.ContactHeader)
+		if !ok || contact.Address == nil || !contact.Address.Equals(r.contact) || contact.Params == nil {
+			continue
+		}
+		if val, ok := contact.Params.Get("expires"); ok && val != nil {
+			if seconds, err := strconv.ParseUint(val.String(), 10, 32); err == nil {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	if expires, ok := res.Expires(); ok {
+		return expires.Duration()
+	}
+
+	return r.expires
+}
+
+func minExpiresOf(res sip.Response) (time.Duration, bool) {
+	minExpires, ok := res.MinExpires()
+	if !ok {
+		return 0, false
+	}
+
+	return minExpires.Duration(), true
+}
+
+// stopRegistrations unregisters all the active registrations of the server.
+func (srv *Server) stopRegistrations() {
+	srv.rmu.Lock()
+	registrations := make([]*Registration, 0, len(srv.registrations))
+	for r := range srv.registrations {
+		registrations = append(registrations, r)
+	}
+	srv.registrations = make(map[*Registration]bool)
+	srv.rmu.Unlock()
+
+	wg := new(sync.WaitGroup)
+	for _, r := range registrations {
+		wg.Add(1)
+		go func(r *Registration) {
+			defer wg.Done()
+			r.stop()
+		}(r)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,227 @@
+package gosip_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/masterclock/gosip"
+	"github.com/masterclock/gosip/auth"
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/timing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registration", func() {
+	var (
+		ua, registrar *gosip.Server
+		registers     chan sip.Request
+		config        *gosip.RegistrationConfig
+	)
+
+	expiresOf := func(req sip.Request) string {
+		expires, ok := req.Expires()
+		Expect(ok).To(BeTrue())
+		return fmt.Sprintf("%d", *expires)
+	}
+
+	expectNoRegister := func() {
+		select {
+		case req := <-registers:
+			Fail("unexpected " + req.Short())
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// set once, goroutines of the previous servers can still read it
+	timing.MockMode = true
+
+	BeforeEach(func() {
+		registers = make(chan sip.Request, 10)
+
+		// listeners of the previous spec may be closed a bit after shutdown
+		registrar = gosip.NewServer(&gosip.ServerConfig{HostAddr: "127.0.0.1"})
+		Eventually(func() error { return registrar.Listen("udp", "127.0.0.1:5074") }).Should(Succeed())
+		ua = gosip.NewServer(&gosip.ServerConfig{HostAddr: "127.0.0.1"})
+		Eventually(func() error { return ua.Listen("udp", "127.0.0.1:5076") }).Should(Succeed())
+
+		port := sip.Port(5074)
+		config = &gosip.RegistrationConfig{
+			Registrar: &sip.SipUri{Host: "127.0.0.1", Port: &port},
+			AOR: &sip.Address{
+				Uri: &sip.SipUri{User: sip.String{Str: "alice"}, Host: "example.com"},
+			},
+			Contact: &sip.SipUri{User: sip.String{Str: "alice"}, Host: "127.0.0.1"},
+			Expires: 60 * time.Second,
+		}
+	})
+	AfterEach(func() {
+		ua.Shutdown()
+		registrar.Shutdown()
+	})
+
+	It("should follow challenges and Min-Expires, refresh and unregister on shutdown", func(done Done) {
+		authenticator, err := auth.NewAuthenticator(&auth.AuthenticatorConfig{
+			Realm: "example.com",
+			Users: auth.UserStoreFunc(func(realm, username string) (*auth.User, bool) {
+				return &auth.User{Username: "alice", Password: "secret"}, username == "alice"
+			}),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(registrar.OnRequest(sip.REGISTER, registrar.WithAuth(authenticator, func(req sip.Request) {
+			registers <- req
+			var res sip.Response
+			if expires := expiresOf(req); expires == "60" {
+				res = sip.NewResponseFromRequest(req, 423, "Interval Too Brief", "")
+				res.AppendHeader(&sip.GenericHeader{HeaderName: "Min-Expires", Contents: "120"})
+			} else {
+				res = sip.NewResponseFromRequest(req, 200, "OK", "")
+				contact, _ := req.Contact()
+				contact = contact.Clone().(*sip.ContactHeader)
+				contact.Params.Add("expires", sip.String{Str: expires})
+				res.AppendHeader(contact)
+			}
+			_, err := registrar.Respond(res)
+			Expect(err).ToNot(HaveOccurred())
+		}))).To(Succeed())
+
+		config.Credentials = auth.StaticCredentials("alice", "secret")
+		registration, err := ua.Register(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		By("expiration is raised up to Min-Expires")
+		req := <-registers
+		Expect(expiresOf(req)).To(Equal("60"))
+		req = <-registers
+		Expect(expiresOf(req)).To(Equal("120"))
+		Expect(req.GetHeaders("Authorization")).To(HaveLen(1))
+
+		state := <-registration.States()
+		Expect(state.Status).To(Equal(gosip.Registered))
+		Expect(state.Expires).To(Equal(120 * time.Second))
+
+		By("binding is refreshed at half of the expiration")
+		time.Sleep(100 * time.Millisecond)
+		timing.Elapse(59 * time.Second)
+		expectNoRegister()
+		timing.Elapse(time.Second)
+		refresh := <-registers
+		Expect(expiresOf(refresh)).To(Equal("120"))
+		callID, _ := refresh.CallID()
+		firstCallID, _ := req.CallID()
+		Expect(callID).To(Equal(firstCallID))
+		cseq, _ := refresh.CSeq()
+		firstCSeq, _ := req.CSeq()
+		Expect(cseq.SeqNo).To(BeNumerically(">", firstCSeq.SeqNo))
+		By("refresh is not reported")
+		select {
+		case state = <-registration.States():
+			Fail("unexpected " + state.String())
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		By("binding is removed on shutdown")
+		go ua.Shutdown()
+		req = <-registers
+		Expect(expiresOf(req)).To(Equal("0"))
+		state = <-registration.States()
+		Expect(state.Status).To(Equal(gosip.Unregistered))
+		Expect(state.Err).ToNot(HaveOccurred())
+		_, ok := <-registration.States()
+		Expect(ok).To(BeFalse())
+		close(done)
+	}, 3)
+
+	It("should retry failed registration with exponential backoff", func(done Done) {
+		Expect(registrar.OnRequest(sip.REGISTER, func(req sip.Request) {
+			registers <- req
+			_, err := registrar.Respond(sip.NewResponseFromRequest(req, 503, "Service Unavailable", ""))
+			Expect(err).ToNot(HaveOccurred())
+		})).To(Succeed())
+
+		config.RetryInterval = 10 * time.Second
+		config.MaxRetryInterval = 30 * time.Second
+		registration, err := ua.Register(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		<-registers
+		state := <-registration.States()
+		Expect(state.Status).To(Equal(gosip.RegistrationFailed))
+		Expect(state.Response.StatusCode()).To(Equal(sip.StatusCode(503)))
+
+		for _, delay := range []time.Duration{10, 20, 30, 30} {
+			By(fmt.Sprintf("retry in %d seconds", delay))
+			time.Sleep(100 * time.Millisecond)
+			timing.Elapse((delay - 1) * time.Second)
+			expectNoRegister()
+			timing.Elapse(time.Second)
+			<-registers
+		}
+
+		By("failed binding is not removed on stop")
+		registration.Unregister()
+		expectNoRegister()
+		state = <-registration.States()
+		Expect(state.Status).To(Equal(gosip.Unregistered))
+		close(done)
+	}, 5)
+
+	It("should retry registration granted for zero seconds", func(done Done) {
+		Expect(registrar.OnRequest(sip.REGISTER, func(req sip.Request) {
+			registers <- req
+			res := sip.NewResponseFromRequest(req, 200, "OK", "")
+			expires := sip.Expires(0)
+			res.AppendHeader(&expires)
+			_, err := registrar.Respond(res)
+			Expect(err).ToNot(HaveOccurred())
+		})).To(Succeed())
+
+		config.RetryInterval = 10 * time.Second
+		registration, err := ua.Register(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		<-registers
+		state := <-registration.States()
+		Expect(state.Status).To(Equal(gosip.RegistrationFailed))
+		Expect(state.Response.StatusCode()).To(Equal(sip.StatusCode(200)))
+
+		By("binding is not refreshed at once")
+		expectNoRegister()
+		timing.Elapse(10 * time.Second)
+		<-registers
+		close(done)
+	}, 3)
+
+	It("should keep only the latest unread state change", func(done Done) {
+		failures := 1
+		Expect(registrar.OnRequest(sip.REGISTER, func(req sip.Request) {
+			registers <- req
+			res := sip.NewResponseFromRequest(req, 200, "OK", "")
+			if failures > 0 {
+				failures--
+				res = sip.NewResponseFromRequest(req, 503, "Service Unavailable", "")
+			}
+			_, err := registrar.Respond(res)
+			Expect(err).ToNot(HaveOccurred())
+		})).To(Succeed())
+
+		config.RetryInterval = 10 * time.Second
+		registration, err := ua.Register(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		<-registers
+		time.Sleep(100 * time.Millisecond)
+		timing.Elapse(10 * time.Second)
+		<-registers
+		time.Sleep(100 * time.Millisecond)
+
+		state := <-registration.States()
+		Expect(state.Status).To(Equal(gosip.Registered))
+		select {
+		case state = <-registration.States():
+			Fail("unexpected " + state.String())
+		case <-time.After(100 * time.Millisecond):
+		}
+		close(done)
+	}, 3)
+})
@@ -0,0 +1,412 @@
+package sdp
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Direction is the media direction attribute (RFC 3264 - 5.1.).
+type Direction string
+
+const (
+	SendRecv Direction = "sendrecv"
+	SendOnly Direction = "sendonly"
+	RecvOnly Direction = "recvonly"
+	Inactive Direction = "inactive"
+)
+
+// Reverse returns direction seen from the other side, e.g. sendonly of the offerer is recvonly of the answerer.
+func (direction Direction) Reverse() Direction {
+	switch direction {
+	case SendOnly:
+		return RecvOnly
+	case RecvOnly:
+		return SendOnly
+	default:
+		return direction
+	}
+}
+
+func (direction Direction) sends() bool {
+	return direction == SendRecv || direction == SendOnly
+}
+
+func (direction Direction) receives() bool {
+	return direction == SendRecv || direction == RecvOnly
+}
+
+func directionOf(attrs Attributes) Direction {
+	for _, attr := range attrs {
+		switch direction := Direction(attr.Key); direction {
+		case SendRecv, SendOnly, RecvOnly, Inactive:
+			return direction
+		}
+	}
+
+	return ""
+}
+
+// Media is the media description started by 'm=' line (RFC 8866 - 5.14.).
+type Media struct {
+	// Type is the media type, e.g. audio or video.
+	Type string
+	// Port is the transport port, 0 means that the stream is rejected or disabled (RFC 3264 - 6.).
+	Port int
+	// PortCount is the number of ports, 0 if it is not set.
+	PortCount int
+	// Protocol is the transport protocol, e.g. RTP/AVP.
+	Protocol string
+	// Formats are media formats, e.g. RTP payload types.
+	Formats     []string
+	Information string
+	// Connection is the media level connection data, may be nil if the session level one is used.
+	Connection *Connection
+	Bandwidths []Bandwidth
+	Key        string
+	Attributes Attributes
+}
+
+func (media *Media) parseLine(typ byte, value string) error {
+	var err error
+	switch typ {
+	case 'm':
+		err = media.parseMediaLine(value)
+	case 'i':
+		media.Information = value
+	case 'c':
+		media.Connection, err = parseConnection(value)
+	case 'b':
+		var bandwidth Bandwidth
+		if bandwidth, err = parseBandwidth(value); err == nil {
+			media.Bandwidths = append(media.Bandwidths, bandwidth)
+		}
+	case 'k':
+		media.Key = value
+	case 'a':
+		media.Attributes = append(media.Attributes, parseAttribute(value))
+	default:
+		err = fmt.Errorf("'%c=' line is not allowed in media description", typ)
+	}
+
+	return err
+}
+
+func (media *Media) parseMediaLine(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return fmt.Errorf("media description must have media, port and protocol")
+	}
+
+	media.Type = fields[0]
+	ports := strings.SplitN(fields[1], "/", 2)
+	port, err := strconv.Atoi(ports[0])
+	if err != nil || port < 0 || port > 65535 {
+		return fmt.Errorf("invalid port '%s'", fields[1])
+	}
+	media.Port = port
+	if len(ports) == 2 {
+		if media.PortCount, err = strconv.Atoi(ports[1]); err != nil {
+			return fmt.Errorf("invalid number of ports '%s'", fields[1])
+		}
+	}
+	media.Protocol = fields[2]
+	media.Formats = fields[3:]
+
+	return nil
+}
+
+func (media *Media) write(buffer *bytes.Buffer) {
+	port := strconv.Itoa(media.Port)
+	if media.PortCount > 0 {
+		port += "/" + strconv.Itoa(media.PortCount)
+	}
+	line := []string{media.Type, port, media.Protocol}
+	writeLine(buffer, 'm', strings.Join(append(line, media.Formats...), " "))
+	writeOptionalLine(buffer, 'i', media.Information)
+	if media.Connection != nil {
+		writeLine(buffer, 'c', media.Connection.String())
+	}
+	for _, bandwidth := range media.Bandwidths {
+		writeLine(buffer, 'b', bandwidth.String())
+	}
+	writeOptionalLine(buffer, 'k', media.Key)
+	for _, attr := range media.Attributes {
+		writeLine(buffer, 'a', attr.String())
+	}
+}
+
+func (media *Media) String() string {
+	var buffer bytes.Buffer
+	media.write(&buffer)
+
+	return buffer.String()
+}
+
+// Clone returns deep copy of the media description.
+func (media *Media) Clone() *Media {
+	clone := *media
+	clone.Formats = cloneStrings(media.Formats)
+	clone.Connection = media.Connection.Clone()
+	if media.Bandwidths != nil {
+		clone.Bandwidths = append([]Bandwidth{}, media.Bandwidths...)
+	}
+	clone.Attributes = media.Attributes.Clone()
+
+	return &clone
+}
+
+// Direction returns media level direction attribute, empty if it is not set.
+// Use Session.MediaDirection to take into account session level attribute.
+func (media *Media) Direction() Direction {
+	return directionOf(media.Attributes)
+}
+
+// SetDirection replaces direction attribute of the media.
+func (media *Media) SetDirection(direction Direction) {
+	media.removeDirection()
+	media.Attributes.Add(string(direction), "")
+}
+
+func (media *Media) removeDirection() {
+	for _, direction := range []Direction{SendRecv, SendOnly, RecvOnly, Inactive} {
+		media.Attributes.Remove(string(direction))
+	}
+}
+
+// IsRejected returns true if the media stream is rejected or disabled with zero port (RFC 3264 - 6.).
+func (media *Media) IsRejected() bool {
+	return media.Port == 0
+}
+
+// Reject sets zero port, formats are kept since at least one format is required by the grammar.
+func (media *Media) Reject() {
+	media.Port = 0
+	media.PortCount = 0
+}
+
+// IsRTP returns true for RTP based protocols, e.g. RTP/AVP or UDP/TLS/RTP/SAVPF,
+// formats of such media are RTP payload types.
+func (media *Media) IsRTP() bool {
+	for _, proto := range strings.Split(media.Protocol, "/") {
+		if proto == "RTP" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RTPMap is the RTP payload type mapping (RFC 8866 - 6.6.).
+type RTPMap struct {
+	Payload   int
+	Encoding  string
+	ClockRate int
+	// Channels is the number of audio channels, 0 if it is not set.
+	Channels int
+}
+
+// ParseRTPMap parses value of 'a=rtpmap' attribute, e.g. "96 opus/48000/2".
+func ParseRTPMap(value string) (RTPMap, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return RTPMap{}, fmt.Errorf("invalid rtpmap '%s'", value)
+	}
+	payload, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return RTPMap{}, fmt.Errorf("invalid rtpmap payload type '%s'", fields[0])
+	}
+
+	parts := strings.Split(fields[1], "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return RTPMap{}, fmt.Errorf("invalid rtpmap encoding '%s'", fields[1])
+	}
+	rtpmap := RTPMap{Payload: payload, Encoding: parts[0]}
+	if rtpmap.ClockRate, err = strconv.Atoi(parts[1]); err != nil {
+		return RTPMap{}, fmt.Errorf("invalid rtpmap clock rate '%s'", parts[1])
+	}
+	if len(parts) == 3 {
+		if rtpmap.Channels, err = strconv.Atoi(parts[2]); err != nil {
+			return RTPMap{}, fmt.Errorf("invalid rtpmap channels '%s'", parts[2])
+		}
+	}
+
+	return rtpmap, nil
+}
+
+func (rtpmap RTPMap) String() string {
+	value := fmt.Sprintf("%d %s/%d", rtpmap.Payload, rtpmap.Encoding, rtpmap.ClockRate)
+	if rtpmap.Channels > 0 {
+		value += "/" + strconv.Itoa(rtpmap.Channels)
+	}
+
+	return value
+}
+
+// Matches returns true if both mappings describe the same codec, payload types are not compared.
+func (rtpmap RTPMap) Matches(other RTPMap) bool {
+	return strings.EqualFold(rtpmap.Encoding, other.Encoding) &&
+		rtpmap.ClockRate == other.ClockRate &&
+		channelsOf(rtpmap) == channelsOf(other)
+}
+
+// channelsOf returns number of channels, omitted number means one channel (RFC 8866 - 6.6.).
+func channelsOf(rtpmap RTPMap) int {
+	if rtpmap.Channels == 0 {
+		return 1
+	}
+
+	return rtpmap.Channels
+}
+
+// staticPayloads are the static RTP payload types which may be used without 'a=rtpmap' (RFC 3551 - 6.).
+var staticPayloads = map[int]RTPMap{
+	0:  {Payload: 0, Encoding: "PCMU", ClockRate: 8000},
+	3:  {Payload: 3, Encoding: "GSM", ClockRate: 8000},
+	4:  {Payload: 4, Encoding: "G723", ClockRate: 8000},
+	8:  {Payload: 8, Encoding: "PCMA", ClockRate: 8000},
+	9:  {Payload: 9, Encoding: "G722", ClockRate: 8000},
+	18: {Payload: 18, Encoding: "G729", ClockRate: 8000},
+}
+
+// RTPMap returns mapping of the payload type, static payload types are resolved without 'a=rtpmap'.
+func (media *Media) RTPMap(payload int) (RTPMap, bool) {
+	for _, value := range media.Attributes.GetAll("rtpmap") {
+		rtpmap, err := ParseRTPMap(value)
+		if err == nil && rtpmap.Payload == payload {
+			return rtpmap, true
+		}
+	}
+	rtpmap, ok := staticPayloads[payload]
+
+	return rtpmap, ok
+}
+
+// RTPMaps returns mappings of the media formats in order of preference, unknown formats are skipped.
+func (media *Media) RTPMaps() []RTPMap {
+	rtpmaps := make([]RTPMap, 0)
+	for _, format := range media.Formats {
+		payload, err := strconv.Atoi(format)
+		if err != nil {
+			continue
+		}
+		if rtpmap, ok := media.RTPMap(payload); ok {
+			rtpmaps = append(rtpmaps, rtpmap)
+		}
+	}
+
+	return rtpmaps
+}
+
+// FMTP returns format specific parameters of the payload type, i.e. value of 'a=fmtp' without the format.
+func (media *Media) FMTP(format string) (string, bool) {
+	for _, value := range media.Attributes.GetAll("fmtp") {
+		parts := strings.SplitN(value, " ", 2)
+		if parts[0] == format {
+			if len(parts) == 1 {
+				return "", true
+			}
+			return strings.TrimSpace(parts[1]), true
+		}
+	}
+
+	return "", false
+}
+
+// AddFormat appends RTP payload type with 'a=rtpmap' and optional 'a=fmtp' attributes.
+func (media *Media) AddFormat(rtpmap RTPMap, fmtp string) {
+	format := strconv.Itoa(rtpmap.Payload)
+	media.Formats = append(media.Formats, format)
+	media.Attributes.Add("rtpmap", rtpmap.String())
+	if fmtp != "" {
+		media.Attributes.Add("fmtp", format+" "+fmtp)
+	}
+}
+
+// Candidate is the ICE candidate attribute (RFC 8839 - 5.1.).
+type Candidate struct {
+	Foundation string
+	Component  int
+	Transport  string
+	Priority   uint32
+	Address    string
+	Port       int
+	// Type is the candidate type: host, srflx, prflx or relay.
+	Type           string
+	RelatedAddress string
+	RelatedPort    int
+	// Extensions are the other attribute-value pairs in order of appearance.
+	Extensions []Attribute
+}
+
+// ParseCandidate parses value of 'a=candidate' attribute.
+func ParseCandidate(value string) (*Candidate, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 8 || fields[6] != "typ" {
+		return nil, fmt.Errorf("invalid candidate '%s'", value)
+	}
+
+	candidate := &Candidate{
+		Foundation: fields[0],
+		Transport:  fields[2],
+		Address:    fields[4],
+		Type:       fields[7],
+	}
+	var err error
+	if candidate.Component, err = strconv.Atoi(fields[1]); err != nil {
+		return nil, fmt.Errorf("invalid candidate component '%s'", fields[1])
+	}
+	priority, err := strconv.ParseUint(fields[3], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid candidate priority '%s'", fields[3])
+	}
+	candidate.Priority = uint32(priority)
+	if candidate.Port, err = strconv.Atoi(fields[5]); err != nil {
+		return nil, fmt.Errorf("invalid candidate port '%s'", fields[5])
+	}
+
+	rest := fields[8:]
+	if len(rest)%2 != 0 {
+		return nil, fmt.Errorf("candidate extension '%s' has no value", rest[len(rest)-1])
+	}
+	for i := 0; i < len(rest); i += 2 {
+		switch rest[i] {
+		case "raddr":
+			candidate.RelatedAddress = rest[i+1]
+		case "rport":
+			if candidate.RelatedPort, err = strconv.Atoi(rest[i+1]); err != nil {
+				return nil, fmt.Errorf("invalid candidate related port '%s'", rest[i+1])
+			}
+		default:
+			candidate.Extensions = append(candidate.Extensions, Attribute{Key: rest[i], Value: rest[i+1]})
+		}
+	}
+
+	return candidate, nil
+}
+
+func (candidate *Candidate) String() string {
+	value := fmt.Sprintf("%s %d %s %d %s %d typ %s", candidate.Foundation, candidate.Component,
+		candidate.Transport, candidate.Priority, candidate.Address, candidate.Port, candidate.Type)
+	if candidate.RelatedAddress != "" {
+		value += fmt.Sprintf(" raddr %s rport %d", candidate.RelatedAddress, candidate.RelatedPort)
+	}
+	for _, ext := range candidate.Extensions {
+		value += fmt.Sprintf(" %s %s", ext.Key, ext.Value)
+	}
+
+	return value
+}
+
+// Candidates returns ICE candidates of the media, malformed candidates are skipped.
+func (media *Media) Candidates() []*Candidate {
+	candidates := make([]*Candidate, 0)
+	for _, value := range media.Attributes.GetAll("candidate") {
+		if candidate, err := ParseCandidate(value); err == nil {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	return candidates
+}
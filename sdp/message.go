@@ -0,0 +1,47 @@
+package sdp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/masterclock/gosip/sip"
+)
+
+// ContentType is the media type of session description (RFC 8866 - 8.1.).
+const ContentType = "application/sdp"
+
+// SetBody sets session description as the body of the message,
+// Content-Type and Content-Length headers are replaced.
+func SetBody(msg sip.Message, s *Session) {
+	msg.RemoveHeader("Content-Type")
+	msg.AppendHeader(sip.NewContentTypeHeader(ContentType))
+	msg.SetBody(s.String(), true)
+}
+
+// HasBody returns true if the message has session description body.
+func HasBody(msg sip.Message) bool {
+	if msg.Body() == "" {
+		return false
+	}
+	if contentType, ok := msg.ContentType(); ok {
+		return contentType.Is(ContentType)
+	}
+	// header may be added by the application as generic one
+	for _, hdr := range msg.GetHeaders("Content-Type") {
+		if hdr, ok := hdr.(*sip.GenericHeader); ok {
+			mediaType := strings.TrimSpace(strings.SplitN(hdr.Contents, ";", 2)[0])
+			return strings.EqualFold(mediaType, ContentType)
+		}
+	}
+
+	return false
+}
+
+// ParseBody parses session description from the body of the message.
+func ParseBody(msg sip.Message) (*Session, error) {
+	if !HasBody(msg) {
+		return nil, fmt.Errorf("%s has no %s body", msg.Short(), ContentType)
+	}
+
+	return Parse(msg.Body())
+}
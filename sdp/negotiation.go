@@ -0,0 +1,242 @@
+package sdp
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Answer creates answer on the offer using the local capabilities (RFC 3264 - 6.).
+// Local session describes supported media streams, codecs, addresses and directions.
+// Each offered stream is answered by the first unused local media of the same type and protocol,
+// the answered formats are intersection of the offered and the local ones in order of the offer.
+// RTP payload types are matched by encoding, clock rate and channels, payload types of the offer are kept.
+// Offered streams with zero port, unsupported streams and streams without common formats are rejected with zero port.
+func Answer(offer, local *Session) (*Session, error) {
+	if offer == nil || local == nil {
+		return nil, fmt.Errorf("offer and local session are required to create answer")
+	}
+	if len(offer.Media) == 0 {
+		return nil, fmt.Errorf("offer has no media streams")
+	}
+
+	answer := local.Clone()
+	answer.Attributes = withoutDirection(answer.Attributes)
+	// RFC 3264 - 6. - the "t=" line in the answer MUST be equal to the one of the offer
+	answer.Timings = offer.Clone().Timings
+	answer.Media = make([]*Media, 0, len(offer.Media))
+
+	used := make(map[*Media]bool)
+	for _, offered := range offer.Media {
+		var answered *Media
+		if !offered.IsRejected() {
+			for _, media := range local.Media {
+				if used[media] || media.IsRejected() ||
+					media.Type != offered.Type || media.Protocol != offered.Protocol {
+					continue
+				}
+				if answered = answerMedia(offer, offered, local, media); answered != nil {
+					used[media] = true
+					break
+				}
+			}
+		}
+		if answered == nil {
+			answered = rejectedMedia(offered)
+		}
+		answer.Media = append(answer.Media, answered)
+	}
+
+	return answer, nil
+}
+
+// answerMedia answers the offered media with the local one, returns nil if there are no common formats.
+func answerMedia(offer *Session, offered *Media, local *Session, media *Media) *Media {
+	answered := &Media{
+		Type:        media.Type,
+		Port:        media.Port,
+		PortCount:   media.PortCount,
+		Protocol:    media.Protocol,
+		Formats:     make([]string, 0),
+		Information: media.Information,
+		Connection:  media.Connection.Clone(),
+		Key:         media.Key,
+	}
+	if media.Bandwidths != nil {
+		answered.Bandwidths = append([]Bandwidth{}, media.Bandwidths...)
+	}
+
+	if offered.IsRTP() {
+		for _, rtpmap := range offered.RTPMaps() {
+			supported, ok := matchRTPMap(media, rtpmap)
+			if !ok {
+				continue
+			}
+			fmtp, _ := media.FMTP(strconv.Itoa(supported.Payload))
+			answered.AddFormat(rtpmap, fmtp)
+		}
+	} else {
+		for _, format := range offered.Formats {
+			if hasFormat(media, format) {
+				answered.Formats = append(answered.Formats, format)
+				if fmtp, ok := media.FMTP(format); ok {
+					answered.Attributes.Add("fmtp", format+" "+fmtp)
+				}
+			}
+		}
+	}
+	if len(answered.Formats) == 0 {
+		return nil
+	}
+
+	for _, attr := range withoutDirection(media.Attributes) {
+		if attr.Key != "rtpmap" && attr.Key != "fmtp" {
+			answered.Attributes = append(answered.Attributes, attr)
+		}
+	}
+	answered.SetDirection(answerDirection(offer.MediaDirection(offered), local.MediaDirection(media)))
+
+	return answered
+}
+
+// answerDirection returns direction of the answered stream (RFC 3264 - 6.1.),
+// the answerer sends only if the offerer receives, and receives only if the offerer sends.
+func answerDirection(offered, local Direction) Direction {
+	sends := offered.receives() && local.sends()
+	receives := offered.sends() && local.receives()
+	switch {
+	case sends && receives:
+		return SendRecv
+	case sends:
+		return SendOnly
+	case receives:
+		return RecvOnly
+	default:
+		return Inactive
+	}
+}
+
+func matchRTPMap(media *Media, rtpmap RTPMap) (RTPMap, bool) {
+	for _, supported := range media.RTPMaps() {
+		if supported.Matches(rtpmap) {
+			return supported, true
+		}
+	}
+
+	return RTPMap{}, false
+}
+
+func hasFormat(media *Media, format string) bool {
+	for _, supported := range media.Formats {
+		if supported == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rejectedMedia answers the offered media with zero port, formats of the offer are kept (RFC 3264 - 6.).
+func rejectedMedia(offered *Media) *Media {
+	return &Media{
+		Type:     offered.Type,
+		Port:     0,
+		Protocol: offered.Protocol,
+		Formats:  cloneStrings(offered.Formats),
+	}
+}
+
+func withoutDirection(attrs Attributes) Attributes {
+	kept := make(Attributes, 0, len(attrs))
+	for _, attr := range attrs {
+		switch Direction(attr.Key) {
+		case SendRecv, SendOnly, RecvOnly, Inactive:
+		default:
+			kept = append(kept, attr)
+		}
+	}
+
+	return kept
+}
+
+// ValidateAnswer checks that the answer matches the offer (RFC 3264 - 6.):
+// the same number and types of the media streams, rejected streams stay rejected
+// and RTP payload types of the accepted streams are taken from the offer.
+func ValidateAnswer(offer, answer *Session) error {
+	if len(offer.Media) != len(answer.Media) {
+		return fmt.Errorf("answer has %d media streams, offer has %d", len(answer.Media), len(offer.Media))
+	}
+
+	for i, offered := range offer.Media {
+		answered := answer.Media[i]
+		if answered.Type != offered.Type {
+			return fmt.Errorf("answered media stream %d has type %s, offered %s", i, answered.Type, offered.Type)
+		}
+		if answered.IsRejected() {
+			continue
+		}
+		if offered.IsRejected() {
+			return fmt.Errorf("answer accepts rejected media stream %d", i)
+		}
+		if len(answered.Formats) == 0 {
+			return fmt.Errorf("answered media stream %d has no formats", i)
+		}
+		if !offered.IsRTP() {
+			continue
+		}
+		for _, format := range answered.Formats {
+			if !hasFormat(offered, format) {
+				return fmt.Errorf("answered media stream %d has not offered payload type %s", i, format)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Hold puts the session on hold in new offer (RFC 3264 - 8.4.):
+// sendrecv streams become sendonly and recvonly streams become inactive.
+// Session version is incremented since the session is modified.
+func (s *Session) Hold() {
+	s.changeDirections(func(direction Direction) Direction {
+		switch direction {
+		case SendRecv:
+			return SendOnly
+		case RecvOnly:
+			return Inactive
+		default:
+			return direction
+		}
+	})
+}
+
+// Resume takes the session off hold in new offer, it reverts Hold:
+// sendonly streams become sendrecv and inactive streams become recvonly.
+// Session version is incremented since the session is modified.
+func (s *Session) Resume() {
+	s.changeDirections(func(direction Direction) Direction {
+		switch direction {
+		case SendOnly:
+			return SendRecv
+		case Inactive:
+			return RecvOnly
+		default:
+			return direction
+		}
+	})
+}
+
+func (s *Session) changeDirections(change func(direction Direction) Direction) {
+	for _, media := range s.Media {
+		if !media.IsRejected() {
+			media.SetDirection(change(s.MediaDirection(media)))
+		}
+	}
+	// directions are moved to the media level
+	s.Attributes = withoutDirection(s.Attributes)
+	s.Origin.SessionVersion++
+}
+
+// IsHeld returns true if the remote party put the media stream on hold, i.e. it does not receive the media.
+func (s *Session) IsHeld(media *Media) bool {
+	return !media.IsRejected() && !s.MediaDirection(media).receives()
+}
@@ -0,0 +1,155 @@
+package sdp
+
+import (
+	"testing"
+)
+
+const localCapabilities = "v=0\r\n" +
+	"o=bob 1 1 IN IP4 198.51.100.1\r\n" +
+	"s=-\r\n" +
+	"c=IN IP4 198.51.100.1\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 5004 RTP/AVP 8 111 0\r\n" +
+	"a=rtpmap:111 OPUS/48000/2\r\n" +
+	"a=fmtp:111 useinbandfec=1\r\n" +
+	"a=ptime:20\r\n" +
+	"m=video 5006 RTP/AVP 31\r\n"
+
+func TestAnswer(t *testing.T) {
+	offer, _ := Parse(webrtcOffer)
+	local, _ := Parse(localCapabilities)
+
+	answer, err := Answer(offer, local)
+	if err != nil {
+		t.Fatalf("[FAIL] Answer failed: %s", err)
+	}
+	if err := ValidateAnswer(offer, answer); err != nil {
+		t.Errorf("[FAIL] Invalid answer: %s", err)
+	}
+
+	expected := "v=0\r\n" +
+		"o=bob 1 1 IN IP4 198.51.100.1\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 198.51.100.1\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 5004 RTP/AVP 0 96\r\n" +
+		"a=rtpmap:0 PCMU/8000\r\n" +
+		"a=rtpmap:96 opus/48000/2\r\n" +
+		"a=fmtp:96 useinbandfec=1\r\n" +
+		"a=ptime:20\r\n" +
+		"a=sendrecv\r\n" +
+		"m=video 0 RTP/AVP 31\r\n"
+	if answer.String() != expected {
+		t.Errorf("[FAIL] Expected:\n%s\nGot:\n%s", expected, answer)
+	}
+}
+
+func TestAnswer_Rejection(t *testing.T) {
+	offer, _ := Parse("v=0\r\no=- 1 1 IN IP4 192.0.2.1\r\ns=-\r\nc=IN IP4 192.0.2.1\r\nt=0 0\r\n" +
+		"m=audio 4000 RTP/AVP 9\r\n" +
+		"m=application 5000 TCP/MSRP *\r\n" +
+		"m=audio 4002 RTP/AVP 8\r\n")
+	local, _ := Parse(localCapabilities)
+
+	answer, err := Answer(offer, local)
+	if err != nil {
+		t.Fatalf("[FAIL] Answer failed: %s", err)
+	}
+	if err := ValidateAnswer(offer, answer); err != nil {
+		t.Errorf("[FAIL] Invalid answer: %s", err)
+	}
+
+	tests := []struct {
+		description string
+		rejected    bool
+	}{
+		{"No common codecs", true},
+		{"Unsupported media", true},
+		{"Common codec with the same local media", false},
+	}
+	for i, test := range tests {
+		if answer.Media[i].IsRejected() != test.rejected {
+			t.Errorf("[FAIL] %s: Expected rejected %v, got media %s", test.description, test.rejected, answer.Media[i])
+		}
+	}
+}
+
+func TestAnswer_Direction(t *testing.T) {
+	tests := []struct {
+		offered  Direction
+		local    Direction
+		expected Direction
+	}{
+		{SendRecv, SendRecv, SendRecv},
+		{SendOnly, SendRecv, RecvOnly},
+		{RecvOnly, SendRecv, SendOnly},
+		{Inactive, SendRecv, Inactive},
+		{SendRecv, RecvOnly, RecvOnly},
+		{SendOnly, SendOnly, Inactive},
+	}
+
+	for _, test := range tests {
+		offer, _ := Parse("v=0\r\no=- 1 1 IN IP4 192.0.2.1\r\ns=-\r\nt=0 0\r\nm=audio 4000 RTP/AVP 8\r\n")
+		local, _ := Parse(localCapabilities)
+		offer.Media[0].SetDirection(test.offered)
+		local.Media[0].SetDirection(test.local)
+
+		answer, _ := Answer(offer, local)
+		if direction := answer.MediaDirection(answer.Media[0]); direction != test.expected {
+			t.Errorf("[FAIL] Offered %s, local %s: Expected: %s, Got: %s",
+				test.offered, test.local, test.expected, direction)
+		}
+	}
+}
+
+func TestValidateAnswer(t *testing.T) {
+	offer, _ := Parse(webrtcOffer)
+
+	tests := []struct {
+		description string
+		answer      string
+	}{
+		{"Missing media stream", "v=0\r\nm=audio 5004 RTP/AVP 0\r\n"},
+		{"Accepted rejected stream", "v=0\r\nm=audio 5004 RTP/AVP 0\r\nm=video 5006 RTP/AVP 31\r\n"},
+		{"Not offered payload type", "v=0\r\nm=audio 5004 RTP/AVP 8\r\nm=video 0 RTP/AVP 31\r\n"},
+		{"Another media type", "v=0\r\nm=video 5004 RTP/AVP 0\r\nm=video 0 RTP/AVP 31\r\n"},
+	}
+	for _, test := range tests {
+		answer, _ := Parse(test.answer)
+		if err := ValidateAnswer(offer, answer); err == nil {
+			t.Errorf("[FAIL] %s: error expected", test.description)
+		}
+	}
+}
+
+func TestSession_Hold(t *testing.T) {
+	s, _ := Parse(webrtcOffer)
+
+	s.Hold()
+	if direction := s.MediaDirection(s.Media[0]); direction != SendOnly {
+		t.Errorf("[FAIL] Expected held direction sendonly, got %s", direction)
+	}
+	if s.Direction() != "" {
+		t.Errorf("[FAIL] Expected session level direction to be removed, got %s", s.Direction())
+	}
+	if s.Media[1].Direction() != RecvOnly {
+		t.Errorf("[FAIL] Expected rejected media to be untouched, got %s", s.Media[1].Direction())
+	}
+	if s.Origin.SessionVersion != 2890844527 {
+		t.Errorf("[FAIL] Expected incremented session version, got %d", s.Origin.SessionVersion)
+	}
+
+	local, _ := Parse(localCapabilities)
+	answer, _ := Answer(s, local)
+	if !s.IsHeld(s.Media[0]) || answer.MediaDirection(answer.Media[0]) != RecvOnly {
+		t.Errorf("[FAIL] Expected recvonly answer on hold, got %s", answer.MediaDirection(answer.Media[0]))
+	}
+
+	s.Resume()
+	if direction := s.MediaDirection(s.Media[0]); direction != SendRecv {
+		t.Errorf("[FAIL] Expected resumed direction sendrecv, got %s", direction)
+	}
+	if s.Origin.SessionVersion != 2890844528 {
+		t.Errorf("[FAIL] Expected incremented session version, got %d", s.Origin.SessionVersion)
+	}
+}
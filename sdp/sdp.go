@@ -0,0 +1,451 @@
+// sdp package implements Session Description Protocol (RFC 8866)
+// and the offer/answer model (RFC 3264).
+package sdp
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Session is a session description (RFC 8866 - 5.).
+type Session struct {
+	// Version is the protocol version (v=), always 0.
+	Version int
+	// Origin identifies the session and its version (o=).
+	Origin Origin
+	// Name is the session name (s=), "-" if the session has no name.
+	Name        string
+	Information string
+	URI         string
+	Emails      []string
+	Phones      []string
+	// Connection is the session level connection data (c=), may be nil if each media has own one.
+	Connection *Connection
+	Bandwidths []Bandwidth
+	// Timings are time descriptions (t=), "t=0 0" is used if empty.
+	Timings []Timing
+	// TimeZones is the raw value of the time zone adjustments (z=).
+	TimeZones string
+	// Key is the raw value of the obsolete encryption key (k=).
+	Key        string
+	Attributes Attributes
+	Media      []*Media
+}
+
+// Origin is the originator of the session and the session identifier (RFC 8866 - 5.2.).
+type Origin struct {
+	Username       string
+	SessionID      uint64
+	SessionVersion uint64
+	NetworkType    string
+	AddressType    string
+	Address        string
+}
+
+func (origin Origin) String() string {
+	username := origin.Username
+	if username == "" {
+		username = "-"
+	}
+
+	return fmt.Sprintf("%s %d %d %s %s %s", username, origin.SessionID, origin.SessionVersion,
+		orDefault(origin.NetworkType, "IN"), orDefault(origin.AddressType, "IP4"), origin.Address)
+}
+
+// Connection is the connection data (RFC 8866 - 5.7.).
+type Connection struct {
+	NetworkType string
+	AddressType string
+	// Address is the connection address with optional TTL and number of addresses, e.g. 224.2.1.1/127/3.
+	Address string
+}
+
+func (conn *Connection) String() string {
+	return fmt.Sprintf("%s %s %s", orDefault(conn.NetworkType, "IN"), orDefault(conn.AddressType, "IP4"), conn.Address)
+}
+
+func (conn *Connection) Clone() *Connection {
+	if conn == nil {
+		return nil
+	}
+	clone := *conn
+
+	return &clone
+}
+
+// Bandwidth is the proposed bandwidth in kilobits per second (RFC 8866 - 5.8.).
+type Bandwidth struct {
+	Type  string
+	Value uint64
+}
+
+func (bandwidth Bandwidth) String() string {
+	return fmt.Sprintf("%s:%d", bandwidth.Type, bandwidth.Value)
+}
+
+// Timing is the start and stop times of the session with the repeat times (RFC 8866 - 5.9., 5.10.).
+type Timing struct {
+	Start uint64
+	Stop  uint64
+	// Repeats are raw values of the repeat times (r=).
+	Repeats []string
+}
+
+func (timing Timing) String() string {
+	return fmt.Sprintf("%d %d", timing.Start, timing.Stop)
+}
+
+// Attribute is the session or media attribute (RFC 8866 - 5.13.).
+// Property attribute, e.g. a=sendrecv, has empty value.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+func (attr Attribute) String() string {
+	if attr.Value == "" {
+		return attr.Key
+	}
+
+	return attr.Key + ":" + attr.Value
+}
+
+// Attributes is the ordered list of attributes, the same attribute may appear many times.
+type Attributes []Attribute
+
+// Get returns value of the first attribute with the key.
+func (attrs Attributes) Get(key string) (string, bool) {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// GetAll returns values of all attributes with the key.
+func (attrs Attributes) GetAll(key string) []string {
+	values := make([]string, 0)
+	for _, attr := range attrs {
+		if attr.Key == key {
+			values = append(values, attr.Value)
+		}
+	}
+
+	return values
+}
+
+func (attrs Attributes) Has(key string) bool {
+	_, ok := attrs.Get(key)
+	return ok
+}
+
+// Add appends attribute to the end of the list.
+func (attrs *Attributes) Add(key, value string) {
+	*attrs = append(*attrs, Attribute{Key: key, Value: value})
+}
+
+// Remove removes all attributes with the key.
+func (attrs *Attributes) Remove(key string) {
+	kept := make(Attributes, 0, len(*attrs))
+	for _, attr := range *attrs {
+		if attr.Key != key {
+			kept = append(kept, attr)
+		}
+	}
+	*attrs = kept
+}
+
+func (attrs Attributes) Clone() Attributes {
+	if attrs == nil {
+		return nil
+	}
+	clone := make(Attributes, len(attrs))
+	copy(clone, attrs)
+
+	return clone
+}
+
+// Parse parses session description.
+// Lines may be terminated with CRLF or LF, unknown line types are ignored (RFC 8866 - 5.).
+func Parse(data string) (*Session, error) {
+	s := new(Session)
+	var media *Media
+
+	lines := strings.Split(strings.Replace(data, "\r\n", "\n", -1), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if len(line) < 2 || line[1] != '=' {
+			return nil, fmt.Errorf("invalid SDP line %d '%s'", i+1, line)
+		}
+		typ, value := line[0], line[2:]
+		if i == 0 && typ != 'v' {
+			return nil, fmt.Errorf("session description must start with 'v=' line")
+		}
+
+		var err error
+		switch {
+		case typ == 'm':
+			// media description starts here and lasts till the next 'm=' line
+			media = new(Media)
+			if err = media.parseLine(typ, value); err == nil {
+				s.Media = append(s.Media, media)
+			}
+		case media != nil:
+			err = media.parseLine(typ, value)
+		default:
+			err = s.parseLine(typ, value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid SDP line %d '%s': %s", i+1, line, err)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Session) parseLine(typ byte, value string) error {
+	var err error
+	switch typ {
+	case 'v':
+		s.Version, err = strconv.Atoi(value)
+	case 'o':
+		s.Origin, err = parseOrigin(value)
+	case 's':
+		s.Name = value
+	case 'i':
+		s.Information = value
+	case 'u':
+		s.URI = value
+	case 'e':
+		s.Emails = append(s.Emails, value)
+	case 'p':
+		s.Phones = append(s.Phones, value)
+	case 'c':
+		s.Connection, err = parseConnection(value)
+	case 'b':
+		var bandwidth Bandwidth
+		if bandwidth, err = parseBandwidth(value); err == nil {
+			s.Bandwidths = append(s.Bandwidths, bandwidth)
+		}
+	case 't':
+		var timing Timing
+		if timing, err = parseTiming(value); err == nil {
+			s.Timings = append(s.Timings, timing)
+		}
+	case 'r':
+		if len(s.Timings) == 0 {
+			return fmt.Errorf("repeat time without time description")
+		}
+		s.Timings[len(s.Timings)-1].Repeats = append(s.Timings[len(s.Timings)-1].Repeats, value)
+	case 'z':
+		s.TimeZones = value
+	case 'k':
+		s.Key = value
+	case 'a':
+		s.Attributes = append(s.Attributes, parseAttribute(value))
+	}
+
+	return err
+}
+
+func parseOrigin(value string) (Origin, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 6 {
+		return Origin{}, fmt.Errorf("origin must have 6 fields")
+	}
+	id, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return Origin{}, fmt.Errorf("invalid session id: %s", err)
+	}
+	version, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return Origin{}, fmt.Errorf("invalid session version: %s", err)
+	}
+
+	return Origin{
+		Username:       fields[0],
+		SessionID:      id,
+		SessionVersion: version,
+		NetworkType:    fields[3],
+		AddressType:    fields[4],
+		Address:        fields[5],
+	}, nil
+}
+
+func parseConnection(value string) (*Connection, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("connection data must have 3 fields")
+	}
+
+	return &Connection{NetworkType: fields[0], AddressType: fields[1], Address: fields[2]}, nil
+}
+
+func parseBandwidth(value string) (Bandwidth, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return Bandwidth{}, fmt.Errorf("bandwidth must have type and value")
+	}
+	bandwidth, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 64)
+	if err != nil {
+		return Bandwidth{}, fmt.Errorf("invalid bandwidth value: %s", err)
+	}
+
+	return Bandwidth{Type: parts[0], Value: bandwidth}, nil
+}
+
+func parseTiming(value string) (Timing, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return Timing{}, fmt.Errorf("time description must have start and stop times")
+	}
+	start, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return Timing{}, fmt.Errorf("invalid start time: %s", err)
+	}
+	stop, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return Timing{}, fmt.Errorf("invalid stop time: %s", err)
+	}
+
+	return Timing{Start: start, Stop: stop}, nil
+}
+
+func parseAttribute(value string) Attribute {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) == 1 {
+		return Attribute{Key: parts[0]}
+	}
+
+	return Attribute{Key: parts[0], Value: parts[1]}
+}
+
+// String returns the session description with the lines in order required by RFC 8866 - 5.
+func (s *Session) String() string {
+	var buffer bytes.Buffer
+
+	writeLine(&buffer, 'v', strconv.Itoa(s.Version))
+	writeLine(&buffer, 'o', s.Origin.String())
+	writeLine(&buffer, 's', orDefault(s.Name, "-"))
+	writeOptionalLine(&buffer, 'i', s.Information)
+	writeOptionalLine(&buffer, 'u', s.URI)
+	for _, email := range s.Emails {
+		writeLine(&buffer, 'e', email)
+	}
+	for _, phone := range s.Phones {
+		writeLine(&buffer, 'p', phone)
+	}
+	if s.Connection != nil {
+		writeLine(&buffer, 'c', s.Connection.String())
+	}
+	for _, bandwidth := range s.Bandwidths {
+		writeLine(&buffer, 'b', bandwidth.String())
+	}
+	if len(s.Timings) == 0 {
+		writeLine(&buffer, 't', "0 0")
+	}
+	for _, timing := range s.Timings {
+		writeLine(&buffer, 't', timing.String())
+		for _, repeat := range timing.Repeats {
+			writeLine(&buffer, 'r', repeat)
+		}
+	}
+	writeOptionalLine(&buffer, 'z', s.TimeZones)
+	writeOptionalLine(&buffer, 'k', s.Key)
+	for _, attr := range s.Attributes {
+		writeLine(&buffer, 'a', attr.String())
+	}
+	for _, media := range s.Media {
+		media.write(&buffer)
+	}
+
+	return buffer.String()
+}
+
+// Clone returns deep copy of the session description.
+func (s *Session) Clone() *Session {
+	clone := *s
+	clone.Emails = cloneStrings(s.Emails)
+	clone.Phones = cloneStrings(s.Phones)
+	clone.Connection = s.Connection.Clone()
+	if s.Bandwidths != nil {
+		clone.Bandwidths = append([]Bandwidth{}, s.Bandwidths...)
+	}
+	if s.Timings != nil {
+		clone.Timings = make([]Timing, len(s.Timings))
+		for i, timing := range s.Timings {
+			clone.Timings[i] = Timing{Start: timing.Start, Stop: timing.Stop, Repeats: cloneStrings(timing.Repeats)}
+		}
+	}
+	clone.Attributes = s.Attributes.Clone()
+	if s.Media != nil {
+		clone.Media = make([]*Media, len(s.Media))
+		for i, media := range s.Media {
+			clone.Media[i] = media.Clone()
+		}
+	}
+
+	return &clone
+}
+
+// Direction returns session level direction attribute, empty if it is not set.
+func (s *Session) Direction() Direction {
+	return directionOf(s.Attributes)
+}
+
+// MediaDirection returns direction of the media stream of the session,
+// media level attribute overrides session level one, default is sendrecv (RFC 3264 - 5.1.).
+func (s *Session) MediaDirection(media *Media) Direction {
+	if direction := directionOf(media.Attributes); direction != "" {
+		return direction
+	}
+	if direction := s.Direction(); direction != "" {
+		return direction
+	}
+
+	return SendRecv
+}
+
+// ConnectionOf returns connection data of the media stream, media level one overrides session level one.
+func (s *Session) ConnectionOf(media *Media) *Connection {
+	if media.Connection != nil {
+		return media.Connection
+	}
+
+	return s.Connection
+}
+
+func writeLine(buffer *bytes.Buffer, typ byte, value string) {
+	buffer.WriteByte(typ)
+	buffer.WriteByte('=')
+	buffer.WriteString(value)
+	buffer.WriteString("\r\n")
+}
+
+func writeOptionalLine(buffer *bytes.Buffer, typ byte, value string) {
+	if value != "" {
+		writeLine(buffer, typ, value)
+	}
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+
+	return value
+}
+
+func cloneStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+
+	return append([]string{}, values...)
+}
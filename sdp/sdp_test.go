@@ -0,0 +1,149 @@
+package sdp
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/masterclock/gosip/sip"
+)
+
+const webrtcOffer = "v=0\r\n" +
+	"o=alice 2890844526 2890844526 IN IP4 192.0.2.1\r\n" +
+	"s=-\r\n" +
+	"c=IN IP4 192.0.2.1\r\n" +
+	"b=AS:256\r\n" +
+	"t=0 0\r\n" +
+	"a=sendrecv\r\n" +
+	"m=audio 49170 RTP/AVP 0 96 101\r\n" +
+	"a=rtpmap:96 opus/48000/2\r\n" +
+	"a=fmtp:96 minptime=10;useinbandfec=1\r\n" +
+	"a=rtpmap:101 telephone-event/8000\r\n" +
+	"a=fmtp:101 0-15\r\n" +
+	"a=candidate:1 1 UDP 2130706431 192.0.2.1 49170 typ host\r\n" +
+	"a=candidate:2 1 UDP 1694498815 203.0.113.1 61000 typ srflx raddr 192.0.2.1 rport 49170 generation 0\r\n" +
+	"m=video 0 RTP/AVP 31\r\n" +
+	"c=IN IP4 192.0.2.2\r\n" +
+	"a=recvonly\r\n"
+
+func TestParse(t *testing.T) {
+	s, err := Parse(webrtcOffer)
+	if err != nil {
+		t.Fatalf("[FAIL] Parse failed: %s", err)
+	}
+
+	if s.Origin.Username != "alice" || s.Origin.SessionID != 2890844526 || s.Origin.Address != "192.0.2.1" {
+		t.Errorf("[FAIL] Unexpected origin: %s", s.Origin)
+	}
+	if s.Connection == nil || s.Connection.Address != "192.0.2.1" {
+		t.Errorf("[FAIL] Unexpected connection: %v", s.Connection)
+	}
+	if len(s.Bandwidths) != 1 || s.Bandwidths[0].Value != 256 {
+		t.Errorf("[FAIL] Unexpected bandwidths: %v", s.Bandwidths)
+	}
+	if len(s.Media) != 2 {
+		t.Fatalf("[FAIL] Expected 2 media streams, got %d", len(s.Media))
+	}
+
+	audio, video := s.Media[0], s.Media[1]
+	if audio.Type != "audio" || audio.Port != 49170 || audio.Protocol != "RTP/AVP" || len(audio.Formats) != 3 {
+		t.Errorf("[FAIL] Unexpected audio media: %v", audio)
+	}
+	if rtpmaps := audio.RTPMaps(); len(rtpmaps) != 3 ||
+		rtpmaps[0] != (RTPMap{Payload: 0, Encoding: "PCMU", ClockRate: 8000}) ||
+		rtpmaps[1] != (RTPMap{Payload: 96, Encoding: "opus", ClockRate: 48000, Channels: 2}) {
+		t.Errorf("[FAIL] Unexpected rtpmaps: %v", rtpmaps)
+	}
+	if fmtp, ok := audio.FMTP("96"); !ok || fmtp != "minptime=10;useinbandfec=1" {
+		t.Errorf("[FAIL] Unexpected fmtp: %s", fmtp)
+	}
+	if candidates := audio.Candidates(); len(candidates) != 2 ||
+		candidates[1].Type != "srflx" || candidates[1].RelatedPort != 49170 ||
+		len(candidates[1].Extensions) != 1 {
+		t.Errorf("[FAIL] Unexpected candidates: %v", candidates)
+	}
+	if direction := s.MediaDirection(audio); direction != SendRecv {
+		t.Errorf("[FAIL] Expected session level direction sendrecv, got %s", direction)
+	}
+	if direction := s.MediaDirection(video); direction != RecvOnly {
+		t.Errorf("[FAIL] Expected media level direction recvonly, got %s", direction)
+	}
+	if !video.IsRejected() || s.ConnectionOf(video).Address != "192.0.2.2" {
+		t.Errorf("[FAIL] Unexpected video media: %v", video)
+	}
+
+	if s.String() != webrtcOffer {
+		t.Errorf("[FAIL] Expected:\n%s\nGot:\n%s", webrtcOffer, s)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+	}{
+		{"Missing version", "o=- 1 1 IN IP4 192.0.2.1\r\n"},
+		{"Malformed line", "v=0\r\nfoo\r\n"},
+		{"Malformed origin", "v=0\r\no=- 1 IN IP4 192.0.2.1\r\n"},
+		{"Malformed port", "v=0\r\nm=audio port RTP/AVP 0\r\n"},
+		{"Session line in media", "v=0\r\nm=audio 49170 RTP/AVP 0\r\ns=-\r\n"},
+	}
+
+	for _, test := range tests {
+		if _, err := Parse(test.input); err == nil {
+			t.Errorf("[FAIL] %s: error expected", test.description)
+		}
+	}
+}
+
+func TestParse_LF(t *testing.T) {
+	s, err := Parse("v=0\no=- 1 2 IN IP4 192.0.2.1\ns=-\nt=0 0\nm=audio 4000 RTP/AVP 8\n")
+	if err != nil {
+		t.Fatalf("[FAIL] Parse failed: %s", err)
+	}
+	if len(s.Media) != 1 || s.Origin.SessionVersion != 2 {
+		t.Errorf("[FAIL] Unexpected session: %s", s)
+	}
+}
+
+func TestCandidate_String(t *testing.T) {
+	for _, value := range []string{
+		"1 1 UDP 2130706431 192.0.2.1 49170 typ host",
+		"2 1 UDP 1694498815 203.0.113.1 61000 typ srflx raddr 192.0.2.1 rport 49170 generation 0",
+	} {
+		candidate, err := ParseCandidate(value)
+		if err != nil {
+			t.Errorf("[FAIL] ParseCandidate failed: %s", err)
+		} else if candidate.String() != value {
+			t.Errorf("[FAIL] Expected: \"%s\", Got: \"%s\"", value, candidate)
+		}
+	}
+}
+
+func TestSetBody(t *testing.T) {
+	s, _ := Parse(webrtcOffer)
+	req := sip.NewRequest(sip.INVITE, &sip.SipUri{Host: "example.com"}, "SIP/2.0", []sip.Header{
+		&sip.GenericHeader{HeaderName: "Content-Type", Contents: "text/plain"},
+	}, "hello")
+
+	SetBody(req, s)
+	if hdrs := req.GetHeaders("Content-Type"); len(hdrs) != 1 || hdrs[0].String() != "Content-Type: application/sdp" {
+		t.Errorf("[FAIL] Unexpected Content-Type: %v", hdrs)
+	}
+	if hdrs := req.GetHeaders("Content-Length"); len(hdrs) != 1 ||
+		hdrs[0].String() != fmt.Sprintf("Content-Length: %d", len(webrtcOffer)) {
+		t.Errorf("[FAIL] Unexpected Content-Length: %v", hdrs)
+	}
+
+	parsed, err := ParseBody(req)
+	if err != nil {
+		t.Fatalf("[FAIL] ParseBody failed: %s", err)
+	}
+	if parsed.String() != webrtcOffer {
+		t.Errorf("[FAIL] Expected:\n%s\nGot:\n%s", webrtcOffer, parsed)
+	}
+
+	req.RemoveHeader("Content-Type")
+	if _, err := ParseBody(req); err == nil {
+		t.Errorf("[FAIL] ParseBody of message without Content-Type: error expected")
+	}
+}
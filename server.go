@@ -2,11 +2,13 @@ package gosip
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
-	"strings"
 	"sync"
 	"sync/atomic"
 
+	"github.com/masterclock/gosip/auth"
+	"github.com/masterclock/gosip/dialog"
 	"github.com/masterclock/gosip/log"
 	"github.com/masterclock/gosip/sip"
 	"github.com/masterclock/gosip/transaction"
@@ -21,10 +23,22 @@ const (
 // of the certain method
 type RequestHandler func(req sip.Request)
 
+// Authenticator authenticates incoming requests, e.g. auth.Authenticator.
+// Authenticate returns true if the request is authenticated,
+// otherwise returns the response with the challenge.
+type Authenticator interface {
+	Authenticate(req sip.Request) (sip.Response, bool)
+}
+
 // ServerConfig describes available options
 type ServerConfig struct {
 	HostAddr   string
 	Extensions []string
+	// TLSConfig is used by TLS transport, required to listen TLS.
+	TLSConfig *tls.Config
+	// TxConfig configures transaction layer, e.g. SIP timers, may be nil.
+	// PRACK requests are built in Server.Dialogs unless TxConfig.Prack is set.
+	TxConfig *transaction.LayerConfig
 }
 
 var defaultConfig = &ServerConfig{
@@ -41,6 +55,9 @@ type Server struct {
 	hmu             *sync.RWMutex
 	requestHandlers map[sip.RequestMethod][]RequestHandler
 	extensions      []string
+	rmu             *sync.Mutex
+	registrations   map[*Registration]bool
+	dialogs         *dialog.Registry
 }
 
 // NewServer creates new instance of SIP server.
@@ -58,8 +75,19 @@ func NewServer(config *ServerConfig) *Server {
 	}
 
 	ctx := context.Background()
-	tp := transport.NewLayer(hostAddr)
-	tx := transaction.NewLayer(tp)
+	tp := transport.NewLayerWithConfig(hostAddr, &transport.LayerConfig{
+		TLSConfig: config.TLSConfig,
+	})
+	dialogs := dialog.NewRegistry()
+	txConfig := &transaction.LayerConfig{}
+	if config.TxConfig != nil {
+		*txConfig = *config.TxConfig
+	}
+	if txConfig.Prack == nil {
+		// PRACK shares CSeq numbers with the other requests of the early dialog
+		txConfig.Prack = dialogs.NewPrack
+	}
+	tx := transaction.NewLayer(tp, txConfig)
 	srv := &Server{
 		tp:              tp,
 		tx:              tx,
@@ -67,6 +95,9 @@ func NewServer(config *ServerConfig) *Server {
 		hmu:             new(sync.RWMutex),
 		requestHandlers: make(map[sip.RequestMethod][]RequestHandler),
 		extensions:      config.Extensions,
+		rmu:             new(sync.Mutex),
+		registrations:   make(map[*Registration]bool),
+		dialogs:         dialogs,
 	}
 
 	go srv.serve(ctx)
@@ -91,21 +122,33 @@ func (srv *Server) serve(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			return
-		case req := <-srv.tx.Requests():
-			if req != nil { // if chan is closed or early exit
+		case req, ok := <-srv.tx.Requests():
+			if !ok { // layers are stopped, closed channels would spin the loop
+				return
+			}
+			if req != nil {
 				srv.hwg.Add(1)
 				go srv.handleRequest(req)
 			}
-		case res := <-srv.tx.Responses():
+		case res, ok := <-srv.tx.Responses():
+			if !ok {
+				return
+			}
 			if res != nil {
 				log.Warnf("GoSIP server received not matched response: %s", res.Short())
 				log.Debug(res.String())
 			}
-		case err := <-srv.tx.Errors():
+		case err, ok := <-srv.tx.Errors():
+			if !ok {
+				return
+			}
 			if err != nil {
 				log.Errorf("GoSIP server received transaction error: %s", err)
 			}
-		case err := <-srv.tp.Errors():
+		case err, ok := <-srv.tp.Errors():
+			if !ok {
+				return
+			}
 			if err != nil {
 				log.Error("GoSIP server received transport error: %s", err)
 			}
@@ -127,8 +170,15 @@ func (srv *Server) handleRequest(req sip.Request) {
 		for _, handler := range handlers {
 			handler(req)
 		}
-	} else if req.IsAck() {
+	} else if req.IsAck() || req.IsCancel() {
 		// nothing to do, just ignore it
+		// CANCEL is already answered by the transaction layer
+	} else if req.Method() == sip.PRACK {
+		// PRACK is already matched to the reliable provisional response by the transaction layer
+		res := sip.NewResponseFromRequest(req, 200, "OK", "")
+		if _, err := srv.Respond(res); err != nil {
+			log.Errorf("GoSIP server failed to respond on PRACK: %s", err)
+		}
 	} else {
 		log.Warnf("GoSIP server not found handler registered for the request %s", req.Short())
 
@@ -141,6 +191,13 @@ func (srv *Server) handleRequest(req sip.Request) {
 	return
 }
 
+// Dialogs returns the registry where early dialogs are created by the reliable provisional
+// responses to build PRACK requests. Requests inside these dialogs should be built by it
+// and their responses passed to it, so CSeq numbers do not collide with PRACK.
+func (srv *Server) Dialogs() *dialog.Registry {
+	return srv.dialogs
+}
+
 // Send SIP message
 func (srv *Server) Request(req sip.Request) (<-chan sip.Response, error) {
 	if srv.shuttingDown() {
@@ -150,6 +207,147 @@ func (srv *Server) Request(req sip.Request) (<-chan sip.Response, error) {
 	return srv.tx.Request(srv.prepareRequest(req))
 }
 
+// AuthRequest is the request sent by RequestWithAuth. Each answered challenge starts
+// the new attempt of the request in new transaction.
+type AuthRequest struct {
+	srv       *Server
+	responses chan sip.Response
+	done      chan struct{}
+	closeOnce *sync.Once
+	mu        *sync.RWMutex
+	current   sip.Request
+	canceled  bool
+}
+
+// Responses returns chan with the responses on the request, the answered challenges are consumed.
+// It is closed when the transaction of the last attempt terminates or Close is called.
+func (r *AuthRequest) Responses() <-chan sip.Response {
+	return r.responses
+}
+
+// Request returns the current attempt of the request, e.g. to match the responses or build ACK.
+func (r *AuthRequest) Request() sip.Request {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.current
+}
+
+// Cancel cancels the current attempt of the pending INVITE request (RFC 3261 - 9.1.),
+// challenges received after it are passed up without answering.
+func (r *AuthRequest) Cancel() error {
+	r.mu.Lock()
+	r.canceled = true
+	req := r.current
+	r.mu.Unlock()
+
+	return r.srv.CancelRequest(req)
+}
+
+// Close stops passing of the responses, e.g. when the caller is not interested in them anymore.
+func (r *AuthRequest) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+}
+
+// RequestWithAuth sends the request and answers authentication challenges (401, 407) with the credentials.
+// Challenged request is re-sent with incremented CSeq in new transaction, the challenge responses are consumed here.
+// All the other responses including the final one are passed to AuthRequest.Responses.
+func (srv *Server) RequestWithAuth(req sip.Request, credentials auth.CredentialsProvider) (*AuthRequest, error) {
+	responses, err := srv.Request(req)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &AuthRequest{
+		srv:       srv,
+		responses: make(chan sip.Response),
+		done:      make(chan struct{}),
+		closeOnce: new(sync.Once),
+		mu:        new(sync.RWMutex),
+		current:   req,
+	}
+	client := auth.NewClient(credentials)
+	go func() {
+		defer close(r.responses)
+
+		for {
+			var res sip.Response
+			var ok bool
+			select {
+			case <-r.done:
+				return
+			case res, ok = <-responses:
+				if !ok {
+					return
+				}
+			}
+
+			if res.StatusCode() == 401 || res.StatusCode() == 407 {
+				if nextResponses, ok := r.retry(client, res); ok {
+					responses = nextResponses
+					continue
+				}
+			}
+
+			select {
+			case <-r.done:
+				return
+			case r.responses <- res:
+			}
+		}
+	}()
+
+	return r, nil
+}
+
+// retry answers the challenge and re-sends the request as the next attempt,
+// returns false if the request is canceled or the challenge can not be answered.
+func (r *AuthRequest) retry(client *auth.Client, res sip.Response) (<-chan sip.Response, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.canceled {
+		return nil, false
+	}
+	next, err := client.Authorize(r.current, res)
+	if err != nil {
+		log.Warnf("GoSIP server failed to authorize %s: %s", r.current.Short(), err)
+		return nil, false
+	}
+	responses, err := r.srv.Request(next)
+	if err != nil {
+		log.Warnf("GoSIP server failed to send authorized %s: %s", next.Short(), err)
+		return nil, false
+	}
+	r.current = next
+
+	return responses, true
+}
+
+// CancelRequest cancels pending INVITE request sent by Request
+func (srv *Server) CancelRequest(req sip.Request) error {
+	if srv.shuttingDown() {
+		return fmt.Errorf("can not send through stopped server")
+	}
+
+	return srv.tx.CancelRequest(req)
+}
+
+// Ack sends ACK on 2xx response to INVITE, it is passed directly to the transport layer
+// since ACK on 2xx is not a part of the INVITE transaction (RFC 3261 - 13.2.2.4.).
+func (srv *Server) Ack(ack sip.Request) error {
+	if srv.shuttingDown() {
+		return fmt.Errorf("can not send through stopped server")
+	}
+	if !ack.IsAck() {
+		return fmt.Errorf("%s is not ACK request", ack.Short())
+	}
+
+	return srv.tp.Send(srv.prepareRequest(ack))
+}
+
 func (srv *Server) prepareRequest(req sip.Request) sip.Request {
 	autoAppendMethods := map[sip.RequestMethod]bool{
 		sip.INVITE:   true,
@@ -160,13 +358,8 @@ func (srv *Server) prepareRequest(req sip.Request) sip.Request {
 	if _, ok := autoAppendMethods[req.Method()]; ok {
 		hdrs := req.GetHeaders("Allow")
 		if len(hdrs) == 0 {
-			methods := make([]string, 0)
-			for _, method := range srv.getAllowedMethods() {
-				methods = append(methods, string(method))
-			}
-			req.AppendHeader(&sip.GenericHeader{
-				HeaderName: "Allow",
-				Contents:   strings.Join(methods, ", "),
+			req.AppendHeader(&sip.AllowHeader{
+				Methods: srv.getAllowedMethods(),
 			})
 		}
 
@@ -200,24 +393,17 @@ func (srv *Server) prepareResponse(res sip.Response) sip.Response {
 	}
 
 	if cseq, ok := res.CSeq(); ok {
-		methods := make([]string, 0)
-		for _, method := range srv.getAllowedMethods() {
-			methods = append(methods, string(method))
-		}
-
 		if _, ok := autoAppendMethods[cseq.MethodName]; ok {
-			hdrs := res.GetHeaders("Allow")
-			if len(hdrs) == 0 {
-				res.AppendHeader(&sip.GenericHeader{
-					HeaderName: "Allow",
-					Contents:   strings.Join(methods, ", "),
-				})
+			if allow, ok := res.Allow(); ok {
+				allow.Methods = srv.getAllowedMethods()
 			} else {
-				allowHeader := hdrs[0].(*sip.GenericHeader)
-				allowHeader.Contents = strings.Join(methods, ", ")
+				res.RemoveHeader("Allow")
+				res.AppendHeader(&sip.AllowHeader{
+					Methods: srv.getAllowedMethods(),
+				})
 			}
 
-			hdrs = res.GetHeaders("Supported")
+			hdrs := res.GetHeaders("Supported")
 			if len(hdrs) == 0 {
 				res.AppendHeader(&sip.SupportedHeader{
 					Options: srv.extensions,
@@ -242,6 +428,9 @@ func (srv *Server) Shutdown() {
 		return
 	}
 
+	// remove bindings while the server is still able to send requests
+	srv.stopRegistrations()
+
 	atomic.AddInt32(&srv.inShutdown, 1)
 	defer atomic.AddInt32(&srv.inShutdown, -1)
 	// stop transaction layer
@@ -276,6 +465,29 @@ func (srv *Server) OnRequest(method sip.RequestMethod, handler RequestHandler) e
 	return nil
 }
 
+// WithAuth wraps the request handler with authentication.
+// Handler is called only for the authenticated requests, the other requests are answered with the challenge.
+// ACK and CANCEL can not be challenged (RFC 3261 - 22.1.) and are passed to the handler as is.
+func (srv *Server) WithAuth(authenticator Authenticator, handler RequestHandler) RequestHandler {
+	return func(req sip.Request) {
+		if req.IsAck() || req.IsCancel() {
+			handler(req)
+			return
+		}
+
+		res, ok := authenticator.Authenticate(req)
+		if ok {
+			handler(req)
+			return
+		}
+
+		log.Debugf("GoSIP server challenges %s", req.Short())
+		if _, err := srv.Respond(res); err != nil {
+			log.Errorf("GoSIP server failed to respond with challenge: %s", err)
+		}
+	}
+}
+
 func (srv *Server) getAllowedMethods() []sip.RequestMethod {
 	methods := []sip.RequestMethod{
 		sip.INVITE,
@@ -5,7 +5,10 @@ import (
 	"sync"
 
 	"github.com/masterclock/gosip"
+	"github.com/masterclock/gosip/auth"
+	"github.com/masterclock/gosip/log"
 	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/sip/parser"
 	"github.com/masterclock/gosip/testutils"
 	"github.com/masterclock/gosip/transport"
 	. "github.com/onsi/ginkgo"
@@ -24,8 +27,9 @@ var _ = Describe("GoSIP Server", func() {
 	localTarget := transport.NewTarget("127.0.0.1", 5060)
 
 	BeforeEach(func() {
+		// listener of the previous spec may be closed a bit after shutdown
 		srv = gosip.NewServer(nil)
-		Expect(srv.Listen("udp", "0.0.0.0:5060")).To(Succeed())
+		Eventually(func() error { return srv.Listen("udp", "0.0.0.0:5060") }).Should(Succeed())
 
 		client1 = testutils.CreateClient("udp", localTarget.Addr(), clientAddr)
 
@@ -67,4 +71,291 @@ var _ = Describe("GoSIP Server", func() {
 		wg.Wait()
 		close(done)
 	}, 3)
+
+	It("should answer authentication challenge on the request", func(done Done) {
+		peer, err := net.ListenPacket("udp", "127.0.0.1:9002")
+		Expect(err).ToNot(HaveOccurred())
+		defer peer.Close()
+
+		go func() {
+			defer GinkgoRecover()
+			buf := make([]byte, transport.MTU)
+			for _, statusCode := range []sip.StatusCode{401, 200} {
+				num, _, err := peer.ReadFrom(buf)
+				Expect(err).ToNot(HaveOccurred())
+				msg, err := parser.ParseMessage(buf[:num], log.StandardLogger())
+				Expect(err).ToNot(HaveOccurred())
+				req := msg.(sip.Request)
+
+				res := sip.NewResponseFromRequest(req, statusCode, "Reason", "")
+				if statusCode == 401 {
+					Expect(req.GetHeaders("Authorization")).To(BeEmpty())
+					res.AppendHeader(&sip.WWWAuthenticateHeader{Challenge: sip.Challenge{
+						Scheme: "Digest",
+						Realm:  "example.com",
+						Nonce:  "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+						Qop:    []string{"auth"},
+					}})
+				} else {
+					hdrs := req.GetHeaders("Authorization")
+					Expect(hdrs).To(HaveLen(1))
+					credentials := hdrs[0].(*sip.AuthorizationHeader).Credentials
+					Expect(credentials.Nonce).To(Equal("dcd98b7102dd2f0e8b11d0f600bfb0c093"))
+					Expect(credentials.NonceCount).To(Equal(uint32(1)))
+					expected, err := auth.DigestResponse(&credentials, &auth.User{Username: "alice", Password: "secret"},
+						sip.OPTIONS, "")
+					Expect(err).ToNot(HaveOccurred())
+					Expect(credentials.Response).To(Equal(expected))
+				}
+				_, err = peer.WriteTo([]byte(res.String()), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5060})
+				Expect(err).ToNot(HaveOccurred())
+			}
+		}()
+
+		options := testutils.Request([]string{
+			"OPTIONS sip:bob@127.0.0.1:9002 SIP/2.0",
+			"From: \"Alice\" <sip:alice@wonderland.com>;tag=1928301774",
+			"To: \"Bob\" <sip:bob@example.com>",
+			"Call-ID: auth-call",
+			"CSeq: 1 OPTIONS",
+			"Content-Length: 0",
+			"",
+			"",
+		})
+		authReq, err := srv.RequestWithAuth(options, auth.StaticCredentials("alice", "secret"))
+		Expect(err).ToNot(HaveOccurred())
+
+		res := <-authReq.Responses()
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(200)))
+		cseq, _ := res.CSeq()
+		Expect(cseq.SeqNo).To(Equal(uint32(2)))
+		cseq, _ = authReq.Request().CSeq()
+		Expect(cseq.SeqNo).To(Equal(uint32(2)))
+		close(done)
+	}, 3)
+
+	It("should cancel the authorized attempt of the challenged INVITE", func(done Done) {
+		peer, err := net.ListenPacket("udp", "127.0.0.1:9002")
+		Expect(err).ToNot(HaveOccurred())
+		defer peer.Close()
+
+		peerAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5060}
+		// retransmissions over UDP are skipped
+		received := make(map[string]bool)
+		read := func() sip.Request {
+			for {
+				buf := make([]byte, transport.MTU)
+				num, _, err := peer.ReadFrom(buf)
+				Expect(err).ToNot(HaveOccurred())
+				if received[string(buf[:num])] {
+					continue
+				}
+				received[string(buf[:num])] = true
+				msg, err := parser.ParseMessage(buf[:num], log.StandardLogger())
+				Expect(err).ToNot(HaveOccurred())
+				return msg.(sip.Request)
+			}
+		}
+		respond := func(req sip.Request, statusCode sip.StatusCode, hdrs ...sip.Header) {
+			res := sip.NewResponseFromRequest(req, statusCode, "Reason", "")
+			for _, hdr := range hdrs {
+				res.AppendHeader(hdr)
+			}
+			_, err := peer.WriteTo([]byte(res.String()), peerAddr)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		go func() {
+			defer GinkgoRecover()
+			req := read()
+			Expect(req.Method()).To(Equal(sip.INVITE))
+			respond(req, 401, &sip.WWWAuthenticateHeader{Challenge: sip.Challenge{
+				Scheme: "Digest",
+				Realm:  "example.com",
+				Nonce:  "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+			}})
+			// ACK of 401 and the authorized INVITE are sent concurrently
+			var invite sip.Request
+			acked := false
+			for invite == nil || !acked {
+				req := read()
+				if req.Method() == sip.ACK {
+					acked = true
+					continue
+				}
+				Expect(req.Method()).To(Equal(sip.INVITE))
+				invite = req
+			}
+			Expect(invite.GetHeaders("Authorization")).To(HaveLen(1))
+			respond(invite, 180)
+
+			cancel := read()
+			Expect(cancel.Method()).To(Equal(sip.CANCEL))
+			inviteVia, _ := invite.ViaHop()
+			inviteBranch, _ := inviteVia.Params.Get("branch")
+			cancelVia, _ := cancel.ViaHop()
+			cancelBranch, _ := cancelVia.Params.Get("branch")
+			Expect(cancelBranch.String()).To(Equal(inviteBranch.String()))
+			respond(cancel, 200)
+			respond(invite, 487)
+		}()
+
+		invite := testutils.Request([]string{
+			"INVITE sip:bob@127.0.0.1:9002 SIP/2.0",
+			"From: \"Alice\" <sip:alice@wonderland.com>;tag=1928301774",
+			"To: \"Bob\" <sip:bob@example.com>",
+			"Call-ID: auth-cancel-call",
+			"CSeq: 1 INVITE",
+			"Content-Length: 0",
+			"",
+			"",
+		})
+		authReq, err := srv.RequestWithAuth(invite, auth.StaticCredentials("alice", "secret"))
+		Expect(err).ToNot(HaveOccurred())
+
+		res := <-authReq.Responses()
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(180)))
+		Expect(authReq.Cancel()).To(Succeed())
+		res = <-authReq.Responses()
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(487)))
+		close(done)
+	}, 3)
+
+	It("should send PRACK in the early dialog of the server", func(done Done) {
+		peer, err := net.ListenPacket("udp", "127.0.0.1:9003")
+		Expect(err).ToNot(HaveOccurred())
+		defer peer.Close()
+
+		peerAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5060}
+		read := func(method sip.RequestMethod) sip.Request {
+			for {
+				buf := make([]byte, transport.MTU)
+				num, _, err := peer.ReadFrom(buf)
+				Expect(err).ToNot(HaveOccurred())
+				msg, err := parser.ParseMessage(buf[:num], log.StandardLogger())
+				Expect(err).ToNot(HaveOccurred())
+				// retransmissions over UDP are skipped
+				if req := msg.(sip.Request); req.Method() == method {
+					return req
+				}
+			}
+		}
+		respond := func(req sip.Request, statusCode sip.StatusCode, hdrs ...sip.Header) {
+			res := sip.NewResponseFromRequest(req, statusCode, "Reason", "")
+			to, _ := res.To()
+			to.Params.Add("tag", sip.String{Str: "a6c85cf"})
+			for _, hdr := range hdrs {
+				res.AppendHeader(hdr)
+			}
+			_, err := peer.WriteTo([]byte(res.String()), peerAddr)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		reliable := func(invite sip.Request, rseq uint32) {
+			rseqHdr := sip.RSeq(rseq)
+			port := sip.Port(9003)
+			respond(invite, 183,
+				&sip.ContactHeader{Address: &sip.SipUri{User: sip.String{Str: "bob"}, Host: "127.0.0.1", Port: &port}},
+				&sip.GenericHeader{HeaderName: "Require", Contents: "100rel"},
+				&rseqHdr,
+			)
+		}
+
+		updated := make(chan struct{})
+		pracks := make(chan sip.Request, 2)
+		go func() {
+			defer GinkgoRecover()
+			invite := read(sip.INVITE)
+			reliable(invite, 1)
+			prack := read(sip.PRACK)
+			pracks <- prack
+			respond(prack, 200)
+
+			<-updated
+			reliable(invite, 2)
+			prack = read(sip.PRACK)
+			pracks <- prack
+			respond(prack, 200)
+			respond(invite, 486)
+		}()
+
+		invite := testutils.Request([]string{
+			"INVITE sip:bob@127.0.0.1:9003 SIP/2.0",
+			"From: \"Alice\" <sip:alice@wonderland.com>;tag=1928301774",
+			"To: \"Bob\" <sip:bob@example.com>",
+			"Call-ID: prack-call",
+			"CSeq: 1 INVITE",
+			"Content-Length: 0",
+			"",
+			"",
+		})
+		responses, err := srv.Request(invite)
+		Expect(err).ToNot(HaveOccurred())
+
+		res := <-responses
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(183)))
+		dlg, ok := srv.Dialogs().MatchResponse(res)
+		Expect(ok).To(BeTrue())
+		prack := <-pracks
+		cseq, _ := prack.CSeq()
+		Expect(cseq.String()).To(Equal("CSeq: 2 PRACK"))
+		// request sent in the early dialog takes the next CSeq number
+		update, err := dlg.NewRequest(sip.RequestMethod("UPDATE"))
+		Expect(err).ToNot(HaveOccurred())
+		cseq, _ = update.CSeq()
+		Expect(cseq.SeqNo).To(Equal(uint32(3)))
+		close(updated)
+
+		res = <-responses
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(183)))
+		prack = <-pracks
+		cseq, _ = prack.CSeq()
+		Expect(cseq.String()).To(Equal("CSeq: 4 PRACK"))
+		rack, _ := prack.RAck()
+		Expect(rack.String()).To(Equal("RAck: 2 1 INVITE"))
+
+		res = <-responses
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(486)))
+		close(done)
+	}, 3)
+
+	It("should call handler only for authenticated requests", func(done Done) {
+		srv2 := gosip.NewServer(&gosip.ServerConfig{HostAddr: "127.0.0.1"})
+		defer srv2.Shutdown()
+		Expect(srv2.Listen("udp", "127.0.0.1:5070")).To(Succeed())
+
+		authenticator, err := auth.NewAuthenticator(&auth.AuthenticatorConfig{
+			Realm: "example.com",
+			Users: auth.UserStoreFunc(func(realm, username string) (*auth.User, bool) {
+				return &auth.User{Username: "alice", Password: "secret"}, username == "alice"
+			}),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		handled := make(chan sip.Request, 1)
+		Expect(srv2.OnRequest(sip.OPTIONS, srv2.WithAuth(authenticator, func(req sip.Request) {
+			handled <- req
+			res := sip.NewResponseFromRequest(req, 200, "OK", "")
+			_, err := srv2.Respond(res)
+			Expect(err).ToNot(HaveOccurred())
+		}))).To(Succeed())
+
+		options := testutils.Request([]string{
+			"OPTIONS sip:bob@127.0.0.1:5070 SIP/2.0",
+			"From: \"Alice\" <sip:alice@wonderland.com>;tag=1928301774",
+			"To: \"Bob\" <sip:bob@example.com>",
+			"Call-ID: auth-middleware-call",
+			"CSeq: 1 OPTIONS",
+			"Content-Length: 0",
+			"",
+			"",
+		})
+		authReq, err := srv.RequestWithAuth(options, auth.StaticCredentials("alice", "secret"))
+		Expect(err).ToNot(HaveOccurred())
+
+		res := <-authReq.Responses()
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(200)))
+		req := <-handled
+		Expect(req.GetHeaders("Authorization")).To(HaveLen(1))
+		close(done)
+	}, 3)
 })
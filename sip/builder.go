@@ -2,7 +2,6 @@ package sip
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/masterclock/gosip/util"
 )
@@ -21,12 +20,12 @@ type RequestBuilder struct {
 	from            *FromHeader
 	to              *ToHeader
 	contact         *ContactHeader
-	expires         *GenericHeader
+	expires         *Expires
 	userAgent       *GenericHeader
 	maxForwards     *GenericHeader
 	supported       *SupportedHeader
 	require         *RequireHeader
-	allow           *GenericHeader
+	allow           *AllowHeader
 }
 
 func NewRequestBuilder() *RequestBuilder {
@@ -118,9 +117,7 @@ func (rb *RequestBuilder) AddVia(via *ViaHop) *RequestBuilder {
 
 func (rb *RequestBuilder) SetFrom(address *Address) *RequestBuilder {
 	address = address.Clone()
-	if address.Uri.Host == "" {
-		address.Uri.Host = rb.host
-	}
+	rb.defaultHost(address.Uri)
 
 	rb.from = &FromHeader{
 		DisplayName: address.DisplayName,
@@ -133,9 +130,7 @@ func (rb *RequestBuilder) SetFrom(address *Address) *RequestBuilder {
 
 func (rb *RequestBuilder) SetTo(address *Address) *RequestBuilder {
 	address = address.Clone()
-	if address.Uri.Host == "" {
-		address.Uri.Host = rb.host
-	}
+	rb.defaultHost(address.Uri)
 
 	rb.to = &ToHeader{
 		DisplayName: address.DisplayName,
@@ -148,24 +143,30 @@ func (rb *RequestBuilder) SetTo(address *Address) *RequestBuilder {
 
 func (rb *RequestBuilder) SetContact(address *Address) *RequestBuilder {
 	address = address.Clone()
-	if address.Uri.Host == "" {
-		address.Uri.Host = rb.host
-	}
-
-	rb.contact = &ContactHeader{
-		DisplayName: address.DisplayName,
-		Address:     address.Uri,
-		Params:      address.Params,
+	rb.defaultHost(address.Uri)
+
+	// all URIs of the package can be used in 'Contact' header
+	if uri, ok := address.Uri.(ContactUri); ok {
+		rb.contact = &ContactHeader{
+			DisplayName: address.DisplayName,
+			Address:     uri,
+			Params:      address.Params,
+		}
 	}
 
 	return rb
 }
 
-func (rb *RequestBuilder) SetExpires(expires uint) *RequestBuilder {
-	rb.expires = &GenericHeader{
-		HeaderName: "Expires",
-		Contents:   fmt.Sprintf("%d", expires),
+// defaultHost sets host of the builder to SIP URI without host.
+func (rb *RequestBuilder) defaultHost(uri Uri) {
+	if sipUri, ok := uri.(*SipUri); ok && sipUri.Host == "" {
+		sipUri.Host = rb.host
 	}
+}
+
+func (rb *RequestBuilder) SetExpires(expires uint) *RequestBuilder {
+	value := Expires(expires)
+	rb.expires = &value
 
 	return rb
 }
@@ -186,14 +187,8 @@ func (rb *RequestBuilder) SetMaxForwards(maxForwards uint) *RequestBuilder {
 }
 
 func (rb *RequestBuilder) SetAllow(methods []RequestMethod) *RequestBuilder {
-	parts := make([]string, 0)
-	for _, method := range methods {
-		parts = append(parts, string(method))
-	}
-
-	rb.allow = &GenericHeader{
-		HeaderName: "Allow",
-		Contents:   strings.Join(parts, ", "),
+	rb.allow = &AllowHeader{
+		Methods: methods,
 	}
 
 	return rb
@@ -15,13 +15,15 @@ const (
 	DefaultUdpPort Port = 5060
 	DefaultTcpPort Port = 5060
 	DefaultTlsPort Port = 5061
+	DefaultWsPort  Port = 80
+	DefaultWssPort Port = 443
 )
 
 // TODO should be refactored, currently here the pit
 
 type Address struct {
 	DisplayName MaybeString
-	Uri         *SipUri
+	Uri         Uri
 	Params      Params
 }
 
@@ -44,14 +46,14 @@ func (addr *Address) String() string {
 func (addr *Address) Clone() *Address {
 	return &Address{
 		DisplayName: addr.DisplayName,
-		Uri:         addr.Uri.Clone().(*SipUri),
-		Params:      addr.Params.Clone(),
+		Uri:         addr.Uri.Clone(),
+		Params:      cloneWithNil(addr.Params),
 	}
 }
 
 func (addr *Address) Equals(other interface{}) bool {
 	if v, ok := other.(*Address); ok {
-		return addr.DisplayName.Equals(v.DisplayName) &&
+		return equalMaybeStrings(addr.DisplayName, v.DisplayName) &&
 			addr.Uri.Equals(v.Uri) &&
 			addr.Params.Equals(v.Params)
 	}
@@ -107,6 +109,14 @@ func (str String) Equals(other interface{}) bool {
 	return false
 }
 
+func equalMaybeStrings(str1, str2 MaybeString) bool {
+	if str1 == nil || str2 == nil {
+		return str1 == nil && str2 == nil
+	}
+
+	return str1.Equals(str2)
+}
+
 type CancelError interface {
 	Canceled() bool
 }
@@ -225,6 +235,10 @@ func DefaultPort(protocol string) Port {
 		return DefaultTcpPort
 	case "udp":
 		return DefaultUdpPort
+	case "ws":
+		return DefaultWsPort
+	case "wss":
+		return DefaultWssPort
 	default:
 		return DefaultTcpPort
 	}
@@ -262,3 +276,15 @@ func MakeDialogIDFromMessage(msg Message) (string, error) {
 func MakeDialogID(callID, innerID, externalID string) string {
 	return strings.Join([]string{callID, innerID, externalID}, "__")
 }
+
+// Tag returns value of 'tag' param, e.g. of 'From' or 'To' header, or empty string if there is no tag.
+func Tag(params Params) string {
+	if params == nil {
+		return ""
+	}
+	if tag, ok := params.Get("tag"); ok && tag != nil {
+		return tag.String()
+	}
+
+	return ""
+}
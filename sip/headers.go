@@ -3,7 +3,9 @@ package sip
 import (
 	"bytes"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/masterclock/gosip/log"
 	"github.com/masterclock/gosip/util"
@@ -35,7 +37,7 @@ type Uri interface {
 }
 
 // A URI from a schema suitable for inclusion in a Contact: header.
-// The only such URIs are sip/sips URIs and the special wildcard URI '*'.
+// These are sip/sips URIs, the special wildcard URI '*' and the absolute URIs of redirect contacts.
 type ContactUri interface {
 	Uri
 	// Return true if and only if the URI is the special wildcard URI '*'; that is, if it is
@@ -244,35 +246,90 @@ func (uri *SipUri) IsWildcard() bool {
 }
 
 // Determine if the SIP URI is equal to the specified URI according to the rules laid down in RFC 3261 s. 19.1.4.
-// TODO: The Equals method is not currently RFC-compliant; fix this!
+// User and password are case-sensitive, host and params are case-insensitive, escaped unreserved characters
+// are equal to their unescaped form. A port, 'transport', 'user', 'ttl', 'method' and 'maddr' params
+// must be equal if present in either URI, any other params are compared only if present in both.
+// Headers must be present in both URIs regardless of the order.
 func (uri *SipUri) Equals(val interface{}) bool {
-	otherPtr, ok := val.(*SipUri)
+	other, ok := val.(*SipUri)
 	if !ok {
 		return false
 	}
 
-	other := *otherPtr
-	result := uri.IsEncrypted == other.IsEncrypted &&
-		uri.User == other.User &&
-		uri.Password == other.Password &&
-		uri.Host == other.Host &&
-		util.Uint16PtrEq((*uint16)(uri.Port), (*uint16)(other.Port))
-
-	if !result {
+	if uri.IsEncrypted != other.IsEncrypted ||
+		normalizePercentEncoding(maybeString(uri.User)) != normalizePercentEncoding(maybeString(other.User)) ||
+		normalizePercentEncoding(maybeString(uri.Password)) != normalizePercentEncoding(maybeString(other.Password)) ||
+		!strings.EqualFold(uri.Host, other.Host) ||
+		!util.Uint16PtrEq((*uint16)(uri.Port), (*uint16)(other.Port)) {
 		return false
 	}
 
-	if !uri.UriParams.Equals(other.UriParams) {
-		return false
+	params, otherParams := uriParams(uri.UriParams), uriParams(other.UriParams)
+	for name := range sipUriSignificantParams {
+		if _, ok := params[name]; !ok {
+			if _, ok := otherParams[name]; ok {
+				return false
+			}
+		}
+	}
+	for name, val := range params {
+		otherVal, ok := otherParams[name]
+		if !ok {
+			if sipUriSignificantParams[name] {
+				return false
+			}
+			continue
+		}
+		if !strings.EqualFold(val, otherVal) {
+			return false
+		}
 	}
 
-	if !uri.Headers.Equals(other.Headers) {
+	headers, otherHeaders := uriParams(uri.Headers), uriParams(other.Headers)
+	if len(headers) != len(otherHeaders) {
 		return false
 	}
+	for name, val := range headers {
+		if otherVal, ok := otherHeaders[name]; !ok || val != otherVal {
+			return false
+		}
+	}
 
 	return true
 }
 
+// URI params which must match if present in either of compared SIP URIs (RFC 3261 - 19.1.4.).
+var sipUriSignificantParams = map[string]bool{
+	"transport": true,
+	"user":      true,
+	"ttl":       true,
+	"method":    true,
+	"maddr":     true,
+}
+
+// uriParams returns URI params or headers with lowercase names and normalized escaping of the values.
+// Params without value are mapped to the empty string.
+func uriParams(params Params) map[string]string {
+	result := make(map[string]string)
+	if params == nil {
+		return result
+	}
+	for _, key := range params.Keys() {
+		val, _ := params.Get(key)
+		result[strings.ToLower(normalizePercentEncoding(key))] = normalizePercentEncoding(maybeString(val))
+	}
+
+	return result
+}
+
+func maybeString(val MaybeString) string {
+	if val == nil {
+		return ""
+	}
+
+	return val.String()
+}
+
 // Generates the string representation of a SipUri struct.
 func (uri *SipUri) String() string {
 	var buffer bytes.Buffer
@@ -543,6 +600,87 @@ func (contact *ContactHeader) Equals(other interface{}) bool {
 	return false
 }
 
+// RouteHeader is 'Route' header with the list of the proxies the request should be routed through (RFC 3261 - 20.34.).
+type RouteHeader struct {
+	Addresses []*Address
+}
+
+func (route *RouteHeader) String() string {
+	return fmt.Sprintf("%s: %s", route.Name(), addressList(route.Addresses))
+}
+
+func (route *RouteHeader) Name() string { return "Route" }
+
+func (route *RouteHeader) Clone() Header {
+	return &RouteHeader{Addresses: cloneAddresses(route.Addresses)}
+}
+
+func (route *RouteHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*RouteHeader); ok {
+		return equalAddresses(route.Addresses, h.Addresses)
+	}
+
+	return false
+}
+
+// RecordRouteHeader is 'Record-Route' header with the list of the proxies
+// that want to stay on the path of the future requests in the dialog (RFC 3261 - 20.30.).
+type RecordRouteHeader struct {
+	Addresses []*Address
+}
+
+func (recordRoute *RecordRouteHeader) String() string {
+	return fmt.Sprintf("%s: %s", recordRoute.Name(), addressList(recordRoute.Addresses))
+}
+
+func (recordRoute *RecordRouteHeader) Name() string { return "Record-Route" }
+
+func (recordRoute *RecordRouteHeader) Clone() Header {
+	return &RecordRouteHeader{Addresses: cloneAddresses(recordRoute.Addresses)}
+}
+
+func (recordRoute *RecordRouteHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*RecordRouteHeader); ok {
+		return equalAddresses(recordRoute.Addresses, h.Addresses)
+	}
+
+	return false
+}
+
+func addressList(addrs []*Address) string {
+	values := make([]string, len(addrs))
+	for i, addr := range addrs {
+		values[i] = addr.String()
+	}
+
+	return strings.Join(values, ", ")
+}
+
+func cloneAddresses(addrs []*Address) []*Address {
+	if addrs == nil {
+		return nil
+	}
+	clone := make([]*Address, len(addrs))
+	for i, addr := range addrs {
+		clone[i] = addr.Clone()
+	}
+
+	return clone
+}
+
+func equalAddresses(addrs1, addrs2 []*Address) bool {
+	if len(addrs1) != len(addrs2) {
+		return false
+	}
+	for i := range addrs1 {
+		if !addrs1[i].Equals(addrs2[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
 // CallID - 'Call-ID' header.
 type CallID string
 
@@ -610,6 +748,59 @@ func (maxForwards MaxForwards) Equals(other interface{}) bool {
 	return false
 }
 
+// RSeq header (RFC 3262 - 7.1.) - sequence number of the reliable provisional response.
+type RSeq uint32
+
+func (rseq RSeq) String() string {
+	return fmt.Sprintf("RSeq: %d", uint32(rseq))
+}
+
+func (rseq RSeq) Name() string { return "RSeq" }
+
+func (rseq RSeq) Clone() Header { return rseq }
+
+func (rseq RSeq) Equals(other interface{}) bool {
+	if h, ok := other.(RSeq); ok {
+		return rseq == h
+	}
+	if h, ok := other.(*RSeq); ok {
+		return rseq == *h
+	}
+
+	return false
+}
+
+// RAck header (RFC 3262 - 7.2.) - acknowledged reliable provisional response in PRACK request.
+type RAck struct {
+	RSeq       uint32
+	CSeq       uint32
+	MethodName RequestMethod
+}
+
+func (rack *RAck) String() string {
+	return fmt.Sprintf("RAck: %d %d %s", rack.RSeq, rack.CSeq, rack.MethodName)
+}
+
+func (rack *RAck) Name() string { return "RAck" }
+
+func (rack *RAck) Clone() Header {
+	return &RAck{
+		RSeq:       rack.RSeq,
+		CSeq:       rack.CSeq,
+		MethodName: rack.MethodName,
+	}
+}
+
+func (rack *RAck) Equals(other interface{}) bool {
+	if h, ok := other.(*RAck); ok {
+		return rack.RSeq == h.RSeq &&
+			rack.CSeq == h.CSeq &&
+			rack.MethodName == h.MethodName
+	}
+
+	return false
+}
+
 type ContentLength uint32
 
 func (contentLength ContentLength) String() string {
@@ -624,6 +815,9 @@ func (contentLength ContentLength) Equals(other interface{}) bool {
 	if h, ok := other.(ContentLength); ok {
 		return contentLength == h
 	}
+	if h, ok := other.(*ContentLength); ok {
+		return contentLength == *h
+	}
 
 	return false
 }
@@ -884,3 +1078,1155 @@ func (unsupported *UnsupportedHeader) Equals(other interface{}) bool {
 
 	return false
 }
+
+// ContentTypeHeader is 'Content-Type' header with the media type of the message body (RFC 3261 - 20.15.).
+type ContentTypeHeader struct {
+	// Type is the top-level media type, e.g. application or multipart.
+	Type string
+	// SubType is the media subtype, e.g. sdp or mixed.
+	SubType string
+	Params  Params
+}
+
+// NewContentTypeHeader creates 'Content-Type' header from the media type, e.g. application/sdp.
+func NewContentTypeHeader(mediaType string) *ContentTypeHeader {
+	header := &ContentTypeHeader{Params: NewParams()}
+	parts := strings.SplitN(mediaType, "/", 2)
+	header.Type = parts[0]
+	if len(parts) == 2 {
+		header.SubType = parts[1]
+	}
+
+	return header
+}
+
+func (contentType *ContentTypeHeader) String() string {
+	return "Content-Type: " + contentType.MediaType() + mimeParams(contentType.Params)
+}
+
+func (contentType *ContentTypeHeader) Name() string { return "Content-Type" }
+
+func (contentType *ContentTypeHeader) Clone() Header {
+	return &ContentTypeHeader{
+		Type:    contentType.Type,
+		SubType: contentType.SubType,
+		Params:  cloneWithNil(contentType.Params),
+	}
+}
+
+func (contentType *ContentTypeHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*ContentTypeHeader); ok {
+		return strings.EqualFold(contentType.Type, h.Type) &&
+			strings.EqualFold(contentType.SubType, h.SubType) &&
+			equalParams(contentType.Params, h.Params)
+	}
+
+	return false
+}
+
+// MediaType returns the media type without params, e.g. application/sdp.
+func (contentType *ContentTypeHeader) MediaType() string {
+	return contentType.Type + "/" + contentType.SubType
+}
+
+// Is returns true if the header has the media type, comparison is case-insensitive.
+func (contentType *ContentTypeHeader) Is(mediaType string) bool {
+	return strings.EqualFold(contentType.MediaType(), mediaType)
+}
+
+// IsMultipart returns true for multipart body (RFC 2046 - 5.1.).
+func (contentType *ContentTypeHeader) IsMultipart() bool {
+	return strings.EqualFold(contentType.Type, "multipart")
+}
+
+// Param returns value of the param, names are case-insensitive (RFC 2045 - 5.1.).
+func (contentType *ContentTypeHeader) Param(name string) (string, bool) {
+	return mimeParam(contentType.Params, name)
+}
+
+// ContentDispositionHeader is 'Content-Disposition' header that describes how the body
+// should be interpreted, e.g. session or render (RFC 3261 - 20.11.).
+type ContentDispositionHeader struct {
+	Disposition string
+	Params      Params
+}
+
+func (disposition *ContentDispositionHeader) String() string {
+	return "Content-Disposition: " + disposition.Disposition + mimeParams(disposition.Params)
+}
+
+func (disposition *ContentDispositionHeader) Name() string { return "Content-Disposition" }
+
+func (disposition *ContentDispositionHeader) Clone() Header {
+	return &ContentDispositionHeader{
+		Disposition: disposition.Disposition,
+		Params:      cloneWithNil(disposition.Params),
+	}
+}
+
+func (disposition *ContentDispositionHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*ContentDispositionHeader); ok {
+		return strings.EqualFold(disposition.Disposition, h.Disposition) &&
+			equalParams(disposition.Params, h.Params)
+	}
+
+	return false
+}
+
+// Handling returns value of 'handling' param, it is "required" by default (RFC 3261 - 20.11.).
+func (disposition *ContentDispositionHeader) Handling() string {
+	if handling, ok := mimeParam(disposition.Params, "handling"); ok {
+		return strings.ToLower(handling)
+	}
+
+	return "required"
+}
+
+// Expires is 'Expires' header with the relative time in seconds after which the message
+// or content expires, e.g. the registration (RFC 3261 - 20.19.).
+type Expires uint32
+
+func (expires Expires) String() string {
+	return fmt.Sprintf("Expires: %d", uint32(expires))
+}
+
+func (expires Expires) Name() string { return "Expires" }
+
+func (expires Expires) Clone() Header { return expires }
+
+func (expires Expires) Equals(other interface{}) bool {
+	if h, ok := other.(Expires); ok {
+		return expires == h
+	}
+	if h, ok := other.(*Expires); ok {
+		return expires == *h
+	}
+
+	return false
+}
+
+// Duration returns the expiration as time.Duration.
+func (expires Expires) Duration() time.Duration {
+	return time.Duration(expires) * time.Second
+}
+
+// MinExpires is 'Min-Expires' header with the minimum refresh interval supported
+// by the registrar or notifier (RFC 3261 - 20.23.).
+type MinExpires uint32
+
+func (minExpires MinExpires) String() string {
+	return fmt.Sprintf("Min-Expires: %d", uint32(minExpires))
+}
+
+func (minExpires MinExpires) Name() string { return "Min-Expires" }
+
+func (minExpires MinExpires) Clone() Header { return minExpires }
+
+func (minExpires MinExpires) Equals(other interface{}) bool {
+	if h, ok := other.(MinExpires); ok {
+		return minExpires == h
+	}
+	if h, ok := other.(*MinExpires); ok {
+		return minExpires == *h
+	}
+
+	return false
+}
+
+// Duration returns the minimum interval as time.Duration.
+func (minExpires MinExpires) Duration() time.Duration {
+	return time.Duration(minExpires) * time.Second
+}
+
+// AllowHeader is 'Allow' header with the methods supported by UA (RFC 3261 - 20.5.).
+type AllowHeader struct {
+	Methods []RequestMethod
+}
+
+func (allow *AllowHeader) String() string {
+	methods := make([]string, len(allow.Methods))
+	for i, method := range allow.Methods {
+		methods[i] = string(method)
+	}
+
+	return fmt.Sprintf("Allow: %s", strings.Join(methods, ", "))
+}
+
+func (allow *AllowHeader) Name() string { return "Allow" }
+
+func (allow *AllowHeader) Clone() Header {
+	dup := make([]RequestMethod, len(allow.Methods))
+	copy(dup, allow.Methods)
+	return &AllowHeader{dup}
+}
+
+func (allow *AllowHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*AllowHeader); ok {
+		if len(allow.Methods) != len(h.Methods) {
+			return false
+		}
+
+		for i, method := range allow.Methods {
+			if method != h.Methods[i] {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// Allows returns true if the method is listed, methods are case-sensitive (RFC 3261 - 7.1.).
+func (allow *AllowHeader) Allows(method RequestMethod) bool {
+	for _, allowed := range allow.Methods {
+		if allowed == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MediaRange is a single media range of 'Accept' header, e.g. application/sdp or text/*.
+type MediaRange struct {
+	Type    string
+	SubType string
+	Params  Params
+}
+
+func (mediaRange *MediaRange) String() string {
+	return mediaRange.Type + "/" + mediaRange.SubType + mimeParams(mediaRange.Params)
+}
+
+func (mediaRange *MediaRange) Clone() *MediaRange {
+	return &MediaRange{
+		Type:    mediaRange.Type,
+		SubType: mediaRange.SubType,
+		Params:  cloneWithNil(mediaRange.Params),
+	}
+}
+
+func (mediaRange *MediaRange) Equals(other interface{}) bool {
+	if h, ok := other.(*MediaRange); ok {
+		return strings.EqualFold(mediaRange.Type, h.Type) &&
+			strings.EqualFold(mediaRange.SubType, h.SubType) &&
+			equalParams(mediaRange.Params, h.Params)
+	}
+
+	return false
+}
+
+// Matches returns true if the media type, e.g. application/sdp, is in the range, wildcards are supported.
+func (mediaRange *MediaRange) Matches(mediaType string) bool {
+	parts := strings.SplitN(mediaType, "/", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	return (mediaRange.Type == "*" || strings.EqualFold(mediaRange.Type, parts[0])) &&
+		(mediaRange.SubType == "*" || strings.EqualFold(mediaRange.SubType, parts[1]))
+}
+
+// AcceptHeader is 'Accept' header with the media types acceptable in the response (RFC 3261 - 20.1.).
+// Empty header means that no body is acceptable.
+type AcceptHeader struct {
+	MediaRanges []*MediaRange
+}
+
+func (accept *AcceptHeader) String() string {
+	ranges := make([]string, len(accept.MediaRanges))
+	for i, mediaRange := range accept.MediaRanges {
+		ranges[i] = mediaRange.String()
+	}
+
+	return fmt.Sprintf("Accept: %s", strings.Join(ranges, ", "))
+}
+
+func (accept *AcceptHeader) Name() string { return "Accept" }
+
+func (accept *AcceptHeader) Clone() Header {
+	dup := make([]*MediaRange, len(accept.MediaRanges))
+	for i, mediaRange := range accept.MediaRanges {
+		dup[i] = mediaRange.Clone()
+	}
+	return &AcceptHeader{dup}
+}
+
+func (accept *AcceptHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*AcceptHeader); ok {
+		if len(accept.MediaRanges) != len(h.MediaRanges) {
+			return false
+		}
+
+		for i, mediaRange := range accept.MediaRanges {
+			if !mediaRange.Equals(h.MediaRanges[i]) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// Accepts returns true if the media type matches any of the media ranges.
+func (accept *AcceptHeader) Accepts(mediaType string) bool {
+	for _, mediaRange := range accept.MediaRanges {
+		if mediaRange.Matches(mediaType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RetryAfterHeader is 'Retry-After' header with the time after which the request
+// can be retried, optional comment and params, e.g. duration (RFC 3261 - 20.33.).
+type RetryAfterHeader struct {
+	Seconds uint32
+	Comment string
+	Params  Params
+}
+
+func (retryAfter *RetryAfterHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("Retry-After: %d", retryAfter.Seconds))
+	if retryAfter.Comment != "" {
+		buffer.WriteString(fmt.Sprintf(" (%s)", retryAfter.Comment))
+	}
+	if retryAfter.Params != nil && retryAfter.Params.Length() > 0 {
+		buffer.WriteString(";" + retryAfter.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+func (retryAfter *RetryAfterHeader) Name() string { return "Retry-After" }
+
+func (retryAfter *RetryAfterHeader) Clone() Header {
+	return &RetryAfterHeader{
+		Seconds: retryAfter.Seconds,
+		Comment: retryAfter.Comment,
+		Params:  cloneWithNil(retryAfter.Params),
+	}
+}
+
+func (retryAfter *RetryAfterHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*RetryAfterHeader); ok {
+		return retryAfter.Seconds == h.Seconds &&
+			retryAfter.Comment == h.Comment &&
+			equalParams(retryAfter.Params, h.Params)
+	}
+
+	return false
+}
+
+// Delay returns the time after which the request can be retried.
+func (retryAfter *RetryAfterHeader) Delay() time.Duration {
+	return time.Duration(retryAfter.Seconds) * time.Second
+}
+
+// TimestampHeader is 'Timestamp' header with the time the request was sent by UAC
+// and the delay of UAS before the response (RFC 3261 - 20.38., 8.2.6.1.).
+type TimestampHeader struct {
+	Timestamp float64
+	// Delay is added by UAS to the copied timestamp, it is omitted if zero.
+	Delay float64
+}
+
+func (timestamp *TimestampHeader) String() string {
+	value := strconv.FormatFloat(timestamp.Timestamp, 'f', -1, 64)
+	if timestamp.Delay > 0 {
+		value += " " + strconv.FormatFloat(timestamp.Delay, 'f', -1, 64)
+	}
+
+	return "Timestamp: " + value
+}
+
+func (timestamp *TimestampHeader) Name() string { return "Timestamp" }
+
+func (timestamp *TimestampHeader) Clone() Header {
+	return &TimestampHeader{Timestamp: timestamp.Timestamp, Delay: timestamp.Delay}
+}
+
+func (timestamp *TimestampHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*TimestampHeader); ok {
+		return timestamp.Timestamp == h.Timestamp && timestamp.Delay == h.Delay
+	}
+
+	return false
+}
+
+// DateHeader is 'Date' header with the date and time in GMT (RFC 3261 - 20.17.).
+type DateHeader struct {
+	Time time.Time
+}
+
+// DateFormat is the format of 'Date' header, only GMT zone is allowed (RFC 3261 - 20.17.).
+const DateFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+func (date *DateHeader) String() string {
+	return "Date: " + date.Time.UTC().Format(DateFormat)
+}
+
+func (date *DateHeader) Name() string { return "Date" }
+
+func (date *DateHeader) Clone() Header {
+	return &DateHeader{Time: date.Time}
+}
+
+func (date *DateHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*DateHeader); ok {
+		return date.Time.Equal(h.Time)
+	}
+
+	return false
+}
+
+// Warning is a single value of 'Warning' header (RFC 3261 - 20.43.).
+type Warning struct {
+	// Code is 3-digit warning code, e.g. 305 - Incompatible media format.
+	Code uint16
+	// Agent is host of the server that added the warning or pseudonym.
+	Agent string
+	Text  string
+}
+
+func (warning *Warning) String() string {
+	text := strings.Replace(warning.Text, `\`, `\\`, -1)
+	text = strings.Replace(text, `"`, `\"`, -1)
+
+	return fmt.Sprintf("%03d %s \"%s\"", warning.Code, warning.Agent, text)
+}
+
+func (warning *Warning) Equals(other interface{}) bool {
+	if h, ok := other.(*Warning); ok {
+		return warning.Code == h.Code &&
+			warning.Agent == h.Agent &&
+			warning.Text == h.Text
+	}
+
+	return false
+}
+
+// WarningHeader is 'Warning' header with the additional information about the status of the response.
+type WarningHeader struct {
+	Warnings []*Warning
+}
+
+func (header *WarningHeader) String() string {
+	warnings := make([]string, len(header.Warnings))
+	for i, warning := range header.Warnings {
+		warnings[i] = warning.String()
+	}
+
+	return fmt.Sprintf("Warning: %s", strings.Join(warnings, ", "))
+}
+
+func (header *WarningHeader) Name() string { return "Warning" }
+
+func (header *WarningHeader) Clone() Header {
+	dup := make([]*Warning, len(header.Warnings))
+	for i, warning := range header.Warnings {
+		clone := *warning
+		dup[i] = &clone
+	}
+	return &WarningHeader{dup}
+}
+
+func (header *WarningHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*WarningHeader); ok {
+		if len(header.Warnings) != len(h.Warnings) {
+			return false
+		}
+
+		for i, warning := range header.Warnings {
+			if !warning.Equals(h.Warnings[i]) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// EventHeader identifies the event package and the subscription (RFC 6665 - 8.2.1.),
+// e.g. 'Event: presence' or 'Event: refer;id=93809824'.
+type EventHeader struct {
+	// EventType is the event package name with optional template, e.g. 'presence.winfo'.
+	EventType string
+	Params    Params
+}
+
+func (event *EventHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("Event: " + event.EventType)
+	if event.Params != nil && event.Params.Length() > 0 {
+		buffer.WriteString(";")
+		buffer.WriteString(event.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+func (event *EventHeader) Name() string { return "Event" }
+
+func (event *EventHeader) Clone() Header {
+	return &EventHeader{
+		EventType: event.EventType,
+		Params:    cloneWithNil(event.Params),
+	}
+}
+
+func (event *EventHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*EventHeader); ok {
+		return event.EventType == h.EventType && equalParams(event.Params, h.Params)
+	}
+
+	return false
+}
+
+// ID returns 'id' param which distinguishes subscriptions of the same event package in one dialog.
+func (event *EventHeader) ID() string {
+	if event.Params == nil {
+		return ""
+	}
+	if id, ok := event.Params.Get("id"); ok && id != nil {
+		return id.String()
+	}
+
+	return ""
+}
+
+// Matches returns true if both headers belong to the same subscription,
+// i.e. event types and 'id' params are equal (RFC 6665 - 8.2.1.).
+func (event *EventHeader) Matches(other *EventHeader) bool {
+	return other != nil && event.EventType == other.EventType && event.ID() == other.ID()
+}
+
+// AllowEventsHeader lists event packages supported by the UA (RFC 6665 - 8.2.2.).
+type AllowEventsHeader struct {
+	EventTypes []string
+}
+
+func (allowEvents *AllowEventsHeader) String() string {
+	return fmt.Sprintf("Allow-Events: %s", strings.Join(allowEvents.EventTypes, ", "))
+}
+
+func (allowEvents *AllowEventsHeader) Name() string { return "Allow-Events" }
+
+func (allowEvents *AllowEventsHeader) Clone() Header {
+	dup := make([]string, len(allowEvents.EventTypes))
+	copy(dup, allowEvents.EventTypes)
+	return &AllowEventsHeader{dup}
+}
+
+func (allowEvents *AllowEventsHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*AllowEventsHeader); ok {
+		if len(allowEvents.EventTypes) != len(h.EventTypes) {
+			return false
+		}
+
+		for i, eventType := range allowEvents.EventTypes {
+			if eventType != h.EventTypes[i] {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// Allows returns true if the event package is listed.
+func (allowEvents *AllowEventsHeader) Allows(eventType string) bool {
+	for _, allowed := range allowEvents.EventTypes {
+		if allowed == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SubscriptionState is the state of the subscription in 'Subscription-State' header.
+type SubscriptionState string
+
+const (
+	SubscriptionActive     SubscriptionState = "active"
+	SubscriptionPending    SubscriptionState = "pending"
+	SubscriptionTerminated SubscriptionState = "terminated"
+)
+
+// SubscriptionStateHeader is the state of the subscription sent in NOTIFY requests (RFC 6665 - 8.2.3.),
+// e.g. 'Subscription-State: active;expires=600' or 'Subscription-State: terminated;reason=timeout'.
+type SubscriptionStateHeader struct {
+	State SubscriptionState
+	// Params are 'expires', 'reason', 'retry-after' and extension params.
+	Params Params
+}
+
+func (subState *SubscriptionStateHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("Subscription-State: " + string(subState.State))
+	if subState.Params != nil && subState.Params.Length() > 0 {
+		buffer.WriteString(";")
+		buffer.WriteString(subState.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+func (subState *SubscriptionStateHeader) Name() string { return "Subscription-State" }
+
+func (subState *SubscriptionStateHeader) Clone() Header {
+	return &SubscriptionStateHeader{
+		State:  subState.State,
+		Params: cloneWithNil(subState.Params),
+	}
+}
+
+func (subState *SubscriptionStateHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*SubscriptionStateHeader); ok {
+		return strings.EqualFold(string(subState.State), string(h.State)) &&
+			equalParams(subState.Params, h.Params)
+	}
+
+	return false
+}
+
+// Expires returns remaining duration of the subscription from 'expires' param.
+func (subState *SubscriptionStateHeader) Expires() (time.Duration, bool) {
+	return subState.seconds("expires")
+}
+
+// RetryAfter returns delay before the subscriber may re-subscribe from 'retry-after' param.
+func (subState *SubscriptionStateHeader) RetryAfter() (time.Duration, bool) {
+	return subState.seconds("retry-after")
+}
+
+// Reason returns reason of the terminated subscription, e.g. 'timeout', 'rejected' or 'noresource'.
+func (subState *SubscriptionStateHeader) Reason() string {
+	if subState.Params == nil {
+		return ""
+	}
+	if reason, ok := subState.Params.Get("reason"); ok && reason != nil {
+		return reason.String()
+	}
+
+	return ""
+}
+
+func (subState *SubscriptionStateHeader) seconds(name string) (time.Duration, bool) {
+	if subState.Params == nil {
+		return 0, false
+	}
+	val, ok := subState.Params.Get(name)
+	if !ok || val == nil {
+		return 0, false
+	}
+	seconds, err := strconv.ParseUint(val.String(), 10, 32)
+	if err != nil {
+		return 0, false
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
+
+// ReferToHeader is the target of the request referred by REFER (RFC 3515 - 2.1.),
+// e.g. URI with embedded 'Replaces' header for attended transfer.
+type ReferToHeader struct {
+	// The display name from the header, may be omitted.
+	DisplayName MaybeString
+	Address     Uri
+	// Any parameters present in the header.
+	Params Params
+}
+
+func (referTo *ReferToHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("Refer-To: ")
+
+	if displayName, ok := referTo.DisplayName.(String); ok && displayName.String() != "" {
+		buffer.WriteString(fmt.Sprintf("\"%s\" ", displayName))
+	}
+
+	// brackets are required for URI with headers
+	buffer.WriteString(fmt.Sprintf("<%s>", referTo.Address))
+
+	if referTo.Params != nil && referTo.Params.Length() > 0 {
+		buffer.WriteString(";")
+		buffer.WriteString(referTo.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+func (referTo *ReferToHeader) Name() string { return "Refer-To" }
+
+func (referTo *ReferToHeader) Clone() Header {
+	return &ReferToHeader{
+		DisplayName: referTo.DisplayName,
+		Address:     referTo.Address.Clone(),
+		Params:      cloneWithNil(referTo.Params),
+	}
+}
+
+func (referTo *ReferToHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*ReferToHeader); ok {
+		return equalMaybeStrings(referTo.DisplayName, h.DisplayName) &&
+			referTo.Address.Equals(h.Address) &&
+			equalParams(referTo.Params, h.Params)
+	}
+
+	return false
+}
+
+// ReferredByHeader identifies the referrer to the target of the referred request (RFC 3892).
+type ReferredByHeader struct {
+	// The display name from the header, may be omitted.
+	DisplayName MaybeString
+	Address     Uri
+	// Any parameters present in the header, e.g. 'cid' of the referrer token.
+	Params Params
+}
+
+func (referredBy *ReferredByHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("Referred-By: ")
+
+	if displayName, ok := referredBy.DisplayName.(String); ok && displayName.String() != "" {
+		buffer.WriteString(fmt.Sprintf("\"%s\" ", displayName))
+	}
+
+	buffer.WriteString(fmt.Sprintf("<%s>", referredBy.Address))
+
+	if referredBy.Params != nil && referredBy.Params.Length() > 0 {
+		buffer.WriteString(";")
+		buffer.WriteString(referredBy.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+func (referredBy *ReferredByHeader) Name() string { return "Referred-By" }
+
+func (referredBy *ReferredByHeader) Clone() Header {
+	return &ReferredByHeader{
+		DisplayName: referredBy.DisplayName,
+		Address:     referredBy.Address.Clone(),
+		Params:      cloneWithNil(referredBy.Params),
+	}
+}
+
+func (referredBy *ReferredByHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*ReferredByHeader); ok {
+		return equalMaybeStrings(referredBy.DisplayName, h.DisplayName) &&
+			referredBy.Address.Equals(h.Address) &&
+			equalParams(referredBy.Params, h.Params)
+	}
+
+	return false
+}
+
+// ReplacesHeader identifies the dialog replaced by INVITE (RFC 3891 - 6.1.),
+// e.g. the consultation call of attended transfer.
+type ReplacesHeader struct {
+	CallID  string
+	ToTag   string
+	FromTag string
+	// EarlyOnly restricts replacing to the early dialog.
+	EarlyOnly bool
+	// Params are the other params of the header.
+	Params Params
+}
+
+func (replaces *ReplacesHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("Replaces: %s;to-tag=%s;from-tag=%s", replaces.CallID, replaces.ToTag, replaces.FromTag))
+	if replaces.EarlyOnly {
+		buffer.WriteString(";early-only")
+	}
+	if replaces.Params != nil && replaces.Params.Length() > 0 {
+		buffer.WriteString(";")
+		buffer.WriteString(replaces.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+func (replaces *ReplacesHeader) Name() string { return "Replaces" }
+
+func (replaces *ReplacesHeader) Clone() Header {
+	return &ReplacesHeader{
+		CallID:    replaces.CallID,
+		ToTag:     replaces.ToTag,
+		FromTag:   replaces.FromTag,
+		EarlyOnly: replaces.EarlyOnly,
+		Params:    cloneWithNil(replaces.Params),
+	}
+}
+
+func (replaces *ReplacesHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*ReplacesHeader); ok {
+		return replaces.CallID == h.CallID &&
+			replaces.ToTag == h.ToTag &&
+			replaces.FromTag == h.FromTag &&
+			replaces.EarlyOnly == h.EarlyOnly &&
+			equalParams(replaces.Params, h.Params)
+	}
+
+	return false
+}
+
+// DialogID returns ID of the replaced dialog on the recipient of INVITE,
+// i.e. 'to-tag' is the local tag and 'from-tag' is the remote one.
+func (replaces *ReplacesHeader) DialogID() string {
+	return MakeDialogID(replaces.CallID, replaces.ToTag, replaces.FromTag)
+}
+
+// ReferSub is 'Refer-Sub' header, false value suppresses the implicit subscription of REFER (RFC 4488 - 4.).
+type ReferSub bool
+
+func (referSub ReferSub) String() string {
+	return fmt.Sprintf("Refer-Sub: %t", bool(referSub))
+}
+
+func (referSub ReferSub) Name() string { return "Refer-Sub" }
+
+func (referSub ReferSub) Clone() Header { return referSub }
+
+func (referSub ReferSub) Equals(other interface{}) bool {
+	if h, ok := other.(ReferSub); ok {
+		return referSub == h
+	}
+	if h, ok := other.(*ReferSub); ok {
+		return referSub == *h
+	}
+
+	return false
+}
+
+// mimeTSpecials are the chars that require quoted value of MIME param (RFC 2045 - 5.1.).
+const mimeTSpecials = "()<>@,;:\\\"/[]?= \t"
+
+// mimeParams renders params of MIME header prefixed with ';', values with special chars are quoted.
+func mimeParams(params Params) string {
+	if params == nil {
+		return ""
+	}
+
+	var buffer bytes.Buffer
+	for _, key := range params.Keys() {
+		buffer.WriteString(";" + key)
+		val, _ := params.Get(key)
+		if val, ok := val.(String); ok {
+			if val.Str == "" || strings.ContainsAny(val.Str, mimeTSpecials) {
+				buffer.WriteString(fmt.Sprintf("=\"%s\"", val.Str))
+			} else {
+				buffer.WriteString("=" + val.Str)
+			}
+		}
+	}
+
+	return buffer.String()
+}
+
+func mimeParam(params Params, name string) (string, bool) {
+	if params == nil {
+		return "", false
+	}
+	for _, key := range params.Keys() {
+		if strings.EqualFold(key, name) {
+			val, _ := params.Get(key)
+			if val == nil {
+				return "", true
+			}
+			return val.String(), true
+		}
+	}
+
+	return "", false
+}
+
+func equalParams(params1, params2 Params) bool {
+	if params1 == nil || params2 == nil {
+		return (params1 == nil || params1.Length() == 0) && (params2 == nil || params2.Length() == 0)
+	}
+
+	return params1.Equals(params2)
+}
+
+// Challenge is a value of 'WWW-Authenticate' and 'Proxy-Authenticate' headers (RFC 3261 - 22.4., RFC 2617 - 3.2.1.).
+type Challenge struct {
+	Scheme    string
+	Realm     string
+	Domain    string
+	Nonce     string
+	Opaque    string
+	Stale     bool
+	Algorithm string
+	Qop       []string
+	// Any other auth params, values are unquoted.
+	Params Params
+}
+
+func (challenge *Challenge) String() string {
+	params := make([]string, 0)
+	params = appendAuthParam(params, "realm", challenge.Realm, true)
+	params = appendAuthParam(params, "domain", challenge.Domain, true)
+	params = appendAuthParam(params, "nonce", challenge.Nonce, true)
+	params = appendAuthParam(params, "opaque", challenge.Opaque, true)
+	if challenge.Stale {
+		params = appendAuthParam(params, "stale", "TRUE", false)
+	}
+	params = appendAuthParam(params, "algorithm", challenge.Algorithm, false)
+	params = appendAuthParam(params, "qop", strings.Join(challenge.Qop, ","), true)
+	params = appendOtherAuthParams(params, challenge.Params)
+
+	return authValue(challenge.Scheme, params)
+}
+
+func (challenge *Challenge) Clone() *Challenge {
+	dup := *challenge
+	if challenge.Qop != nil {
+		dup.Qop = make([]string, len(challenge.Qop))
+		copy(dup.Qop, challenge.Qop)
+	}
+	if challenge.Params != nil {
+		dup.Params = challenge.Params.Clone()
+	}
+
+	return &dup
+}
+
+func (challenge *Challenge) Equals(other interface{}) bool {
+	if c, ok := other.(*Challenge); ok {
+		return challenge.String() == c.String()
+	}
+
+	return false
+}
+
+// HasQop returns true if the challenge offers the quality of protection.
+func (challenge *Challenge) HasQop(qop string) bool {
+	for _, q := range challenge.Qop {
+		if strings.EqualFold(strings.TrimSpace(q), qop) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Credentials is a value of 'Authorization' and 'Proxy-Authorization' headers (RFC 3261 - 22.4., RFC 2617 - 3.2.2.).
+type Credentials struct {
+	Scheme     string
+	Username   string
+	Realm      string
+	Nonce      string
+	Uri        string
+	Response   string
+	Algorithm  string
+	Cnonce     string
+	Opaque     string
+	Qop        string
+	NonceCount uint32
+	// Any other auth params, values are unquoted.
+	Params Params
+}
+
+func (credentials *Credentials) String() string {
+	params := make([]string, 0)
+	params = appendAuthParam(params, "username", credentials.Username, true)
+	params = appendAuthParam(params, "realm", credentials.Realm, true)
+	params = appendAuthParam(params, "nonce", credentials.Nonce, true)
+	params = appendAuthParam(params, "uri", credentials.Uri, true)
+	params = appendAuthParam(params, "response", credentials.Response, true)
+	params = appendAuthParam(params, "algorithm", credentials.Algorithm, false)
+	params = appendAuthParam(params, "cnonce", credentials.Cnonce, true)
+	params = appendAuthParam(params, "opaque", credentials.Opaque, true)
+	params = appendAuthParam(params, "qop", credentials.Qop, false)
+	if credentials.NonceCount > 0 {
+		params = appendAuthParam(params, "nc", fmt.Sprintf("%08x", credentials.NonceCount), false)
+	}
+	params = appendOtherAuthParams(params, credentials.Params)
+
+	return authValue(credentials.Scheme, params)
+}
+
+func (credentials *Credentials) Clone() *Credentials {
+	dup := *credentials
+	if credentials.Params != nil {
+		dup.Params = credentials.Params.Clone()
+	}
+
+	return &dup
+}
+
+func (credentials *Credentials) Equals(other interface{}) bool {
+	if c, ok := other.(*Credentials); ok {
+		return credentials.String() == c.String()
+	}
+
+	return false
+}
+
+func appendAuthParam(params []string, name string, value string, quoted bool) []string {
+	if value == "" {
+		return params
+	}
+	if quoted {
+		value = "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(value) + "\""
+	}
+
+	return append(params, name+"="+value)
+}
+
+func appendOtherAuthParams(params []string, other Params) []string {
+	if other == nil {
+		return params
+	}
+	for _, key := range other.Keys() {
+		if val, ok := other.Get(key); ok && val != nil {
+			params = appendAuthParam(params, key, val.String(), true)
+		} else {
+			params = append(params, key)
+		}
+	}
+
+	return params
+}
+
+func authValue(scheme string, params []string) string {
+	if scheme == "" {
+		scheme = "Digest"
+	}
+
+	return scheme + " " + strings.Join(params, ", ")
+}
+
+// WWWAuthenticateHeader is 'WWW-Authenticate' header with the challenge of UAS or registrar (RFC 3261 - 20.44.).
+type WWWAuthenticateHeader struct {
+	Challenge
+}
+
+func (header *WWWAuthenticateHeader) String() string {
+	return fmt.Sprintf("%s: %s", header.Name(), header.Challenge.String())
+}
+
+func (header *WWWAuthenticateHeader) Name() string { return "WWW-Authenticate" }
+
+func (header *WWWAuthenticateHeader) Clone() Header {
+	return &WWWAuthenticateHeader{*header.Challenge.Clone()}
+}
+
+func (header *WWWAuthenticateHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*WWWAuthenticateHeader); ok {
+		return header.Challenge.Equals(&h.Challenge)
+	}
+
+	return false
+}
+
+// ProxyAuthenticateHeader is 'Proxy-Authenticate' header with the challenge of proxy (RFC 3261 - 20.27.).
+type ProxyAuthenticateHeader struct {
+	Challenge
+}
+
+func (header *ProxyAuthenticateHeader) String() string {
+	return fmt.Sprintf("%s: %s", header.Name(), header.Challenge.String())
+}
+
+func (header *ProxyAuthenticateHeader) Name() string { return "Proxy-Authenticate" }
+
+func (header *ProxyAuthenticateHeader) Clone() Header {
+	return &ProxyAuthenticateHeader{*header.Challenge.Clone()}
+}
+
+func (header *ProxyAuthenticateHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*ProxyAuthenticateHeader); ok {
+		return header.Challenge.Equals(&h.Challenge)
+	}
+
+	return false
+}
+
+// AuthorizationHeader is 'Authorization' header with the credentials of UA (RFC 3261 - 20.7.).
+type AuthorizationHeader struct {
+	Credentials
+}
+
+func (header *AuthorizationHeader) String() string {
+	return fmt.Sprintf("%s: %s", header.Name(), header.Credentials.String())
+}
+
+func (header *AuthorizationHeader) Name() string { return "Authorization" }
+
+func (header *AuthorizationHeader) Clone() Header {
+	return &AuthorizationHeader{*header.Credentials.Clone()}
+}
+
+func (header *AuthorizationHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*AuthorizationHeader); ok {
+		return header.Credentials.Equals(&h.Credentials)
+	}
+
+	return false
+}
+
+// ProxyAuthorizationHeader is 'Proxy-Authorization' header with the credentials of UA for proxy (RFC 3261 - 20.28.).
+type ProxyAuthorizationHeader struct {
+	Credentials
+}
+
+func (header *ProxyAuthorizationHeader) String() string {
+	return fmt.Sprintf("%s: %s", header.Name(), header.Credentials.String())
+}
+
+func (header *ProxyAuthorizationHeader) Name() string { return "Proxy-Authorization" }
+
+func (header *ProxyAuthorizationHeader) Clone() Header {
+	return &ProxyAuthorizationHeader{*header.Credentials.Clone()}
+}
+
+func (header *ProxyAuthorizationHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*ProxyAuthorizationHeader); ok {
+		return header.Credentials.Equals(&h.Credentials)
+	}
+
+	return false
+}
+
+// HasOptionTag returns true if the option tag is listed in the message headers
+// with the given name, e.g. Require or Supported.
+func HasOptionTag(msg Message, headerName string, tag string) bool {
+	for _, header := range msg.GetHeaders(headerName) {
+		var options []string
+		switch header := header.(type) {
+		case *RequireHeader:
+			options = header.Options
+		case *SupportedHeader:
+			options = header.Options
+		case *ProxyRequireHeader:
+			options = header.Options
+		case *UnsupportedHeader:
+			options = header.Options
+		case *GenericHeader:
+			options = strings.Split(header.Contents, ",")
+		}
+		for _, option := range options {
+			if strings.EqualFold(strings.TrimSpace(option), tag) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -3,6 +3,7 @@ package sip
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/masterclock/gosip/log"
@@ -39,6 +40,7 @@ const (
 	SUBSCRIBE RequestMethod = "SUBSCRIBE"
 	NOTIFY    RequestMethod = "NOTIFY"
 	REFER     RequestMethod = "REFER"
+	PRACK     RequestMethod = "PRACK"
 )
 
 // Message introduces common SIP message RFC 3261 - 7.
@@ -49,6 +51,8 @@ type Message interface {
 	StartLine() string
 	// String returns string representation of SIP message in RFC 3261 form.
 	String() string
+	// WriteTo writes SIP message in RFC 3261 form, body is written as is without conversion to string.
+	WriteTo(w io.Writer) (int64, error)
 	// Short returns short string info about message.
 	Short() string
 	// SipVersion returns SIP protocol version.
@@ -72,6 +76,11 @@ type Message interface {
 	Body() string
 	// SetBody sets message body.
 	SetBody(body string, setContentLength bool)
+	// BodyBytes returns message body, e.g. binary ISUP or S/MIME body.
+	// Returned slice is shared with the message and must not be modified.
+	BodyBytes() []byte
+	// SetBodyBytes sets message body without conversion, the slice must not be modified after the call.
+	SetBodyBytes(body []byte, setContentLength bool)
 
 	/* Helper getters for common headers */
 	// CallID returns 'Call-ID' header.
@@ -86,8 +95,52 @@ type Message interface {
 	To() (*ToHeader, bool)
 	// CSeq returns 'CSeq' header field.
 	CSeq() (*CSeq, bool)
+	// MaxForwards returns 'Max-Forwards' header field.
+	MaxForwards() (*MaxForwards, bool)
 	ContentLength() (*ContentLength, bool)
 	Contact() (*ContactHeader, bool)
+	// Route returns the top 'Route' header field.
+	Route() (*RouteHeader, bool)
+	// RecordRoute returns the top 'Record-Route' header field.
+	RecordRoute() (*RecordRouteHeader, bool)
+	// RSeq returns 'RSeq' header field of the reliable provisional response.
+	RSeq() (*RSeq, bool)
+	// RAck returns 'RAck' header field of the PRACK request.
+	RAck() (*RAck, bool)
+	// ContentType returns 'Content-Type' header field.
+	ContentType() (*ContentTypeHeader, bool)
+	// ContentDisposition returns 'Content-Disposition' header field.
+	ContentDisposition() (*ContentDispositionHeader, bool)
+	// Expires returns 'Expires' header field.
+	Expires() (*Expires, bool)
+	// MinExpires returns 'Min-Expires' header field.
+	MinExpires() (*MinExpires, bool)
+	// Allow returns the first 'Allow' header field.
+	Allow() (*AllowHeader, bool)
+	// Accept returns the first 'Accept' header field.
+	Accept() (*AcceptHeader, bool)
+	// RetryAfter returns 'Retry-After' header field.
+	RetryAfter() (*RetryAfterHeader, bool)
+	// Timestamp returns 'Timestamp' header field.
+	Timestamp() (*TimestampHeader, bool)
+	// Date returns 'Date' header field.
+	Date() (*DateHeader, bool)
+	// Warning returns the first 'Warning' header field.
+	Warning() (*WarningHeader, bool)
+	// Event returns the first 'Event' header field.
+	Event() (*EventHeader, bool)
+	// AllowEvents returns the first 'Allow-Events' header field.
+	AllowEvents() (*AllowEventsHeader, bool)
+	// SubscriptionState returns the first 'Subscription-State' header field.
+	SubscriptionState() (*SubscriptionStateHeader, bool)
+	// ReferTo returns 'Refer-To' header field.
+	ReferTo() (*ReferToHeader, bool)
+	// ReferredBy returns 'Referred-By' header field.
+	ReferredBy() (*ReferredByHeader, bool)
+	// Replaces returns 'Replaces' header field.
+	Replaces() (*ReplacesHeader, bool)
+	// ReferSub returns 'Refer-Sub' header field.
+	ReferSub() (*ReferSub, bool)
 
 	Transport() string
 	Source() string
@@ -317,16 +370,34 @@ func (hs *headers) CSeq() (*CSeq, bool) {
 	return cseq, true
 }
 
+func (hs *headers) MaxForwards() (*MaxForwards, bool) {
+	hdrs := hs.GetHeaders("Max-Forwards")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	switch maxForwards := hdrs[0].(type) {
+	case *MaxForwards:
+		return maxForwards, true
+	case MaxForwards:
+		return &maxForwards, true
+	default:
+		return nil, false
+	}
+}
+
 func (hs *headers) ContentLength() (*ContentLength, bool) {
 	hdrs := hs.GetHeaders("Content-Length")
 	if len(hdrs) == 0 {
 		return nil, false
 	}
-	contentLength, ok := hdrs[0].(*ContentLength)
-	if !ok {
+	switch contentLength := hdrs[0].(type) {
+	case *ContentLength:
+		return contentLength, true
+	case ContentLength:
+		return &contentLength, true
+	default:
 		return nil, false
 	}
-	return contentLength, true
 }
 
 func (hs *headers) Contact() (*ContactHeader, bool) {
@@ -341,12 +412,276 @@ func (hs *headers) Contact() (*ContactHeader, bool) {
 	return contactHeader, true
 }
 
+func (hs *headers) Route() (*RouteHeader, bool) {
+	hdrs := hs.GetHeaders("Route")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	route, ok := hdrs[0].(*RouteHeader)
+	if !ok {
+		return nil, false
+	}
+	return route, true
+}
+
+func (hs *headers) RecordRoute() (*RecordRouteHeader, bool) {
+	hdrs := hs.GetHeaders("Record-Route")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	recordRoute, ok := hdrs[0].(*RecordRouteHeader)
+	if !ok {
+		return nil, false
+	}
+	return recordRoute, true
+}
+
+func (hs *headers) RSeq() (*RSeq, bool) {
+	hdrs := hs.GetHeaders("RSeq")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	switch rseq := hdrs[0].(type) {
+	case *RSeq:
+		return rseq, true
+	case RSeq:
+		return &rseq, true
+	default:
+		return nil, false
+	}
+}
+
+func (hs *headers) RAck() (*RAck, bool) {
+	hdrs := hs.GetHeaders("RAck")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	rack, ok := hdrs[0].(*RAck)
+	if !ok {
+		return nil, false
+	}
+	return rack, true
+}
+
+func (hs *headers) ContentType() (*ContentTypeHeader, bool) {
+	hdrs := hs.GetHeaders("Content-Type")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	contentType, ok := hdrs[0].(*ContentTypeHeader)
+	if !ok {
+		return nil, false
+	}
+	return contentType, true
+}
+
+func (hs *headers) ContentDisposition() (*ContentDispositionHeader, bool) {
+	hdrs := hs.GetHeaders("Content-Disposition")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	disposition, ok := hdrs[0].(*ContentDispositionHeader)
+	if !ok {
+		return nil, false
+	}
+	return disposition, true
+}
+
+func (hs *headers) Expires() (*Expires, bool) {
+	hdrs := hs.GetHeaders("Expires")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	switch expires := hdrs[0].(type) {
+	case *Expires:
+		return expires, true
+	case Expires:
+		return &expires, true
+	default:
+		return nil, false
+	}
+}
+
+func (hs *headers) MinExpires() (*MinExpires, bool) {
+	hdrs := hs.GetHeaders("Min-Expires")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	switch minExpires := hdrs[0].(type) {
+	case *MinExpires:
+		return minExpires, true
+	case MinExpires:
+		return &minExpires, true
+	default:
+		return nil, false
+	}
+}
+
+func (hs *headers) Allow() (*AllowHeader, bool) {
+	hdrs := hs.GetHeaders("Allow")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	allow, ok := hdrs[0].(*AllowHeader)
+	if !ok {
+		return nil, false
+	}
+	return allow, true
+}
+
+func (hs *headers) Accept() (*AcceptHeader, bool) {
+	hdrs := hs.GetHeaders("Accept")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	accept, ok := hdrs[0].(*AcceptHeader)
+	if !ok {
+		return nil, false
+	}
+	return accept, true
+}
+
+func (hs *headers) RetryAfter() (*RetryAfterHeader, bool) {
+	hdrs := hs.GetHeaders("Retry-After")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	retryAfter, ok := hdrs[0].(*RetryAfterHeader)
+	if !ok {
+		return nil, false
+	}
+	return retryAfter, true
+}
+
+func (hs *headers) Timestamp() (*TimestampHeader, bool) {
+	hdrs := hs.GetHeaders("Timestamp")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	timestamp, ok := hdrs[0].(*TimestampHeader)
+	if !ok {
+		return nil, false
+	}
+	return timestamp, true
+}
+
+func (hs *headers) Date() (*DateHeader, bool) {
+	hdrs := hs.GetHeaders("Date")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	date, ok := hdrs[0].(*DateHeader)
+	if !ok {
+		return nil, false
+	}
+	return date, true
+}
+
+func (hs *headers) Warning() (*WarningHeader, bool) {
+	hdrs := hs.GetHeaders("Warning")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	warning, ok := hdrs[0].(*WarningHeader)
+	if !ok {
+		return nil, false
+	}
+	return warning, true
+}
+
+func (hs *headers) Event() (*EventHeader, bool) {
+	hdrs := hs.GetHeaders("Event")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	event, ok := hdrs[0].(*EventHeader)
+	if !ok {
+		return nil, false
+	}
+	return event, true
+}
+
+func (hs *headers) AllowEvents() (*AllowEventsHeader, bool) {
+	hdrs := hs.GetHeaders("Allow-Events")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	allowEvents, ok := hdrs[0].(*AllowEventsHeader)
+	if !ok {
+		return nil, false
+	}
+	return allowEvents, true
+}
+
+func (hs *headers) SubscriptionState() (*SubscriptionStateHeader, bool) {
+	hdrs := hs.GetHeaders("Subscription-State")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	subState, ok := hdrs[0].(*SubscriptionStateHeader)
+	if !ok {
+		return nil, false
+	}
+	return subState, true
+}
+
+func (hs *headers) ReferTo() (*ReferToHeader, bool) {
+	hdrs := hs.GetHeaders("Refer-To")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	referTo, ok := hdrs[0].(*ReferToHeader)
+	if !ok {
+		return nil, false
+	}
+	return referTo, true
+}
+
+func (hs *headers) ReferredBy() (*ReferredByHeader, bool) {
+	hdrs := hs.GetHeaders("Referred-By")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	referredBy, ok := hdrs[0].(*ReferredByHeader)
+	if !ok {
+		return nil, false
+	}
+	return referredBy, true
+}
+
+func (hs *headers) Replaces() (*ReplacesHeader, bool) {
+	hdrs := hs.GetHeaders("Replaces")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	replaces, ok := hdrs[0].(*ReplacesHeader)
+	if !ok {
+		return nil, false
+	}
+	return replaces, true
+}
+
+func (hs *headers) ReferSub() (*ReferSub, bool) {
+	hdrs := hs.GetHeaders("Refer-Sub")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	switch referSub := hdrs[0].(type) {
+	case *ReferSub:
+		return referSub, true
+	case ReferSub:
+		return &referSub, true
+	default:
+		return nil, false
+	}
+}
+
 // basic message implementation
 type message struct {
 	// message headers
 	*headers
 	sipVersion string
-	body       string
+	body       []byte
 	logger     log.LocalLogger
 	startLine  func() string
 	src        string
@@ -386,15 +721,28 @@ func (msg *message) Short() string {
 
 func (msg *message) String() string {
 	var buffer bytes.Buffer
+	msg.WriteTo(&buffer)
+
+	return buffer.String()
+}
+
+func (msg *message) WriteTo(w io.Writer) (int64, error) {
+	var buffer bytes.Buffer
 
 	// write message start line
 	buffer.WriteString(msg.StartLine() + "\r\n")
 	// Write the headers.
 	buffer.WriteString(msg.headers.String())
+	buffer.WriteString("\r\n")
+
+	n, err := buffer.WriteTo(w)
+	if err != nil {
+		return n, err
+	}
 	// message body
-	buffer.WriteString("\r\n" + msg.Body())
+	m, err := w.Write(msg.body)
 
-	return buffer.String()
+	return n + int64(m), err
 }
 
 func (msg *message) SipVersion() string {
@@ -406,19 +754,28 @@ func (msg *message) SetSipVersion(version string) {
 }
 
 func (msg *message) Body() string {
-	return msg.body
+	return string(msg.body)
 }
 
 // SetBody sets message body, calculates it length and add 'Content-Length' header.
 func (msg *message) SetBody(body string, setContentLength bool) {
+	msg.SetBodyBytes([]byte(body), setContentLength)
+}
+
+func (msg *message) BodyBytes() []byte {
+	return msg.body
+}
+
+// SetBodyBytes sets message body, calculates it length in bytes and add 'Content-Length' header.
+func (msg *message) SetBodyBytes(body []byte, setContentLength bool) {
 	msg.body = body
 	if setContentLength {
+		length := ContentLength(len(body))
 		hdrs := msg.GetHeaders("Content-Length")
 		if len(hdrs) == 0 {
-			length := ContentLength(len(body))
-			msg.AppendHeader(length)
+			msg.AppendHeader(&length)
 		} else {
-			hdrs[0] = ContentLength(len(body))
+			hdrs[0] = &length
 		}
 	}
 }
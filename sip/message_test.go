@@ -1,6 +1,7 @@
 package sip
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 )
@@ -562,3 +563,104 @@ func TestHeaders_String(t *testing.T) {
 		},
 	}, t)
 }
+
+func routeAddress(host string, lr bool) *Address {
+	params := NewParams()
+	if lr {
+		params.Add("lr", nil)
+	}
+	return &Address{Uri: &SipUri{Host: host, UriParams: params, Headers: NewParams()}, Params: NewParams()}
+}
+
+func routeRequest(routes ...*Address) Request {
+	hdrs := make([]Header, 0)
+	if len(routes) > 0 {
+		hdrs = append(hdrs, &RouteHeader{Addresses: routes})
+	}
+	return NewRequest(
+		"INVITE",
+		&SipUri{User: String{"bob"}, Host: "far-far-away.com", UriParams: NewParams(), Headers: NewParams()},
+		"SIP/2.0",
+		hdrs,
+		"",
+	)
+}
+
+func TestNextHop(t *testing.T) {
+	doTests([]stringTest{
+		{"Request without route set", NextHop(routeRequest()), "sip:bob@far-far-away.com"},
+		{"Loose router", NextHop(routeRequest(routeAddress("p1.com", true), routeAddress("p2.com", true))), "sip:p1.com;lr"},
+		{"Strict router", NextHop(routeRequest(routeAddress("p1.com", false))), "sip:bob@far-far-away.com"},
+		{"Destination of loose routed request", String{routeRequest(routeAddress("p1.com", true)).Destination()}, "p1.com:5060"},
+	}, t)
+}
+
+func TestRewriteStrictRoute(t *testing.T) {
+	loose := routeRequest(routeAddress("p1.com", true), routeAddress("p2.com", false))
+	if RewriteStrictRoute(loose) {
+		t.Errorf("[FAIL] request with loose router on top was rewritten")
+	}
+	strict := routeRequest(routeAddress("p1.com", false), routeAddress("p2.com", true))
+	if !RewriteStrictRoute(strict) {
+		t.Errorf("[FAIL] request with strict router on top was not rewritten")
+	}
+	route, _ := strict.Route()
+
+	doTests([]stringTest{
+		{"Loose routed Request-URI", loose.Recipient(), "sip:bob@far-far-away.com"},
+		{"Strict routed Request-URI", strict.Recipient(), "sip:p1.com"},
+		{"Strict routed route set", route, "Route: <sip:p2.com;lr>, <sip:bob@far-far-away.com>"},
+	}, t)
+}
+
+func TestMessage_BodyBytes(t *testing.T) {
+	body := []byte("Привет\x00\x01\xff")
+	req := NewRequest(INVITE, &SipUri{Host: "example.com", UriParams: noParams, Headers: noParams}, "SIP/2.0", nil, "")
+	req.SetBodyBytes(body, true)
+
+	length, ok := req.ContentLength()
+	if !ok || int(*length) != len(body) {
+		t.Errorf("[FAIL] Expected Content-Length %d bytes, got %v", len(body), length)
+	}
+
+	var buffer bytes.Buffer
+	n, err := req.WriteTo(&buffer)
+	expected := "INVITE sip:example.com SIP/2.0\r\nContent-Length: 15\r\n\r\n" + string(body)
+	if err != nil || int(n) != len(expected) || buffer.String() != expected || req.String() != expected {
+		t.Errorf("[FAIL] Expected: %q, Got: %q (%d bytes, error %v)", expected, buffer.String(), n, err)
+	}
+
+	clone := req.Clone()
+	body[0] = 'X'
+	if !bytes.Equal(clone.BodyBytes(), []byte("Привет\x00\x01\xff")) {
+		t.Errorf("[FAIL] Cloned body is shared with the original message: %q", clone.BodyBytes())
+	}
+
+	req.SetBody("ok", true)
+	doTests([]stringTest{
+		{"Content-Length replaced", req, "INVITE sip:example.com SIP/2.0\r\nContent-Length: 2\r\n\r\nok"},
+	}, t)
+}
+
+func TestMessage_MaxForwardsAndTag(t *testing.T) {
+	maxForwards := MaxForwards(70)
+	params := NewParams().Add("tag", String{Str: "alice"})
+	req := NewRequest(INVITE, &SipUri{Host: "example.com", UriParams: noParams, Headers: noParams}, "SIP/2.0",
+		[]Header{&maxForwards, &FromHeader{Address: &SipUri{Host: "example.com", UriParams: noParams, Headers: noParams}, Params: params}}, "")
+
+	if value, ok := req.MaxForwards(); !ok || *value != 70 {
+		t.Errorf("[FAIL] Expected Max-Forwards 70, got %v", value)
+	}
+	from, _ := req.From()
+	if tag := Tag(from.Params); tag != "alice" {
+		t.Errorf("[FAIL] Expected tag \"alice\", got %q", tag)
+	}
+	if tag := Tag(noParams); tag != "" {
+		t.Errorf("[FAIL] Expected no tag, got %q", tag)
+	}
+
+	req.RemoveHeader("Max-Forwards")
+	if value, ok := req.MaxForwards(); ok {
+		t.Errorf("[FAIL] Expected no Max-Forwards, got %v", value)
+	}
+}
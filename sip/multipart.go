@@ -0,0 +1,149 @@
+package sip
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/masterclock/gosip/util"
+)
+
+// MultipartBody is multipart MIME body (RFC 2046 - 5.1., RFC 5621),
+// e.g. SDP with ISUP in SIP-I or SDP with PIDF-LO in emergency calls.
+type MultipartBody struct {
+	// SubType is the multipart subtype, e.g. mixed, alternative or related.
+	SubType string
+	// Boundary delimits the parts, it must not occur inside of any part.
+	Boundary string
+	// Params are the other params of 'Content-Type' header, e.g. type of multipart/related.
+	Params Params
+	Parts  []*BodyPart
+}
+
+// NewMultipartBody creates multipart body with random boundary.
+func NewMultipartBody(subType string, parts ...*BodyPart) *MultipartBody {
+	return &MultipartBody{
+		SubType:  subType,
+		Boundary: "gosip-" + util.RandString(24),
+		Params:   NewParams(),
+		Parts:    parts,
+	}
+}
+
+// ContentType returns 'Content-Type' header of the multipart body with the boundary.
+func (body *MultipartBody) ContentType() *ContentTypeHeader {
+	contentType := NewContentTypeHeader("multipart/" + body.SubType)
+	contentType.Params.Add("boundary", String{Str: body.Boundary})
+	if body.Params != nil {
+		for _, key := range body.Params.Keys() {
+			if !strings.EqualFold(key, "boundary") {
+				val, _ := body.Params.Get(key)
+				contentType.Params.Add(key, val)
+			}
+		}
+	}
+
+	return contentType
+}
+
+// String returns the multipart body with CRLF line breaks (RFC 2046 - 5.1.1.).
+func (body *MultipartBody) String() string {
+	var buffer bytes.Buffer
+	for _, part := range body.Parts {
+		buffer.WriteString("--" + body.Boundary + "\r\n")
+		buffer.WriteString(part.String())
+		buffer.WriteString("\r\n")
+	}
+	buffer.WriteString("--" + body.Boundary + "--\r\n")
+
+	return buffer.String()
+}
+
+// Part returns the first part with the media type, e.g. application/sdp, nested parts are searched too.
+func (body *MultipartBody) Part(mediaType string) (*BodyPart, bool) {
+	for _, part := range body.Parts {
+		if contentType, ok := part.ContentType(); ok && contentType.Is(mediaType) {
+			return part, true
+		}
+		if part.Multipart != nil {
+			if nested, ok := part.Multipart.Part(mediaType); ok {
+				return nested, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+func (body *MultipartBody) Clone() *MultipartBody {
+	clone := &MultipartBody{
+		SubType:  body.SubType,
+		Boundary: body.Boundary,
+		Params:   cloneWithNil(body.Params),
+		Parts:    make([]*BodyPart, 0, len(body.Parts)),
+	}
+	for _, part := range body.Parts {
+		clone.Parts = append(clone.Parts, part.Clone())
+	}
+
+	return clone
+}
+
+// BodyPart is a single part of multipart body with own headers, e.g. Content-Type and Content-Disposition.
+type BodyPart struct {
+	*headers
+	// Body is the content of the part, it is ignored if the part has nested multipart body.
+	Body string
+	// Multipart is the nested multipart body.
+	Multipart *MultipartBody
+}
+
+// NewBodyPart creates body part with the headers, e.g. 'Content-Type' and 'Content-Disposition'.
+func NewBodyPart(hdrs []Header, body string) *BodyPart {
+	return &BodyPart{headers: newHeaders(hdrs), Body: body}
+}
+
+// NewMultipartBodyPart creates body part with the nested multipart body.
+func NewMultipartBodyPart(hdrs []Header, body *MultipartBody) *BodyPart {
+	part := &BodyPart{headers: newHeaders(hdrs), Multipart: body}
+	part.RemoveHeader("Content-Type")
+	part.PrependHeader(body.ContentType())
+
+	return part
+}
+
+// Content returns content of the part, nested multipart body is rendered.
+func (part *BodyPart) Content() string {
+	if part.Multipart != nil {
+		return part.Multipart.String()
+	}
+
+	return part.Body
+}
+
+func (part *BodyPart) String() string {
+	return part.headers.String() + "\r\n" + part.Content()
+}
+
+func (part *BodyPart) Clone() *BodyPart {
+	clone := &BodyPart{headers: newHeaders(part.CloneHeaders()), Body: part.Body}
+	if part.Multipart != nil {
+		clone.Multipart = part.Multipart.Clone()
+	}
+
+	return clone
+}
+
+// SetMultipartBody sets multipart body of the message, 'Content-Type' header is replaced
+// and 'Content-Length' is updated with the length of the rendered body.
+func SetMultipartBody(msg Message, body *MultipartBody) error {
+	if body.Boundary == "" {
+		return fmt.Errorf("multipart body has empty boundary")
+	}
+
+	msg.RemoveHeader("Content-Type")
+	msg.AppendHeader(body.ContentType())
+	msg.SetBody(body.String(), true)
+
+	return nil
+}
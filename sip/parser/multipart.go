@@ -0,0 +1,159 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/masterclock/gosip/sip"
+)
+
+// ParseMultipart parses multipart body of the message using its 'Content-Type' header.
+func ParseMultipart(msg sip.Message) (*sip.MultipartBody, error) {
+	contentType, ok := msg.ContentType()
+	if !ok {
+		return nil, fmt.Errorf("%s has no Content-Type header", msg.Short())
+	}
+
+	return ParseMultipartBody(contentType, msg.Body())
+}
+
+// ParseMultipartBody parses multipart body with the boundary of the content type (RFC 2046 - 5.1.1.).
+// Preamble and epilogue are dropped. Headers of the parts are parsed by the default header parsers,
+// nested multipart bodies are parsed recursively.
+func ParseMultipartBody(contentType *sip.ContentTypeHeader, body string) (*sip.MultipartBody, error) {
+	if !contentType.IsMultipart() {
+		return nil, fmt.Errorf("%s is not multipart media type", contentType.MediaType())
+	}
+	boundary, ok := contentType.Param("boundary")
+	if !ok || boundary == "" {
+		return nil, fmt.Errorf("missing boundary of %s body", contentType.MediaType())
+	}
+
+	multipart := &sip.MultipartBody{
+		SubType:  contentType.SubType,
+		Boundary: boundary,
+		Params:   sip.NewParams(),
+		Parts:    make([]*sip.BodyPart, 0),
+	}
+	for _, key := range contentType.Params.Keys() {
+		if !strings.EqualFold(key, "boundary") {
+			val, _ := contentType.Params.Get(key)
+			multipart.Params.Add(key, val)
+		}
+	}
+
+	delimiter := "--" + boundary
+	// the first delimiter may be not preceded by CRLF if there is no preamble
+	pos := strings.Index("\n"+body, "\n"+delimiter)
+	if pos == -1 {
+		return nil, fmt.Errorf("missing boundary '%s' in multipart body", boundary)
+	}
+	pos += len(delimiter)
+
+	for {
+		if strings.HasPrefix(body[pos:], "--") {
+			// close delimiter, the rest is epilogue
+			return multipart, nil
+		}
+		// skip transport padding till the end of the delimiter line
+		eol := strings.Index(body[pos:], "\n")
+		if eol == -1 {
+			return nil, fmt.Errorf("unexpected end of multipart body after boundary '%s'", boundary)
+		}
+		start := pos + eol + 1
+
+		// search from the line break of the previous delimiter to catch empty part
+		end := strings.Index(body[start-1:], "\n"+delimiter)
+		if end == -1 {
+			return nil, fmt.Errorf("missing close boundary '%s' in multipart body", boundary)
+		}
+		end += start - 1
+		pos = end + 1 + len(delimiter)
+		// CRLF preceding the delimiter belongs to the delimiter
+		if end > start && body[end-1] == '\r' {
+			end--
+		}
+		if end < start {
+			end = start
+		}
+
+		part, err := parseBodyPart(body[start:end])
+		if err != nil {
+			return nil, err
+		}
+		multipart.Parts = append(multipart.Parts, part)
+	}
+}
+
+func parseBodyPart(data string) (*sip.BodyPart, error) {
+	var hdrs []sip.Header
+	var content string
+
+	switch {
+	case strings.HasPrefix(data, "\r\n"):
+		content = data[2:]
+	case strings.HasPrefix(data, "\n"):
+		content = data[1:]
+	default:
+		idx, sep := strings.Index(data, "\r\n\r\n"), 4
+		if lfIdx := strings.Index(data, "\n\n"); lfIdx != -1 && (idx == -1 || lfIdx < idx) {
+			idx, sep = lfIdx, 2
+		}
+		if idx == -1 {
+			// body part consists of the headers only
+			idx, sep = len(data), 0
+		}
+
+		var err error
+		if hdrs, err = parseHeaderLines(data[:idx]); err != nil {
+			return nil, err
+		}
+		content = data[idx+sep:]
+	}
+
+	part := sip.NewBodyPart(hdrs, content)
+	if contentType, ok := part.ContentType(); ok && contentType.IsMultipart() {
+		nested, err := ParseMultipartBody(contentType, content)
+		if err != nil {
+			return nil, err
+		}
+		part.Multipart = nested
+	}
+
+	return part, nil
+}
+
+// parseHeaderLines parses headers of the body part with the default header parsers,
+// unknown headers are kept as generic headers.
+func parseHeaderLines(data string) ([]sip.Header, error) {
+	parsers := defaultHeaderParsers()
+	lines := strings.Split(strings.Replace(data, "\r\n", "\n", -1), "\n")
+	hdrs := make([]sip.Header, 0)
+
+	for len(lines) > 0 {
+		headerText, consumed := getNextHeaderLine(lines)
+		if consumed == 0 {
+			break
+		}
+		lines = lines[consumed:]
+
+		colonIdx := strings.Index(headerText, ":")
+		if colonIdx == -1 {
+			return nil, fmt.Errorf("field name with no value in body part header: %s", headerText)
+		}
+		fieldName := strings.TrimSpace(headerText[:colonIdx])
+		fieldText := strings.TrimSpace(headerText[colonIdx+1:])
+		headerParser, ok := parsers[strings.ToLower(fieldName)]
+		if !ok {
+			hdrs = append(hdrs, &sip.GenericHeader{HeaderName: fieldName, Contents: fieldText})
+			continue
+		}
+		parsed, err := headerParser(strings.ToLower(fieldName), fieldText)
+		if err != nil {
+			return nil, err
+		}
+		hdrs = append(hdrs, parsed...)
+	}
+
+	return hdrs, nil
+}
@@ -0,0 +1,174 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/masterclock/gosip/log"
+	"github.com/masterclock/gosip/sip"
+)
+
+const sdpBody = "v=0\r\no=- 1 1 IN IP4 192.0.2.1\r\ns=-\r\nc=IN IP4 192.0.2.1\r\nt=0 0\r\nm=audio 4000 RTP/AVP 0\r\n"
+
+func TestParseMultipartBody(t *testing.T) {
+	contentType := &sip.ContentTypeHeader{Type: "multipart", SubType: "mixed",
+		Params: sip.NewParams().Add("boundary", sip.String{Str: "unique-boundary-1"})}
+	body := "This is a preamble\r\n" +
+		"--unique-boundary-1\r\n" +
+		"Content-Type: application/sdp\r\n" +
+		"\r\n" +
+		sdpBody +
+		"\r\n--unique-boundary-1 \r\n" +
+		"Content-Type: application/ISUP;version=itu-t92+\r\n" +
+		"Content-Disposition: signal;handling=optional\r\n" +
+		"Content-ID: <isup@example.com>\r\n" +
+		"\r\n" +
+		"\x01\x00\x49\x00\x00\x03\x02\x00\x07" +
+		"\r\n--unique-boundary-1\r\n" +
+		"\r\n" +
+		"plain text without headers" +
+		"\r\n--unique-boundary-1--\r\n" +
+		"This is an epilogue\r\n"
+
+	multipart, err := ParseMultipartBody(contentType, body)
+	if err != nil {
+		t.Fatalf("[FAIL] ParseMultipartBody failed: %s", err)
+	}
+	if len(multipart.Parts) != 3 {
+		t.Fatalf("[FAIL] Expected 3 parts, got %d", len(multipart.Parts))
+	}
+
+	sdp, ok := multipart.Part("application/sdp")
+	if !ok || sdp.Body != sdpBody {
+		t.Errorf("[FAIL] Unexpected SDP part: %v", sdp)
+	}
+	isup, ok := multipart.Part("application/isup")
+	if !ok || isup.Body != "\x01\x00\x49\x00\x00\x03\x02\x00\x07" {
+		t.Errorf("[FAIL] Unexpected ISUP part: %v", isup)
+	} else {
+		if disposition, ok := isup.ContentDisposition(); !ok || disposition.Handling() != "optional" {
+			t.Errorf("[FAIL] Unexpected ISUP Content-Disposition: %v", disposition)
+		}
+		if hdrs := isup.GetHeaders("Content-ID"); len(hdrs) != 1 {
+			t.Errorf("[FAIL] Expected Content-ID header of ISUP part, got: %v", hdrs)
+		}
+	}
+	if text := multipart.Parts[2]; len(text.Headers()) != 0 || text.Body != "plain text without headers" {
+		t.Errorf("[FAIL] Unexpected part without headers: %v", text)
+	}
+}
+
+func TestParseMultipartBody_Nested(t *testing.T) {
+	contentType := &sip.ContentTypeHeader{Type: "multipart", SubType: "mixed",
+		Params: sip.NewParams().Add("boundary", sip.String{Str: "outer"})}
+	body := "--outer\n" +
+		"Content-Type: multipart/alternative; boundary=inner\n" +
+		"\n" +
+		"--inner\n" +
+		"Content-Type: text/plain\n" +
+		"\n" +
+		"hello\n" +
+		"--inner\n" +
+		"Content-Type: text/html\n" +
+		"\n" +
+		"<p>hello</p>\n" +
+		"--inner--\n" +
+		"\n" +
+		"--outer\n" +
+		"Content-Type: application/pidf+xml\n" +
+		"Content-ID: <location@example.com>\n" +
+		"\n" +
+		"<presence/>\n" +
+		"--outer--"
+
+	multipart, err := ParseMultipartBody(contentType, body)
+	if err != nil {
+		t.Fatalf("[FAIL] ParseMultipartBody failed: %s", err)
+	}
+	if len(multipart.Parts) != 2 || multipart.Parts[0].Multipart == nil {
+		t.Fatalf("[FAIL] Expected nested multipart body, got: %s", multipart)
+	}
+	if nested := multipart.Parts[0].Multipart; nested.SubType != "alternative" || len(nested.Parts) != 2 {
+		t.Errorf("[FAIL] Unexpected nested body: %s", nested)
+	}
+	if html, ok := multipart.Part("text/html"); !ok || html.Body != "<p>hello</p>" {
+		t.Errorf("[FAIL] Unexpected nested part: %v", html)
+	}
+	if pidf, ok := multipart.Part("application/pidf+xml"); !ok || pidf.Body != "<presence/>" {
+		t.Errorf("[FAIL] Unexpected PIDF part: %v", pidf)
+	}
+}
+
+func TestParseMultipartBody_Errors(t *testing.T) {
+	tests := []struct {
+		description string
+		contentType string
+		body        string
+	}{
+		{"Not multipart", "application/sdp", "--b\r\n\r\nfoo\r\n--b--"},
+		{"Missing boundary param", "multipart/mixed", "--b\r\n\r\nfoo\r\n--b--"},
+		{"Missing boundary", "multipart/mixed;boundary=b", "foo"},
+		{"Missing close boundary", "multipart/mixed;boundary=b", "--b\r\n\r\nfoo"},
+		{"Malformed part header", "multipart/mixed;boundary=b", "--b\r\nContent-Type\r\n\r\nfoo\r\n--b--"},
+	}
+
+	for _, test := range tests {
+		headers, err := parseContentType("content-type", test.contentType)
+		if err != nil {
+			t.Fatalf("[FAIL] %s: failed to parse Content-Type: %s", test.description, err)
+		}
+		if _, err := ParseMultipartBody(headers[0].(*sip.ContentTypeHeader), test.body); err == nil {
+			t.Errorf("[FAIL] %s: error expected", test.description)
+		}
+	}
+}
+
+func TestSetMultipartBody(t *testing.T) {
+	isup := sip.NewBodyPart([]sip.Header{
+		&sip.ContentTypeHeader{Type: "application", SubType: "ISUP", Params: sip.NewParams().Add("version", sip.String{Str: "itu-t92+"})},
+		&sip.ContentDispositionHeader{Disposition: "signal", Params: sip.NewParams().Add("handling", sip.String{Str: "optional"})},
+	}, "\x01\x00\x49\x00")
+	nested := sip.NewMultipartBody("alternative",
+		sip.NewBodyPart([]sip.Header{sip.NewContentTypeHeader("text/plain")}, "hello"),
+		sip.NewBodyPart([]sip.Header{sip.NewContentTypeHeader("text/html")}, "<p>hello</p>"),
+	)
+	body := sip.NewMultipartBody("mixed",
+		sip.NewBodyPart([]sip.Header{sip.NewContentTypeHeader("application/sdp")}, sdpBody),
+		isup,
+		sip.NewMultipartBodyPart(nil, nested),
+	)
+
+	req := sip.NewRequest(sip.INVITE, &sip.SipUri{Host: "example.com"}, "SIP/2.0", []sip.Header{
+		&sip.ContentTypeHeader{Type: "application", SubType: "sdp"},
+	}, "")
+	if err := sip.SetMultipartBody(req, body); err != nil {
+		t.Fatalf("[FAIL] SetMultipartBody failed: %s", err)
+	}
+
+	msg, err := ParseMessage([]byte(req.String()), log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] ParseMessage failed: %s", err)
+	}
+	if length, ok := msg.ContentLength(); !ok || int(*length) != len(body.String()) {
+		t.Errorf("[FAIL] Unexpected Content-Length: %v", length)
+	}
+	if contentType, ok := msg.ContentType(); !ok || !contentType.Is("multipart/mixed") {
+		t.Errorf("[FAIL] Unexpected Content-Type: %v", contentType)
+	}
+
+	parsed, err := ParseMultipart(msg)
+	if err != nil {
+		t.Fatalf("[FAIL] ParseMultipart failed: %s", err)
+	}
+	if parsed.String() != body.String() {
+		t.Errorf("[FAIL] Expected:\n%s\nGot:\n%s", body, parsed)
+	}
+	part, ok := parsed.Part("application/isup")
+	if contentType, _ := part.ContentType(); !ok || fmt.Sprint(contentType) != "Content-Type: application/ISUP;version=itu-t92+" {
+		t.Errorf("[FAIL] Unexpected ISUP part: %v", part)
+	}
+	if !strings.Contains(parsed.Parts[2].String(), "Content-Type: multipart/alternative;boundary="+nested.Boundary) {
+		t.Errorf("[FAIL] Unexpected nested part: %s", parsed.Parts[2])
+	}
+}
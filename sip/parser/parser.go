@@ -5,9 +5,11 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
@@ -52,21 +54,69 @@ type Parser interface {
 // It should return a slice of headers, which should have length > 1 unless it also returns an error.
 type HeaderParser func(headerName string, headerData string) ([]sip.Header, error)
 
+// Headers that the message can not be processed without, they are skipped if typed parsing fails.
+// The other headers are kept as GenericHeader.
+var coreHeaders = map[string]bool{
+	"to":             true,
+	"t":              true,
+	"from":           true,
+	"f":              true,
+	"contact":        true,
+	"m":              true,
+	"call-id":        true,
+	"cseq":           true,
+	"via":            true,
+	"v":              true,
+	"max-forwards":   true,
+	"content-length": true,
+	"l":              true,
+}
+
 func defaultHeaderParsers() map[string]HeaderParser {
 	return map[string]HeaderParser{
-		"to":             parseAddressHeader,
-		"t":              parseAddressHeader,
-		"from":           parseAddressHeader,
-		"f":              parseAddressHeader,
-		"contact":        parseAddressHeader,
-		"m":              parseAddressHeader,
-		"Call-ID":        parseCallId,
-		"cseq":           parseCSeq,
-		"via":            parseViaHeader,
-		"v":              parseViaHeader,
-		"max-forwards":   parseMaxForwards,
-		"content-length": parseContentLength,
-		"l":              parseContentLength,
+		"to":                  parseAddressHeader,
+		"t":                   parseAddressHeader,
+		"from":                parseAddressHeader,
+		"f":                   parseAddressHeader,
+		"contact":             parseAddressHeader,
+		"m":                   parseAddressHeader,
+		"Call-ID":             parseCallId,
+		"cseq":                parseCSeq,
+		"via":                 parseViaHeader,
+		"v":                   parseViaHeader,
+		"max-forwards":        parseMaxForwards,
+		"content-length":      parseContentLength,
+		"l":                   parseContentLength,
+		"rseq":                parseRSeq,
+		"rack":                parseRAck,
+		"content-type":        parseContentType,
+		"c":                   parseContentType,
+		"content-disposition": parseContentDisposition,
+		"expires":             parseExpires,
+		"min-expires":         parseMinExpires,
+		"allow":               parseAllow,
+		"accept":              parseAccept,
+		"retry-after":         parseRetryAfter,
+		"timestamp":           parseTimestamp,
+		"date":                parseDate,
+		"warning":             parseWarning,
+		"event":               parseEvent,
+		"o":                   parseEvent,
+		"allow-events":        parseAllowEvents,
+		"u":                   parseAllowEvents,
+		"subscription-state":  parseSubscriptionState,
+		"refer-to":            parseReferAddress,
+		"r":                   parseReferAddress,
+		"referred-by":         parseReferAddress,
+		"b":                   parseReferAddress,
+		"replaces":            parseReplaces,
+		"refer-sub":           parseReferSub,
+		"route":               parseRouteHeader,
+		"record-route":        parseRouteHeader,
+		"www-authenticate":    parseAuthHeader,
+		"proxy-authenticate":  parseAuthHeader,
+		"authorization":       parseAuthHeader,
+		"proxy-authorization": parseAuthHeader,
 	}
 }
 
@@ -296,6 +346,10 @@ func (p *parser) parse(requireContentLength bool) {
 				newHeaders, err := p.parseHeader(buffer.String())
 				if err == nil {
 					headers = append(headers, newHeaders...)
+				} else if header, ok := genericHeader(buffer.String()); ok {
+					// the header is kept as is, e.g. to be forwarded by proxy
+					p.Log().Debugf("%s keeps header '%s' as generic due to error: %s", p, buffer, err)
+					headers = append(headers, header)
 				} else {
 					p.Log().Warnf("skipping header '%s' due to error: %s", buffer, err)
 				}
@@ -409,8 +463,9 @@ func (p *parser) parse(requireContentLength bool) {
 			continue
 		}
 
-		if strings.TrimSpace(body) != "" {
-			msg.SetBody(body, false)
+		// body is kept as is, e.g. binary or whitespace-only body
+		if contentLength > 0 {
+			msg.SetBodyBytes(body, false)
 		}
 		p.output <- msg
 	}
@@ -540,8 +595,107 @@ func ParseUri(uriStr string) (uri sip.Uri, err error) {
 		var sipUri sip.SipUri
 		sipUri, err = ParseSipUri(uriStr)
 		uri = &sipUri
+	case "tel":
+		var telUri sip.TelUri
+		telUri, err = ParseTelUri(uriStr)
+		uri = &telUri
 	default:
-		err = fmt.Errorf("unsupported URI schema %s", uriStr[:colonIdx])
+		var genericUri sip.GenericUri
+		genericUri, err = ParseGenericUri(uriStr)
+		uri = &genericUri
+	}
+
+	return
+}
+
+// ParseTelUri converts a string representation of a tel URI into a TelUri object (RFC 3966 - 3.).
+// Visual separators are removed from the number, from 'ext' and from global 'phone-context'.
+func ParseTelUri(uriStr string) (uri sip.TelUri, err error) {
+	colonIdx := strings.Index(uriStr, ":")
+	if colonIdx == -1 || !strings.EqualFold(uriStr[:colonIdx], "tel") {
+		err = fmt.Errorf("invalid tel uri protocol name in '%s'", uriStr)
+		return
+	}
+	uriStr = uriStr[colonIdx+1:]
+
+	endOfNumber := strings.Index(uriStr, ";")
+	if endOfNumber == -1 {
+		endOfNumber = len(uriStr)
+	}
+	number := uriStr[:endOfNumber]
+
+	params, _, err := parseParams(uriStr[endOfNumber:], ';', ';', 0, false, true)
+	if err != nil {
+		return
+	}
+	uri.UriParams = sip.NewParams()
+	for _, key := range params.Keys() {
+		val, _ := params.Get(key)
+		if val != nil {
+			switch name := strings.ToLower(key); {
+			case name == "ext", name == "phone-context" && strings.HasPrefix(val.String(), "+"):
+				val = sip.String{Str: sip.NormalizeTelNumber(val.String())}
+			}
+		}
+		uri.UriParams.Add(key, val)
+	}
+
+	if strings.HasPrefix(number, "+") {
+		// global-number-digits = "+" *phonedigit DIGIT *phonedigit
+		uri.Number = "+" + sip.NormalizeTelNumber(number[1:])
+		if len(uri.Number) == 1 || strings.IndexFunc(uri.Number[1:], isNotDigit) != -1 {
+			err = fmt.Errorf("invalid global number in tel uri '%s'", uriStr)
+		}
+		return
+	}
+
+	// local-number-digits = *phonedigit-hex (HEXDIG / "*" / "#") *phonedigit-hex
+	uri.Number = sip.NormalizeTelNumber(number)
+	if uri.Number == "" || strings.IndexFunc(uri.Number, isNotPhoneDigitHex) != -1 {
+		err = fmt.Errorf("invalid local number in tel uri '%s'", uriStr)
+		return
+	}
+	if context, ok := uri.PhoneContext(); !ok || context == "" {
+		err = fmt.Errorf("local number without 'phone-context' in tel uri '%s'", uriStr)
+	}
+
+	return
+}
+
+func isNotDigit(r rune) bool {
+	return r < '0' || r > '9'
+}
+
+func isNotPhoneDigitHex(r rune) bool {
+	return isNotDigit(r) && (r < 'a' || r > 'f') && (r < 'A' || r > 'F') && r != '*' && r != '#'
+}
+
+// ParseGenericUri converts a string representation of an absolute URI of any scheme into a GenericUri object,
+// the scheme specific part is kept opaque (RFC 3986 - 3.).
+func ParseGenericUri(uriStr string) (uri sip.GenericUri, err error) {
+	colonIdx := strings.Index(uriStr, ":")
+	if colonIdx == -1 {
+		err = fmt.Errorf("no ':' in URI %s", uriStr)
+		return
+	}
+
+	// scheme = ALPHA *( ALPHA / DIGIT / "+" / "-" / "." )
+	scheme := uriStr[:colonIdx]
+	for idx, r := range scheme {
+		if !unicode.IsLetter(r) && (idx == 0 || (isNotDigit(r) && !strings.ContainsRune("+-.", r))) || r > unicode.MaxASCII {
+			err = fmt.Errorf("invalid URI scheme '%s'", scheme)
+			return
+		}
+	}
+	if scheme == "" {
+		err = fmt.Errorf("empty URI scheme in '%s'", uriStr)
+		return
+	}
+
+	uri.Scheme = scheme
+	uri.Opaque = uriStr[colonIdx+1:]
+	if uri.Opaque == "" || strings.ContainsAny(uri.Opaque, abnfWs+"<>\"") {
+		err = fmt.Errorf("invalid URI '%s'", uriStr)
 	}
 
 	return
@@ -855,6 +1009,21 @@ func (p *parser) parseHeader(headerText string) (headers []sip.Header, err error
 	return
 }
 
+// genericHeader wraps the header that failed typed parsing into GenericHeader,
+// returns false for the core headers that can not be kept untyped.
+func genericHeader(headerText string) (sip.Header, bool) {
+	colonIdx := strings.Index(headerText, ":")
+	if colonIdx == -1 {
+		return nil, false
+	}
+	fieldName := strings.TrimSpace(headerText[:colonIdx])
+	if coreHeaders[strings.ToLower(fieldName)] {
+		return nil, false
+	}
+
+	return &sip.GenericHeader{HeaderName: fieldName, Contents: strings.TrimSpace(headerText[colonIdx+1:])}, true
+}
+
 // Parse a To, From or Contact header line, producing one or more logical SipHeaders.
 func parseAddressHeader(headerName string, headerText string) (
 	headers []sip.Header, err error) {
@@ -962,10 +1131,10 @@ func parseAddressHeader(headerName string, headerText string) (
 					}
 					header = &contactHeader
 				default:
-					// URIs in contact headers are restricted to being either SIP URIs or 'Contact: *'.
+					// URIs in contact headers are restricted to being either absolute URIs or 'Contact: *'.
 					return nil,
 						fmt.Errorf(
-							"uri %s not valid in Contact header. Must be SIP uri or '*'",
+							"uri %s not valid in Contact header. Must be absolute uri or '*'",
 							uris[idx].String(),
 						)
 				}
@@ -978,6 +1147,206 @@ func parseAddressHeader(headerName string, headerText string) (
 	return
 }
 
+// Parse a Route or Record-Route header line, producing a single header with all the route addresses.
+func parseRouteHeader(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	displayNames, uris, paramSets, err := ParseAddressValues(headerText)
+	if err != nil {
+		return
+	}
+
+	addrs := make([]*sip.Address, 0, len(uris))
+	for idx, uri := range uris {
+		sipUri, ok := uri.(*sip.SipUri)
+		if !ok {
+			// RFC 3261 - 20.30., 20.34. - route values are name-addr with SIP or SIPS URI
+			err = fmt.Errorf("uri %s not valid in %s header. Must be SIP uri", uri, headerName)
+			return
+		}
+		params := paramSets[idx]
+		if params == nil {
+			params = sip.NewParams()
+		}
+		addrs = append(addrs, &sip.Address{
+			DisplayName: displayNames[idx],
+			Uri:         sipUri,
+			Params:      params,
+		})
+	}
+	if len(addrs) == 0 {
+		err = fmt.Errorf("empty %s header", headerName)
+		return
+	}
+
+	switch headerName {
+	case "route":
+		headers = append(headers, &sip.RouteHeader{Addresses: addrs})
+	default:
+		headers = append(headers, &sip.RecordRouteHeader{Addresses: addrs})
+	}
+
+	return
+}
+
+// Parse a WWW-Authenticate, Proxy-Authenticate, Authorization or Proxy-Authorization header line,
+// producing a single header with challenge or credentials.
+func parseAuthHeader(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	headerText = strings.TrimSpace(headerText)
+	idx := strings.IndexAny(headerText, abnfWs)
+	if idx == -1 {
+		err = fmt.Errorf("missing auth params in %s header: '%s'", headerName, headerText)
+		return
+	}
+	scheme := headerText[:idx]
+	names, values, err := parseAuthParams(headerText[idx+1:])
+	if err != nil {
+		return
+	}
+
+	switch headerName {
+	case "www-authenticate", "proxy-authenticate":
+		challenge := sip.Challenge{Scheme: scheme, Params: sip.NewParams()}
+		for i, name := range names {
+			switch strings.ToLower(name) {
+			case "realm":
+				challenge.Realm = values[i]
+			case "domain":
+				challenge.Domain = values[i]
+			case "nonce":
+				challenge.Nonce = values[i]
+			case "opaque":
+				challenge.Opaque = values[i]
+			case "stale":
+				challenge.Stale = strings.EqualFold(values[i], "true")
+			case "algorithm":
+				challenge.Algorithm = values[i]
+			case "qop":
+				for _, qop := range strings.Split(values[i], ",") {
+					if qop = strings.TrimSpace(qop); qop != "" {
+						challenge.Qop = append(challenge.Qop, qop)
+					}
+				}
+			default:
+				challenge.Params.Add(name, sip.String{Str: values[i]})
+			}
+		}
+		if headerName == "www-authenticate" {
+			headers = append(headers, &sip.WWWAuthenticateHeader{Challenge: challenge})
+		} else {
+			headers = append(headers, &sip.ProxyAuthenticateHeader{Challenge: challenge})
+		}
+	default:
+		credentials := sip.Credentials{Scheme: scheme, Params: sip.NewParams()}
+		for i, name := range names {
+			switch strings.ToLower(name) {
+			case "username":
+				credentials.Username = values[i]
+			case "realm":
+				credentials.Realm = values[i]
+			case "nonce":
+				credentials.Nonce = values[i]
+			case "uri":
+				credentials.Uri = values[i]
+			case "response":
+				credentials.Response = values[i]
+			case "algorithm":
+				credentials.Algorithm = values[i]
+			case "cnonce":
+				credentials.Cnonce = values[i]
+			case "opaque":
+				credentials.Opaque = values[i]
+			case "qop":
+				credentials.Qop = values[i]
+			case "nc":
+				var nc uint64
+				if nc, err = strconv.ParseUint(values[i], 16, 32); err != nil {
+					err = fmt.Errorf("invalid nonce count in %s header: '%s'", headerName, headerText)
+					return
+				}
+				credentials.NonceCount = uint32(nc)
+			default:
+				credentials.Params.Add(name, sip.String{Str: values[i]})
+			}
+		}
+		if headerName == "authorization" {
+			headers = append(headers, &sip.AuthorizationHeader{Credentials: credentials})
+		} else {
+			headers = append(headers, &sip.ProxyAuthorizationHeader{Credentials: credentials})
+		}
+	}
+
+	return
+}
+
+// parseAuthParams parses comma-separated list of auth params (RFC 3261 - 25.1., auth-param),
+// returns names and unquoted values in the order of appearance.
+func parseAuthParams(text string) (names []string, values []string, err error) {
+	var name, value bytes.Buffer
+	var inName = true
+	var inQuotes, escaped, quoted bool
+
+	flush := func() error {
+		n := strings.TrimSpace(name.String())
+		v := value.String()
+		if !quoted {
+			v = strings.TrimSpace(v)
+		}
+		name.Reset()
+		value.Reset()
+		inName = true
+		quoted = false
+		if n == "" {
+			if strings.TrimSpace(v) != "" {
+				return fmt.Errorf("auth param without name: '%s'", text)
+			}
+			return nil
+		}
+		names = append(names, n)
+		values = append(values, v)
+		return nil
+	}
+
+	for _, char := range text {
+		switch {
+		case escaped:
+			value.WriteRune(char)
+			escaped = false
+		case inQuotes && char == '\\':
+			escaped = true
+		case char == '"':
+			if inName {
+				return nil, nil, fmt.Errorf("unexpected '\"' in auth param name: '%s'", text)
+			}
+			inQuotes = !inQuotes
+			quoted = true
+		case inQuotes:
+			value.WriteRune(char)
+		case char == '=' && inName:
+			inName = false
+		case char == ',':
+			if err = flush(); err != nil {
+				return nil, nil, err
+			}
+		case inName:
+			name.WriteRune(char)
+		default:
+			value.WriteRune(char)
+		}
+	}
+	if inQuotes {
+		return nil, nil, fmt.Errorf("unclosed quotes in auth params: '%s'", text)
+	}
+	if err = flush(); err != nil {
+		return nil, nil, err
+	}
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("empty auth params: '%s'", text)
+	}
+
+	return names, values, nil
+}
+
 // Parse a string representation of a CSeq header, returning a slice of at most one CSeq.
 func parseCSeq(headerName string, headerText string) (
 	headers []sip.Header, err error) {
@@ -1146,6 +1515,471 @@ func parseContentLength(headerName string, headerText string) (
 	return
 }
 
+// Parse a string representation of a RSeq header into a slice of at most one RSeq header object.
+func parseRSeq(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	var value uint64
+	value, err = strconv.ParseUint(strings.TrimSpace(headerText), 10, 32)
+	if err != nil {
+		return
+	}
+	if value == 0 || value > maxCseq {
+		err = fmt.Errorf("invalid RSeq %d: must be between 1 and 2**31 - 1", value)
+		return
+	}
+	rseq := sip.RSeq(value)
+
+	headers = []sip.Header{&rseq}
+	return
+}
+
+// Parse a string representation of a RAck header into a slice of at most one RAck header object.
+func parseRAck(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	var rack sip.RAck
+
+	parts := splitByWhitespace(headerText)
+	if len(parts) != 3 {
+		err = fmt.Errorf("RAck field should have precisely two whitespace sections: '%s'", headerText)
+		return
+	}
+
+	var rseq, cseq uint64
+	if rseq, err = strconv.ParseUint(parts[0], 10, 32); err != nil {
+		return
+	}
+	if cseq, err = strconv.ParseUint(parts[1], 10, 32); err != nil {
+		return
+	}
+	if rseq == 0 || rseq > maxCseq || cseq > maxCseq {
+		err = fmt.Errorf("invalid RAck %s: sequence numbers exceed maximum permitted value 2**31 - 1", headerText)
+		return
+	}
+	rack.RSeq = uint32(rseq)
+	rack.CSeq = uint32(cseq)
+	rack.MethodName = sip.RequestMethod(strings.TrimSpace(parts[2]))
+
+	if strings.Contains(string(rack.MethodName), ";") {
+		err = fmt.Errorf("unexpected ';' in RAck body: %s", headerText)
+		return
+	}
+
+	headers = []sip.Header{&rack}
+	return
+}
+
+// Parse a string representation of a Content-Type header into a slice of at most one ContentTypeHeader object.
+func parseContentType(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	mediaType, params, err := parseMimeValue(headerText)
+	if err != nil {
+		return
+	}
+	parts := strings.Split(mediaType, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		err = fmt.Errorf("invalid media type in Content-Type header: '%s'", headerText)
+		return
+	}
+
+	headers = []sip.Header{&sip.ContentTypeHeader{Type: parts[0], SubType: parts[1], Params: params}}
+	return
+}
+
+// Parse a string representation of a Content-Disposition header into a slice of at most one
+// ContentDispositionHeader object.
+func parseContentDisposition(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	disposition, params, err := parseMimeValue(headerText)
+	if err != nil {
+		return
+	}
+	if disposition == "" {
+		err = fmt.Errorf("missing disposition type in Content-Disposition header: '%s'", headerText)
+		return
+	}
+
+	headers = []sip.Header{&sip.ContentDispositionHeader{Disposition: disposition, Params: params}}
+	return
+}
+
+// parseMimeValue parses value of MIME header with optional params, e.g. "multipart/mixed;boundary=abc".
+func parseMimeValue(headerText string) (value string, params sip.Params, err error) {
+	headerText = strings.TrimSpace(headerText)
+	idx := findUnescaped(headerText, ';', quotesDelim)
+	if idx == -1 {
+		return headerText, sip.NewParams(), nil
+	}
+
+	value = strings.TrimSpace(headerText[:idx])
+	params, _, err = parseParams(headerText[idx:], ';', ';', 0, true, true)
+	return
+}
+
+// Parse a string representation of an Expires header into a slice of at most one Expires header object.
+func parseExpires(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	var value uint32
+	if value, err = parseDeltaSeconds(headerText); err != nil {
+		return
+	}
+	expires := sip.Expires(value)
+
+	headers = []sip.Header{&expires}
+	return
+}
+
+// Parse a string representation of a Min-Expires header into a slice of at most one MinExpires header object.
+func parseMinExpires(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	var value uint64
+	if value, err = strconv.ParseUint(strings.TrimSpace(headerText), 10, 32); err != nil {
+		return
+	}
+	minExpires := sip.MinExpires(value)
+
+	headers = []sip.Header{&minExpires}
+	return
+}
+
+// parseDeltaSeconds parses delta-seconds value of Expires header,
+// values above 2**32-1 are taken as 2**32-1 as RFC 3261 requires.
+func parseDeltaSeconds(text string) (uint32, error) {
+	text = strings.TrimSpace(text)
+	value, err := strconv.ParseUint(text, 10, 32)
+	if err != nil {
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			return math.MaxUint32, nil
+		}
+		return 0, err
+	}
+
+	return uint32(value), nil
+}
+
+// Parse a string representation of an Allow header into a slice of at most one AllowHeader object.
+// Empty header is allowed (RFC 3261 - 20.5.).
+func parseAllow(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	allow := &sip.AllowHeader{Methods: make([]sip.RequestMethod, 0)}
+	for _, method := range strings.Split(headerText, ",") {
+		method = strings.TrimSpace(method)
+		if method == "" {
+			continue
+		}
+		if strings.ContainsAny(method, abnfWs+";") {
+			err = fmt.Errorf("invalid method '%s' in Allow header: '%s'", method, headerText)
+			return
+		}
+		allow.Methods = append(allow.Methods, sip.RequestMethod(method))
+	}
+
+	headers = []sip.Header{allow}
+	return
+}
+
+// Parse a string representation of an Accept header into a slice of at most one AcceptHeader object.
+// Empty header is allowed and means that no body is acceptable (RFC 3261 - 20.1.).
+func parseAccept(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	accept := &sip.AcceptHeader{MediaRanges: make([]*sip.MediaRange, 0)}
+	for _, value := range splitUnquoted(headerText, ',') {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+
+		var mediaType string
+		var params sip.Params
+		if mediaType, params, err = parseMimeValue(value); err != nil {
+			return
+		}
+		parts := strings.Split(mediaType, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			err = fmt.Errorf("invalid media range '%s' in Accept header: '%s'", mediaType, headerText)
+			return
+		}
+		accept.MediaRanges = append(accept.MediaRanges, &sip.MediaRange{Type: parts[0], SubType: parts[1], Params: params})
+	}
+
+	headers = []sip.Header{accept}
+	return
+}
+
+// Parse a string representation of a Retry-After header into a slice of at most one RetryAfterHeader object,
+// e.g. "18000 (in a meeting);duration=3600".
+func parseRetryAfter(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	headerText = strings.TrimSpace(headerText)
+	idx := strings.IndexAny(headerText, abnfWs+"(;")
+	if idx == -1 {
+		idx = len(headerText)
+	}
+
+	var seconds uint64
+	if seconds, err = strconv.ParseUint(headerText[:idx], 10, 32); err != nil {
+		return
+	}
+	retryAfter := &sip.RetryAfterHeader{Seconds: uint32(seconds), Params: sip.NewParams()}
+
+	rest := strings.TrimSpace(headerText[idx:])
+	if strings.HasPrefix(rest, "(") {
+		// comments can be nested (RFC 3261 - 25.1.)
+		depth, end := 0, -1
+		for i := 0; i < len(rest) && end == -1; i++ {
+			switch rest[i] {
+			case '(':
+				depth++
+			case ')':
+				if depth--; depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end == -1 {
+			err = fmt.Errorf("unclosed comment in Retry-After header: '%s'", headerText)
+			return
+		}
+		retryAfter.Comment = rest[1:end]
+		rest = strings.TrimSpace(rest[end+1:])
+	}
+	if rest != "" {
+		if retryAfter.Params, _, err = parseParams(rest, ';', ';', 0, true, true); err != nil {
+			return
+		}
+	}
+
+	headers = []sip.Header{retryAfter}
+	return
+}
+
+// Parse a string representation of a Timestamp header into a slice of at most one TimestampHeader object.
+func parseTimestamp(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	parts := splitByWhitespace(strings.TrimSpace(headerText))
+	if len(parts) == 0 || len(parts) > 2 {
+		err = fmt.Errorf("Timestamp field should have value and optional delay: '%s'", headerText)
+		return
+	}
+
+	timestamp := &sip.TimestampHeader{}
+	if timestamp.Timestamp, err = strconv.ParseFloat(parts[0], 64); err != nil {
+		return
+	}
+	if len(parts) == 2 {
+		if timestamp.Delay, err = strconv.ParseFloat(parts[1], 64); err != nil {
+			return
+		}
+	}
+
+	headers = []sip.Header{timestamp}
+	return
+}
+
+// Parse a string representation of a Date header into a slice of at most one DateHeader object.
+func parseDate(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	var date time.Time
+	if date, err = time.Parse(time.RFC1123, strings.TrimSpace(headerText)); err != nil {
+		return
+	}
+
+	headers = []sip.Header{&sip.DateHeader{Time: date}}
+	return
+}
+
+// Parse a string representation of a Warning header into a slice of at most one WarningHeader object,
+// e.g. `307 isi.edu "Session parameter 'foo' not understood"`.
+func parseWarning(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	header := &sip.WarningHeader{Warnings: make([]*sip.Warning, 0)}
+	for _, value := range splitUnquoted(headerText, ',') {
+		value = strings.TrimSpace(value)
+		parts := strings.SplitN(value, " ", 3)
+		if len(parts) != 3 || len(parts[0]) != 3 {
+			err = fmt.Errorf("invalid warning value '%s' in Warning header", value)
+			return
+		}
+
+		var code uint64
+		if code, err = strconv.ParseUint(parts[0], 10, 16); err != nil {
+			return
+		}
+		text := strings.TrimSpace(parts[2])
+		if len(text) < 2 || text[0] != '"' || text[len(text)-1] != '"' {
+			err = fmt.Errorf("warning text must be quoted in Warning header: '%s'", value)
+			return
+		}
+		text = strings.Replace(text[1:len(text)-1], `\"`, `"`, -1)
+		text = strings.Replace(text, `\\`, `\`, -1)
+
+		header.Warnings = append(header.Warnings, &sip.Warning{Code: uint16(code), Agent: parts[1], Text: text})
+	}
+
+	headers = []sip.Header{header}
+	return
+}
+
+// Parse a string representation of an Event header into a slice of at most one EventHeader object.
+func parseEvent(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	var eventType string
+	var params sip.Params
+	if eventType, params, err = parseMimeValue(headerText); err != nil {
+		return
+	}
+	if eventType == "" || strings.ContainsAny(eventType, abnfWs+",") {
+		err = fmt.Errorf("invalid event type '%s' in Event header: '%s'", eventType, headerText)
+		return
+	}
+
+	headers = []sip.Header{&sip.EventHeader{EventType: eventType, Params: params}}
+	return
+}
+
+// Parse a string representation of an Allow-Events header into a slice of at most one AllowEventsHeader object.
+func parseAllowEvents(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	allowEvents := &sip.AllowEventsHeader{EventTypes: make([]string, 0)}
+	for _, eventType := range strings.Split(headerText, ",") {
+		eventType = strings.TrimSpace(eventType)
+		if eventType == "" || strings.ContainsAny(eventType, abnfWs+";") {
+			err = fmt.Errorf("invalid event type '%s' in Allow-Events header: '%s'", eventType, headerText)
+			return
+		}
+		allowEvents.EventTypes = append(allowEvents.EventTypes, eventType)
+	}
+
+	headers = []sip.Header{allowEvents}
+	return
+}
+
+// Parse a string representation of a Subscription-State header into a slice of at most one
+// SubscriptionStateHeader object.
+func parseSubscriptionState(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	var state string
+	var params sip.Params
+	if state, params, err = parseMimeValue(headerText); err != nil {
+		return
+	}
+	if state == "" || strings.ContainsAny(state, abnfWs+",") {
+		err = fmt.Errorf("invalid state '%s' in Subscription-State header: '%s'", state, headerText)
+		return
+	}
+	for _, name := range []string{"expires", "retry-after"} {
+		if val, ok := params.Get(name); ok && val != nil {
+			if _, err = strconv.ParseUint(val.String(), 10, 32); err != nil {
+				err = fmt.Errorf("invalid '%s' param in Subscription-State header: '%s'", name, headerText)
+				return
+			}
+		}
+	}
+
+	headers = []sip.Header{&sip.SubscriptionStateHeader{
+		State:  sip.SubscriptionState(strings.ToLower(state)),
+		Params: params,
+	}}
+	return
+}
+
+// Parse a string representation of a Refer-To or Referred-By header into a slice of at most one
+// ReferToHeader or ReferredByHeader object.
+func parseReferAddress(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	var displayNames []sip.MaybeString
+	var uris []sip.Uri
+	var paramSets []sip.Params
+	if displayNames, uris, paramSets, err = ParseAddressValues(headerText); err != nil {
+		return
+	}
+	if len(uris) != 1 {
+		err = fmt.Errorf("%s header must contain exactly one address: '%s'", headerName, headerText)
+		return
+	}
+	if _, ok := uris[0].(sip.WildcardUri); ok {
+		err = fmt.Errorf("wildcard uri not permitted in %s header: '%s'", headerName, headerText)
+		return
+	}
+
+	switch headerName {
+	case "refer-to", "r":
+		headers = []sip.Header{&sip.ReferToHeader{
+			DisplayName: displayNames[0],
+			Address:     uris[0],
+			Params:      paramSets[0],
+		}}
+	case "referred-by", "b":
+		headers = []sip.Header{&sip.ReferredByHeader{
+			DisplayName: displayNames[0],
+			Address:     uris[0],
+			Params:      paramSets[0],
+		}}
+	}
+	return
+}
+
+// Parse a string representation of a Replaces header into a slice of at most one ReplacesHeader object.
+func parseReplaces(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	var callID string
+	var params sip.Params
+	if callID, params, err = parseMimeValue(headerText); err != nil {
+		return
+	}
+	if callID == "" || strings.ContainsAny(callID, abnfWs) {
+		err = fmt.Errorf("invalid Call-ID '%s' in Replaces header: '%s'", callID, headerText)
+		return
+	}
+
+	replaces := &sip.ReplacesHeader{CallID: callID, Params: sip.NewParams()}
+	for _, key := range params.Keys() {
+		val, _ := params.Get(key)
+		switch strings.ToLower(key) {
+		case "to-tag":
+			if val != nil {
+				replaces.ToTag = val.String()
+			}
+		case "from-tag":
+			if val != nil {
+				replaces.FromTag = val.String()
+			}
+		case "early-only":
+			replaces.EarlyOnly = true
+		default:
+			replaces.Params.Add(key, val)
+		}
+	}
+	if replaces.ToTag == "" || replaces.FromTag == "" {
+		err = fmt.Errorf("missing 'to-tag' or 'from-tag' param in Replaces header: '%s'", headerText)
+		return
+	}
+
+	headers = []sip.Header{replaces}
+	return
+}
+
+// Parse a string representation of a Refer-Sub header into a slice of at most one ReferSub header object.
+func parseReferSub(headerName string, headerText string) (
+	headers []sip.Header, err error) {
+	var value string
+	if value, _, err = parseMimeValue(headerText); err != nil {
+		return
+	}
+
+	var referSub sip.ReferSub
+	switch strings.ToLower(value) {
+	case "true":
+		referSub = true
+	case "false":
+		referSub = false
+	default:
+		err = fmt.Errorf("invalid value '%s' of Refer-Sub header: '%s'", value, headerText)
+		return
+	}
+
+	headers = []sip.Header{&referSub}
+	return
+}
+
 // ParseAddressValues parses a comma-separated list of addresses, returning
 // any display names and header params, as well as the SIP URIs themselves.
 // ParseAddressValues is aware of < > bracketing and quoting, and will not
@@ -1378,6 +2212,19 @@ func findAnyUnescaped(text string, targets string, delims ...delimiter) int {
 	return -1
 }
 
+// Splits the given string into sections separated by the char which is not enclosed in quotes.
+func splitUnquoted(text string, sep uint8) []string {
+	result := make([]string, 0)
+	for {
+		idx := findUnescaped(text, sep, quotesDelim)
+		if idx == -1 {
+			return append(result, text)
+		}
+		result = append(result, text[:idx])
+		text = text[idx+1:]
+	}
+}
+
 // Splits the given string into sections, separated by one or more characters
 // from c_ABNF_WS.
 func splitByWhitespace(text string) []string {
@@ -285,6 +285,33 @@ func TestSipUris(t *testing.T) {
 	}, t)
 }
 
+func TestUris(t *testing.T) {
+	doTests([]test{
+		{uriInput("tel:+1-201-555-0123"), &uriResult{pass, &sip.TelUri{Number: "+12015550123", UriParams: noParams}}},
+		{uriInput("TEL:+1(201)555.0123;ext=1-23"),
+			&uriResult{pass, &sip.TelUri{Number: "+12015550123", UriParams: sip.NewParams().Add("ext", sip.String{Str: "123"})}}},
+		{uriInput("tel:7042;phone-context=example.com"),
+			&uriResult{pass, &sip.TelUri{Number: "7042", UriParams: sip.NewParams().Add("phone-context", sip.String{Str: "example.com"})}}},
+		{uriInput("tel:863-1234;phone-context=+1-914-555"),
+			&uriResult{pass, &sip.TelUri{Number: "8631234", UriParams: sip.NewParams().Add("phone-context", sip.String{Str: "+1914555"})}}},
+		{uriInput("tel:*67#;phone-context=example.com"),
+			&uriResult{pass, &sip.TelUri{Number: "*67#", UriParams: sip.NewParams().Add("phone-context", sip.String{Str: "example.com"})}}},
+		{uriInput("tel:+358-555-1234567;isub=1411;postd=pp22"),
+			&uriResult{pass, &sip.TelUri{Number: "+3585551234567",
+				UriParams: sip.NewParams().Add("isub", sip.String{Str: "1411"}).Add("postd", sip.String{Str: "pp22"})}}},
+		{uriInput("urn:service:sos"), &uriResult{pass, &sip.GenericUri{Scheme: "urn", Opaque: "service:sos"}}},
+		{uriInput("mailto:alice@example.com"), &uriResult{pass, &sip.GenericUri{Scheme: "mailto", Opaque: "alice@example.com"}}},
+		{uriInput("sip:bob@example.com"), &uriResult{pass, &sip.SipUri{User: sip.String{Str: "bob"}, Host: "example.com", UriParams: noParams, Headers: noParams}}},
+		{uriInput("tel:+"), &uriResult{fail, nil}},
+		{uriInput("tel:+1-201-555-0123x"), &uriResult{fail, nil}},
+		{uriInput("tel:7042"), &uriResult{fail, nil}},
+		{uriInput("tel:70g2;phone-context=example.com"), &uriResult{fail, nil}},
+		{uriInput("1urn:service:sos"), &uriResult{fail, nil}},
+		{uriInput("urn:"), &uriResult{fail, nil}},
+		{uriInput("service sos"), &uriResult{fail, nil}},
+	}, t)
+}
+
 func TestHostPort(t *testing.T) {
 	doTests([]test{
 		{hostPortInput("example.com"), &hostPortResult{pass, "example.com", nil}},
@@ -1016,6 +1043,263 @@ func TestContentLength(t *testing.T) {
 	}, t)
 }
 
+func TestRSeq(t *testing.T) {
+	doTests([]test{
+		{rSeqInput("RSeq: 1"), &rSeqResult{pass, sip.RSeq(1)}},
+		{rSeqInput("RSeq: 988789"), &rSeqResult{pass, sip.RSeq(988789)}},
+		{rSeqInput("RSeq:	2147483647"), &rSeqResult{pass, sip.RSeq(2147483647)}},
+		{rSeqInput("RSeq: 0"), &rSeqResult{fail, sip.RSeq(0)}},
+		{rSeqInput("RSeq: 2147483648"), &rSeqResult{fail, sip.RSeq(0)}},
+		{rSeqInput("RSeq: -1"), &rSeqResult{fail, sip.RSeq(0)}},
+		{rSeqInput("RSeq:"), &rSeqResult{fail, sip.RSeq(0)}},
+	}, t)
+}
+
+func TestRAck(t *testing.T) {
+	doTests([]test{
+		{rAckInput("RAck: 776656 1 INVITE"), &rAckResult{pass, &sip.RAck{776656, 1, sip.INVITE}}},
+		{rAckInput("RAck:	1  314159	INVITE"), &rAckResult{pass, &sip.RAck{1, 314159, sip.INVITE}}},
+		{rAckInput("RAck: 1 0 invite"), &rAckResult{pass, &sip.RAck{1, 0, "invite"}}},
+		{rAckInput("RAck: 0 1 INVITE"), &rAckResult{fail, &sip.RAck{}}},
+		{rAckInput("RAck: 1 2147483648 INVITE"), &rAckResult{fail, &sip.RAck{}}},
+		{rAckInput("RAck: 1 INVITE"), &rAckResult{fail, &sip.RAck{}}},
+		{rAckInput("RAck: FOO 1 INVITE"), &rAckResult{fail, &sip.RAck{}}},
+		{rAckInput("RAck: 1 1 INVITE;foo=bar"), &rAckResult{fail, &sip.RAck{}}},
+		{rAckInput("RAck:"), &rAckResult{fail, &sip.RAck{}}},
+	}, t)
+}
+
+func TestRouteHeaders(t *testing.T) {
+	lr := sip.NewParams().Add("lr", nil)
+	p1 := &sip.Address{Uri: &sip.SipUri{Host: "p1.example.com", UriParams: lr, Headers: noParams}, Params: noParams}
+	p2 := &sip.Address{Uri: &sip.SipUri{Host: "p2.example.com", Port: &port5060, UriParams: noParams, Headers: noParams}, Params: noParams}
+	p3 := &sip.Address{
+		DisplayName: sip.String{Str: "Proxy"},
+		Uri:         &sip.SipUri{IsEncrypted: true, Host: "p3.example.com", UriParams: lr, Headers: noParams},
+		Params:      sip.NewParams().Add("foo", sip.String{Str: "bar"}),
+	}
+	doTests([]test{
+		{routeInput("Route: <sip:p1.example.com;lr>"), &routeResult{pass, &sip.RouteHeader{[]*sip.Address{p1}}}},
+		{routeInput("Route: <sip:p1.example.com;lr>,<sip:p2.example.com:5060>"), &routeResult{pass, &sip.RouteHeader{[]*sip.Address{p1, p2}}}},
+		{routeInput("Route: <sip:p1.example.com;lr>, \"Proxy\" <sips:p3.example.com;lr>;foo=bar"), &routeResult{pass, &sip.RouteHeader{[]*sip.Address{p1, p3}}}},
+		{routeInput("Record-Route: <sip:p2.example.com:5060>"), &routeResult{pass, &sip.RecordRouteHeader{[]*sip.Address{p2}}}},
+		{routeInput("Record-Route: <sip:p2.example.com:5060>, <sip:p1.example.com;lr>"), &routeResult{pass, &sip.RecordRouteHeader{[]*sip.Address{p2, p1}}}},
+		{routeInput("Route: <tel:+12125551212>"), &routeResult{fail, nil}},
+		{routeInput("Record-Route: *"), &routeResult{fail, nil}},
+		{routeInput("Route:"), &routeResult{fail, nil}},
+	}, t)
+}
+
+func TestAuthHeaders(t *testing.T) {
+	doTests([]test{
+		{authInput(`WWW-Authenticate: Digest realm="atlanta.com", domain="sip:boxesbybob.com", qop="auth", ` +
+			`nonce="f84f1cec41e6cbe5aea9c8e88d359", opaque="", stale=FALSE, algorithm=MD5`),
+			&authResult{pass, &sip.WWWAuthenticateHeader{sip.Challenge{
+				Scheme:    "Digest",
+				Realm:     "atlanta.com",
+				Domain:    "sip:boxesbybob.com",
+				Nonce:     "f84f1cec41e6cbe5aea9c8e88d359",
+				Algorithm: "MD5",
+				Qop:       []string{"auth"},
+			}}}},
+		{authInput(`Proxy-Authenticate: Digest realm="a, \"b\"",nonce="n",qop="auth,auth-int",stale=true,foo=bar`),
+			&authResult{pass, &sip.ProxyAuthenticateHeader{sip.Challenge{
+				Scheme: "Digest",
+				Realm:  `a, "b"`,
+				Nonce:  "n",
+				Stale:  true,
+				Qop:    []string{"auth", "auth-int"},
+				Params: sip.NewParams().Add("foo", sip.String{Str: "bar"}),
+			}}}},
+		{authInput(`Authorization: Digest username="bob", realm="biloxi.com", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", ` +
+			`uri="sip:bob@biloxi.com", qop=auth, nc=0000000a, cnonce="0a4f113b", response="6629fae49393a05397450978507c4ef1", ` +
+			`opaque="5ccc069c403ebaf9f0171e9517f40e41"`),
+			&authResult{pass, &sip.AuthorizationHeader{sip.Credentials{
+				Scheme:     "Digest",
+				Username:   "bob",
+				Realm:      "biloxi.com",
+				Nonce:      "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+				Uri:        "sip:bob@biloxi.com",
+				Response:   "6629fae49393a05397450978507c4ef1",
+				Cnonce:     "0a4f113b",
+				Opaque:     "5ccc069c403ebaf9f0171e9517f40e41",
+				Qop:        "auth",
+				NonceCount: 10,
+			}}}},
+		{authInput(`Proxy-Authorization: Digest username="alice", realm="atlanta.com", nonce="c60f3082ee1212b402a21831ae", ` +
+			`response="245f23415f11432b3434341c022", algorithm=SHA-256`),
+			&authResult{pass, &sip.ProxyAuthorizationHeader{sip.Credentials{
+				Scheme:    "Digest",
+				Username:  "alice",
+				Realm:     "atlanta.com",
+				Nonce:     "c60f3082ee1212b402a21831ae",
+				Response:  "245f23415f11432b3434341c022",
+				Algorithm: "SHA-256",
+			}}}},
+		{authInput(`Authorization: Digest username="bob", nc=xyz`), &authResult{fail, nil}},
+		{authInput(`WWW-Authenticate: Digest realm="atlanta.com`), &authResult{fail, nil}},
+		{authInput(`WWW-Authenticate: Digest`), &authResult{fail, nil}},
+	}, t)
+}
+
+func TestMimeHeaders(t *testing.T) {
+	doTests([]test{
+		{mimeInput("Content-Type: application/sdp"),
+			&mimeResult{pass, &sip.ContentTypeHeader{Type: "application", SubType: "sdp", Params: noParams}}},
+		{mimeInput("c: Application/SDP"),
+			&mimeResult{pass, &sip.ContentTypeHeader{Type: "application", SubType: "sdp", Params: noParams}}},
+		{mimeInput(`Content-Type: multipart/mixed; boundary="unique:boundary;1"`),
+			&mimeResult{pass, &sip.ContentTypeHeader{Type: "multipart", SubType: "mixed",
+				Params: sip.NewParams().Add("boundary", sip.String{Str: "unique:boundary;1"})}}},
+		{mimeInput("Content-Type: text/plain;charset=UTF-8;format=flowed"),
+			&mimeResult{pass, &sip.ContentTypeHeader{Type: "text", SubType: "plain",
+				Params: sip.NewParams().Add("charset", sip.String{Str: "UTF-8"}).Add("format", sip.String{Str: "flowed"})}}},
+		{mimeInput("Content-Disposition: session"),
+			&mimeResult{pass, &sip.ContentDispositionHeader{Disposition: "session", Params: noParams}}},
+		{mimeInput("Content-Disposition: signal;handling=optional"),
+			&mimeResult{pass, &sip.ContentDispositionHeader{Disposition: "signal",
+				Params: sip.NewParams().Add("handling", sip.String{Str: "optional"})}}},
+		{mimeInput("Content-Type: application"), &mimeResult{fail, nil}},
+		{mimeInput("Content-Type: application/sdp;charset=\"utf-8"), &mimeResult{fail, nil}},
+		{mimeInput("Content-Disposition: ;handling=optional"), &mimeResult{fail, nil}},
+	}, t)
+}
+
+func TestTypedHeaders(t *testing.T) {
+	expires := sip.Expires(3600)
+	zeroExpires := sip.Expires(0)
+	maxExpires := sip.Expires(4294967295)
+	minExpires := sip.MinExpires(60)
+	date := time.Date(2010, time.November, 13, 23, 29, 0, 0, time.UTC)
+	doTests([]test{
+		{typedHeaderInput("Expires: 3600"), &typedHeaderResult{pass, &expires}},
+		{typedHeaderInput("Expires: 0"), &typedHeaderResult{pass, &zeroExpires}},
+		{typedHeaderInput("Expires: 4294967296"), &typedHeaderResult{pass, &maxExpires}},
+		{typedHeaderInput("Min-Expires: 60"), &typedHeaderResult{pass, &minExpires}},
+		{typedHeaderInput("Allow: INVITE, ACK,OPTIONS ,BYE"),
+			&typedHeaderResult{pass, &sip.AllowHeader{Methods: []sip.RequestMethod{sip.INVITE, sip.ACK, sip.OPTIONS, sip.BYE}}}},
+		{typedHeaderInput("Allow: "), &typedHeaderResult{pass, &sip.AllowHeader{Methods: []sip.RequestMethod{}}}},
+		{typedHeaderInput("Accept: application/sdp;level=1, application/x-private, text/*"),
+			&typedHeaderResult{pass, &sip.AcceptHeader{MediaRanges: []*sip.MediaRange{
+				{Type: "application", SubType: "sdp", Params: sip.NewParams().Add("level", sip.String{Str: "1"})},
+				{Type: "application", SubType: "x-private", Params: noParams},
+				{Type: "text", SubType: "*", Params: noParams},
+			}}}},
+		{typedHeaderInput(`Accept: text/plain;charset="a,b"`),
+			&typedHeaderResult{pass, &sip.AcceptHeader{MediaRanges: []*sip.MediaRange{
+				{Type: "text", SubType: "plain", Params: sip.NewParams().Add("charset", sip.String{Str: "a,b"})},
+			}}}},
+		{typedHeaderInput("Retry-After: 120"),
+			&typedHeaderResult{pass, &sip.RetryAfterHeader{Seconds: 120, Params: noParams}}},
+		{typedHeaderInput("Retry-After: 18000;duration=3600"),
+			&typedHeaderResult{pass, &sip.RetryAfterHeader{Seconds: 18000,
+				Params: sip.NewParams().Add("duration", sip.String{Str: "3600"})}}},
+		{typedHeaderInput("Retry-After: 18000 (in a (very) long meeting) ;duration=3600"),
+			&typedHeaderResult{pass, &sip.RetryAfterHeader{Seconds: 18000, Comment: "in a (very) long meeting",
+				Params: sip.NewParams().Add("duration", sip.String{Str: "3600"})}}},
+		{typedHeaderInput("Timestamp: 54"), &typedHeaderResult{pass, &sip.TimestampHeader{Timestamp: 54}}},
+		{typedHeaderInput("Timestamp: 54.25 0.5"), &typedHeaderResult{pass, &sip.TimestampHeader{Timestamp: 54.25, Delay: 0.5}}},
+		{typedHeaderInput("Date: Sat, 13 Nov 2010 23:29:00 GMT"), &typedHeaderResult{pass, &sip.DateHeader{Time: date}}},
+		{typedHeaderInput(`Warning: 307 isi.edu "Session parameter 'foo' not understood"`),
+			&typedHeaderResult{pass, &sip.WarningHeader{Warnings: []*sip.Warning{
+				{Code: 307, Agent: "isi.edu", Text: "Session parameter 'foo' not understood"},
+			}}}},
+		{typedHeaderInput(`Warning: 301 isi.edu "Incompatible network address type 'E.164'", 399 192.0.2.1:5060 "say \"hi\", bye"`),
+			&typedHeaderResult{pass, &sip.WarningHeader{Warnings: []*sip.Warning{
+				{Code: 301, Agent: "isi.edu", Text: "Incompatible network address type 'E.164'"},
+				{Code: 399, Agent: "192.0.2.1:5060", Text: `say "hi", bye`},
+			}}}},
+		{typedHeaderInput("Expires: -1"), &typedHeaderResult{fail, nil}},
+		{typedHeaderInput("Expires: soon"), &typedHeaderResult{fail, nil}},
+		{typedHeaderInput("Min-Expires: 4294967296"), &typedHeaderResult{fail, nil}},
+		{typedHeaderInput("Allow: INVITE ACK"), &typedHeaderResult{fail, nil}},
+		{typedHeaderInput("Accept: application"), &typedHeaderResult{fail, nil}},
+		{typedHeaderInput("Retry-After: (comment)"), &typedHeaderResult{fail, nil}},
+		{typedHeaderInput("Retry-After: 120 (unclosed"), &typedHeaderResult{fail, nil}},
+		{typedHeaderInput("Timestamp: 1 2 3"), &typedHeaderResult{fail, nil}},
+		{typedHeaderInput("Date: 13 Nov 2010"), &typedHeaderResult{fail, nil}},
+		{typedHeaderInput("Warning: 307 isi.edu unquoted"), &typedHeaderResult{fail, nil}},
+		{typedHeaderInput(`Warning: 3070 isi.edu "text"`), &typedHeaderResult{fail, nil}},
+	}, t)
+}
+
+func TestEventHeaders(t *testing.T) {
+	doTests([]test{
+		{typedHeaderInput("Event: presence"), &typedHeaderResult{pass, &sip.EventHeader{EventType: "presence", Params: noParams}}},
+		{typedHeaderInput("o: refer;id=93809824"),
+			&typedHeaderResult{pass, &sip.EventHeader{EventType: "refer",
+				Params: sip.NewParams().Add("id", sip.String{Str: "93809824"})}}},
+		{typedHeaderInput("Event: presence.winfo"), &typedHeaderResult{pass, &sip.EventHeader{EventType: "presence.winfo", Params: noParams}}},
+		{typedHeaderInput("Allow-Events: presence, dialog,message-summary"),
+			&typedHeaderResult{pass, &sip.AllowEventsHeader{EventTypes: []string{"presence", "dialog", "message-summary"}}}},
+		{typedHeaderInput("u: refer"), &typedHeaderResult{pass, &sip.AllowEventsHeader{EventTypes: []string{"refer"}}}},
+		{typedHeaderInput("Subscription-State: active;expires=600"),
+			&typedHeaderResult{pass, &sip.SubscriptionStateHeader{State: sip.SubscriptionActive,
+				Params: sip.NewParams().Add("expires", sip.String{Str: "600"})}}},
+		{typedHeaderInput("Subscription-State: Pending"),
+			&typedHeaderResult{pass, &sip.SubscriptionStateHeader{State: sip.SubscriptionPending, Params: noParams}}},
+		{typedHeaderInput("Subscription-State: terminated;reason=rejected;retry-after=3600"),
+			&typedHeaderResult{pass, &sip.SubscriptionStateHeader{State: sip.SubscriptionTerminated,
+				Params: sip.NewParams().Add("reason", sip.String{Str: "rejected"}).Add("retry-after", sip.String{Str: "3600"})}}},
+		{typedHeaderInput("Event: "), &typedHeaderResult{fail, nil}},
+		{typedHeaderInput("Event: presence dialog"), &typedHeaderResult{fail, nil}},
+		{typedHeaderInput("Allow-Events: presence,,dialog"), &typedHeaderResult{fail, nil}},
+		{typedHeaderInput("Subscription-State: active;expires=soon"), &typedHeaderResult{fail, nil}},
+		{typedHeaderInput("Subscription-State: terminated;retry-after=-1"), &typedHeaderResult{fail, nil}},
+	}, t)
+
+	subState := &sip.SubscriptionStateHeader{State: sip.SubscriptionTerminated,
+		Params: sip.NewParams().Add("reason", sip.String{Str: "timeout"}).Add("retry-after", sip.String{Str: "30"})}
+	if retryAfter, ok := subState.RetryAfter(); !ok || retryAfter != 30*time.Second {
+		t.Errorf("[FAIL] Unexpected retry-after of %s: %s", subState, retryAfter)
+	}
+	if _, ok := subState.Expires(); ok {
+		t.Errorf("[FAIL] Unexpected expires of %s", subState)
+	}
+	if subState.Reason() != "timeout" {
+		t.Errorf("[FAIL] Unexpected reason of %s: %s", subState, subState.Reason())
+	}
+	event := &sip.EventHeader{EventType: "refer", Params: sip.NewParams().Add("id", sip.String{Str: "1"})}
+	if event.Matches(&sip.EventHeader{EventType: "refer"}) || !event.Matches(event.Clone().(*sip.EventHeader)) {
+		t.Errorf("[FAIL] Unexpected matching of %s", event)
+	}
+}
+
+func TestReferHeaders(t *testing.T) {
+	carol := &sip.SipUri{User: sip.String{Str: "carol"}, Host: "cleveland.example.org", UriParams: noParams, Headers: noParams}
+	dave := &sip.SipUri{User: sip.String{Str: "dave"}, Host: "denver.example.org", UriParams: noParams,
+		Headers: sip.NewParams().Add("Replaces", sip.String{Str: "12345%40192.168.118.3%3Bto-tag%3D12345%3Bfrom-tag%3D5FFE-3994"})}
+	bob := &sip.SipUri{User: sip.String{Str: "bob"}, Host: "biloxi.example.com", UriParams: noParams, Headers: noParams}
+	referSubFalse := sip.ReferSub(false)
+	doTests([]test{
+		{typedHeaderInput("Refer-To: sip:carol@cleveland.example.org"),
+			&typedHeaderResult{pass, &sip.ReferToHeader{Address: carol, Params: noParams}}},
+		{typedHeaderInput("r: <sip:dave@denver.example.org?Replaces=12345%40192.168.118.3%3Bto-tag%3D12345%3Bfrom-tag%3D5FFE-3994>"),
+			&typedHeaderResult{pass, &sip.ReferToHeader{Address: dave, Params: noParams}}},
+		{typedHeaderInput("Referred-By: \"Bob\" <sip:bob@biloxi.example.com>;cid=\"20398823.2UWQFN309shb3@biloxi.example.com\""),
+			&typedHeaderResult{pass, &sip.ReferredByHeader{DisplayName: sip.String{Str: "Bob"}, Address: bob,
+				Params: sip.NewParams().Add("cid", sip.String{Str: "20398823.2UWQFN309shb3@biloxi.example.com"})}}},
+		{typedHeaderInput("b: <sip:bob@biloxi.example.com>"),
+			&typedHeaderResult{pass, &sip.ReferredByHeader{Address: bob, Params: noParams}}},
+		{typedHeaderInput("Replaces: 425928@bobster.example.org;to-tag=7743;from-tag=6472"),
+			&typedHeaderResult{pass, &sip.ReplacesHeader{CallID: "425928@bobster.example.org", ToTag: "7743", FromTag: "6472", Params: noParams}}},
+		{typedHeaderInput("Replaces: 98732@sip.example.com;from-tag=r33th4x0r;to-tag=ff87ff;early-only"),
+			&typedHeaderResult{pass, &sip.ReplacesHeader{CallID: "98732@sip.example.com", ToTag: "ff87ff", FromTag: "r33th4x0r",
+				EarlyOnly: true, Params: noParams}}},
+		{typedHeaderInput("Refer-Sub: false"), &typedHeaderResult{pass, referSubFalse}},
+		{typedHeaderInput("Refer-Sub: TRUE"), &typedHeaderResult{pass, sip.ReferSub(true)}},
+		{typedHeaderInput("Refer-To: <sip:carol@cleveland.example.org>, <sip:dave@denver.example.org>"), &typedHeaderResult{fail, nil}},
+		{typedHeaderInput("Refer-To: *"), &typedHeaderResult{fail, nil}},
+		{typedHeaderInput("Replaces: 425928@bobster.example.org;to-tag=7743"), &typedHeaderResult{fail, nil}},
+		{typedHeaderInput("Refer-Sub: maybe"), &typedHeaderResult{fail, nil}},
+	}, t)
+
+	replaces := &sip.ReplacesHeader{CallID: "425928@bobster.example.org", ToTag: "7743", FromTag: "6472"}
+	if replaces.DialogID() != sip.MakeDialogID("425928@bobster.example.org", "7743", "6472") {
+		t.Errorf("[FAIL] Unexpected dialog ID of %s: %s", replaces, replaces.DialogID())
+	}
+}
+
 func TestViaHeaders(t *testing.T) {
 	// branch=z9hG4bKnashds8
 	fooEqBar := sip.NewParams().Add("foo", sip.String{Str: "bar"})
@@ -1290,6 +1574,69 @@ func TestUnstreamedParse8(t *testing.T) {
 	test.Test(t)
 }
 
+// Test headers which fail typed parsing, they are kept as generic headers.
+func TestUnstreamedParse9(t *testing.T) {
+	test := ParserTest{false, []parserTestStep{
+		{"SIP/2.0 200 OK\r\n" +
+			"CSeq: 2 INVITE\r\n" +
+			"Date: 13 Nov 2010\r\n" +
+			"Expires: soon\r\n" +
+			"\r\n",
+			sip.NewResponse(
+				"SIP/2.0",
+				200,
+				"OK",
+				[]sip.Header{
+					&sip.CSeq{SeqNo: 2, MethodName: sip.INVITE},
+					&sip.GenericHeader{HeaderName: "Date", Contents: "13 Nov 2010"},
+					&sip.GenericHeader{HeaderName: "Expires", Contents: "soon"},
+				},
+				"",
+			),
+			nil,
+			nil},
+	}}
+
+	test.Test(t)
+}
+
+// Test bodies which must be kept byte for byte.
+func TestParseBodyBytes(t *testing.T) {
+	for _, body := range [][]byte{
+		{0x00, 0x01, 0x0d, 0x0a, 0xff, 0xfe},
+		[]byte("\r\n"),
+		[]byte("  "),
+	} {
+		for _, streamed := range []bool{false, true} {
+			output := make(chan sip.Message)
+			errs := make(chan error)
+			p := NewParser(output, errs, streamed)
+
+			data := append([]byte("SIP/2.0 200 OK\r\n"+
+				"CSeq: 2 INVITE\r\n"+
+				fmt.Sprintf("Content-Length: %d\r\n", len(body))+
+				"\r\n"), body...)
+			if _, err := p.Write(data); err != nil {
+				t.Errorf("[FAIL] Write of body %q failed: %s", body, err)
+				p.Stop()
+				continue
+			}
+
+			select {
+			case msg := <-output:
+				if !bytes.Equal(msg.BodyBytes(), body) {
+					t.Errorf("[FAIL] Expected body %q, got %q", body, msg.BodyBytes())
+				}
+			case err := <-errs:
+				t.Errorf("[FAIL] Parse of body %q failed: %s", body, err)
+			case <-time.After(time.Second):
+				t.Errorf("[FAIL] Parse of body %q timed out", body)
+			}
+			p.Stop()
+		}
+	}
+}
+
 // TODO: Error cases for unstreamed parse.
 // TODO: Multiple writes on unstreamed parse.
 
@@ -1500,6 +1847,40 @@ func (expected *sipUriResult) equals(other result) (equal bool, reason string) {
 	return
 }
 
+type uriInput string
+
+func (data uriInput) String() string {
+	return string(data)
+}
+
+func (data uriInput) evaluate() result {
+	output, err := ParseUri(string(data))
+	return &uriResult{err, output}
+}
+
+type uriResult struct {
+	err error
+	uri sip.Uri
+}
+
+func (expected *uriResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*uriResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.uri.String())
+	} else if actual.err != nil {
+		// Expected error. Test passes immediately.
+		return true, ""
+	}
+
+	equal = expected.uri.Equals(actual.uri) && expected.uri.String() == actual.uri.String()
+	if !equal {
+		reason = fmt.Sprintf("expected result %s, but got %s", expected.uri.String(), actual.uri.String())
+	}
+	return
+}
+
 type hostPortInput string
 
 func (data hostPortInput) String() string {
@@ -1935,6 +2316,219 @@ func (expected *maxForwardsResult) equals(other result) (equal bool, reason stri
 	return true, ""
 }
 
+type rSeqInput string
+
+func (data rSeqInput) String() string {
+	return string(data)
+}
+
+func (data rSeqInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &rSeqResult{err, *(headers[0].(*sip.RSeq))}
+	} else if len(headers) == 0 {
+		return &rSeqResult{err, sip.RSeq(0)}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by RSeq test: %s", string(data)))
+	}
+}
+
+type rSeqResult struct {
+	err    error
+	header sip.RSeq
+}
+
+func (expected *rSeqResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*rSeqResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && expected.header != actual.header {
+		return false, fmt.Sprintf("unexpected RSeq value: expected \"%d\", got \"%d\"",
+			expected.header, actual.header)
+	}
+	return true, ""
+}
+
+type rAckInput string
+
+func (data rAckInput) String() string {
+	return string(data)
+}
+
+func (data rAckInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &rAckResult{err, headers[0].(*sip.RAck)}
+	} else if len(headers) == 0 {
+		return &rAckResult{err, &sip.RAck{}}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by RAck test: %s", string(data)))
+	}
+}
+
+type rAckResult struct {
+	err    error
+	header *sip.RAck
+}
+
+func (expected *rAckResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*rAckResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && !expected.header.Equals(actual.header) {
+		return false, fmt.Sprintf("unexpected RAck: expected \"%s\", got \"%s\"",
+			expected.header, actual.header)
+	}
+	return true, ""
+}
+
+type routeInput string
+
+func (data routeInput) String() string {
+	return string(data)
+}
+
+func (data routeInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &routeResult{err, headers[0]}
+	} else if len(headers) == 0 {
+		return &routeResult{err, nil}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by Route test: %s", string(data)))
+	}
+}
+
+type routeResult struct {
+	err    error
+	header sip.Header
+}
+
+func (expected *routeResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*routeResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && !expected.header.Equals(actual.header) {
+		return false, fmt.Sprintf("unexpected header: expected \"%s\", got \"%s\"",
+			expected.header, actual.header)
+	}
+	return true, ""
+}
+
+type authInput string
+
+func (data authInput) String() string {
+	return string(data)
+}
+
+func (data authInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &authResult{err, headers[0]}
+	} else if len(headers) == 0 {
+		return &authResult{err, nil}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by auth test: %s", string(data)))
+	}
+}
+
+type authResult struct {
+	err    error
+	header sip.Header
+}
+
+func (expected *authResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*authResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && !expected.header.Equals(actual.header) {
+		return false, fmt.Sprintf("unexpected header: expected \"%s\", got \"%s\"",
+			expected.header, actual.header)
+	}
+	return true, ""
+}
+
+type mimeInput string
+
+func (data mimeInput) String() string {
+	return string(data)
+}
+
+func (data mimeInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &mimeResult{err, headers[0]}
+	} else if len(headers) == 0 {
+		return &mimeResult{err, nil}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by MIME header test: %s", string(data)))
+	}
+}
+
+type mimeResult struct {
+	err    error
+	header sip.Header
+}
+
+func (expected *mimeResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*mimeResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && !expected.header.Equals(actual.header) {
+		return false, fmt.Sprintf("unexpected header: expected \"%s\", got \"%s\"",
+			expected.header, actual.header)
+	}
+	return true, ""
+}
+
+type typedHeaderInput string
+
+func (data typedHeaderInput) String() string {
+	return string(data)
+}
+
+func (data typedHeaderInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &typedHeaderResult{err, headers[0]}
+	} else if len(headers) == 0 {
+		return &typedHeaderResult{err, nil}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by typed header test: %s", string(data)))
+	}
+}
+
+type typedHeaderResult struct {
+	err    error
+	header sip.Header
+}
+
+func (expected *typedHeaderResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*typedHeaderResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && !expected.header.Equals(actual.header) {
+		return false, fmt.Sprintf("unexpected header: expected \"%s\", got \"%s\"",
+			expected.header, actual.header)
+	} else if actual.err == nil && expected.header.String() != actual.header.String() {
+		return false, fmt.Sprintf("unexpected rendering: expected \"%s\", got \"%s\"",
+			expected.header, actual.header)
+	}
+	return true, ""
+}
+
 type contentLengthInput string
 
 func (data contentLengthInput) String() string {
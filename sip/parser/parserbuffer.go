@@ -77,22 +77,22 @@ func (pb *parserBuffer) NextLine() (response string, err error) {
 	}
 }
 
-// Block until the buffer contains at least n characters.
-// Return precisely those n characters, then delete them from the buffer.
-func (pb *parserBuffer) NextChunk(n int) (response string, err error) {
-	var data = make([]byte, n)
+// Block until the buffer contains at least n bytes.
+// Return precisely those n bytes, then delete them from the buffer.
+func (pb *parserBuffer) NextChunk(n int) (response []byte, err error) {
+	response = make([]byte, n)
 
 	var read int
 	for total := 0; total < n; {
-		read, err = pb.reader.Read(data[total:])
+		read, err = pb.reader.Read(response[total:])
 		total += read
 		if err != nil {
+			response = response[:total]
 			return
 		}
 	}
 
-	response = string(data)
-	pb.Log().Debugf("ParserBuffer %p returns chunk '%s'", pb, response)
+	pb.Log().Debugf("ParserBuffer %p returns chunk of %d bytes", pb, len(response))
 	return
 }
 
@@ -0,0 +1,28 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/masterclock/gosip/log"
+	"github.com/masterclock/gosip/sip"
+)
+
+// ParseSipFrag parses message/sipfrag body (RFC 3420) with the SIP parser, e.g. 'SIP/2.0 180 Ringing'
+// in NOTIFY of REFER. Fragment must start with the start line, headers and body are optional.
+func ParseSipFrag(body string) (sip.Message, error) {
+	data := strings.TrimLeft(body, "\r\n")
+	if data == "" {
+		return nil, fmt.Errorf("empty message/sipfrag body")
+	}
+	// bare LF line breaks are tolerated in the bodies composed by hand
+	if !strings.Contains(data, "\r\n") {
+		data = strings.Replace(data, "\n", "\r\n", -1)
+	}
+	// fragment without body may lack the empty line terminating the headers
+	if !strings.Contains(data, "\r\n\r\n") {
+		data = strings.TrimRight(data, "\r\n") + "\r\n\r\n"
+	}
+
+	return ParseMessage([]byte(data), log.StandardLogger())
+}
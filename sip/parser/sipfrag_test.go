@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/masterclock/gosip/sip"
+)
+
+func TestParseSipFrag(t *testing.T) {
+	msg, err := ParseSipFrag(sip.StatusFrag(180, "Ringing"))
+	if err != nil {
+		t.Fatalf("[FAIL] ParseSipFrag of status line failed: %s", err)
+	}
+	res, ok := msg.(sip.Response)
+	if !ok {
+		t.Fatalf("[FAIL] Expected response, got %s", msg.Short())
+	}
+	if res.StatusCode() != 180 || res.Reason() != "Ringing" {
+		t.Errorf("[FAIL] Unexpected status line: %s", res.StartLine())
+	}
+
+	msg, err = ParseSipFrag("SIP/2.0 603 Declined\r\nRetry-After: 120\r\n")
+	if err != nil {
+		t.Fatalf("[FAIL] ParseSipFrag of status line with headers failed: %s", err)
+	}
+	if hdrs := msg.GetHeaders("Retry-After"); len(hdrs) != 1 {
+		t.Errorf("[FAIL] Expected 1 Retry-After header, got %d", len(hdrs))
+	}
+
+	msg, err = ParseSipFrag("INVITE sip:alice@atlanta.example.com SIP/2.0\n" +
+		"Contact: <sip:alice@atlanta.example.com>\n" +
+		"Content-Type: text/plain\n" +
+		"Content-Length: 5\n" +
+		"\n" +
+		"hello")
+	if err != nil {
+		t.Fatalf("[FAIL] ParseSipFrag of request with body failed: %s", err)
+	}
+	req, ok := msg.(sip.Request)
+	if !ok {
+		t.Fatalf("[FAIL] Expected request, got %s", msg.Short())
+	}
+	if req.Method() != sip.INVITE || req.Body() != "hello" {
+		t.Errorf("[FAIL] Unexpected request: %s", req)
+	}
+
+	for _, body := range []string{"", "\r\n", "garbage"} {
+		if _, err := ParseSipFrag(body); err == nil {
+			t.Errorf("[FAIL] Unexpected success of ParseSipFrag(%q)", body)
+		}
+	}
+}
+
+func TestNewSipFrag(t *testing.T) {
+	res := sip.NewResponse("SIP/2.0", 486, "Busy Here", []sip.Header{
+		&sip.GenericHeader{HeaderName: "Retry-After", Contents: "60"},
+		&sip.GenericHeader{HeaderName: "Subject", Contents: "lunch"},
+	}, "")
+	frag := sip.NewSipFrag(res, "Retry-After")
+	if frag != "SIP/2.0 486 Busy Here\r\nRetry-After: 60\r\n" {
+		t.Errorf("[FAIL] Unexpected message/sipfrag body: %q", frag)
+	}
+
+	msg, err := ParseSipFrag(frag)
+	if err != nil {
+		t.Fatalf("[FAIL] ParseSipFrag of %q failed: %s", frag, err)
+	}
+	if msg.StartLine() != res.StartLine() {
+		t.Errorf("[FAIL] Unexpected start line: %s", msg.StartLine())
+	}
+}
@@ -16,9 +16,13 @@ type Request interface {
 	SetMethod(method RequestMethod)
 	Recipient() Uri
 	SetRecipient(recipient Uri)
+	// HasDestination returns true if the destination is set explicitly by SetDestination,
+	// e.g. to send the request through the outbound proxy.
+	HasDestination() bool
 	/* Common Helpers */
 	IsInvite() bool
 	IsAck() bool
+	IsCancel() bool
 }
 
 type request struct {
@@ -90,8 +94,12 @@ func (req *request) Clone() Message {
 		req.Recipient().Clone(),
 		req.SipVersion(),
 		req.headers.CloneHeaders(),
-		req.Body(),
+		"",
 	)
+	if len(req.body) > 0 {
+		// Content-Length is already cloned with the headers
+		clone.SetBodyBytes(append([]byte{}, req.body...), false)
+	}
 	clone.SetLog(req.Log())
 	return clone
 }
@@ -104,6 +112,10 @@ func (req *request) IsAck() bool {
 	return req.Method() == ACK
 }
 
+func (req *request) IsCancel() bool {
+	return req.Method() == CANCEL
+}
+
 func (req *request) Source() string {
 	if req.src != "" {
 		return req.src
@@ -137,12 +149,17 @@ func (req *request) Source() string {
 	return fmt.Sprintf("%v:%v", host, port)
 }
 
+func (req *request) HasDestination() bool {
+	return req.dest != ""
+}
+
 func (req *request) Destination() string {
 	if req.dest != "" {
 		return req.dest
 	}
 
-	uri, ok := req.Recipient().(*SipUri)
+	// RFC 3261 - 8.1.2. - request is sent to the loose router on the top of the route set
+	uri, ok := NextHop(req).(*SipUri)
 	if !ok {
 		return ""
 	}
@@ -157,3 +174,87 @@ func (req *request) Destination() string {
 
 	return fmt.Sprintf("%v:%v", host, port)
 }
+
+// Routes returns all 'Route' header values of the message in the order of appearance.
+func Routes(msg Message) []*Address {
+	routes := make([]*Address, 0)
+	for _, header := range msg.GetHeaders("Route") {
+		if route, ok := header.(*RouteHeader); ok {
+			routes = append(routes, route.Addresses...)
+		}
+	}
+
+	return routes
+}
+
+// RecordRoutes returns all 'Record-Route' header values of the message in the order of appearance.
+func RecordRoutes(msg Message) []*Address {
+	routes := make([]*Address, 0)
+	for _, header := range msg.GetHeaders("Record-Route") {
+		if recordRoute, ok := header.(*RecordRouteHeader); ok {
+			routes = append(routes, recordRoute.Addresses...)
+		}
+	}
+
+	return routes
+}
+
+// SetRoutes replaces 'Route' headers of the message with a single header holding the routes.
+// All 'Route' headers are removed if the routes are empty.
+func SetRoutes(msg Message, routes []*Address) {
+	msg.RemoveHeader("Route")
+	if len(routes) > 0 {
+		msg.AppendHeader(&RouteHeader{Addresses: routes})
+	}
+}
+
+// IsLooseRouter returns true if the route URI has 'lr' param (RFC 3261 - 19.1.1.).
+func IsLooseRouter(route *Address) bool {
+	if route == nil {
+		return false
+	}
+	uri, ok := route.Uri.(*SipUri)
+	return ok && uri != nil && uri.UriParams != nil && uri.UriParams.Has("lr")
+}
+
+// NextHop returns URI of the next hop of the request (RFC 3261 - 8.1.2.):
+// the top 'Route' URI if it is a loose router, Request-URI otherwise.
+func NextHop(req Request) Uri {
+	if route, ok := req.Route(); ok && len(route.Addresses) > 0 && IsLooseRouter(route.Addresses[0]) {
+		return route.Addresses[0].Uri
+	}
+
+	return req.Recipient()
+}
+
+// RewriteStrictRoute prepares the request for the strict router on the top of the route set (RFC 3261 - 16.6. - 6.):
+// Request-URI is appended to the route set, the top route is removed and placed into Request-URI.
+// Returns false if the request is left untouched: there is no route set or the top route is a loose router.
+func RewriteStrictRoute(req Request) bool {
+	routes := Routes(req)
+	if len(routes) == 0 || IsLooseRouter(routes[0]) {
+		return false
+	}
+	target, ok := req.Recipient().(*SipUri)
+	if !ok {
+		return false
+	}
+	top, ok := routes[0].Uri.(*SipUri)
+	if !ok {
+		return false
+	}
+
+	recipient := top.Clone().(*SipUri)
+	// RFC 3261 - 12.2.1.1. - URI headers are not allowed in Request-URI
+	recipient.Headers = nil
+	rest := make([]*Address, 0, len(routes))
+	for _, route := range routes[1:] {
+		rest = append(rest, route.Clone())
+	}
+	rest = append(rest, &Address{Uri: target.Clone(), Params: NewParams()})
+
+	req.SetRecipient(recipient)
+	SetRoutes(req, rest)
+
+	return true
+}
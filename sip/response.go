@@ -25,6 +25,17 @@ type Response interface {
 	IsGlobalError() bool
 }
 
+// Rejection is returned by application callbacks, e.g. proxy.Router, to answer the request with the status
+// instead of processing it, e.g. 404 for unknown user.
+type Rejection struct {
+	StatusCode StatusCode
+	Reason     string
+}
+
+func (err *Rejection) Error() string {
+	return fmt.Sprintf("request rejected with %d %s", err.StatusCode, err.Reason)
+}
+
 type response struct {
 	message
 	status StatusCode
@@ -94,8 +105,12 @@ func (res *response) Clone() Message {
 		res.StatusCode(),
 		res.Reason(),
 		res.headers.CloneHeaders(),
-		res.Body(),
+		"",
 	)
+	if len(res.body) > 0 {
+		// Content-Length is already cloned with the headers
+		clone.SetBodyBytes(append([]byte{}, res.body...), false)
+	}
 	clone.SetLog(res.Log())
 	return clone
 }
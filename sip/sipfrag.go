@@ -0,0 +1,30 @@
+package sip
+
+import (
+	"bytes"
+)
+
+// SipFragContentType is the media type of SIP message fragments (RFC 3420).
+const SipFragContentType = "message/sipfrag"
+
+// NewSipFrag encodes the start line and the named headers of the message as message/sipfrag body (RFC 3420),
+// e.g. NOTIFY of REFER reports the status line of the response on the referred request (RFC 3515 - 2.4.5.).
+// Body of the message is not included.
+func NewSipFrag(msg Message, headerNames ...string) string {
+	var buffer bytes.Buffer
+	buffer.WriteString(msg.StartLine())
+	buffer.WriteString("\r\n")
+	for _, name := range headerNames {
+		for _, header := range msg.GetHeaders(name) {
+			buffer.WriteString(header.String())
+			buffer.WriteString("\r\n")
+		}
+	}
+
+	return buffer.String()
+}
+
+// StatusFrag returns message/sipfrag body with the status line only, e.g. 'SIP/2.0 100 Trying'.
+func StatusFrag(statusCode StatusCode, reason string) string {
+	return NewSipFrag(NewResponse("SIP/2.0", statusCode, reason, nil, ""))
+}
@@ -0,0 +1,324 @@
+package sip
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Visual separators which may appear in telephone numbers only for readability (RFC 3966 - 5.1.1.).
+const telVisualSeparators = "-.()"
+
+// TelUri is a telephone number URI (RFC 3966), e.g. 'tel:+1-201-555-0123' or 'tel:7042;phone-context=example.com'.
+type TelUri struct {
+	// Number is the global number starting with '+' or the local number.
+	// Visual separators are removed by the parser.
+	Number string
+
+	// Any parameters of the URI, e.g. 'phone-context', 'isub' or 'ext'.
+	UriParams Params
+}
+
+// NormalizeTelNumber removes visual separators from the telephone number, e.g. '+1-(201)-555.0123'.
+func NormalizeTelNumber(number string) string {
+	var buffer bytes.Buffer
+	for _, r := range number {
+		if !strings.ContainsRune(telVisualSeparators, r) {
+			buffer.WriteRune(r)
+		}
+	}
+
+	return buffer.String()
+}
+
+// TelUri can be used as address of redirect contacts (RFC 3261 - 20.10.).
+func (uri *TelUri) IsWildcard() bool {
+	return false
+}
+
+// IsGlobal returns true if the URI has the global number in E.164 format.
+func (uri *TelUri) IsGlobal() bool {
+	return strings.HasPrefix(uri.Number, "+")
+}
+
+// PhoneContext returns 'phone-context' param which is mandatory for the local numbers.
+func (uri *TelUri) PhoneContext() (string, bool) {
+	return uri.param("phone-context")
+}
+
+// Isub returns ISDN subaddress of the number.
+func (uri *TelUri) Isub() (string, bool) {
+	return uri.param("isub")
+}
+
+// Ext returns extension of the number.
+func (uri *TelUri) Ext() (string, bool) {
+	return uri.param("ext")
+}
+
+func (uri *TelUri) param(name string) (string, bool) {
+	if uri.UriParams == nil {
+		return "", false
+	}
+	for _, key := range uri.UriParams.Keys() {
+		if strings.EqualFold(key, name) {
+			val, _ := uri.UriParams.Get(key)
+			if val == nil {
+				return "", true
+			}
+			return val.String(), true
+		}
+	}
+
+	return "", false
+}
+
+// Determine if the tel URI is equal to the specified URI according to the rules laid down in RFC 3966 - 4.
+// Numbers are compared without visual separators, parameters are compared by name regardless of the order,
+// 'phone-context' is compared as a host name or as a global number.
+func (uri *TelUri) Equals(val interface{}) bool {
+	other, ok := val.(*TelUri)
+	if !ok {
+		return false
+	}
+	if !strings.EqualFold(NormalizeTelNumber(uri.Number), NormalizeTelNumber(other.Number)) {
+		return false
+	}
+
+	params, otherParams := telParams(uri.UriParams), telParams(other.UriParams)
+	if len(params) != len(otherParams) {
+		return false
+	}
+	for key, val := range params {
+		otherVal, ok := otherParams[key]
+		if !ok || !strings.EqualFold(val, otherVal) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// telParams returns params with lowercase names, visual separators are removed
+// from 'ext' and from global number of 'phone-context'.
+func telParams(params Params) map[string]string {
+	result := make(map[string]string)
+	if params == nil {
+		return result
+	}
+	for _, key := range params.Keys() {
+		name := strings.ToLower(key)
+		var value string
+		if val, _ := params.Get(key); val != nil {
+			value = val.String()
+		}
+		if name == "ext" || (name == "phone-context" && strings.HasPrefix(value, "+")) {
+			value = NormalizeTelNumber(value)
+		}
+		result[name] = value
+	}
+
+	return result
+}
+
+// Generates the string representation of a TelUri struct.
+func (uri *TelUri) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("tel:")
+	buffer.WriteString(uri.Number)
+
+	if (uri.UriParams != nil) && uri.UriParams.Length() > 0 {
+		buffer.WriteString(";")
+		buffer.WriteString(uri.UriParams.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+// Clone the tel URI.
+func (uri *TelUri) Clone() Uri {
+	return &TelUri{
+		Number:    uri.Number,
+		UriParams: cloneWithNil(uri.UriParams),
+	}
+}
+
+// GenericUri is an absolute URI of the scheme which is not natively supported (RFC 3986),
+// e.g. 'urn:service:sos' or 'mailto:alice@example.com'.
+type GenericUri struct {
+	// Scheme of the URI, e.g. 'urn'.
+	Scheme string
+	// Opaque is the scheme specific part following the colon.
+	Opaque string
+}
+
+// GenericUri can be used as address of redirect contacts (RFC 3261 - 20.10.).
+func (uri *GenericUri) IsWildcard() bool {
+	return false
+}
+
+// Determine if the URI is equal to the specified URI according to the rules laid down in RFC 3986 - 6.2.2.
+// Scheme and hexadecimal digits of percent-encodings are case-insensitive, percent-encoded unreserved
+// characters are decoded. Namespace identifier of the URNs is case-insensitive too (RFC 8141 - 3.).
+func (uri *GenericUri) Equals(val interface{}) bool {
+	other, ok := val.(*GenericUri)
+	if !ok {
+		return false
+	}
+	if !strings.EqualFold(uri.Scheme, other.Scheme) {
+		return false
+	}
+
+	opaque, otherOpaque := normalizePercentEncoding(uri.Opaque), normalizePercentEncoding(other.Opaque)
+	if strings.EqualFold(uri.Scheme, "urn") {
+		nid, nss := splitUrn(opaque)
+		otherNid, otherNss := splitUrn(otherOpaque)
+		return strings.EqualFold(nid, otherNid) && nss == otherNss
+	}
+
+	return opaque == otherOpaque
+}
+
+func splitUrn(opaque string) (nid string, nss string) {
+	parts := strings.SplitN(opaque, ":", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
+
+// normalizePercentEncoding uppercases hexadecimal digits of percent-encodings
+// and decodes percent-encoded unreserved characters (RFC 3986 - 6.2.2.1., 6.2.2.2.).
+func normalizePercentEncoding(text string) string {
+	if !strings.Contains(text, "%") {
+		return text
+	}
+
+	var buffer bytes.Buffer
+	for i := 0; i < len(text); i++ {
+		if text[i] != '%' || i+2 >= len(text) || !isHex(text[i+1]) || !isHex(text[i+2]) {
+			buffer.WriteByte(text[i])
+			continue
+		}
+		hex := strings.ToUpper(text[i+1 : i+3])
+		if c := unhex(hex[0])<<4 | unhex(hex[1]); isUnreserved(c) {
+			buffer.WriteByte(c)
+		} else {
+			buffer.WriteString("%" + hex)
+		}
+		i += 2
+	}
+
+	return buffer.String()
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	if c <= '9' {
+		return c - '0'
+	}
+
+	return c - 'A' + 10
+}
+
+func isUnreserved(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// Generates the string representation of a GenericUri struct.
+func (uri *GenericUri) String() string {
+	return uri.Scheme + ":" + uri.Opaque
+}
+
+// Clone the generic URI.
+func (uri *GenericUri) Clone() Uri {
+	return &GenericUri{
+		Scheme: uri.Scheme,
+		Opaque: uri.Opaque,
+	}
+}
+
+// NormalizeUri returns the canonical form of the URI which can be used as a map key.
+// URIs which are equal according to their Equals method have the same canonical form:
+// case-insensitive parts are lowercased, escaping is normalized, params and headers are sorted.
+// Params of SIP URIs other than 'transport', 'user', 'ttl', 'method' and 'maddr' are dropped,
+// since they are ignored when only one of compared URIs has them (RFC 3261 - 19.1.4.).
+func NormalizeUri(uri Uri) string {
+	switch uri := uri.(type) {
+	case *SipUri:
+		var buffer bytes.Buffer
+		if uri.IsEncrypted {
+			buffer.WriteString("sips:")
+		} else {
+			buffer.WriteString("sip:")
+		}
+		if user := normalizePercentEncoding(maybeString(uri.User)); user != "" {
+			buffer.WriteString(user)
+			if password := normalizePercentEncoding(maybeString(uri.Password)); password != "" {
+				buffer.WriteString(":" + password)
+			}
+			buffer.WriteString("@")
+		}
+		buffer.WriteString(strings.ToLower(uri.Host))
+		if uri.Port != nil {
+			buffer.WriteString(fmt.Sprintf(":%d", *uri.Port))
+		}
+
+		params := uriParams(uri.UriParams)
+		for name := range params {
+			if !sipUriSignificantParams[name] {
+				delete(params, name)
+			}
+		}
+		writeSortedParams(&buffer, params, ";", ";", true)
+		writeSortedParams(&buffer, uriParams(uri.Headers), "?", "&", false)
+
+		return buffer.String()
+	case *TelUri:
+		var buffer bytes.Buffer
+		buffer.WriteString("tel:")
+		buffer.WriteString(strings.ToLower(NormalizeTelNumber(uri.Number)))
+		writeSortedParams(&buffer, telParams(uri.UriParams), ";", ";", true)
+
+		return buffer.String()
+	case *GenericUri:
+		opaque := normalizePercentEncoding(uri.Opaque)
+		if strings.EqualFold(uri.Scheme, "urn") {
+			nid, nss := splitUrn(opaque)
+			opaque = strings.ToLower(nid) + ":" + nss
+		}
+
+		return strings.ToLower(uri.Scheme) + ":" + opaque
+	default:
+		return uri.String()
+	}
+}
+
+func writeSortedParams(buffer *bytes.Buffer, params map[string]string, start string, sep string, lowerValues bool) {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for idx, name := range names {
+		if idx == 0 {
+			buffer.WriteString(start)
+		} else {
+			buffer.WriteString(sep)
+		}
+		buffer.WriteString(name)
+		if val := params[name]; val != "" {
+			if lowerValues {
+				val = strings.ToLower(val)
+			}
+			buffer.WriteString("=" + val)
+		}
+	}
+}
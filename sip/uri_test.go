@@ -0,0 +1,173 @@
+package sip
+
+import "testing"
+
+func TestTelUri_Equals(t *testing.T) {
+	tests := []struct {
+		description string
+		uri         *TelUri
+		other       Uri
+		equal       bool
+	}{
+		{"Visual separators",
+			&TelUri{Number: "+1-201-555-0123", UriParams: NewParams()},
+			&TelUri{Number: "+1(201)555.0123", UriParams: NewParams()}, true},
+		{"Params order and case",
+			&TelUri{Number: "7042", UriParams: NewParams().Add("phone-context", String{Str: "Example.COM"}).Add("ext", String{Str: "1-2"})},
+			&TelUri{Number: "7042", UriParams: NewParams().Add("EXT", String{Str: "12"}).Add("phone-context", String{Str: "example.com"})}, true},
+		{"Global phone-context",
+			&TelUri{Number: "8631234", UriParams: NewParams().Add("phone-context", String{Str: "+1-914-555"})},
+			&TelUri{Number: "863-1234", UriParams: NewParams().Add("phone-context", String{Str: "+1914555"})}, true},
+		{"Hex digits of local number",
+			&TelUri{Number: "7ab", UriParams: NewParams().Add("phone-context", String{Str: "example.com"})},
+			&TelUri{Number: "7AB", UriParams: NewParams().Add("phone-context", String{Str: "example.com"})}, true},
+		{"Global and local numbers",
+			&TelUri{Number: "+7042", UriParams: NewParams()},
+			&TelUri{Number: "7042", UriParams: NewParams().Add("phone-context", String{Str: "example.com"})}, false},
+		{"Different numbers",
+			&TelUri{Number: "+12015550123", UriParams: NewParams()},
+			&TelUri{Number: "+12015550124", UriParams: NewParams()}, false},
+		{"Param present in one URI only",
+			&TelUri{Number: "+12015550123", UriParams: NewParams().Add("isub", String{Str: "1411"})},
+			&TelUri{Number: "+12015550123", UriParams: nil}, false},
+		{"Different phone-context",
+			&TelUri{Number: "7042", UriParams: NewParams().Add("phone-context", String{Str: "example.com"})},
+			&TelUri{Number: "7042", UriParams: NewParams().Add("phone-context", String{Str: "example.org"})}, false},
+		{"Other scheme",
+			&TelUri{Number: "+12015550123", UriParams: NewParams()},
+			&GenericUri{Scheme: "tel", Opaque: "+12015550123"}, false},
+	}
+
+	for _, test := range tests {
+		if equal := test.uri.Equals(test.other); equal != test.equal {
+			t.Errorf("[FAIL] %s: %s equals %s: expected %t, got %t", test.description, test.uri, test.other, test.equal, equal)
+		}
+	}
+}
+
+func TestGenericUri_Equals(t *testing.T) {
+	tests := []struct {
+		description string
+		uri         *GenericUri
+		other       Uri
+		equal       bool
+	}{
+		{"Scheme case",
+			&GenericUri{Scheme: "urn", Opaque: "service:sos"},
+			&GenericUri{Scheme: "URN", Opaque: "service:sos"}, true},
+		{"URN namespace case",
+			&GenericUri{Scheme: "urn", Opaque: "service:sos"},
+			&GenericUri{Scheme: "urn", Opaque: "SERVICE:sos"}, true},
+		{"URN namespace specific string case",
+			&GenericUri{Scheme: "urn", Opaque: "service:sos"},
+			&GenericUri{Scheme: "urn", Opaque: "service:SOS"}, false},
+		{"Percent-encoding case",
+			&GenericUri{Scheme: "mailto", Opaque: "a%2cb@example.com"},
+			&GenericUri{Scheme: "mailto", Opaque: "a%2Cb@example.com"}, true},
+		{"Percent-encoded unreserved char",
+			&GenericUri{Scheme: "mailto", Opaque: "%61lice@example.com"},
+			&GenericUri{Scheme: "mailto", Opaque: "alice@example.com"}, true},
+		{"Percent-encoded reserved char",
+			&GenericUri{Scheme: "mailto", Opaque: "a%2Cb@example.com"},
+			&GenericUri{Scheme: "mailto", Opaque: "a,b@example.com"}, false},
+		{"Different schemes",
+			&GenericUri{Scheme: "mailto", Opaque: "alice@example.com"},
+			&GenericUri{Scheme: "im", Opaque: "alice@example.com"}, false},
+		{"SIP URI",
+			&GenericUri{Scheme: "sip", Opaque: "example.com"},
+			&SipUri{Host: "example.com"}, false},
+	}
+
+	for _, test := range tests {
+		if equal := test.uri.Equals(test.other); equal != test.equal {
+			t.Errorf("[FAIL] %s: %s equals %s: expected %t, got %t", test.description, test.uri, test.other, test.equal, equal)
+		}
+	}
+}
+
+func TestSipUri_Equals(t *testing.T) {
+	port5060 := Port(5060)
+	tests := []struct {
+		description string
+		uri         *SipUri
+		other       Uri
+		equal       bool
+	}{
+		{"Host and params case",
+			&SipUri{User: String{Str: "alice"}, Host: "AtLanTa.CoM", UriParams: NewParams().Add("Transport", String{Str: "TCP"})},
+			&SipUri{User: String{Str: "alice"}, Host: "atlanta.com", UriParams: NewParams().Add("transport", String{Str: "tcp"})}, true},
+		{"Escaped user",
+			&SipUri{User: String{Str: "%61lice"}, Host: "atlanta.com"},
+			&SipUri{User: String{Str: "alice"}, Host: "AtLanTa.CoM"}, true},
+		{"Params order",
+			&SipUri{User: String{Str: "carol"}, Host: "chicago.com", UriParams: NewParams().Add("newparam", String{Str: "5"}).Add("security", String{Str: "on"})},
+			&SipUri{User: String{Str: "carol"}, Host: "chicago.com", UriParams: NewParams().Add("security", String{Str: "on"}).Add("newparam", String{Str: "5"})}, true},
+		{"Other param present in one URI only",
+			&SipUri{User: String{Str: "carol"}, Host: "chicago.com", UriParams: NewParams().Add("newparam", String{Str: "5"})},
+			&SipUri{User: String{Str: "carol"}, Host: "chicago.com", UriParams: NewParams().Add("security", String{Str: "on"})}, true},
+		{"Headers order",
+			&SipUri{Host: "biloxi.com", Headers: NewParams().Add("subject", String{Str: "project"}).Add("priority", String{Str: "urgent"})},
+			&SipUri{Host: "biloxi.com", Headers: NewParams().Add("priority", String{Str: "urgent"}).Add("subject", String{Str: "project"})}, true},
+		{"User case",
+			&SipUri{User: String{Str: "ALICE"}, Host: "atlanta.com"},
+			&SipUri{User: String{Str: "alice"}, Host: "atlanta.com"}, false},
+		{"Password case",
+			&SipUri{User: String{Str: "alice"}, Password: String{Str: "Secret"}, Host: "atlanta.com"},
+			&SipUri{User: String{Str: "alice"}, Password: String{Str: "secret"}, Host: "atlanta.com"}, false},
+		{"Default port",
+			&SipUri{User: String{Str: "bob"}, Host: "biloxi.com"},
+			&SipUri{User: String{Str: "bob"}, Host: "biloxi.com", Port: &port5060}, false},
+		{"Transport present in one URI only",
+			&SipUri{User: String{Str: "bob"}, Host: "biloxi.com", UriParams: NewParams().Add("transport", String{Str: "udp"})},
+			&SipUri{User: String{Str: "bob"}, Host: "biloxi.com"}, false},
+		{"Maddr present in one URI only",
+			&SipUri{User: String{Str: "bob"}, Host: "biloxi.com"},
+			&SipUri{User: String{Str: "bob"}, Host: "biloxi.com", UriParams: NewParams().Add("maddr", String{Str: "239.255.255.1"})}, false},
+		{"Different values of other param",
+			&SipUri{Host: "chicago.com", UriParams: NewParams().Add("security", String{Str: "off"})},
+			&SipUri{Host: "chicago.com", UriParams: NewParams().Add("security", String{Str: "on"})}, false},
+		{"Header present in one URI only",
+			&SipUri{Host: "biloxi.com", Headers: NewParams().Add("subject", String{Str: "project"})},
+			&SipUri{Host: "biloxi.com"}, false},
+		{"SIP and SIPS",
+			&SipUri{User: String{Str: "bob"}, Host: "biloxi.com"},
+			&SipUri{IsEncrypted: true, User: String{Str: "bob"}, Host: "biloxi.com"}, false},
+		{"Other scheme",
+			&SipUri{User: String{Str: "bob"}, Host: "biloxi.com"},
+			&GenericUri{Scheme: "sip", Opaque: "bob@biloxi.com"}, false},
+	}
+
+	for _, test := range tests {
+		if equal := test.uri.Equals(test.other); equal != test.equal {
+			t.Errorf("[FAIL] %s: %s equals %s: expected %t, got %t", test.description, test.uri, test.other, test.equal, equal)
+		}
+		if test.equal && NormalizeUri(test.uri) != NormalizeUri(test.other) {
+			t.Errorf("[FAIL] %s: expected equal normalized URIs, got %s and %s",
+				test.description, NormalizeUri(test.uri), NormalizeUri(test.other))
+		}
+	}
+}
+
+func TestNormalizeUri(t *testing.T) {
+	port5060 := Port(5060)
+	tests := []struct {
+		uri      Uri
+		expected string
+	}{
+		{&SipUri{User: String{Str: "%61lice"}, Host: "AtLanTa.CoM", Port: &port5060,
+			UriParams: NewParams().Add("lr", nil).Add("Transport", String{Str: "TCP"}).Add("maddr", String{Str: "Example.COM"}),
+			Headers:   NewParams().Add("Subject", String{Str: "Project"}).Add("priority", String{Str: "urgent"})},
+			"sip:alice@atlanta.com:5060;maddr=example.com;transport=tcp?priority=urgent&subject=Project"},
+		{&SipUri{IsEncrypted: true, Host: "biloxi.com"}, "sips:biloxi.com"},
+		{&TelUri{Number: "7042", UriParams: NewParams().Add("phone-context", String{Str: "Example.COM"}).Add("EXT", String{Str: "1-2"})},
+			"tel:7042;ext=12;phone-context=example.com"},
+		{&GenericUri{Scheme: "URN", Opaque: "Service:SOS"}, "urn:service:SOS"},
+		{WildcardUri{}, "*"},
+	}
+
+	for _, test := range tests {
+		if normalized := NormalizeUri(test.uri); normalized != test.expected {
+			t.Errorf("[FAIL] Expected normalized %s to be %s, got %s", test.uri, test.expected, normalized)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package testutils
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/masterclock/gosip/transport"
+)
+
+// FakeDNS is an in-memory transport.DNS implementation.
+type FakeDNS struct {
+	mu    *sync.RWMutex
+	naptr map[string][]*transport.NAPTR
+	srv   map[string][]*net.SRV
+	hosts map[string][]string
+}
+
+func NewFakeDNS() *FakeDNS {
+	return &FakeDNS{
+		mu:    new(sync.RWMutex),
+		naptr: make(map[string][]*transport.NAPTR),
+		srv:   make(map[string][]*net.SRV),
+		hosts: make(map[string][]string),
+	}
+}
+
+func (dns *FakeDNS) AddNAPTR(name string, records ...*transport.NAPTR) *FakeDNS {
+	dns.mu.Lock()
+	defer dns.mu.Unlock()
+	name = fakeDNSName(name)
+	dns.naptr[name] = append(dns.naptr[name], records...)
+	return dns
+}
+
+func (dns *FakeDNS) AddSRV(name string, records ...*net.SRV) *FakeDNS {
+	dns.mu.Lock()
+	defer dns.mu.Unlock()
+	name = fakeDNSName(name)
+	dns.srv[name] = append(dns.srv[name], records...)
+	return dns
+}
+
+func (dns *FakeDNS) AddHost(host string, addrs ...string) *FakeDNS {
+	dns.mu.Lock()
+	defer dns.mu.Unlock()
+	host = fakeDNSName(host)
+	dns.hosts[host] = append(dns.hosts[host], addrs...)
+	return dns
+}
+
+func (dns *FakeDNS) LookupNAPTR(name string) ([]*transport.NAPTR, error) {
+	dns.mu.RLock()
+	defer dns.mu.RUnlock()
+	records, ok := dns.naptr[fakeDNSName(name)]
+	if !ok {
+		return nil, fakeDNSNotFound(name)
+	}
+	return records, nil
+}
+
+func (dns *FakeDNS) LookupSRV(name string) ([]*net.SRV, error) {
+	dns.mu.RLock()
+	defer dns.mu.RUnlock()
+	records, ok := dns.srv[fakeDNSName(name)]
+	if !ok {
+		return nil, fakeDNSNotFound(name)
+	}
+	return records, nil
+}
+
+func (dns *FakeDNS) LookupHost(host string) ([]string, error) {
+	dns.mu.RLock()
+	defer dns.mu.RUnlock()
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	addrs, ok := dns.hosts[fakeDNSName(host)]
+	if !ok {
+		return nil, fakeDNSNotFound(host)
+	}
+	return addrs, nil
+}
+
+func fakeDNSName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func fakeDNSNotFound(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/masterclock/gosip/log"
 	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/transport"
 )
 
 type MockListener struct {
@@ -92,11 +93,15 @@ func (conn *MockConn) RemoteAddr() net.Addr {
 }
 
 type MockTransportLayer struct {
-	logger  log.LocalLogger
-	InMsgs  chan sip.Message
-	InErrs  chan error
-	OutMsgs chan sip.Message
-	done    chan struct{}
+	logger log.LocalLogger
+	// Resolver locates request targets, when nil request destination is used as the single target.
+	Resolver transport.Resolver
+	// Unreliable makes all transports unreliable, e.g. to test retransmission timers.
+	Unreliable bool
+	InMsgs     chan sip.Message
+	InErrs     chan error
+	OutMsgs    chan sip.Message
+	done       chan struct{}
 }
 
 func NewMockTransportLayer() *MockTransportLayer {
@@ -130,8 +135,27 @@ func (tpl *MockTransportLayer) Send(msg sip.Message) error {
 	return nil
 }
 
+// SendTo sets target address as the message destination and sends it.
+func (tpl *MockTransportLayer) SendTo(msg sip.Message, target *transport.Target) error {
+	if target != nil {
+		msg.SetDestination(target.Addr())
+	}
+	return tpl.Send(msg)
+}
+
+func (tpl *MockTransportLayer) Resolve(req sip.Request) ([]*transport.Target, error) {
+	if tpl.Resolver != nil {
+		return tpl.Resolver.Resolve(req.Recipient())
+	}
+	target, err := transport.NewTargetFromAddr(req.Destination())
+	if err != nil {
+		return nil, err
+	}
+	return []*transport.Target{target}, nil
+}
+
 func (tpl *MockTransportLayer) IsReliable(network string) bool {
-	return true
+	return !tpl.Unreliable
 }
 
 func (tpl *MockTransportLayer) String() string {
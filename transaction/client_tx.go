@@ -15,6 +15,9 @@ import (
 type ClientTx interface {
 	Tx
 	Responses() <-chan sip.Response
+	// Cancel cancels INVITE transaction (RFC 3261 - 9.1.).
+	// CANCEL request is sent only after provisional response arrives.
+	Cancel() error
 }
 
 type clientTx struct {
@@ -25,27 +28,79 @@ type clientTx struct {
 	timer_b      timing.Timer
 	timer_d_time time.Duration // Current duration of timer D.
 	timer_d      timing.Timer
+	timer_m      timing.Timer
 	reliable     bool
-	mu           *sync.RWMutex
+	// located targets, the first one is the current
+	targets []*transport.Target
+	// request sent to the current target, the copy of origin with new Via branch after failover
+	request sip.Request
+	// keys of the requests sent to the next targets, origin key stays the key of the transaction
+	failoverKeys []TxKey
+	// registers key of the request sent to the next target in the transaction layer
+	addKey func(key TxKey)
+	// provisional or final response received
+	provisional bool
+	final       bool
+	// CANCEL requested by TU and sent
+	canceling  bool
+	canceled   bool
+	sendCancel func(cancel sip.Request, target *transport.Target) error
+	// To tags of the 2xx responses passed up in Accepted state
+	accepted map[string]bool
+	// RFC 3262 - 4. - RSeq of the last reliable provisional response
+	// and number of the sent PRACK requests by To tag
+	rseqs     map[string]uint32
+	pracks    map[string]uint32
+	sendPrack func(prack sip.Request) error
+	newPrack  PrackFactory
+	mu        *sync.RWMutex
+	// serializes received responses, so FSM actions see the response that spun them
+	rmu *sync.Mutex
+	// guards sending to responses and errs against closing, separated from mu,
+	// so Cancel is not blocked by the consumer that does not read
+	chmu *sync.RWMutex
 }
 
 func NewClientTx(origin sip.Request, tpl transport.Layer) (ClientTx, error) {
+	return newClientTx(origin, tpl), nil
+}
+
+func newClientTx(origin sip.Request, tpl transport.Layer) *clientTx {
 	tx := new(clientTx)
 	tx.logger = log.NewSafeLocalLogger()
 	tx.origin = origin
+	tx.request = origin
 	tx.tpl = tpl
 	tx.responses = make(chan sip.Response)
 	tx.errs = make(chan error, 1)
 	tx.done = make(chan bool, 1)
+	tx.accepted = make(map[string]bool)
+	tx.rseqs = make(map[string]uint32)
+	tx.pracks = make(map[string]uint32)
+	tx.timings = DefaultTimings()
 	tx.mu = new(sync.RWMutex)
+	tx.rmu = new(sync.Mutex)
+	tx.chmu = new(sync.RWMutex)
 
-	return tx, nil
+	return tx
 }
 
 func (tx *clientTx) Init() error {
 	tx.initFSM()
 
-	if err := tx.tpl.Send(tx.Origin()); err != nil {
+	// RFC 3263 - 4. - locate servers to try,
+	// targets can be already known, e.g. CANCEL is sent to the target of INVITE
+	if len(tx.targets) == 0 {
+		targets, err := tx.tpl.Resolve(tx.Origin())
+		if err != nil {
+			tx.lastErr = err
+			tx.fsm.Spin(client_input_transport_err)
+			return err
+		}
+		tx.targets = targets
+	}
+
+	if err := tx.send(); err != nil {
 		tx.lastErr = err
 		tx.fsm.Spin(client_input_transport_err)
 		return err
@@ -57,9 +112,34 @@ func (tx *clientTx) Init() error {
 		return err
 	} else {
 		tx.key = key
-		if viaHop, ok := tx.Origin().ViaHop(); ok {
-			tx.reliable = tx.tpl.IsReliable(viaHop.Transport)
-		}
+	}
+
+	tx.startTimers()
+
+	// lastErr is not read here, timers already started may resend the request
+	return nil
+}
+
+// startTimers (re)starts timers A, B, which depend on the transport of the current target.
+func (tx *clientTx) startTimers() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if viaHop, ok := tx.Origin().ViaHop(); ok {
+		tx.reliable = tx.tpl.IsReliable(viaHop.Transport)
+	}
+	if tx.timer_a != nil {
+		tx.timer_a.Stop()
+		tx.timer_a = nil
+	}
+	if tx.timer_b != nil {
+		tx.timer_b.Stop()
+	}
+
+	// RFC 3261 - 17.1.2.2. - non-INVITE transaction uses timers E, F, K in the same way
+	retransmit, timeout, wait := tx.timings.timerA(), tx.timings.timerB(), tx.timings.timerD()
+	if !tx.Origin().IsInvite() {
+		retransmit, timeout, wait = tx.timings.timerE(), tx.timings.timerF(), tx.timings.timerK()
 	}
 
 	if tx.reliable {
@@ -70,23 +150,57 @@ func (tx *clientTx) Init() error {
 		// If a reliable transport is being used, the client transaction SHOULD NOT
 		// start timer A (Timer A controls request retransmissions).
 		// Timer A - retransmission
-		tx.Log().Debugf("%s, timer_a set to %v", tx, Timer_A)
-		tx.timer_a_time = Timer_A
+		tx.Log().Debugf("%s, timer_a set to %v", tx, retransmit)
+		tx.timer_a_time = retransmit
 		tx.timer_a = timing.AfterFunc(tx.timer_a_time, func() {
 			tx.Log().Debugf("%s, timer_a fired", tx)
 			tx.fsm.Spin(client_input_timer_a)
 		})
-		// Timer D is set to 32 seconds for unreliable transports
-		tx.timer_d_time = Timer_D
+		// Timer D is set to 64*T1 (32 seconds by default) for unreliable transports
+		tx.timer_d_time = wait
 	}
 	// Timer B - timeout
-	tx.Log().Debugf("%s, timer_b set to %v", tx, Timer_B)
-	tx.timer_b = timing.AfterFunc(Timer_B, func() {
+	tx.Log().Debugf("%s, timer_b set to %v", tx, timeout)
+	tx.timer_b = timing.AfterFunc(timeout, func() {
 		tx.Log().Debugf("%s, timer_b fired", tx)
 		tx.fsm.Spin(client_input_timer_b)
 	})
+}
+
+// send sends origin request to the current target.
+// RFC 3263 - 4.3. - on transport error the next target is tried.
+func (tx *clientTx) send() error {
+	if len(tx.targets) == 0 {
+		return fmt.Errorf("%s has no targets to send %s", tx, tx.Origin().Short())
+	}
+
+	req := tx.sent()
+	var err error
+	for len(tx.targets) > 0 {
+		if err = tx.tpl.SendTo(req, tx.targets[0]); err == nil {
+			return nil
+		}
+		tx.Log().Warnf("%s failed to send %s to %s: %s", tx, req.Short(), tx.targets[0], err)
+		tx.targets = tx.targets[1:]
+	}
 
-	return tx.lastErr
+	return err
+}
+
+// sent returns request sent to the current target.
+func (tx *clientTx) sent() sip.Request {
+	tx.mu.RLock()
+	defer tx.mu.RUnlock()
+
+	return tx.request
+}
+
+// sentKeys returns keys of the requests sent on failover.
+func (tx *clientTx) sentKeys() []TxKey {
+	tx.mu.RLock()
+	defer tx.mu.RUnlock()
+
+	return append([]TxKey{}, tx.failoverKeys...)
 }
 
 func (tx *clientTx) String() string {
@@ -101,8 +215,29 @@ func (tx *clientTx) Receive(msg sip.Message) error {
 			msg.String(),
 		}
 	}
+
+	tx.rmu.Lock()
+	defer tx.rmu.Unlock()
+
+	if tx.Origin().IsInvite() && isReliableProvisional(res) {
+		// RFC 3262 - 4. - each reliable provisional response is passed up and acknowledged once
+		rseq, cseqNo, ok := tx.acceptReliable(res)
+		if !ok {
+			tx.Log().Debugf("%s discards retransmitted or out of order %s", tx, res.Short())
+			return nil
+		}
+		if err := tx.prack(res, rseq, cseqNo); err != nil {
+			tx.Log().Warnf("%s failed to send PRACK: %s", tx, err)
+		}
+	}
 	tx.mu.Lock()
 	tx.lastResp = res
+	if res.IsProvisional() {
+		tx.provisional = true
+	} else {
+		tx.final = true
+	}
+	canceling := tx.canceling && !tx.canceled && !tx.final
 	tx.mu.Unlock()
 	var input fsm.Input
 	switch {
@@ -114,7 +249,223 @@ func (tx *clientTx) Receive(msg sip.Message) error {
 		input = client_input_300_plus
 	}
 
-	return tx.fsm.Spin(input)
+	err := tx.fsm.Spin(input)
+	// RFC 3261 - 9.1. - postponed CANCEL is sent after the first provisional response
+	if canceling && res.IsProvisional() {
+		if err := tx.cancel(); err != nil {
+			tx.Log().Warnf("%s failed to cancel: %s", tx, err)
+		}
+	}
+
+	return err
+}
+
+func (tx *clientTx) Cancel() error {
+	if !tx.Origin().IsInvite() {
+		return fmt.Errorf("%s can not cancel non-INVITE request", tx)
+	}
+	select {
+	case <-tx.done:
+		return fmt.Errorf("%s is terminated", tx)
+	default:
+	}
+
+	tx.mu.Lock()
+	if tx.final {
+		tx.mu.Unlock()
+		return fmt.Errorf("%s has already received final response", tx)
+	}
+	if tx.canceling {
+		tx.mu.Unlock()
+		return nil
+	}
+	tx.canceling = true
+	provisional := tx.provisional
+	tx.mu.Unlock()
+
+	if !provisional {
+		tx.Log().Debugf("%s postpones CANCEL until provisional response", tx)
+		return nil
+	}
+
+	return tx.cancel()
+}
+
+// cancel sends CANCEL request to the target of the origin request.
+func (tx *clientTx) cancel() error {
+	tx.mu.Lock()
+	if tx.canceled {
+		tx.mu.Unlock()
+		return nil
+	}
+	tx.canceled = true
+	var target *transport.Target
+	if len(tx.targets) > 0 {
+		target = tx.targets[0]
+	}
+	tx.mu.Unlock()
+
+	if tx.sendCancel == nil {
+		return fmt.Errorf("%s is not served by transaction layer", tx)
+	}
+	if err := tx.sendCancel(newCancelRequest(tx.sent()), target); err != nil {
+		return err
+	}
+
+	// RFC 3261 - 9.1. - INVITE is considered cancelled if no final response arrives in 64*T1
+	tx.mu.Lock()
+	if tx.timer_b != nil {
+		tx.timer_b.Stop()
+	}
+	tx.timer_b = timing.AfterFunc(tx.timings.timerB(), func() {
+		tx.Log().Debugf("%s, timer_b fired", tx)
+		tx.fsm.Spin(client_input_timer_b)
+	})
+	tx.mu.Unlock()
+
+	return nil
+}
+
+// newCancelRequest builds CANCEL request for the origin request (RFC 3261 - 9.1.).
+func newCancelRequest(origin sip.Request) sip.Request {
+	cancel := sip.NewRequest(
+		sip.CANCEL,
+		origin.Recipient(),
+		origin.SipVersion(),
+		[]sip.Header{},
+		"",
+	)
+	cancel.SetLog(origin.Log())
+
+	// single Via header with the same branch
+	if viaHop, ok := origin.ViaHop(); ok {
+		cancel.AppendHeader(sip.ViaHeader{viaHop.Clone()})
+	}
+	sip.CopyHeaders("Route", origin, cancel)
+	sip.CopyHeaders("From", origin, cancel)
+	sip.CopyHeaders("To", origin, cancel)
+	sip.CopyHeaders("Call-ID", origin, cancel)
+	if cseq, ok := origin.CSeq(); ok {
+		cseq = cseq.Clone().(*sip.CSeq)
+		cseq.MethodName = sip.CANCEL
+		cancel.AppendHeader(cseq)
+	}
+	sip.CopyHeaders("Max-Forwards", origin, cancel)
+	cancel.SetBody("", true)
+
+	return cancel
+}
+
+// acceptReliable checks RSeq of the reliable provisional response,
+// returns RSeq and CSeq number of PRACK if the response is new and received in order.
+func (tx *clientTx) acceptReliable(res sip.Response) (uint32, uint32, bool) {
+	rseq, ok := res.RSeq()
+	if !ok {
+		return 0, 0, false
+	}
+	cseq, ok := tx.Origin().CSeq()
+	if !ok {
+		return 0, 0, false
+	}
+	tag := toTagOf(res)
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if last, ok := tx.rseqs[tag]; ok && uint32(*rseq) != last+1 {
+		return 0, 0, false
+	}
+	tx.rseqs[tag] = uint32(*rseq)
+	// PRACK requests are sent in the early dialog established by the response
+	tx.pracks[tag]++
+
+	return uint32(*rseq), cseq.SeqNo + tx.pracks[tag], true
+}
+
+// prack sends PRACK request for the reliable provisional response.
+func (tx *clientTx) prack(res sip.Response, rseq uint32, cseqNo uint32) error {
+	tx.mu.RLock()
+	final := tx.final
+	tx.mu.RUnlock()
+	if final {
+		return nil
+	}
+	if tx.sendPrack == nil {
+		return fmt.Errorf("%s is not served by transaction layer", tx)
+	}
+	if tx.newPrack == nil {
+		return tx.sendPrack(newPrackRequest(tx.Origin(), res, rseq, cseqNo))
+	}
+
+	prack, err := tx.newPrack(tx.Origin(), res)
+	if err != nil {
+		return err
+	}
+	if _, ok := prack.Via(); !ok {
+		if via, ok := newBranchVia(tx.Origin()); ok {
+			prack.PrependHeader(via)
+		}
+	}
+
+	return tx.sendPrack(prack)
+}
+
+// newPrackRequest builds PRACK request for the reliable provisional response (RFC 3262 - 7.1.).
+func newPrackRequest(origin sip.Request, res sip.Response, rseq uint32, cseqNo uint32) sip.Request {
+	var recipient sip.Uri = origin.Recipient()
+	if contact, ok := res.Contact(); ok {
+		recipient = contact.Address
+	}
+	prack := sip.NewRequest(
+		sip.PRACK,
+		recipient.Clone(),
+		origin.SipVersion(),
+		[]sip.Header{},
+		"",
+	)
+	prack.SetLog(origin.Log())
+
+	if via, ok := newBranchVia(origin); ok {
+		prack.AppendHeader(via)
+	}
+	// route set of the early dialog is taken in reverse order
+	if routes := sip.RecordRoutes(res); len(routes) > 0 {
+		routeSet := make([]*sip.Address, len(routes))
+		for i, route := range routes {
+			routeSet[len(routes)-1-i] = route.Clone()
+		}
+		prack.AppendHeader(&sip.RouteHeader{Addresses: routeSet})
+	}
+	sip.CopyHeaders("From", origin, prack)
+	sip.CopyHeaders("To", res, prack)
+	sip.CopyHeaders("Call-ID", origin, prack)
+	prack.AppendHeader(&sip.CSeq{SeqNo: cseqNo, MethodName: sip.PRACK})
+	if cseq, ok := origin.CSeq(); ok {
+		prack.AppendHeader(&sip.RAck{RSeq: rseq, CSeq: cseq.SeqNo, MethodName: cseq.MethodName})
+	}
+	maxForwards := sip.MaxForwards(70)
+	prack.AppendHeader(maxForwards)
+	prack.SetBody("", true)
+	// RFC 3261 - 12.2.1.1. - the first route becomes Request-URI if it is a strict router
+	sip.RewriteStrictRoute(prack)
+
+	return prack
+}
+
+// newBranchVia returns the first Via hop of the origin request with the new branch,
+// i.e. Via of the new transaction with the same transport.
+func newBranchVia(origin sip.Request) (sip.ViaHeader, bool) {
+	viaHop, ok := origin.ViaHop()
+	if !ok {
+		return nil, false
+	}
+	viaHop = viaHop.Clone()
+	if viaHop.Params == nil {
+		viaHop.Params = sip.NewParams()
+	}
+	viaHop.Params.Add("branch", sip.String{Str: sip.GenerateBranch()})
+
+	return sip.ViaHeader{viaHop}, true
 }
 
 func (tx *clientTx) Responses() <-chan sip.Response {
@@ -130,7 +481,11 @@ func (tx *clientTx) Terminate() {
 	tx.delete()
 }
 
-func (tx clientTx) ack() {
+func (tx *clientTx) ack() {
+	tx.mu.RLock()
+	lastResp := tx.lastResp
+	tx.mu.RUnlock()
+
 	ack := sip.NewRequest(
 		sip.ACK,
 		tx.Origin().Recipient(),
@@ -153,7 +508,8 @@ func (tx clientTx) ack() {
 	cseq = cseq.Clone().(*sip.CSeq)
 	cseq.MethodName = sip.ACK
 	ack.AppendHeader(cseq)
-	via, ok := tx.Origin().Via()
+	// ACK has the branch of the INVITE sent to the current target
+	via, ok := tx.sent().Via()
 	if !ok {
 		tx.Log().Errorf("failed to send ACK request on client transaction %p: %s", tx)
 		return
@@ -161,10 +517,15 @@ func (tx clientTx) ack() {
 	via = via.Clone().(sip.ViaHeader)
 	ack.AppendHeader(via)
 	// Copy headers from response.
-	sip.CopyHeaders("To", tx.lastResp, ack)
+	sip.CopyHeaders("To", lastResp, ack)
 
-	// Send the ACK.
-	err := tx.tpl.Send(ack)
+	// Send the ACK to the same target as the INVITE.
+	var err error
+	if len(tx.targets) > 0 {
+		err = tx.tpl.SendTo(ack, tx.targets[0])
+	} else {
+		err = tx.tpl.Send(ack)
+	}
 	if err != nil {
 		tx.Log().Warnf("failed to send ACK request on client transaction %p: %s", tx, err)
 		tx.lastErr = err
@@ -177,6 +538,7 @@ const (
 	client_state_calling = iota
 	client_state_proceeding
 	client_state_completed
+	client_state_accepted
 	client_state_terminated
 )
 
@@ -190,6 +552,8 @@ const (
 	client_input_timer_d
 	client_input_transport_err
 	client_input_delete
+	client_input_timeout
+	client_input_timer_m
 )
 
 // Initialises the correct kind of FSM based on request method.
@@ -209,10 +573,11 @@ func (tx *clientTx) initInviteFSM() {
 		Index: client_state_calling,
 		Outcomes: map[fsm.Input]fsm.Outcome{
 			client_input_1xx:           {client_state_proceeding, tx.act_passup},
-			client_input_2xx:           {client_state_terminated, tx.act_passup_delete},
+			client_input_2xx:           {client_state_accepted, tx.act_passup_accept},
 			client_input_300_plus:      {client_state_completed, tx.act_invite_final},
 			client_input_timer_a:       {client_state_calling, tx.act_invite_resend},
-			client_input_timer_b:       {client_state_terminated, tx.act_timeout},
+			client_input_timer_b:       {client_state_calling, tx.act_failover},
+			client_input_timeout:       {client_state_terminated, tx.act_timeout},
 			client_input_transport_err: {client_state_terminated, tx.act_trans_err},
 		},
 	}
@@ -222,10 +587,11 @@ func (tx *clientTx) initInviteFSM() {
 		Index: client_state_proceeding,
 		Outcomes: map[fsm.Input]fsm.Outcome{
 			client_input_1xx:      {client_state_proceeding, tx.act_passup},
-			client_input_2xx:      {client_state_terminated, tx.act_passup_delete},
+			client_input_2xx:      {client_state_accepted, tx.act_passup_accept},
 			client_input_300_plus: {client_state_completed, tx.act_invite_final},
 			client_input_timer_a:  {client_state_proceeding, fsm.NO_ACTION},
-			client_input_timer_b:  {client_state_proceeding, fsm.NO_ACTION},
+			client_input_timer_b:  {client_state_proceeding, tx.act_cancel_timeout},
+			client_input_timeout:  {client_state_terminated, tx.act_timeout},
 		},
 	}
 
@@ -243,6 +609,20 @@ func (tx *clientTx) initInviteFSM() {
 		},
 	}
 
+	// Accepted (RFC 6026 - 7.2.)
+	client_state_def_accepted := fsm.State{
+		Index: client_state_accepted,
+		Outcomes: map[fsm.Input]fsm.Outcome{
+			client_input_1xx:      {client_state_accepted, fsm.NO_ACTION},
+			client_input_2xx:      {client_state_accepted, tx.act_passup_accepted},
+			client_input_300_plus: {client_state_accepted, fsm.NO_ACTION},
+			client_input_timer_a:  {client_state_accepted, fsm.NO_ACTION},
+			client_input_timer_b:  {client_state_accepted, fsm.NO_ACTION},
+			client_input_timer_d:  {client_state_accepted, fsm.NO_ACTION},
+			client_input_timer_m:  {client_state_terminated, tx.act_delete},
+		},
+	}
+
 	// Terminated
 	client_state_def_terminated := fsm.State{
 		Index: client_state_terminated,
@@ -253,6 +633,7 @@ func (tx *clientTx) initInviteFSM() {
 			client_input_timer_a:  {client_state_terminated, fsm.NO_ACTION},
 			client_input_timer_b:  {client_state_terminated, fsm.NO_ACTION},
 			client_input_timer_d:  {client_state_terminated, fsm.NO_ACTION},
+			client_input_timer_m:  {client_state_terminated, fsm.NO_ACTION},
 			client_input_delete:   {client_state_terminated, tx.act_delete},
 		},
 	}
@@ -261,6 +642,7 @@ func (tx *clientTx) initInviteFSM() {
 		client_state_def_calling,
 		client_state_def_proceeding,
 		client_state_def_completed,
+		client_state_def_accepted,
 		client_state_def_terminated,
 	)
 
@@ -282,7 +664,8 @@ func (tx *clientTx) initNonInviteFSM() {
 			client_input_2xx:           {client_state_completed, tx.act_non_invite_final},
 			client_input_300_plus:      {client_state_completed, tx.act_non_invite_final},
 			client_input_timer_a:       {client_state_calling, tx.act_non_invite_resend},
-			client_input_timer_b:       {client_state_terminated, tx.act_timeout},
+			client_input_timer_b:       {client_state_calling, tx.act_failover},
+			client_input_timeout:       {client_state_terminated, tx.act_timeout},
 			client_input_transport_err: {client_state_terminated, tx.act_trans_err},
 		},
 	}
@@ -343,7 +726,7 @@ func (tx *clientTx) initNonInviteFSM() {
 
 func (tx *clientTx) resend() {
 	tx.Log().Infof("%s resend %v", tx, tx.Origin().Short())
-	tx.lastErr = tx.tpl.Send(tx.Origin())
+	tx.lastErr = tx.send()
 	if tx.lastErr != nil {
 		tx.fsm.Spin(client_input_transport_err)
 	}
@@ -355,12 +738,12 @@ func (tx *clientTx) passUp() {
 	tx.mu.RUnlock()
 
 	if lastResp != nil {
-		tx.mu.RLock()
+		tx.chmu.RLock()
 		select {
 		case <-tx.done:
 		case tx.responses <- lastResp:
 		}
-		tx.mu.RUnlock()
+		tx.chmu.RUnlock()
 	}
 }
 
@@ -368,17 +751,21 @@ func (tx *clientTx) transportErr() {
 	// todo bloody patch
 	defer func() { recover() }()
 
+	tx.mu.RLock()
+	lastResp := tx.lastResp
+	tx.mu.RUnlock()
+
 	err := &TxTransportError{
-		fmt.Errorf("%s failed to send %s: %s", tx, tx.lastResp.Short(), tx.lastErr),
+		fmt.Errorf("%s failed to send %s: %s", tx, lastResp.Short(), tx.lastErr),
 		tx.Key(),
 		tx.String(),
 	}
-	tx.mu.RLock()
+	tx.chmu.RLock()
 	select {
 	case <-tx.done:
 	case tx.errs <- err:
 	}
-	tx.mu.RUnlock()
+	tx.chmu.RUnlock()
 }
 
 func (tx *clientTx) timeoutErr() {
@@ -390,12 +777,12 @@ func (tx *clientTx) timeoutErr() {
 		tx.Key(),
 		tx.String(),
 	}
-	tx.mu.RLock()
+	tx.chmu.RLock()
 	select {
 	case <-tx.done:
 	case tx.errs <- err:
 	}
-	tx.mu.RUnlock()
+	tx.chmu.RUnlock()
 }
 
 func (tx *clientTx) delete() {
@@ -419,12 +806,15 @@ func (tx *clientTx) delete() {
 	if tx.timer_d != nil {
 		tx.timer_d.Stop()
 	}
+	if tx.timer_m != nil {
+		tx.timer_m.Stop()
+	}
 	tx.mu.Unlock()
 
-	tx.mu.Lock()
+	tx.chmu.Lock()
 	close(tx.responses)
 	close(tx.errs)
-	tx.mu.Unlock()
+	tx.chmu.Unlock()
 }
 
 // Define actions
@@ -443,8 +833,8 @@ func (tx *clientTx) act_non_invite_resend() fsm.Input {
 	tx.mu.Lock()
 	tx.timer_a_time *= 2
 	// For non-INVITE, cap timer A at T2 seconds.
-	if tx.timer_a_time > T2 {
-		tx.timer_a_time = T2
+	if tx.timer_a_time > tx.timings.T2 {
+		tx.timer_a_time = tx.timings.T2
 	}
 	tx.timer_a.Reset(tx.timer_a_time)
 	tx.mu.Unlock()
@@ -499,16 +889,110 @@ func (tx *clientTx) act_trans_err() fsm.Input {
 	return client_input_delete
 }
 
+// RFC 3263 - 4.3. - no response from the current target, try the next one.
+// The request is sent with new Via branch, so it constitutes new transaction for the next target.
+func (tx *clientTx) act_failover() fsm.Input {
+	tx.Log().Debugf("%s, act_failover", tx)
+	if len(tx.targets) < 2 {
+		return client_input_timeout
+	}
+	req, key, err := newFailoverRequest(tx.Origin())
+	if err != nil {
+		tx.lastErr = err
+		return client_input_transport_err
+	}
+	// responses to the new branch are matched to this transaction
+	if tx.addKey != nil {
+		tx.addKey(key)
+	}
+	tx.mu.Lock()
+	tx.targets = tx.targets[1:]
+	tx.request = req
+	tx.failoverKeys = append(tx.failoverKeys, key)
+	tx.mu.Unlock()
+	tx.Log().Infof("%s fails over to %s", tx, tx.targets[0])
+	if err := tx.send(); err != nil {
+		tx.lastErr = err
+		return client_input_transport_err
+	}
+	tx.startTimers()
+	return fsm.NO_INPUT
+}
+
+// newFailoverRequest returns copy of the request with new Via branch and its client transaction key.
+func newFailoverRequest(origin sip.Request) (sip.Request, TxKey, error) {
+	req := origin.Clone().(sip.Request)
+	viaHop, ok := req.ViaHop()
+	if !ok || viaHop.Params == nil {
+		return nil, "", fmt.Errorf("missing 'Via' header in %s", origin.Short())
+	}
+	viaHop.Params.Add("branch", sip.String{Str: sip.GenerateBranch()})
+	key, err := MakeClientTxKey(req)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return req, key, nil
+}
+
+// RFC 3261 - 9.1. - cancelled INVITE has not received final response.
+func (tx *clientTx) act_cancel_timeout() fsm.Input {
+	tx.Log().Debugf("%s, act_cancel_timeout", tx)
+	tx.mu.RLock()
+	canceled := tx.canceled
+	tx.mu.RUnlock()
+	if canceled {
+		return client_input_timeout
+	}
+	return fsm.NO_INPUT
+}
+
 func (tx *clientTx) act_timeout() fsm.Input {
 	tx.Log().Debugf("%s, act_timeout", tx)
 	tx.timeoutErr()
 	return client_input_delete
 }
 
-func (tx *clientTx) act_passup_delete() fsm.Input {
-	tx.Log().Debugf("%s, act_passup_delete", tx)
+// RFC 6026 - 7.2. - pass up the first 2xx response and wait for retransmissions
+// and forked 2xx responses until Timer M fires.
+func (tx *clientTx) act_passup_accept() fsm.Input {
+	tx.Log().Debugf("%s, act_passup_accept", tx)
+
+	tx.mu.Lock()
+	tx.accepted[toTagOf(tx.lastResp)] = true
+	if tx.timer_a != nil {
+		tx.timer_a.Stop()
+	}
+	if tx.timer_b != nil {
+		tx.timer_b.Stop()
+	}
+	tx.timer_m = timing.AfterFunc(tx.timings.timerM(), func() {
+		tx.Log().Debugf("%s, timer_m fired", tx)
+		tx.fsm.Spin(client_input_timer_m)
+	})
+	tx.mu.Unlock()
+
 	tx.passUp()
-	return client_input_delete
+	return fsm.NO_INPUT
+}
+
+// Retransmitted 2xx responses are absorbed, 2xx responses from the other forks are passed up.
+func (tx *clientTx) act_passup_accepted() fsm.Input {
+	tx.mu.Lock()
+	lastResp := tx.lastResp
+	tag := toTagOf(lastResp)
+	retransmitted := tx.accepted[tag]
+	tx.accepted[tag] = true
+	tx.mu.Unlock()
+
+	if retransmitted {
+		tx.Log().Debugf("%s, absorbs retransmitted %s", tx, lastResp.Short())
+		return fsm.NO_INPUT
+	}
+
+	tx.Log().Debugf("%s, act_passup_accepted", tx)
+	tx.passUp()
+	return fsm.NO_INPUT
 }
 
 func (tx *clientTx) act_delete() fsm.Input {
@@ -516,3 +1000,15 @@ func (tx *clientTx) act_delete() fsm.Input {
 	tx.delete()
 	return fsm.NO_INPUT
 }
+
+func toTagOf(res sip.Response) string {
+	if res == nil {
+		return ""
+	}
+	to, ok := res.To()
+	if !ok {
+		return ""
+	}
+
+	return sip.Tag(to.Params)
+}
@@ -1,12 +1,16 @@
 package transaction_test
 
 import (
+	"fmt"
+	"net"
 	"sync"
 	"time"
 
 	"github.com/masterclock/gosip/sip"
 	"github.com/masterclock/gosip/testutils"
+	"github.com/masterclock/gosip/timing"
 	"github.com/masterclock/gosip/transaction"
+	"github.com/masterclock/gosip/transport"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -21,7 +25,7 @@ var _ = Describe("ClientTx", func() {
 
 	BeforeEach(func() {
 		tpl = testutils.NewMockTransportLayer()
-		txl = transaction.NewLayer(tpl)
+		txl = transaction.NewLayer(tpl, nil)
 	})
 	AfterEach(func(done Done) {
 		txl.Cancel()
@@ -194,5 +198,446 @@ var _ = Describe("ClientTx", func() {
 				Expect(msg.String()).To(Equal(notOk.String()))
 			})
 		})
+
+		Context("cancels INVITE", func() {
+			It("should send CANCEL after provisional response", func(done Done) {
+				canceled := testutils.Response([]string{
+					"SIP/2.0 487 Request Terminated",
+					"CSeq: 1 INVITE",
+					"Via: SIP/2.0/UDP " + clientAddr + ";branch=" + inviteBranch,
+					"",
+					"",
+				})
+				cancelOk := testutils.Response([]string{
+					"SIP/2.0 200 OK",
+					"CSeq: 1 CANCEL",
+					"Via: SIP/2.0/UDP " + clientAddr + ";branch=" + inviteBranch,
+					"",
+					"",
+				})
+				wg := new(sync.WaitGroup)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					msg := <-tpl.OutMsgs
+					Expect(msg.String()).To(Equal(invite.String()))
+
+					By("CANCEL is postponed until provisional response")
+					select {
+					case msg = <-tpl.OutMsgs:
+						Fail("unexpected " + msg.Short())
+					case <-time.After(100 * time.Millisecond):
+					}
+					tpl.InMsgs <- trying
+
+					By("CANCEL is sent with the same branch")
+					msg = <-tpl.OutMsgs
+					cancel, ok := msg.(sip.Request)
+					Expect(ok).To(BeTrue())
+					Expect(cancel.Method()).To(Equal(sip.CANCEL))
+					Expect(cancel.Recipient().String()).To(Equal("sip:bob@example.com"))
+					cseq, _ := cancel.CSeq()
+					Expect(cseq.String()).To(Equal("CSeq: 1 CANCEL"))
+					viaHop, _ := cancel.ViaHop()
+					branch, _ := viaHop.Params.Get("branch")
+					Expect(branch.String()).To(Equal(inviteBranch))
+
+					tpl.InMsgs <- cancelOk
+					tpl.InMsgs <- canceled
+					msg = <-tpl.OutMsgs
+					Expect(msg.(sip.Request).Method()).To(Equal(sip.ACK))
+				}()
+
+				responses, err := txl.Request(invite.(sip.Request))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(txl.CancelRequest(invite.(sip.Request))).To(Succeed())
+
+				res := <-responses
+				Expect(res.String()).To(Equal(trying.String()))
+				res = <-responses
+				Expect(res.String()).To(Equal(canceled.String()))
+				wg.Wait()
+				Expect(txl.CancelRequest(invite.(sip.Request))).ToNot(Succeed())
+				close(done)
+			}, 3)
+
+			It("should cancel while provisional response waits for the consumer", func(done Done) {
+				provisional := func(status string) sip.Message {
+					return testutils.Response([]string{
+						"SIP/2.0 " + status,
+						"CSeq: 1 INVITE",
+						"Via: SIP/2.0/UDP " + clientAddr + ";branch=" + inviteBranch,
+						"",
+						"",
+					})
+				}
+				ringing := provisional("180 Ringing")
+				progress := provisional("183 Session Progress")
+
+				sent := make(chan sip.Message, 1)
+				go func() { sent <- <-tpl.OutMsgs }()
+				responses, err := txl.Request(invite.(sip.Request))
+				Expect(err).ToNot(HaveOccurred())
+				Expect((<-sent).String()).To(Equal(invite.String()))
+
+				tpl.InMsgs <- ringing
+				Expect((<-responses).String()).To(Equal(ringing.String()))
+				By("183 is not read, so it blocks in the transaction")
+				tpl.InMsgs <- progress
+				time.Sleep(100 * time.Millisecond)
+
+				canceled := make(chan error, 1)
+				go func() { canceled <- txl.CancelRequest(invite.(sip.Request)) }()
+				msg := <-tpl.OutMsgs
+				Expect(msg.(sip.Request).Method()).To(Equal(sip.CANCEL))
+				Eventually(canceled).Should(Receive(BeNil()))
+
+				Expect((<-responses).String()).To(Equal(progress.String()))
+				tpl.InMsgs <- provisional("487 Request Terminated")
+				Expect((<-responses).StatusCode()).To(Equal(sip.StatusCode(487)))
+				msg = <-tpl.OutMsgs
+				Expect(msg.(sip.Request).Method()).To(Equal(sip.ACK))
+				close(done)
+			}, 3)
+		})
+
+		Context("receives retransmitted and forked 2xx on INVITE", func() {
+			It("should absorb retransmissions and pass up forked 2xx in INVITE tx", func(done Done) {
+				okFork1 := testutils.Response([]string{
+					"SIP/2.0 200 OK",
+					"CSeq: 1 INVITE",
+					"Via: SIP/2.0/UDP " + clientAddr + ";branch=" + inviteBranch,
+					"To: <sip:bob@example.com>;tag=fork1",
+					"",
+					"",
+				})
+				okFork2 := testutils.Response([]string{
+					"SIP/2.0 200 OK",
+					"CSeq: 1 INVITE",
+					"Via: SIP/2.0/UDP " + clientAddr + ";branch=" + inviteBranch,
+					"To: <sip:bob@example.com>;tag=fork2",
+					"",
+					"",
+				})
+				go func() {
+					msg := <-tpl.OutMsgs
+					Expect(msg.String()).To(Equal(invite.String()))
+
+					time.Sleep(100 * time.Millisecond)
+					tpl.InMsgs <- okFork1
+					time.Sleep(100 * time.Millisecond)
+					tpl.InMsgs <- okFork1
+					tpl.InMsgs <- okFork2
+					tpl.InMsgs <- okFork1
+				}()
+
+				responses, err := txl.Request(invite.(sip.Request))
+				Expect(err).ToNot(HaveOccurred())
+
+				res := <-responses
+				Expect(res.String()).To(Equal(okFork1.String()))
+				res = <-responses
+				Expect(res.String()).To(Equal(okFork2.String()))
+
+				select {
+				case res = <-responses:
+					Fail("unexpected " + res.Short())
+				case res = <-txl.Responses():
+					Fail("unexpected unmatched " + res.Short())
+				case <-time.After(200 * time.Millisecond):
+				}
+				close(done)
+			}, 3)
+		})
+		Context("receives concurrent forked 2xx on INVITE", func() {
+			It("should pass up each forked 2xx once in INVITE tx", func(done Done) {
+				forks := make(map[string]sip.Message)
+				for i := 0; i < 5; i++ {
+					tag := fmt.Sprintf("fork%d", i)
+					forks[tag] = testutils.Response([]string{
+						"SIP/2.0 200 OK",
+						"CSeq: 1 INVITE",
+						"Via: SIP/2.0/UDP " + clientAddr + ";branch=" + inviteBranch,
+						"To: <sip:bob@example.com>;tag=" + tag,
+						"",
+						"",
+					})
+				}
+				go func() {
+					msg := <-tpl.OutMsgs
+					Expect(msg.String()).To(Equal(invite.String()))
+
+					time.Sleep(100 * time.Millisecond)
+					for _, res := range forks {
+						tpl.InMsgs <- res
+					}
+				}()
+
+				responses, err := txl.Request(invite.(sip.Request))
+				Expect(err).ToNot(HaveOccurred())
+
+				received := make(map[string]bool)
+				for range forks {
+					res := <-responses
+					to, ok := res.To()
+					Expect(ok).To(BeTrue())
+					tag, ok := to.Params.Get("tag")
+					Expect(ok).To(BeTrue())
+					Expect(received).ToNot(HaveKey(tag.String()))
+					received[tag.String()] = true
+					Expect(res.String()).To(Equal(forks[tag.String()].String()))
+				}
+
+				select {
+				case res := <-responses:
+					Fail("unexpected " + res.Short())
+				case <-time.After(200 * time.Millisecond):
+				}
+				close(done)
+			}, 3)
+		})
+		Context("receives reliable provisional response on INVITE", func() {
+			It("should send PRACK and absorb retransmissions of the response", func(done Done) {
+				ringing := testutils.Response([]string{
+					"SIP/2.0 180 Ringing",
+					"CSeq: 1 INVITE",
+					"Via: SIP/2.0/UDP " + clientAddr + ";branch=" + inviteBranch,
+					"To: <sip:bob@example.com>;tag=bob",
+					"Contact: <sip:bob@192.168.0.1>",
+					"Require: 100rel",
+					"RSeq: 1",
+					"",
+					"",
+				})
+				go func() {
+					msg := <-tpl.OutMsgs
+					Expect(msg.String()).To(Equal(invite.String()))
+
+					time.Sleep(100 * time.Millisecond)
+					tpl.InMsgs <- ringing
+
+					msg = <-tpl.OutMsgs
+					prack, ok := msg.(sip.Request)
+					Expect(ok).To(BeTrue())
+					Expect(prack.Method()).To(Equal(sip.PRACK))
+					Expect(prack.Recipient().String()).To(Equal("sip:bob@192.168.0.1"))
+					cseq, _ := prack.CSeq()
+					Expect(cseq.String()).To(Equal("CSeq: 2 PRACK"))
+					rack, _ := prack.RAck()
+					Expect(rack.String()).To(Equal("RAck: 1 1 INVITE"))
+					to, _ := prack.To()
+					Expect(to.String()).To(Equal("To: <sip:bob@example.com>;tag=bob"))
+
+					time.Sleep(100 * time.Millisecond)
+					tpl.InMsgs <- ringing
+				}()
+
+				responses, err := txl.Request(invite.(sip.Request))
+				Expect(err).ToNot(HaveOccurred())
+
+				res := <-responses
+				Expect(res.String()).To(Equal(ringing.String()))
+
+				select {
+				case res = <-responses:
+					Fail("unexpected " + res.Short())
+				case <-time.After(300 * time.Millisecond):
+				}
+				close(done)
+			}, 3)
+
+			Context("with PRACK factory", func() {
+				BeforeEach(func() {
+					txl.Cancel()
+					<-txl.Done()
+					txl = transaction.NewLayer(tpl, &transaction.LayerConfig{
+						Prack: func(invite sip.Request, res sip.Response) (sip.Request, error) {
+							rseq, _ := res.RSeq()
+							return testutils.Request([]string{
+								"PRACK sip:bob@192.168.0.1 SIP/2.0",
+								"To: <sip:bob@example.com>;tag=bob",
+								"CSeq: 5 PRACK",
+								fmt.Sprintf("RAck: %d 1 INVITE", uint32(*rseq)),
+								"",
+								"",
+							}), nil
+						},
+					})
+				})
+
+				It("should send PRACK built by the factory", func(done Done) {
+					go func() {
+						msg := <-tpl.OutMsgs
+						Expect(msg.String()).To(Equal(invite.String()))
+
+						time.Sleep(100 * time.Millisecond)
+						tpl.InMsgs <- testutils.Response([]string{
+							"SIP/2.0 180 Ringing",
+							"CSeq: 1 INVITE",
+							"Via: SIP/2.0/UDP " + clientAddr + ";branch=" + inviteBranch,
+							"To: <sip:bob@example.com>;tag=bob",
+							"Contact: <sip:bob@192.168.0.1>",
+							"Require: 100rel",
+							"RSeq: 1",
+							"",
+							"",
+						})
+
+						msg = <-tpl.OutMsgs
+						prack, ok := msg.(sip.Request)
+						Expect(ok).To(BeTrue())
+						Expect(prack.Method()).To(Equal(sip.PRACK))
+						cseq, _ := prack.CSeq()
+						Expect(cseq.String()).To(Equal("CSeq: 5 PRACK"))
+						viaHop, ok := prack.ViaHop()
+						Expect(ok).To(BeTrue())
+						Expect(viaHop.Transport).To(Equal("UDP"))
+						branch, _ := viaHop.Params.Get("branch")
+						Expect(branch.String()).ToNot(Equal(inviteBranch))
+						close(done)
+					}()
+
+					_, err := txl.Request(invite.(sip.Request))
+					Expect(err).ToNot(HaveOccurred())
+				}, 3)
+			})
+		})
+	})
+
+	Context("sends request to the located targets", func() {
+		var options sip.Request
+
+		BeforeEach(func() {
+			timing.MockMode = true
+			tpl.Resolver = transport.NewResolver(testutils.NewFakeDNS().
+				AddSRV("_sip._udp.example.com",
+					&net.SRV{Target: "sip1.example.com", Port: 5070, Priority: 10},
+					&net.SRV{Target: "sip2.example.com", Port: 5080, Priority: 20},
+				).
+				AddHost("sip1.example.com", "192.0.2.11").
+				AddHost("sip2.example.com", "192.0.2.12"))
+			options = testutils.Request([]string{
+				"OPTIONS sip:bob@example.com SIP/2.0",
+				"Via: SIP/2.0/UDP " + clientAddr + ";branch=" + sip.GenerateBranch(),
+				"CSeq: 1 OPTIONS",
+				"",
+				"",
+			})
+		})
+		AfterEach(func(done Done) {
+			// transactions read mock mode till the layer is done
+			txl.Cancel()
+			<-txl.Done()
+			timing.MockMode = false
+			close(done)
+		}, 3)
+
+		It("should fail over to the next target on timeout", func(done Done) {
+			var responses <-chan sip.Response
+			wg := new(sync.WaitGroup)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var err error
+				responses, err = txl.Request(options)
+				Expect(err).ToNot(HaveOccurred())
+			}()
+
+			By("request sent to the first target")
+			msg := <-tpl.OutMsgs
+			Expect(msg.Destination()).To(Equal("192.0.2.11:5070"))
+			wg.Wait()
+
+			By("Timer F fires, request sent to the next target")
+			timing.Elapse(transaction.Timer_B)
+			msg = <-tpl.OutMsgs
+			Expect(msg.Destination()).To(Equal("192.0.2.12:5080"))
+
+			By("Timer F fires again, transaction times out")
+			time.Sleep(100 * time.Millisecond)
+			timing.Elapse(transaction.Timer_B)
+			err := <-txl.Errors()
+			_, ok := err.(*transaction.TxTimeoutError)
+			Expect(ok).To(BeTrue())
+			_, ok = <-responses
+			Expect(ok).To(BeFalse())
+			close(done)
+		}, 3)
+
+		It("should send request to the next target with new branch", func(done Done) {
+			branchOf := func(msg sip.Message) string {
+				hop, ok := msg.ViaHop()
+				Expect(ok).To(BeTrue())
+				branch, ok := hop.Params.Get("branch")
+				Expect(ok).To(BeTrue())
+				return branch.String()
+			}
+
+			var responses <-chan sip.Response
+			wg := new(sync.WaitGroup)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var err error
+				responses, err = txl.Request(options)
+				Expect(err).ToNot(HaveOccurred())
+			}()
+
+			first := <-tpl.OutMsgs
+			wg.Wait()
+			timing.Elapse(transaction.Timer_B)
+			next := <-tpl.OutMsgs
+			Expect(next.Destination()).To(Equal("192.0.2.12:5080"))
+			Expect(branchOf(next)).ToNot(Equal(branchOf(first)))
+			Expect(branchOf(first)).To(Equal(branchOf(options)))
+
+			By("response of the next target is matched by the new branch")
+			tpl.InMsgs <- sip.NewResponseFromRequest(next.(sip.Request), 200, "OK", "")
+			res := <-responses
+			Expect(res.StatusCode()).To(Equal(sip.StatusCode(200)))
+			Expect(branchOf(res)).To(Equal(branchOf(next)))
+			close(done)
+		}, 3)
+
+		Context("with configured timings", func() {
+			t1 := 10 * time.Millisecond
+
+			BeforeEach(func() {
+				txl.Cancel()
+				<-txl.Done()
+				txl = transaction.NewLayer(tpl, &transaction.LayerConfig{
+					Timings: transaction.Timings{T1: time.Second},
+					MethodTimings: map[sip.RequestMethod]transaction.Timings{
+						sip.OPTIONS: {T1: t1},
+					},
+				})
+			})
+
+			It("should derive Timer F from T1 of the request method", func(done Done) {
+				go func() {
+					defer GinkgoRecover()
+					_, err := txl.Request(options)
+					Expect(err).ToNot(HaveOccurred())
+				}()
+
+				msg := <-tpl.OutMsgs
+				Expect(msg.Destination()).To(Equal("192.0.2.11:5070"))
+
+				By("Timer F is not fired before 64*T1")
+				time.Sleep(100 * time.Millisecond)
+				timing.Elapse(64*t1 - time.Millisecond)
+				select {
+				case msg = <-tpl.OutMsgs:
+					Fail("unexpected " + msg.Short())
+				case <-time.After(100 * time.Millisecond):
+				}
+
+				By("Timer F fires, request sent to the next target")
+				timing.Elapse(time.Millisecond)
+				msg = <-tpl.OutMsgs
+				Expect(msg.Destination()).To(Equal("192.0.2.12:5080"))
+				close(done)
+			}, 3)
+		})
 	})
 })
@@ -3,6 +3,7 @@ package transaction
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/masterclock/gosip/log"
 	"github.com/masterclock/gosip/sip"
@@ -16,6 +17,8 @@ type Layer interface {
 	Done() <-chan struct{}
 	String() string
 	Request(req sip.Request) (<-chan sip.Response, error)
+	// CancelRequest cancels pending INVITE request sent by Request (RFC 3261 - 9.1.).
+	CancelRequest(req sip.Request) error
 	Respond(res sip.Response) (<-chan sip.Request, error)
 	Transport() transport.Layer
 	// Requests returns channel with new incoming server transactions.
@@ -25,9 +28,34 @@ type Layer interface {
 	Errors() <-chan error
 }
 
+// LayerConfig describes transaction layer options.
+type LayerConfig struct {
+	// Timings - base timer values T1, T2, T4, defaults are used for zero values.
+	Timings Timings
+	// MethodTimings - timings of the transactions by request method,
+	// zero values are taken from Timings.
+	MethodTimings map[sip.RequestMethod]Timings
+	// TryingDelay - delay of the automatic 100 Trying response on INVITE, defaults to Timer_1xx.
+	TryingDelay time.Duration
+	// DisableTrying disables automatic 100 Trying response on INVITE.
+	DisableTrying bool
+	// Prack builds PRACK requests sent on reliable provisional responses, e.g. dialog.Registry.NewPrack,
+	// so CSeq numbers are taken from the early dialog. gosip.Server sets it by default. Without it
+	// PRACK CSeq follows INVITE CSeq, that is valid only if no other request is sent in the early dialog.
+	Prack PrackFactory
+}
+
+// PrackFactory builds PRACK request for the reliable provisional response on INVITE (RFC 3262 - 7.1.).
+// Via header is added by the transaction layer if it is missing.
+type PrackFactory func(invite sip.Request, res sip.Response) (sip.Request, error)
+
 type layer struct {
 	logger       log.LocalLogger
 	tpl          transport.Layer
+	timings      Timings
+	methods      map[sip.RequestMethod]Timings
+	tryingDelay  time.Duration
+	newPrack     PrackFactory
 	requests     chan sip.Request
 	responses    chan sip.Response
 	errs         chan error
@@ -38,10 +66,33 @@ type layer struct {
 	txWgLock     *sync.RWMutex
 }
 
-func NewLayer(tpl transport.Layer) Layer {
+// NewLayer creates transaction layer.
+// 	- tpl - transport layer
+// 	- config - optional layer configuration, may be nil
+func NewLayer(tpl transport.Layer, config *LayerConfig) Layer {
+	if config == nil {
+		config = &LayerConfig{}
+	}
+	timings := config.Timings.Merge(DefaultTimings())
+	methods := make(map[sip.RequestMethod]Timings, len(config.MethodTimings))
+	for method, methodTimings := range config.MethodTimings {
+		methods[method] = methodTimings.Merge(timings)
+	}
+	tryingDelay := config.TryingDelay
+	if tryingDelay <= 0 {
+		tryingDelay = Timer_1xx
+	}
+	if config.DisableTrying {
+		tryingDelay = 0
+	}
+
 	txl := &layer{
 		logger:       log.NewSafeLocalLogger(),
 		tpl:          tpl,
+		timings:      timings,
+		methods:      methods,
+		tryingDelay:  tryingDelay,
+		newPrack:     config.Prack,
 		requests:     make(chan sip.Request),
 		responses:    make(chan sip.Response),
 		errs:         make(chan error),
@@ -115,14 +166,37 @@ func (txl *layer) Request(req sip.Request) (<-chan sip.Response, error) {
 
 	txl.Log().Debugf("%s sends %s", txl, req.Short())
 
-	tx, err := NewClientTx(req, txl.tpl)
-	tx.SetLog(txl.Log())
+	tx, err := txl.request(req, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	err = tx.Init()
-	if err != nil {
+	return tx.Responses(), nil
+}
+
+// methodTimings returns timings of the transactions with the request method.
+func (txl *layer) methodTimings(method sip.RequestMethod) Timings {
+	if timings, ok := txl.methods[method]; ok {
+		return timings
+	}
+
+	return txl.timings
+}
+
+// request starts client transaction, targets are resolved by transport layer if not provided.
+func (txl *layer) request(req sip.Request, targets []*transport.Target) (ClientTx, error) {
+	tx := newClientTx(req, txl.tpl)
+	tx.SetLog(txl.Log())
+	tx.timings = txl.methodTimings(req.Method())
+	tx.targets = targets
+	tx.sendCancel = txl.sendCancel
+	tx.sendPrack = txl.sendPrack
+	tx.newPrack = txl.newPrack
+	tx.addKey = func(key TxKey) {
+		txl.transactions.put(key, tx)
+	}
+
+	if err := tx.Init(); err != nil {
 		return nil, err
 	}
 
@@ -132,7 +206,50 @@ func (txl *layer) Request(req sip.Request) (<-chan sip.Response, error) {
 	go txl.serveTransaction(tx)
 	txl.transactions.put(tx.Key(), tx)
 
-	return tx.Responses(), nil
+	return tx, nil
+}
+
+func (txl *layer) CancelRequest(req sip.Request) error {
+	select {
+	case <-txl.canceled:
+		return fmt.Errorf("%s is canceled", txl)
+	default:
+	}
+
+	tx, err := txl.getClientTx(req)
+	if err != nil {
+		return err
+	}
+
+	return tx.Cancel()
+}
+
+// sendCancel starts client transaction for CANCEL request, CANCEL is sent to the target of INVITE.
+func (txl *layer) sendCancel(cancel sip.Request, target *transport.Target) error {
+	select {
+	case <-txl.canceled:
+		return fmt.Errorf("%s is canceled", txl)
+	default:
+	}
+
+	txl.Log().Debugf("%s sends %s", txl, cancel.Short())
+
+	var targets []*transport.Target
+	if target != nil {
+		targets = []*transport.Target{target}
+	}
+	tx, err := txl.request(cancel, targets)
+	if err != nil {
+		return err
+	}
+	// responses on CANCEL are consumed here, TU receives final response on INVITE
+	go func() {
+		for res := range tx.Responses() {
+			txl.Log().Debugf("%s received %s on %s", txl, res.Short(), cancel.Short())
+		}
+	}()
+
+	return nil
 }
 
 func (txl *layer) Respond(res sip.Response) (<-chan sip.Request, error) {
@@ -192,6 +309,11 @@ func (txl *layer) serveTransaction(tx Tx) {
 		log.Debugf("%s deletes transaction %s", txl, tx)
 		tx.Terminate()
 		txl.transactions.drop(tx.Key())
+		if tx, ok := tx.(*clientTx); ok {
+			for _, key := range tx.sentKeys() {
+				txl.transactions.drop(key)
+			}
+		}
 		txl.txWg.Done()
 	}()
 
@@ -249,13 +371,15 @@ func (txl *layer) handleRequest(req sip.Request) {
 	// or create new one only for new requests except ACKs on 2xx
 	if !req.IsAck() {
 		txl.Log().Debugf("%s creates new server transaction for %s", txl, req.Short())
-		tx, err := NewServerTx(req, txl.tpl)
+		tx, err := newServerTx(req, txl.tpl)
 		if err != nil {
 			txl.Log().Error(err)
 			return
 		}
 
 		tx.SetLog(txl.Log())
+		tx.timings = txl.methodTimings(req.Method())
+		tx.timer_1xx_time = txl.tryingDelay
 		// put tx to store, to match retransmitting requests later
 		txl.transactions.put(tx.Key(), tx)
 
@@ -268,6 +392,13 @@ func (txl *layer) handleRequest(req sip.Request) {
 			txl.Log().Error(err)
 			return
 		}
+
+		if req.IsCancel() && !txl.cancelServerTx(tx, req) {
+			return
+		}
+		if req.Method() == sip.PRACK && !txl.prackServerTx(tx, req) {
+			return
+		}
 	}
 	// pass up request
 	txl.Log().Debugf("%s pass up %s", txl, req.Short())
@@ -277,6 +408,92 @@ func (txl *layer) handleRequest(req sip.Request) {
 	}
 }
 
+// RFC 3261 - 9.2. - match CANCEL to INVITE transaction and respond on both requests.
+// Returns false if INVITE transaction not found, CANCEL should not be passed up in this case.
+func (txl *layer) cancelServerTx(cancelTx ServerTx, cancel sip.Request) bool {
+	var inviteTx ServerTx
+	if key, err := MakeCanceledServerTxKey(cancel); err == nil {
+		if tx, ok := txl.transactions.get(key); ok {
+			inviteTx, _ = tx.(ServerTx)
+		}
+	}
+
+	if inviteTx == nil {
+		txl.Log().Warnf("%s failed to match %s to INVITE transaction", txl, cancel.Short())
+		res := sip.NewResponseFromRequest(cancel, 481, "Call/Transaction Does Not Exist", "")
+		if err := cancelTx.Respond(res); err != nil {
+			txl.Log().Error(err)
+		}
+		return false
+	}
+
+	txl.Log().Debugf("%s cancels %s by %s", txl, inviteTx, cancel.Short())
+	if err := cancelTx.Respond(sip.NewResponseFromRequest(cancel, 200, "OK", "")); err != nil {
+		txl.Log().Error(err)
+	}
+	// INVITE transaction responds with 487 if final response was not sent yet
+	if err := inviteTx.Receive(cancel); err != nil {
+		txl.Log().Error(err)
+	}
+
+	return true
+}
+
+// RFC 3262 - 3. - match PRACK to the reliable provisional response of INVITE transaction.
+// Returns false if the response is not found, PRACK is answered with 481 and should not be passed up in this case.
+// Matched PRACK is passed up and should be answered by TU.
+func (txl *layer) prackServerTx(prackTx ServerTx, prack sip.Request) bool {
+	var matched *serverTx
+	if rack, ok := prack.RAck(); ok {
+		for _, tx := range txl.transactions.all() {
+			inviteTx, ok := tx.(*serverTx)
+			if !ok || !inviteTx.Origin().IsInvite() || !sameDialogRequests(inviteTx.Origin(), prack) {
+				continue
+			}
+			if inviteTx.prack(rack) {
+				matched = inviteTx
+				break
+			}
+		}
+	}
+
+	if matched == nil {
+		txl.Log().Warnf("%s failed to match %s to reliable provisional response", txl, prack.Short())
+		res := sip.NewResponseFromRequest(prack, 481, "Call/Transaction Does Not Exist", "")
+		if err := prackTx.Respond(res); err != nil {
+			txl.Log().Error(err)
+		}
+		return false
+	}
+
+	txl.Log().Debugf("%s acknowledges reliable provisional response of %s by %s", txl, matched, prack.Short())
+
+	return true
+}
+
+// sendPrack starts client transaction for PRACK request, responses on PRACK are consumed here.
+func (txl *layer) sendPrack(prack sip.Request) error {
+	select {
+	case <-txl.canceled:
+		return fmt.Errorf("%s is canceled", txl)
+	default:
+	}
+
+	txl.Log().Debugf("%s sends %s", txl, prack.Short())
+
+	tx, err := txl.request(prack, nil)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for res := range tx.Responses() {
+			txl.Log().Debugf("%s received %s on %s", txl, res.Short(), prack.Short())
+		}
+	}()
+
+	return nil
+}
+
 func (txl *layer) handleResponse(res sip.Response) {
 	select {
 	case <-txl.canceled:
@@ -2,6 +2,7 @@ package transaction
 
 import (
 	"fmt"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -16,23 +17,44 @@ type ServerTx interface {
 	Tx
 	Respond(res sip.Response) error
 	Ack() <-chan sip.Request
+	// Cancels returns channel with CANCEL request that cancelled INVITE transaction.
+	Cancels() <-chan sip.Request
 }
 
 type serverTx struct {
 	commonTx
 	ack          chan sip.Request
+	cancels      chan sip.Request
 	timer_g      timing.Timer
 	timer_g_time time.Duration
 	timer_h      timing.Timer
 	timer_i      timing.Timer
 	timer_i_time time.Duration
 	timer_j      timing.Timer
+	timer_l      timing.Timer
 	timer_1xx    timing.Timer
-	reliable     bool
-	mu           *sync.RWMutex
+	// delay of the automatic 100 Trying, disabled if zero
+	timer_1xx_time time.Duration
+	// RFC 3262 - reliable provisional response waiting for PRACK and its RSeq
+	unacked        sip.Response
+	rseq           uint32
+	timer_rel      timing.Timer
+	timer_rel_time time.Duration
+	timer_rel_sum  time.Duration
+	reliable       bool
+	mu             *sync.RWMutex
 }
 
 func NewServerTx(origin sip.Request, tpl transport.Layer) (ServerTx, error) {
+	tx, err := newServerTx(origin, tpl)
+	if err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+func newServerTx(origin sip.Request, tpl transport.Layer) (*serverTx, error) {
 	key, err := MakeServerTxKey(origin)
 	if err != nil {
 		return nil, err
@@ -44,8 +66,11 @@ func NewServerTx(origin sip.Request, tpl transport.Layer) (ServerTx, error) {
 	tx.origin = origin
 	tx.tpl = tpl
 	tx.ack = make(chan sip.Request, 1)
+	tx.cancels = make(chan sip.Request, 1)
 	tx.errs = make(chan error, 1)
 	tx.done = make(chan bool, 1)
+	tx.timings = DefaultTimings()
+	tx.timer_1xx_time = Timer_1xx
 	tx.mu = new(sync.RWMutex)
 	if viaHop, ok := tx.Origin().ViaHop(); ok {
 		tx.reliable = tx.tpl.IsReliable(viaHop.Transport)
@@ -61,16 +86,16 @@ func (tx *serverTx) Init() error {
 	if tx.reliable {
 		tx.timer_i_time = 0
 	} else {
-		tx.timer_g_time = Timer_G
-		tx.timer_i_time = Timer_I
+		tx.timer_g_time = tx.timings.timerG()
+		tx.timer_i_time = tx.timings.timerI()
 	}
 	tx.mu.Unlock()
 	// RFC 3261 - 17.2.1
-	if tx.Origin().IsInvite() {
-		tx.Log().Debugf("%s, set timer_1xx to %v", tx, Timer_1xx)
+	if tx.Origin().IsInvite() && tx.timer_1xx_time > 0 {
+		tx.Log().Debugf("%s, set timer_1xx to %v", tx, tx.timer_1xx_time)
 		// todo set as timer, reset in Respond
 		tx.mu.Lock()
-		tx.timer_1xx = timing.AfterFunc(Timer_1xx, func() {
+		tx.timer_1xx = timing.AfterFunc(tx.timer_1xx_time, func() {
 			tx.Log().Debugf("%s, timer_1xx fired", tx)
 			tx.Respond(sip.NewResponseFromRequest(tx.Origin(), 100, "Trying", ""))
 		})
@@ -112,6 +137,17 @@ func (tx *serverTx) Receive(msg sip.Message) error {
 		case tx.ack <- req:
 		}
 		tx.mu.RUnlock()
+	case req.IsCancel() && tx.Origin().IsInvite():
+		// RFC 3261 - 9.2. - CANCEL matched to INVITE transaction
+		input = server_input_cancel
+		tx.mu.RLock()
+		select {
+		case <-tx.done:
+		case tx.cancels <- req:
+		default:
+			// TU was already notified
+		}
+		tx.mu.RUnlock()
 	default:
 		return &sip.UnexpectedMessageError{
 			fmt.Errorf("invalid %s correlated to %s", msg, tx),
@@ -123,7 +159,23 @@ func (tx *serverTx) Receive(msg sip.Message) error {
 }
 
 func (tx *serverTx) Respond(res sip.Response) error {
+	reliable := tx.Origin().IsInvite() && isReliableProvisional(res)
+
 	tx.mu.Lock()
+	// RFC 3262 - 3. - the next reliable provisional response and 2xx response
+	// can be sent only after PRACK
+	if tx.unacked != nil && (reliable || res.IsSuccess()) {
+		unacked := tx.unacked
+		tx.mu.Unlock()
+		return fmt.Errorf("%s failed to send %s: reliable provisional response %s is not acknowledged",
+			tx, res.Short(), unacked.Short())
+	}
+	if reliable {
+		tx.setRSeq(res)
+		tx.unacked = res
+	} else if !res.IsProvisional() {
+		tx.stopReliable()
+	}
 	tx.lastResp = res
 
 	if tx.timer_1xx != nil {
@@ -134,6 +186,8 @@ func (tx *serverTx) Respond(res sip.Response) error {
 
 	var input fsm.Input
 	switch {
+	case reliable:
+		input = server_input_user_rel_1xx
 	case res.IsProvisional():
 		input = server_input_user_1xx
 	case res.IsSuccess():
@@ -145,10 +199,59 @@ func (tx *serverTx) Respond(res sip.Response) error {
 	return tx.fsm.Spin(input)
 }
 
+// setRSeq adds RSeq header to the reliable provisional response, RSeq of the response set by TU is kept.
+func (tx *serverTx) setRSeq(res sip.Response) {
+	if rseq, ok := res.RSeq(); ok {
+		tx.rseq = uint32(*rseq)
+		return
+	}
+
+	if tx.rseq == 0 {
+		// RFC 3262 - 3. - initial value is chosen randomly, it is kept low enough to not overflow
+		tx.rseq = uint32(rand.Int31n(1<<30)) + 1
+	} else {
+		tx.rseq++
+	}
+	rseq := sip.RSeq(tx.rseq)
+	res.AppendHeader(&rseq)
+}
+
+// stopReliable stops retransmissions of the reliable provisional response, should be called under lock.
+func (tx *serverTx) stopReliable() {
+	tx.unacked = nil
+	if tx.timer_rel != nil {
+		tx.timer_rel.Stop()
+		tx.timer_rel = nil
+	}
+}
+
+// prack acknowledges the reliable provisional response with PRACK (RFC 3262 - 3.).
+// Returns false if RAck does not match the response waiting for PRACK.
+func (tx *serverTx) prack(rack *sip.RAck) bool {
+	cseq, ok := tx.Origin().CSeq()
+	if !ok || rack.CSeq != cseq.SeqNo || rack.MethodName != cseq.MethodName {
+		return false
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.unacked == nil || rack.RSeq != tx.rseq {
+		return false
+	}
+	tx.stopReliable()
+
+	return true
+}
+
 func (tx *serverTx) Ack() <-chan sip.Request {
 	return tx.ack
 }
 
+func (tx *serverTx) Cancels() <-chan sip.Request {
+	return tx.cancels
+}
+
 func (tx *serverTx) Terminate() {
 	select {
 	case <-tx.done:
@@ -164,6 +267,7 @@ const (
 	server_state_proceeding
 	server_state_completed
 	server_state_confirmed
+	server_state_accepted
 	server_state_terminated
 )
 
@@ -180,6 +284,11 @@ const (
 	server_input_timer_j
 	server_input_transport_err
 	server_input_delete
+	server_input_cancel
+	server_input_timer_l
+	server_input_user_rel_1xx
+	server_input_timer_rel
+	server_input_prack_timeout
 )
 
 // Choose the right FSM init function depending on request method.
@@ -201,12 +310,34 @@ func (tx *serverTx) initInviteFSM() {
 		Outcomes: map[fsm.Input]fsm.Outcome{
 			server_input_request:       {server_state_proceeding, tx.act_respond},
 			server_input_user_1xx:      {server_state_proceeding, tx.act_respond},
-			server_input_user_2xx:      {server_state_terminated, tx.act_respond_delete},
+			server_input_user_rel_1xx:  {server_state_proceeding, tx.act_respond_reliable},
+			server_input_timer_rel:     {server_state_proceeding, tx.act_resend_reliable},
+			server_input_prack_timeout: {server_state_completed, tx.act_prack_timeout},
+			server_input_user_2xx:      {server_state_accepted, tx.act_respond_accept},
 			server_input_user_300_plus: {server_state_completed, tx.act_respond_complete},
+			server_input_cancel:        {server_state_completed, tx.act_cancel},
 			server_input_transport_err: {server_state_terminated, tx.act_trans_err},
 		},
 	}
 
+	// Accepted (RFC 6026 - 7.1.)
+	server_state_def_accepted := fsm.State{
+		Index: server_state_accepted,
+		Outcomes: map[fsm.Input]fsm.Outcome{
+			// retransmitted INVITE is absorbed, 2xx is retransmitted by TU
+			server_input_request:       {server_state_accepted, fsm.NO_ACTION},
+			server_input_ack:           {server_state_accepted, fsm.NO_ACTION},
+			server_input_user_1xx:      {server_state_accepted, fsm.NO_ACTION},
+			server_input_user_rel_1xx:  {server_state_accepted, fsm.NO_ACTION},
+			server_input_timer_rel:     {server_state_accepted, fsm.NO_ACTION},
+			server_input_user_2xx:      {server_state_accepted, tx.act_respond},
+			server_input_user_300_plus: {server_state_accepted, fsm.NO_ACTION},
+			server_input_cancel:        {server_state_accepted, fsm.NO_ACTION},
+			server_input_timer_l:       {server_state_terminated, tx.act_delete},
+			server_input_transport_err: {server_state_accepted, tx.act_trans_err_accepted},
+		},
+	}
+
 	// Completed
 	server_state_def_completed := fsm.State{
 		Index: server_state_completed,
@@ -214,8 +345,11 @@ func (tx *serverTx) initInviteFSM() {
 			server_input_request:       {server_state_completed, tx.act_respond},
 			server_input_ack:           {server_state_confirmed, tx.act_confirm},
 			server_input_user_1xx:      {server_state_completed, fsm.NO_ACTION},
+			server_input_user_rel_1xx:  {server_state_completed, fsm.NO_ACTION},
+			server_input_timer_rel:     {server_state_completed, fsm.NO_ACTION},
 			server_input_user_2xx:      {server_state_completed, fsm.NO_ACTION},
 			server_input_user_300_plus: {server_state_completed, fsm.NO_ACTION},
+			server_input_cancel:        {server_state_completed, fsm.NO_ACTION},
 			server_input_timer_g:       {server_state_completed, tx.act_respond_complete},
 			server_input_timer_h:       {server_state_terminated, tx.act_timeout},
 			server_input_transport_err: {server_state_terminated, tx.act_trans_err},
@@ -228,8 +362,11 @@ func (tx *serverTx) initInviteFSM() {
 		Outcomes: map[fsm.Input]fsm.Outcome{
 			server_input_request:       {server_state_confirmed, fsm.NO_ACTION},
 			server_input_user_1xx:      {server_state_confirmed, fsm.NO_ACTION},
+			server_input_user_rel_1xx:  {server_state_confirmed, fsm.NO_ACTION},
+			server_input_timer_rel:     {server_state_confirmed, fsm.NO_ACTION},
 			server_input_user_2xx:      {server_state_confirmed, fsm.NO_ACTION},
 			server_input_user_300_plus: {server_state_confirmed, fsm.NO_ACTION},
+			server_input_cancel:        {server_state_confirmed, fsm.NO_ACTION},
 			server_input_timer_i:       {server_state_terminated, tx.act_delete},
 			// server_input_timer_g:       {server_state_confirmed, fsm.NO_ACTION},
 		},
@@ -242,8 +379,12 @@ func (tx *serverTx) initInviteFSM() {
 			server_input_request:       {server_state_terminated, fsm.NO_ACTION},
 			server_input_ack:           {server_state_terminated, fsm.NO_ACTION},
 			server_input_user_1xx:      {server_state_terminated, fsm.NO_ACTION},
+			server_input_user_rel_1xx:  {server_state_terminated, fsm.NO_ACTION},
+			server_input_timer_rel:     {server_state_terminated, fsm.NO_ACTION},
 			server_input_user_2xx:      {server_state_terminated, fsm.NO_ACTION},
 			server_input_user_300_plus: {server_state_terminated, fsm.NO_ACTION},
+			server_input_cancel:        {server_state_terminated, fsm.NO_ACTION},
+			server_input_timer_l:       {server_state_terminated, fsm.NO_ACTION},
 			server_input_delete:        {server_state_terminated, tx.act_delete},
 		},
 	}
@@ -253,6 +394,7 @@ func (tx *serverTx) initInviteFSM() {
 		server_state_def_proceeding,
 		server_state_def_completed,
 		server_state_def_confirmed,
+		server_state_def_accepted,
 		server_state_def_terminated,
 	)
 	if err != nil {
@@ -296,6 +438,8 @@ func (tx *serverTx) initNonInviteFSM() {
 		Outcomes: map[fsm.Input]fsm.Outcome{
 			server_input_request:       {server_state_completed, tx.act_respond},
 			server_input_user_1xx:      {server_state_completed, fsm.NO_ACTION},
+			server_input_user_rel_1xx:  {server_state_completed, fsm.NO_ACTION},
+			server_input_timer_rel:     {server_state_completed, fsm.NO_ACTION},
 			server_input_user_2xx:      {server_state_completed, fsm.NO_ACTION},
 			server_input_user_300_plus: {server_state_completed, fsm.NO_ACTION},
 			server_input_timer_j:       {server_state_terminated, tx.act_timeout},
@@ -309,6 +453,8 @@ func (tx *serverTx) initNonInviteFSM() {
 		Outcomes: map[fsm.Input]fsm.Outcome{
 			server_input_request:       {server_state_terminated, fsm.NO_ACTION},
 			server_input_user_1xx:      {server_state_terminated, fsm.NO_ACTION},
+			server_input_user_rel_1xx:  {server_state_terminated, fsm.NO_ACTION},
+			server_input_timer_rel:     {server_state_terminated, fsm.NO_ACTION},
 			server_input_user_2xx:      {server_state_terminated, fsm.NO_ACTION},
 			server_input_user_300_plus: {server_state_terminated, fsm.NO_ACTION},
 			server_input_timer_j:       {server_state_terminated, fsm.NO_ACTION},
@@ -389,6 +535,12 @@ func (tx *serverTx) delete() {
 	if tx.timer_j != nil {
 		tx.timer_j.Stop()
 	}
+	if tx.timer_l != nil {
+		tx.timer_l.Stop()
+	}
+	if tx.timer_rel != nil {
+		tx.timer_rel.Stop()
+	}
 	if tx.timer_1xx != nil {
 		tx.timer_1xx.Stop()
 	}
@@ -396,6 +548,7 @@ func (tx *serverTx) delete() {
 
 	tx.mu.Lock()
 	close(tx.ack)
+	close(tx.cancels)
 	close(tx.errs)
 	tx.mu.Unlock()
 }
@@ -440,8 +593,8 @@ func (tx *serverTx) act_respond_complete() fsm.Input {
 			})
 		} else {
 			tx.timer_g_time *= 2
-			if tx.timer_g_time > T2 {
-				tx.timer_g_time = T2
+			if tx.timer_g_time > tx.timings.T2 {
+				tx.timer_g_time = tx.timings.T2
 			}
 			tx.timer_g.Reset(tx.timer_g_time)
 		}
@@ -450,7 +603,7 @@ func (tx *serverTx) act_respond_complete() fsm.Input {
 
 	tx.mu.Lock()
 	if tx.timer_h == nil {
-		tx.timer_h = timing.AfterFunc(Timer_H, func() {
+		tx.timer_h = timing.AfterFunc(tx.timings.timerH(), func() {
 			tx.Log().Debugf("%s, timer_h fired", tx)
 			tx.fsm.Spin(server_input_timer_h)
 		})
@@ -473,7 +626,7 @@ func (tx *serverTx) act_final() fsm.Input {
 	}
 
 	tx.mu.Lock()
-	tx.timer_j = timing.AfterFunc(Timer_J, func() {
+	tx.timer_j = timing.AfterFunc(tx.timings.timerJ(), func() {
 		tx.Log().Debugf("%s, timer_j fired")
 		tx.fsm.Spin(server_input_timer_j)
 	})
@@ -503,15 +656,18 @@ func (tx *serverTx) act_delete() fsm.Input {
 	return fsm.NO_INPUT
 }
 
-// Send response and delete the transaction.
-func (tx *serverTx) act_respond_delete() fsm.Input {
-	tx.Log().Debugf("%s, act_respond_delete", tx)
-	tx.delete()
+// RFC 6026 - 7.1. - send 2xx response and wait for retransmissions of INVITE until Timer L fires.
+func (tx *serverTx) act_respond_accept() fsm.Input {
+	tx.Log().Debugf("%s, act_respond_accept %s", tx, tx.lastResp.Short())
 
 	lastErr := tx.tpl.Send(tx.lastResp)
 
 	tx.mu.Lock()
 	tx.lastErr = lastErr
+	tx.timer_l = timing.AfterFunc(tx.timings.timerL(), func() {
+		tx.Log().Debugf("%s, timer_l fired", tx)
+		tx.fsm.Spin(server_input_timer_l)
+	})
 	tx.mu.Unlock()
 
 	if lastErr != nil {
@@ -521,11 +677,18 @@ func (tx *serverTx) act_respond_delete() fsm.Input {
 	return fsm.NO_INPUT
 }
 
+// Inform user of transport error, the transaction stays in Accepted state (RFC 6026 - 8.9.).
+func (tx *serverTx) act_trans_err_accepted() fsm.Input {
+	tx.Log().Debugf("%s, act_trans_err_accepted", tx)
+	tx.transportErr()
+	return fsm.NO_INPUT
+}
+
 func (tx *serverTx) act_confirm() fsm.Input {
 	tx.Log().Debugf("%s, act_confirm")
 
 	tx.mu.Lock()
-	tx.timer_i = timing.AfterFunc(Timer_I, func() {
+	tx.timer_i = timing.AfterFunc(tx.timings.timerI(), func() {
 		tx.Log().Debugf("%s, timer_i fired")
 		tx.fsm.Spin(server_input_timer_i)
 	})
@@ -533,3 +696,100 @@ func (tx *serverTx) act_confirm() fsm.Input {
 
 	return fsm.NO_INPUT
 }
+
+// RFC 3261 - 9.2. - INVITE cancelled before final response, respond with 487.
+func (tx *serverTx) act_cancel() fsm.Input {
+	tx.Log().Debugf("%s, act_cancel", tx)
+
+	res := sip.NewResponseFromRequest(tx.Origin(), 487, "Request Terminated", "")
+	tx.mu.Lock()
+	// keep To tag of the sent provisional response
+	if tx.lastResp != nil {
+		res.RemoveHeader("To")
+		sip.CopyHeaders("To", tx.lastResp, res)
+	}
+	tx.lastResp = res
+	tx.mu.Unlock()
+
+	return tx.act_respond_complete()
+}
+
+// RFC 3262 - 3. - send reliable provisional response and retransmit it until PRACK arrives.
+func (tx *serverTx) act_respond_reliable() fsm.Input {
+	tx.Log().Debugf("%s, act_respond_reliable %s", tx, tx.lastResp.Short())
+
+	lastErr := tx.tpl.Send(tx.lastResp)
+
+	tx.mu.Lock()
+	tx.lastErr = lastErr
+	if lastErr == nil && tx.unacked != nil {
+		if tx.timer_rel != nil {
+			tx.timer_rel.Stop()
+		}
+		tx.timer_rel_time = tx.timings.T1
+		tx.timer_rel_sum = 0
+		tx.timer_rel = timing.AfterFunc(tx.timer_rel_time, func() {
+			tx.Log().Debugf("%s, timer_rel fired", tx)
+			tx.fsm.Spin(server_input_timer_rel)
+		})
+	}
+	tx.mu.Unlock()
+
+	if lastErr != nil {
+		return server_input_transport_err
+	}
+
+	return fsm.NO_INPUT
+}
+
+// Retransmit reliable provisional response with doubling interval during 64*T1.
+func (tx *serverTx) act_resend_reliable() fsm.Input {
+	tx.mu.Lock()
+	unacked := tx.unacked
+	if unacked == nil {
+		tx.mu.Unlock()
+		return fsm.NO_INPUT
+	}
+	tx.timer_rel_sum += tx.timer_rel_time
+	if tx.timer_rel_sum >= tx.timings.timerPRACK() {
+		tx.mu.Unlock()
+		return server_input_prack_timeout
+	}
+	tx.timer_rel_time *= 2
+	tx.timer_rel.Reset(tx.timer_rel_time)
+	tx.mu.Unlock()
+
+	tx.Log().Debugf("%s, act_resend_reliable %s", tx, unacked.Short())
+
+	lastErr := tx.tpl.Send(unacked)
+
+	tx.mu.Lock()
+	tx.lastErr = lastErr
+	tx.mu.Unlock()
+
+	if lastErr != nil {
+		return server_input_transport_err
+	}
+
+	return fsm.NO_INPUT
+}
+
+// RFC 3262 - 3. - reliable provisional response was not acknowledged, reject INVITE with 5xx.
+func (tx *serverTx) act_prack_timeout() fsm.Input {
+	tx.Log().Debugf("%s, act_prack_timeout", tx)
+
+	res := sip.NewResponseFromRequest(tx.Origin(), 500, "Server Internal Error", "")
+	tx.mu.Lock()
+	// keep To tag of the sent provisional response
+	if tx.unacked != nil {
+		res.RemoveHeader("To")
+		sip.CopyHeaders("To", tx.unacked, res)
+	}
+	tx.stopReliable()
+	tx.lastResp = res
+	tx.mu.Unlock()
+
+	tx.timeoutErr()
+
+	return tx.act_respond_complete()
+}
@@ -23,7 +23,7 @@ var _ = Describe("ServerTx", func() {
 
 	BeforeEach(func() {
 		tpl = testutils.NewMockTransportLayer()
-		txl = transaction.NewLayer(tpl)
+		txl = transaction.NewLayer(tpl, nil)
 	})
 	AfterEach(func(done Done) {
 		txl.Cancel()
@@ -36,6 +36,191 @@ var _ = Describe("ServerTx", func() {
 			Expect(txl.Transport()).To(Equal(tpl))
 		})
 	})
+	It("should respond 481 on CANCEL without INVITE transaction", func(done Done) {
+		cancel := testutils.Request([]string{
+			"CANCEL sip:bob@example.com SIP/2.0",
+			"Via: SIP/2.0/UDP " + clientAddr + ";branch=" + sip.GenerateBranch(),
+			"CSeq: 1 CANCEL",
+			"",
+			"",
+		})
+		go func() {
+			tpl.InMsgs <- cancel
+		}()
+
+		msg := <-tpl.OutMsgs
+		res, ok := msg.(sip.Response)
+		Expect(ok).To(BeTrue())
+		Expect(res.StatusCode()).To(Equal(sip.StatusCode(481)))
+		close(done)
+	}, 3)
+
+	Context("when automatic 100 Trying is disabled", func() {
+		BeforeEach(func() {
+			txl.Cancel()
+			<-txl.Done()
+			txl = transaction.NewLayer(tpl, &transaction.LayerConfig{DisableTrying: true})
+		})
+
+		It("should not send 100 Trying on INVITE", func(done Done) {
+			invite := testutils.Request([]string{
+				"INVITE sip:bob@example.com SIP/2.0",
+				"Via: SIP/2.0/UDP " + clientAddr + ";branch=" + sip.GenerateBranch(),
+				"CSeq: 1 INVITE",
+				"",
+				"",
+			})
+			go func() {
+				tpl.InMsgs <- invite
+			}()
+
+			req := <-txl.Requests()
+			Expect(req.String()).To(Equal(invite.String()))
+			select {
+			case msg := <-tpl.OutMsgs:
+				Fail("unexpected " + msg.Short())
+			case <-time.After(transaction.Timer_1xx + 100*time.Millisecond):
+			}
+			close(done)
+		}, 3)
+	})
+
+	Context("when INVITE is answered with reliable provisional response", func() {
+		var invite sip.Request
+		var ringing, ok sip.Response
+		var out chan sip.Message
+		var stop chan struct{}
+		var inviteBranch string
+
+		prack := func(rack string) sip.Request {
+			return testutils.Request([]string{
+				"PRACK sip:bob@example.com SIP/2.0",
+				"Via: SIP/2.0/UDP " + clientAddr + ";branch=" + sip.GenerateBranch(),
+				"From: <sip:alice@example.com>;tag=alice",
+				"To: <sip:bob@example.com>;tag=bob",
+				"Call-ID: prack-call",
+				"CSeq: 2 PRACK",
+				"RAck: " + rack,
+				"",
+				"",
+			})
+		}
+
+		BeforeEach(func() {
+			txl.Cancel()
+			<-txl.Done()
+			txl = transaction.NewLayer(tpl, &transaction.LayerConfig{
+				Timings:       transaction.Timings{T1: 20 * time.Millisecond},
+				DisableTrying: true,
+			})
+
+			inviteBranch = sip.GenerateBranch()
+			invite = testutils.Request([]string{
+				"INVITE sip:bob@example.com SIP/2.0",
+				"Via: SIP/2.0/UDP " + clientAddr + ";branch=" + inviteBranch,
+				"From: <sip:alice@example.com>;tag=alice",
+				"To: <sip:bob@example.com>",
+				"Call-ID: prack-call",
+				"CSeq: 1 INVITE",
+				"Supported: 100rel",
+				"",
+				"",
+			})
+			ringing = testutils.Response([]string{
+				"SIP/2.0 180 Ringing",
+				"Via: SIP/2.0/UDP " + clientAddr + ";branch=" + inviteBranch,
+				"From: <sip:alice@example.com>;tag=alice",
+				"To: <sip:bob@example.com>;tag=bob",
+				"Call-ID: prack-call",
+				"CSeq: 1 INVITE",
+				"Require: 100rel",
+				"",
+				"",
+			})
+			ok = testutils.Response([]string{
+				"SIP/2.0 200 OK",
+				"Via: SIP/2.0/UDP " + clientAddr + ";branch=" + inviteBranch,
+				"From: <sip:alice@example.com>;tag=alice",
+				"To: <sip:bob@example.com>;tag=bob",
+				"Call-ID: prack-call",
+				"CSeq: 1 INVITE",
+				"",
+				"",
+			})
+
+			// goroutines use own copies, the variables are reassigned by the next spec
+			out = make(chan sip.Message, 100)
+			stop = make(chan struct{})
+			go func(tpl *testutils.MockTransportLayer, out chan<- sip.Message, stop <-chan struct{}) {
+				for {
+					select {
+					case msg := <-tpl.OutMsgs:
+						out <- msg
+					case <-stop:
+						return
+					}
+				}
+			}(tpl, out, stop)
+			go func(tpl *testutils.MockTransportLayer, invite sip.Request) {
+				tpl.InMsgs <- invite
+			}(tpl, invite)
+			req := <-txl.Requests()
+			Expect(req.String()).To(Equal(invite.String()))
+
+			_, err := txl.Respond(ringing)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			close(stop)
+		})
+
+		It("should retransmit response with RSeq until PRACK arrives", func(done Done) {
+			msg := <-out
+			rseq, found := msg.RSeq()
+			Expect(found).To(BeTrue())
+			msg = <-out
+			rseq2, _ := msg.RSeq()
+			Expect(*rseq2).To(Equal(*rseq))
+
+			By("UAS can not send 2xx before PRACK")
+			_, err := txl.Respond(ok)
+			Expect(err).To(HaveOccurred())
+
+			By("UAC sends PRACK")
+			tpl.InMsgs <- prack(fmt.Sprintf("%d 1 INVITE", *rseq))
+			req := <-txl.Requests()
+			Expect(req.Method()).To(Equal(sip.PRACK))
+
+			time.Sleep(50 * time.Millisecond)
+			for len(out) > 0 {
+				<-out
+			}
+			select {
+			case msg := <-out:
+				Fail("unexpected " + msg.Short())
+			case <-time.After(200 * time.Millisecond):
+			}
+
+			_, err = txl.Respond(ok)
+			Expect(err).ToNot(HaveOccurred())
+			msg = <-out
+			Expect(msg.String()).To(Equal(ok.String()))
+			close(done)
+		}, 3)
+
+		It("should respond 481 on PRACK that does not match the response", func(done Done) {
+			tpl.InMsgs <- prack("0 1 INVITE")
+			for msg := range out {
+				if res, ok := msg.(sip.Response); ok && res.StatusCode() == 481 {
+					cseq, _ := res.CSeq()
+					Expect(cseq.MethodName).To(Equal(sip.PRACK))
+					break
+				}
+			}
+			close(done)
+		}, 3)
+	})
+
 	// TODO: think about how to test Tx state switches and deletion
 	Context("when INVITE request arrives", func() {
 		var err error
@@ -142,6 +327,39 @@ var _ = Describe("ServerTx", func() {
 				Expect(err).ToNot(HaveOccurred())
 			})
 
+			Context("when CANCEL arrives", func() {
+				It("should respond 200 OK on CANCEL, 487 on INVITE and pass up CANCEL", func(done Done) {
+					cancel := testutils.Request([]string{
+						"CANCEL sip:bob@example.com SIP/2.0",
+						"Via: SIP/2.0/UDP " + clientAddr + ";branch=" + inviteBranch,
+						"CSeq: 1 CANCEL",
+						"",
+						"",
+					})
+					go func() {
+						tpl.InMsgs <- cancel
+					}()
+
+					msg := <-tpl.OutMsgs
+					res, ok := msg.(sip.Response)
+					Expect(ok).To(BeTrue())
+					Expect(res.StatusCode()).To(Equal(sip.StatusCode(200)))
+					cseq, _ := res.CSeq()
+					Expect(cseq.MethodName).To(Equal(sip.CANCEL))
+
+					msg = <-tpl.OutMsgs
+					res, ok = msg.(sip.Response)
+					Expect(ok).To(BeTrue())
+					Expect(res.StatusCode()).To(Equal(sip.StatusCode(487)))
+					cseq, _ = res.CSeq()
+					Expect(cseq.MethodName).To(Equal(sip.INVITE))
+
+					req := <-txl.Requests()
+					Expect(req.IsCancel()).To(BeTrue())
+					close(done)
+				}, 3)
+			})
+
 			Context("after 2xx OK was sent", func() {
 				wg2 := new(sync.WaitGroup)
 				BeforeEach(func() {
@@ -180,6 +398,30 @@ var _ = Describe("ServerTx", func() {
 
 					close(done)
 				})
+
+				It("should absorb retransmitted INVITE and send 2xx retransmitted by UAS", func(done Done) {
+					ackReq := <-txl.Requests()
+					Expect(ackReq.String()).To(Equal(ack.String()))
+
+					By(fmt.Sprintf("UAC retransmits %s", invite.Short()))
+					tpl.InMsgs <- invite
+					select {
+					case req := <-txl.Requests():
+						Fail("unexpected " + req.Short())
+					case msg := <-tpl.OutMsgs:
+						Fail("unexpected " + msg.Short())
+					case <-time.After(200 * time.Millisecond):
+					}
+
+					By(fmt.Sprintf("UAS retransmits %s", ok.Short()))
+					go func() {
+						defer close(done)
+						msg := <-tpl.OutMsgs
+						Expect(msg.String()).To(Equal(ok.String()))
+					}()
+					_, err := txl.Respond(ok.(sip.Response))
+					Expect(err).ToNot(HaveOccurred())
+				}, 3)
 			})
 
 			Context("after 3xx was sent", func() {
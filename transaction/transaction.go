@@ -9,6 +9,8 @@ import (
 	"github.com/masterclock/gosip/sip"
 )
 
+// Default timer values (RFC 3261 - Table 4, RFC 6026 - 8.4.),
+// used when Layer is created without config.
 const (
 	T1        = 500 * time.Millisecond
 	T2        = 4 * time.Second
@@ -23,9 +25,58 @@ const (
 	Timer_I   = T4
 	Timer_J   = 64 * T1
 	Timer_K   = T4
+	Timer_L   = 64 * T1
+	Timer_M   = 64 * T1
 	Timer_1xx = 200 * time.Millisecond
 )
 
+// Timings holds the base timer values, all the other timers are derived from them.
+// Zero values are replaced with defaults.
+type Timings struct {
+	// T1 - RTT estimate, default T1
+	T1 time.Duration
+	// T2 - maximum retransmit interval for non-INVITE requests and INVITE responses, default T2
+	T2 time.Duration
+	// T4 - maximum duration a message will remain in the network, default T4
+	T4 time.Duration
+}
+
+// DefaultTimings returns timings with default values.
+func DefaultTimings() Timings {
+	return Timings{T1: T1, T2: T2, T4: T4}
+}
+
+// Merge returns copy of the timings with zero values taken from the defaults.
+func (t Timings) Merge(defaults Timings) Timings {
+	if t.T1 <= 0 {
+		t.T1 = defaults.T1
+	}
+	if t.T2 <= 0 {
+		t.T2 = defaults.T2
+	}
+	if t.T4 <= 0 {
+		t.T4 = defaults.T4
+	}
+
+	return t
+}
+
+func (t Timings) timerA() time.Duration { return t.T1 }
+func (t Timings) timerB() time.Duration { return 64 * t.T1 }
+func (t Timings) timerD() time.Duration { return 64 * t.T1 }
+func (t Timings) timerE() time.Duration { return t.T1 }
+func (t Timings) timerF() time.Duration { return 64 * t.T1 }
+func (t Timings) timerG() time.Duration { return t.T1 }
+func (t Timings) timerH() time.Duration { return 64 * t.T1 }
+func (t Timings) timerI() time.Duration { return t.T4 }
+func (t Timings) timerJ() time.Duration { return 64 * t.T1 }
+func (t Timings) timerK() time.Duration { return t.T4 }
+func (t Timings) timerL() time.Duration { return 64 * t.T1 }
+func (t Timings) timerM() time.Duration { return 64 * t.T1 }
+
+// timerPRACK - retransmission time of the reliable provisional response (RFC 3262 - 3.)
+func (t Timings) timerPRACK() time.Duration { return 64 * t.T1 }
+
 // TxMessage is an message with related Tx
 type TxMessage interface {
 	sip.Message
@@ -173,6 +224,24 @@ func (err *TxTransportError) Error() string {
 
 // MakeServerTxKey creates server commonTx key for matching retransmitting requests - RFC 3261 17.2.3.
 func MakeServerTxKey(msg sip.Message) (TxKey, error) {
+	cseq, ok := msg.CSeq()
+	if !ok {
+		return "", fmt.Errorf("'CSeq' header not found in %s", msg.Short())
+	}
+	method := cseq.MethodName
+	if method == sip.ACK {
+		method = sip.INVITE
+	}
+
+	return makeServerTxKey(msg, method)
+}
+
+// MakeCanceledServerTxKey creates key of the server transaction cancelled by CANCEL request - RFC 3261 9.2.
+func MakeCanceledServerTxKey(cancel sip.Request) (TxKey, error) {
+	return makeServerTxKey(cancel, sip.INVITE)
+}
+
+func makeServerTxKey(msg sip.Message, method sip.RequestMethod) (TxKey, error) {
 	var sep = "$"
 
 	firstViaHop, ok := msg.ViaHop()
@@ -184,10 +253,6 @@ func MakeServerTxKey(msg sip.Message) (TxKey, error) {
 	if !ok {
 		return "", fmt.Errorf("'CSeq' header not found in %s", msg.Short())
 	}
-	method := cseq.MethodName
-	if method == sip.ACK {
-		method = sip.INVITE
-	}
 
 	var isRFC3261 bool
 	branch, ok := firstViaHop.Params.Get("branch")
@@ -272,3 +337,24 @@ func MakeClientTxKey(msg sip.Message) (TxKey, error) {
 		string(method),
 	}, sep)), nil
 }
+
+// RFC 3262 - 3. - provisional response is sent reliably if it requires 100rel extension.
+func isReliableProvisional(res sip.Response) bool {
+	return res.IsProvisional() && res.StatusCode() > 100 && sip.HasOptionTag(res, "Require", "100rel")
+}
+
+// sameDialogRequests returns true if the requests are sent by the same UAC in the same call.
+func sameDialogRequests(req1, req2 sip.Request) bool {
+	callID1, ok1 := req1.CallID()
+	callID2, ok2 := req2.CallID()
+	if !ok1 || !ok2 || *callID1 != *callID2 {
+		return false
+	}
+	from1, ok1 := req1.From()
+	from2, ok2 := req2.From()
+	if !ok1 || !ok2 {
+		return false
+	}
+
+	return sip.Tag(from1.Params) == sip.Tag(from2.Params)
+}
@@ -40,6 +40,7 @@ type commonTx struct {
 	errs     chan error
 	lastErr  error
 	done     chan bool
+	timings  Timings
 }
 
 func (tx *commonTx) String() string {
@@ -1,6 +1,7 @@
 package transport
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"strings"
@@ -33,6 +34,7 @@ type connection struct {
 	baseConn net.Conn
 	laddr    net.Addr
 	raddr    net.Addr
+	network  string
 	streamed bool
 	mu       *sync.RWMutex
 }
@@ -48,11 +50,22 @@ func NewConnection(
 		stream = true
 	}
 
+	var network string
+	switch c := baseConn.(type) {
+	case *wsConn:
+		network = c.network
+	case *tls.Conn:
+		network = "tls"
+	default:
+		network = baseConn.LocalAddr().Network()
+	}
+
 	conn := &connection{
 		logger:   log.NewSafeLocalLogger(),
 		baseConn: baseConn,
 		laddr:    baseConn.LocalAddr(),
 		raddr:    baseConn.RemoteAddr(),
+		network:  network,
 		streamed: stream,
 		mu:       new(sync.RWMutex),
 	}
@@ -84,7 +97,7 @@ func (conn *connection) Log() log.Logger {
 func (conn *connection) SetLog(logger log.Logger) {
 	conn.logger.SetLog(logger.WithFields(map[string]interface{}{
 		"laddr": fmt.Sprintf("%v", conn.LocalAddr()),
-		"net":   strings.ToUpper(conn.LocalAddr().Network()),
+		"net":   conn.Network(),
 	}))
 }
 
@@ -93,7 +106,7 @@ func (conn *connection) Streamed() bool {
 }
 
 func (conn *connection) Network() string {
-	return strings.ToUpper(conn.baseConn.LocalAddr().Network())
+	return strings.ToUpper(conn.network)
 }
 
 func (conn *connection) Read(buf []byte) (int, error) {
@@ -300,10 +300,8 @@ func (pool *connectionPool) dispose() {
 	// stop serveHandlers goroutine
 	close(pool.hmess)
 	close(pool.herrs)
-	// close store channels
-	close(pool.gets)
-	close(pool.updates)
-	close(pool.drops)
+	// store channels are not closed, requests may still be sent by the callers,
+	// they select on cancel signal instead
 }
 
 func (pool *connectionPool) serveHandlers() {
@@ -815,29 +813,27 @@ func (handler *connectionHandler) pipeOutputs(msgs <-chan sip.Message, errs <-ch
 			handler.Log().Infof("%s received message %s; pass it up", handler, msg.Short())
 			// add Remote Address
 			raddr := getRemoteAddr()
-			rhost, rport, _ := net.SplitHostPort(raddr)
+			rhost, rport, err := net.SplitHostPort(raddr)
+			if err != nil {
+				handler.Log().Warnf("%s failed to get source address of %s: %s", handler, msg.Short(), err)
+			}
 
 			switch msg := msg.(type) {
 			case sip.Request:
-				// RFC 3261 - 18.2.1
+				// RFC 3261 - 18.2.1, RFC 3581 - 4.
 				viaHop, ok := msg.ViaHop()
 				if !ok {
 					handler.Log().Warnf("%s ignores message without 'Via' header %s", handler, msg.Short())
 					continue
 				}
-
-				if rhost != "" && viaHop.Host != rhost {
-					handler.Log().Debugf("%s adds 'received' = %s param to 'Via' header of %s, "+
-						"because host %s from the first 'Via' header differs from the actual source address %s", handler, rhost,
-						msg.Short(), viaHop.Host, raddr)
-					viaHop.Params.Add("received", sip.String{rhost})
-				}
-				// rfc3581
-				if viaHop.Params.Has("rport") {
-					viaHop.Params.Add("rport", sip.String{rport})
+				if stampViaHop(viaHop, rhost, rport) {
+					handler.Log().Debugf("%s stamps 'Via' header of %s with the actual source address %s",
+						handler, msg.Short(), raddr)
 				}
 
-				if handler.Connection().Streamed() {
+				// connection oriented transports (TCP, TLS, WS) must send responses
+				// over the same connection
+				if handler.Connection().RemoteAddr() != nil {
 					msg.SetSource(raddr)
 				}
 			case sip.Response:
@@ -910,3 +906,40 @@ func (handler *connectionHandler) Cancel() {
 func (handler *connectionHandler) Done() <-chan struct{} {
 	return handler.done
 }
+
+// stampViaHop adds 'received' and fills 'rport' params of the top Via hop of an incoming request
+// by the actual source address. Returns true if the hop was modified.
+// RFC 3261 - 18.2.1. - 'received' is added when sent-by host differs from the source address.
+// RFC 3581 - 4. - empty 'rport' is filled with the source port, 'received' is added
+// in this case even if sent-by host equals to the source address.
+func stampViaHop(viaHop *sip.ViaHop, rhost, rport string) bool {
+	if rhost == "" {
+		return false
+	}
+	if viaHop.Params == nil {
+		viaHop.Params = sip.NewParams()
+	}
+
+	stamped := false
+	if viaHop.Params.Has("rport") && rport != "" {
+		viaHop.Params.Add("rport", sip.String{Str: rport})
+		stamped = true
+	}
+	if stamped || !sameHost(viaHop.Host, rhost) {
+		viaHop.Params.Add("received", sip.String{Str: rhost})
+		stamped = true
+	}
+
+	return stamped
+}
+
+// sameHost compares hosts taking into account different forms of IP addresses.
+func sameHost(host1, host2 string) bool {
+	host1 = strings.Trim(host1, "[]")
+	host2 = strings.Trim(host2, "[]")
+	if ip1, ip2 := net.ParseIP(host1), net.ParseIP(host2); ip1 != nil && ip2 != nil {
+		return ip1.Equal(ip2)
+	}
+
+	return strings.EqualFold(host1, host2)
+}
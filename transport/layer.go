@@ -1,7 +1,9 @@
 package transport
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"strconv"
 	"strings"
@@ -22,7 +24,13 @@ type Layer interface {
 	// Listen starts listening on `addr` for each registered protocol.
 	Listen(network string, addr string) error
 	// Send sends message on suitable protocol.
+	// Requests are sent to the targets located by Resolve one by one until the first success.
 	Send(msg sip.Message) error
+	// SendTo sends message to the target, uses target transport when it is defined.
+	SendTo(msg sip.Message, target *Target) error
+	// Resolve locates ordered targets of the request (RFC 3263),
+	// destination set explicitly by SetDestination is the only target.
+	Resolve(req sip.Request) ([]*Target, error)
 	String() string
 	IsReliable(network string) bool
 }
@@ -32,12 +40,19 @@ var protocolFactory ProtocolFactory = func(
 	output chan<- sip.Message,
 	errs chan<- error,
 	cancel <-chan struct{},
+	tlsConfig *tls.Config,
 ) (Protocol, error) {
 	switch strings.ToLower(network) {
 	case "udp":
 		return NewUdpProtocol(output, errs, cancel), nil
 	case "tcp":
 		return NewTcpProtocol(output, errs, cancel), nil
+	case "tls":
+		return NewTlsProtocol(output, errs, cancel, tlsConfig), nil
+	case "ws":
+		return NewWsProtocol(output, errs, cancel), nil
+	case "wss":
+		return NewWssProtocol(output, errs, cancel, tlsConfig), nil
 	default:
 		return nil, UnsupportedProtocolError(fmt.Sprintf("protocol %s is not supported", network))
 	}
@@ -53,12 +68,24 @@ func GetProtocolFactory() ProtocolFactory {
 	return protocolFactory
 }
 
+// LayerConfig describes transport layer options.
+type LayerConfig struct {
+	// TLSConfig is used by TLS protocol to serve incoming connections
+	// (server certificates, client certificates verification, SNI)
+	// and to establish outgoing connections.
+	TLSConfig *tls.Config
+	// Resolver locates request targets, RFC 3263 resolver with DefaultDNS is used if nil.
+	Resolver Resolver
+}
+
 // TransportLayer implementation.
 type layer struct {
 	logger    log.LocalLogger
 	hostAddr  string
 	host      string
 	port      *uint
+	tlsConfig *tls.Config
+	resolver  Resolver
 	protocols *protocolStore
 	msgs      chan sip.Message
 	errs      chan error
@@ -72,6 +99,13 @@ type layer struct {
 // NewLayer creates transport layer.
 // 	- hostAddr - current server host address (IP or FQDN)
 func NewLayer(hostAddr string) Layer {
+	return NewLayerWithConfig(hostAddr, nil)
+}
+
+// NewLayerWithConfig creates transport layer with options.
+// 	- hostAddr - current server host address (IP or FQDN)
+// 	- config - optional layer configuration, may be nil
+func NewLayerWithConfig(hostAddr string, config *LayerConfig) Layer {
 	// todo pass up error
 	var host string
 	var port *uint
@@ -92,11 +126,21 @@ func NewLayer(hostAddr string) Layer {
 		host = hostAddr
 	}
 
+	if config == nil {
+		config = &LayerConfig{}
+	}
+	resolver := config.Resolver
+	if resolver == nil {
+		resolver = NewResolver(DefaultDNS, "UDP", "TCP", "TLS", "WS", "WSS")
+	}
+
 	tpl := &layer{
 		logger:    log.NewSafeLocalLogger(),
 		hostAddr:  hostAddr,
 		host:      host,
 		port:      port,
+		tlsConfig: config.TLSConfig,
+		resolver:  resolver,
 		wg:        new(sync.WaitGroup),
 		protocols: newProtocolStore(),
 		msgs:      make(chan sip.Message),
@@ -170,7 +214,7 @@ func (tpl *layer) Listen(network string, addr string) error {
 	protocol, ok := tpl.protocols.get(protocolKey(network))
 	if !ok {
 		var err error
-		protocol, err = protocolFactory(network, tpl.pmsgs, tpl.perrs, tpl.canceled)
+		protocol, err = tpl.newProtocol(network)
 		if err != nil {
 			return err
 		}
@@ -185,16 +229,77 @@ func (tpl *layer) Listen(network string, addr string) error {
 }
 
 func (tpl *layer) Send(msg sip.Message) error {
+	switch msg := msg.(type) {
+	// RFC 3263 - 4.3. - try located targets one by one
+	case sip.Request:
+		targets, err := tpl.Resolve(msg)
+		if err != nil {
+			return err
+		}
+		for _, target := range targets {
+			if err = tpl.SendTo(msg, target); err == nil {
+				return nil
+			}
+			tpl.Log().Warnf("%s failed to send %s to %s: %s", tpl, msg.Short(), target, err)
+		}
+
+		return err
+	default:
+		return tpl.SendTo(msg, nil)
+	}
+}
+
+func (tpl *layer) Resolve(req sip.Request) ([]*Target, error) {
+	// explicit destination, e.g. the outbound proxy, is used as is
+	if req.HasDestination() {
+		target, err := NewTargetFromAddr(req.Destination())
+		if err != nil {
+			return nil, err
+		}
+
+		return []*Target{target}, nil
+	}
+
+	// RFC 3261 - 8.1.2. - targets are located by the loose router on the top of the route set
+	nextHop := sip.NextHop(req)
+	targets, err := tpl.resolver.Resolve(nextHop)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets found for %s", nextHop)
+	}
+
+	return targets, nil
+}
+
+func (tpl *layer) SendTo(msg sip.Message, target *Target) error {
 	nets := make([]string, 0)
 
 	switch msg := msg.(type) {
 	// RFC 3261 - 18.1.1.
 	case sip.Request:
-		msgLen := len(msg.String())
+		// the length is counted without building the message string, the body may be large
+		msgLen, _ := msg.WriteTo(ioutil.Discard)
 		// todo check for reliable/non-reliable
-		if msgLen > int(MTU)-200 {
+		transport, secure := requestTransport(msg)
+		if target != nil && target.Transport != "" {
+			transport = strings.ToUpper(target.Transport)
+		}
+		switch {
+		case transport == "WS" || transport == "WSS":
+			if secure {
+				transport = "WSS"
+			}
+			nets = append(nets, transport)
+		case secure || transport == "TLS":
+			// RFC 3261 - 26.2.2. - SIPS URI requires TLS on each hop
+			nets = append(nets, "TLS")
+		case transport == "TCP":
+			nets = append(nets, "TCP")
+		case msgLen > int64(MTU)-200:
 			nets = append(nets, "TCP", "UDP")
-		} else {
+		default:
 			nets = append(nets, "UDP")
 		}
 
@@ -247,12 +352,15 @@ func (tpl *layer) Send(msg sip.Message) error {
 				viaHop.Port = &defPort
 			}
 
-			target, err := NewTargetFromAddr(msg.Destination())
-			if err != nil {
-				return err
+			trg := target
+			if trg == nil {
+				trg, err = NewTargetFromAddr(msg.Destination())
+				if err != nil {
+					return err
+				}
 			}
 
-			err = protocol.Send(target, msg)
+			err = protocol.Send(&Target{Host: trg.Host, Port: trg.Port}, msg)
 			if err == nil {
 				break
 			}
@@ -269,17 +377,24 @@ func (tpl *layer) Send(msg sip.Message) error {
 			}
 		}
 		// resolve protocol from Via
-		protocol, ok := tpl.protocols.get(protocolKey(viaHop.Transport))
+		nt := viaHop.Transport
+		if target != nil && target.Transport != "" {
+			nt = target.Transport
+		}
+		protocol, ok := tpl.protocols.get(protocolKey(strings.ToUpper(nt)))
 		if !ok {
-			return UnsupportedProtocolError(fmt.Sprintf("protocol %s is not supported", viaHop.Transport))
+			return UnsupportedProtocolError(fmt.Sprintf("protocol %s is not supported", nt))
 		}
 
-		target, err := NewTargetFromAddr(msg.Destination())
-		if err != nil {
-			return err
+		if target == nil {
+			var err error
+			target, err = NewTargetFromAddr(msg.Destination())
+			if err != nil {
+				return err
+			}
 		}
 
-		return protocol.Send(target, msg)
+		return protocol.Send(&Target{Host: target.Host, Port: target.Port}, msg)
 	default:
 		return &sip.UnsupportedMessageError{
 			Err: fmt.Errorf("unsupported message %s", msg.Short()),
@@ -288,6 +403,31 @@ func (tpl *layer) Send(msg sip.Message) error {
 	}
 }
 
+// requestTransport returns upper cased transport param of the next hop URI
+// and whether request should be sent over secure transport:
+// Request-URI or the next hop URI is a SIPS URI or has transport=tls param.
+func requestTransport(req sip.Request) (transport string, secure bool) {
+	uri, ok := sip.NextHop(req).(*sip.SipUri)
+	if !ok {
+		return "", false
+	}
+	if recipient, ok := req.Recipient().(*sip.SipUri); ok && recipient.IsEncrypted {
+		secure = true
+	}
+	if uri.UriParams != nil {
+		if param, ok := uri.UriParams.Get("transport"); ok && param != nil {
+			transport = strings.ToUpper(param.String())
+		}
+	}
+
+	return transport, secure || uri.IsEncrypted || transport == "TLS" || transport == "WSS"
+}
+
+// newProtocol creates protocol of the network by the protocol factory with TLS config of the layer.
+func (tpl *layer) newProtocol(network string) (Protocol, error) {
+	return protocolFactory(network, tpl.pmsgs, tpl.perrs, tpl.canceled, tpl.tlsConfig)
+}
+
 func (tpl *layer) serveProtocols() {
 	defer func() {
 		tpl.Log().Infof("%s stops serves protocols", tpl)
@@ -355,7 +495,9 @@ func (tpl *layer) handleMessage(msg sip.Message) {
 		}
 	case sip.Request:
 		// incoming Request
-		// RFC 3261 - 18.2.1. - Receiving Request. already done in ConnectionHandler
+		// RFC 3261 - 18.2.1. - Receiving Request, RFC 3581 - 4.
+		// 'received' and 'rport' params of the top Via header are stamped by the connection handler
+		// for all protocols, so response destination is resolved from the request source.
 	default:
 		// unsupported message received, log and discard
 		tpl.Log().Warnf(
@@ -1,8 +1,10 @@
 package transport_test
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -223,3 +225,211 @@ var _ = Describe("TransportLayer", func() {
 		})
 	})
 })
+
+var _ = Describe("TransportLayer with Resolver", func() {
+	var (
+		tpl    transport.Layer
+		server net.Listener
+	)
+	localAddr := "127.0.0.1:9090"
+
+	BeforeEach(func() {
+		dns := testutils.NewFakeDNS().
+			AddSRV("_sip._tcp.example.com",
+				&net.SRV{Target: "sip1.example.com", Port: 9091, Priority: 10},
+				&net.SRV{Target: "sip2.example.com", Port: 9092, Priority: 20},
+			).
+			AddHost("sip1.example.com", "127.0.0.1").
+			AddHost("sip2.example.com", "127.0.0.1")
+		tpl = transport.NewLayerWithConfig("127.0.0.1", &transport.LayerConfig{
+			Resolver: transport.NewResolver(dns),
+		})
+		// listener of the previous layer may be closed a bit after its Done
+		Eventually(func() error { return tpl.Listen("tcp", localAddr) }).Should(Succeed())
+
+		var err error
+		server, err = net.Listen("tcp", "127.0.0.1:9092")
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func(done Done) {
+		server.Close()
+		tpl.Cancel()
+		<-tpl.Done()
+		close(done)
+	}, 3)
+
+	It("should resolve request targets", func() {
+		targets, err := tpl.Resolve(testutils.Request([]string{
+			"OPTIONS sip:bob@example.com;transport=tcp SIP/2.0",
+			"CSeq: 1 OPTIONS",
+			"",
+			"",
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(targets).To(HaveLen(2))
+		Expect(targets[0].Addr()).To(Equal("127.0.0.1:9091"))
+		Expect(targets[0].Transport).To(Equal("TCP"))
+		Expect(targets[1].Addr()).To(Equal("127.0.0.1:9092"))
+	})
+
+	It("should resolve targets of the loose router in the route set", func() {
+		targets, err := tpl.Resolve(testutils.Request([]string{
+			"OPTIONS sip:bob@192.168.0.1 SIP/2.0",
+			"Route: <sip:example.com;transport=tcp;lr>",
+			"CSeq: 1 OPTIONS",
+			"",
+			"",
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(targets).To(HaveLen(2))
+		Expect(targets[0].Addr()).To(Equal("127.0.0.1:9091"))
+	})
+
+	It("should send request to the explicit destination without resolving", func(done Done) {
+		received := make(chan string, 1)
+		go func() {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			buf := make([]byte, 65535)
+			num, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			received <- string(buf[:num])
+		}()
+
+		req := testutils.Request([]string{
+			"OPTIONS sip:bob@unknown.example.org;transport=tcp SIP/2.0",
+			"CSeq: 1 OPTIONS",
+			"Content-Length: 0",
+			"",
+			"",
+		})
+		req.SetDestination("127.0.0.1:9092")
+		targets, err := tpl.Resolve(req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(targets).To(HaveLen(1))
+		Expect(targets[0].Addr()).To(Equal("127.0.0.1:9092"))
+
+		Expect(tpl.Send(req)).To(Succeed())
+		Expect(<-received).To(HavePrefix("OPTIONS sip:bob@unknown.example.org;transport=tcp SIP/2.0"))
+		close(done)
+	}, 3)
+
+	It("should fail over to the next target on transport error", func(done Done) {
+		received := make(chan string, 1)
+		go func() {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			buf := make([]byte, 65535)
+			num, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			received <- string(buf[:num])
+		}()
+
+		req := testutils.Request([]string{
+			"OPTIONS sip:bob@example.com;transport=tcp SIP/2.0",
+			"CSeq: 1 OPTIONS",
+			"Content-Length: 0",
+			"",
+			"",
+		})
+		Expect(tpl.Send(req)).To(Succeed())
+		Expect(<-received).To(HavePrefix("OPTIONS sip:bob@example.com;transport=tcp SIP/2.0\r\nVia: SIP/2.0/TCP 127.0.0.1"))
+		close(done)
+	}, 3)
+})
+
+var _ = Describe("TransportLayer with protocol factory", func() {
+	var factory transport.ProtocolFactory
+
+	BeforeEach(func() {
+		factory = transport.GetProtocolFactory()
+	})
+	AfterEach(func() {
+		transport.SetProtocolFactory(factory)
+	})
+
+	It("should create secure protocols by the factory with TLS config of the layer", func(done Done) {
+		config := &tls.Config{ServerName: "example.com"}
+		configs := make(map[string]*tls.Config)
+		transport.SetProtocolFactory(func(
+			network string,
+			output chan<- sip.Message,
+			errs chan<- error,
+			cancel <-chan struct{},
+			tlsConfig *tls.Config,
+		) (transport.Protocol, error) {
+			configs[network] = tlsConfig
+			return nil, transport.UnsupportedProtocolError(network)
+		})
+
+		tpl := transport.NewLayerWithConfig("127.0.0.1", &transport.LayerConfig{TLSConfig: config})
+		Expect(tpl.Listen("tls", "127.0.0.1:5061")).ToNot(Succeed())
+		Expect(tpl.Listen("wss", "127.0.0.1:5081")).ToNot(Succeed())
+		Expect(configs).To(HaveKeyWithValue("tls", config))
+		Expect(configs).To(HaveKeyWithValue("wss", config))
+		tpl.Cancel()
+		<-tpl.Done()
+		close(done)
+	}, 3)
+
+	It("should send large request over TCP", func(done Done) {
+		sent := make(chan string, 1)
+		transport.SetProtocolFactory(func(
+			network string,
+			output chan<- sip.Message,
+			errs chan<- error,
+			cancel <-chan struct{},
+			tlsConfig *tls.Config,
+		) (transport.Protocol, error) {
+			protocol, err := factory(network, output, errs, cancel, tlsConfig)
+			if err != nil {
+				return nil, err
+			}
+			return &sendingProtocol{Protocol: protocol, sent: sent}, nil
+		})
+
+		tpl := transport.NewLayer("127.0.0.1")
+		// listeners of the previous spec may be closed a bit after shutdown
+		Eventually(func() error { return tpl.Listen("udp", "127.0.0.1:5093") }).Should(Succeed())
+		Eventually(func() error { return tpl.Listen("tcp", "127.0.0.1:5093") }).Should(Succeed())
+
+		for _, test := range []struct {
+			bodyLen int
+			network string
+		}{{1100, "UDP"}, {1400, "TCP"}} {
+			req := testutils.Request([]string{
+				"MESSAGE sip:bob@127.0.0.1:5094 SIP/2.0",
+				"CSeq: 1 MESSAGE",
+				"",
+				"",
+			})
+			req.SetBodyBytes([]byte(strings.Repeat("\xff", test.bodyLen)), true)
+			Expect(tpl.SendTo(req, transport.NewTarget("127.0.0.1", 5094))).To(Succeed())
+			Expect(strings.ToUpper(<-sent)).To(Equal(test.network))
+		}
+		tpl.Cancel()
+		<-tpl.Done()
+		close(done)
+	}, 3)
+})
+
+// sendingProtocol reports network of the sent messages instead of sending them.
+type sendingProtocol struct {
+	transport.Protocol
+	sent chan<- string
+}
+
+func (pr *sendingProtocol) Send(target *transport.Target, msg sip.Message) error {
+	pr.sent <- pr.Network()
+	return nil
+}
@@ -280,10 +280,8 @@ func (pool *listenerPool) dispose() {
 	// stop serveHandlers goroutine
 	close(pool.hconns)
 	close(pool.herrs)
-	// close store channels
-	close(pool.gets)
-	close(pool.updates)
-	close(pool.drops)
+	// store channels are not closed, requests may still be sent by the callers,
+	// they select on cancel signal instead
 }
 
 func (pool *listenerPool) serveHandlers() {
@@ -669,7 +667,11 @@ func (handler *listenerHandler) Done() <-chan struct{} {
 }
 
 func listenerNetwork(ls net.Listener) string {
-	switch ls.(type) {
+	switch ls := ls.(type) {
+	case *wsListener:
+		return ls.network
+	case *tlsListener:
+		return "tls"
 	case *net.TCPListener:
 		return "tcp"
 	case *net.UnixListener:
@@ -1,6 +1,8 @@
 package transport
 
 import (
+	"crypto/tls"
+	"bytes"
 	"fmt"
 	"strings"
 	"time"
@@ -26,11 +28,14 @@ type Protocol interface {
 	String() string
 }
 
+// ProtocolFactory creates protocol of the network,
+// tlsConfig is TLS config of the transport layer used by secure protocols, it may be nil.
 type ProtocolFactory func(
 	network string,
 	output chan<- sip.Message,
 	errs chan<- error,
 	cancel <-chan struct{},
+	tlsConfig *tls.Config,
 ) (Protocol, error)
 
 type protocol struct {
@@ -69,3 +74,14 @@ func (pr *protocol) Reliable() bool {
 func (pr *protocol) Streamed() bool {
 	return pr.streamed
 }
+
+// messageData returns message in wire format, the message is written once into the buffer
+// to send it with single write, the body is not converted to string.
+func messageData(msg sip.Message) ([]byte, error) {
+	var buffer bytes.Buffer
+	if _, err := msg.WriteTo(&buffer); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
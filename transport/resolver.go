@@ -0,0 +1,351 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/masterclock/gosip/sip"
+)
+
+// NAPTR is a DNS NAPTR resource record (RFC 3403).
+type NAPTR struct {
+	Order       uint16
+	Preference  uint16
+	Flags       string
+	Service     string
+	Regexp      string
+	Replacement string
+}
+
+// DNS performs DNS queries required to locate SIP servers.
+type DNS interface {
+	// LookupNAPTR returns NAPTR records of the domain or ErrNAPTRUnsupported
+	// if the client can not query them, resolution starts with SRV queries then.
+	LookupNAPTR(name string) ([]*NAPTR, error)
+	// LookupSRV returns SRV records of the fully qualified service name, e.g. _sip._udp.example.com.
+	LookupSRV(name string) ([]*net.SRV, error)
+	// LookupHost returns addresses (A and AAAA records) of the host.
+	LookupHost(host string) ([]string, error)
+}
+
+// Resolver turns SIP URI into ordered list of targets (RFC 3263 - 4.).
+type Resolver interface {
+	Resolve(uri sip.Uri) ([]*Target, error)
+}
+
+// NAPTR services (RFC 3263 - 4.1., RFC 7118 - 8.) mapped to transports
+var naptrServices = map[string]string{
+	"SIP+D2U":  "UDP",
+	"SIP+D2T":  "TCP",
+	"SIPS+D2T": "TLS",
+	"SIP+D2W":  "WS",
+	"SIPS+D2W": "WSS",
+}
+
+// srvPrefix returns SRV service prefix of the transport.
+func srvPrefix(transport string) string {
+	switch strings.ToUpper(transport) {
+	case "UDP":
+		return "_sip._udp."
+	case "TCP":
+		return "_sip._tcp."
+	case "TLS":
+		return "_sips._tcp."
+	case "WS":
+		return "_sip._ws."
+	case "WSS":
+		return "_sips._ws."
+	default:
+		return ""
+	}
+}
+
+func isSecureTransport(transport string) bool {
+	switch strings.ToUpper(transport) {
+	case "TLS", "WSS":
+		return true
+	default:
+		return false
+	}
+}
+
+// ErrNAPTRUnsupported is returned by DNS client which can not query NAPTR records.
+var ErrNAPTRUnsupported = errors.New("NAPTR lookup is not supported")
+
+// netDNS is a DNS implementation on top of the net package.
+// NAPTR queries are not supported by the net package, so LookupNAPTR always returns ErrNAPTRUnsupported
+// and resolution starts with SRV queries. DNS client with NAPTR support can be passed to NewResolver.
+type netDNS struct{}
+
+func (dns netDNS) LookupNAPTR(name string) ([]*NAPTR, error) {
+	return nil, ErrNAPTRUnsupported
+}
+
+func (dns netDNS) LookupSRV(name string) ([]*net.SRV, error) {
+	_, records, err := net.LookupSRV("", "", name)
+	return records, err
+}
+
+func (dns netDNS) LookupHost(host string) ([]string, error) {
+	return net.LookupHost(host)
+}
+
+// DefaultDNS resolves names with the net package.
+var DefaultDNS DNS = netDNS{}
+
+// RFC 3263 resolver implementation.
+type resolver struct {
+	dns        DNS
+	transports []string
+}
+
+// NewResolver creates RFC 3263 resolver.
+// 	- dns - DNS client, DefaultDNS is used if nil
+// 	- transports - supported transports in order of preference, used when
+// 	  URI does not define transport and domain has no NAPTR records;
+// 	  defaults to UDP, TCP, TLS
+func NewResolver(dns DNS, transports ...string) Resolver {
+	if dns == nil {
+		dns = DefaultDNS
+	}
+	if len(transports) == 0 {
+		transports = []string{"UDP", "TCP", "TLS"}
+	}
+	supported := make([]string, len(transports))
+	for i, transport := range transports {
+		supported[i] = strings.ToUpper(transport)
+	}
+
+	return &resolver{dns, supported}
+}
+
+func (r *resolver) Resolve(uri sip.Uri) ([]*Target, error) {
+	sipUri, ok := uri.(*sip.SipUri)
+	if !ok {
+		return nil, fmt.Errorf("failed to resolve %s: only SIP and SIPS URIs are supported", uri)
+	}
+
+	host := sipUri.Host
+	var transport string
+	if sipUri.UriParams != nil {
+		if maddr, ok := sipUri.UriParams.Get("maddr"); ok && maddr != nil && maddr.String() != "" {
+			host = maddr.String()
+		}
+		if param, ok := sipUri.UriParams.Get("transport"); ok && param != nil {
+			transport = strings.ToUpper(param.String())
+		}
+	}
+	if host == "" {
+		return nil, fmt.Errorf("failed to resolve %s: empty host", uri)
+	}
+	secure := sipUri.IsEncrypted
+	if secure {
+		switch transport {
+		case "TCP":
+			transport = "TLS"
+		case "WS":
+			transport = "WSS"
+		}
+	}
+	numeric := net.ParseIP(strings.Trim(host, "[]")) != nil
+
+	// RFC 3263 - 4.1. - Selecting a Transport Protocol
+	if transport == "" {
+		if !numeric && sipUri.Port == nil {
+			if targets := r.resolveNAPTR(host, secure); len(targets) > 0 {
+				return targets, nil
+			}
+			if targets := r.resolveSRVs(host, secure); len(targets) > 0 {
+				return targets, nil
+			}
+		}
+
+		if secure {
+			transport = "TLS"
+		} else {
+			transport = "UDP"
+		}
+	}
+
+	// RFC 3263 - 4.2. - Determining Port and IP Address
+	// invalid domain names (RFC 7118 - 5.) can be reached only over existing connections,
+	// so they are passed as is to the transport
+	if numeric || strings.HasSuffix(strings.ToLower(host), ".invalid") {
+		port := DefaultPort(transport)
+		if sipUri.Port != nil {
+			port = *sipUri.Port
+		}
+		return []*Target{{Host: strings.Trim(host, "[]"), Port: &port, Transport: transport}}, nil
+	}
+
+	if sipUri.Port == nil {
+		if targets := r.resolveSRV(srvPrefix(transport)+host, transport); len(targets) > 0 {
+			return targets, nil
+		}
+	}
+
+	port := DefaultPort(transport)
+	if sipUri.Port != nil {
+		port = *sipUri.Port
+	}
+	targets, err := r.resolveHost(host, port, transport)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("failed to resolve %s: no addresses found", uri)
+	}
+
+	return targets, nil
+}
+
+func (r *resolver) supports(transport string) bool {
+	for _, t := range r.transports {
+		if t == transport {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *resolver) resolveNAPTR(host string, secure bool) []*Target {
+	records, err := r.dns.LookupNAPTR(host)
+	if err != nil {
+		// missing records and unsupported NAPTR queries (ErrNAPTRUnsupported) are not an error
+		return nil
+	}
+
+	records = append([]*NAPTR{}, records...)
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Order != records[j].Order {
+			return records[i].Order < records[j].Order
+		}
+		return records[i].Preference < records[j].Preference
+	})
+
+	targets := make([]*Target, 0)
+	for _, record := range records {
+		transport, ok := naptrServices[strings.ToUpper(record.Service)]
+		if !ok || !strings.EqualFold(record.Flags, "s") {
+			continue
+		}
+		// SIPS URI requires secure transports only
+		if secure && !isSecureTransport(transport) {
+			continue
+		}
+		if !r.supports(transport) {
+			continue
+		}
+		targets = append(targets, r.resolveSRV(record.Replacement, transport)...)
+	}
+
+	return targets
+}
+
+func (r *resolver) resolveSRVs(host string, secure bool) []*Target {
+	targets := make([]*Target, 0)
+	for _, transport := range r.transports {
+		if secure && !isSecureTransport(transport) {
+			continue
+		}
+		prefix := srvPrefix(transport)
+		if prefix == "" {
+			continue
+		}
+		targets = append(targets, r.resolveSRV(prefix+host, transport)...)
+	}
+
+	return targets
+}
+
+func (r *resolver) resolveSRV(name string, transport string) []*Target {
+	records, err := r.dns.LookupSRV(strings.TrimSuffix(name, "."))
+	if err != nil {
+		// missing records are not an error
+		return nil
+	}
+
+	targets := make([]*Target, 0)
+	for _, record := range orderSRV(records) {
+		// RFC 2782 - target "." means service is not available
+		host := strings.TrimSuffix(record.Target, ".")
+		if host == "" {
+			continue
+		}
+		found, err := r.resolveHost(host, sip.Port(record.Port), transport)
+		if err != nil {
+			// try the next SRV target
+			continue
+		}
+		targets = append(targets, found...)
+	}
+
+	return targets
+}
+
+func (r *resolver) resolveHost(host string, port sip.Port, transport string) ([]*Target, error) {
+	addrs, err := r.dns.LookupHost(strings.TrimSuffix(host, "."))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve host %s: %s", host, err)
+	}
+
+	targets := make([]*Target, 0, len(addrs))
+	for _, addr := range addrs {
+		p := port
+		targets = append(targets, &Target{Host: addr, Port: &p, Transport: transport})
+	}
+
+	return targets, nil
+}
+
+// orderSRV orders SRV records by priority and weight (RFC 2782).
+func orderSRV(records []*net.SRV) []*net.SRV {
+	records = append([]*net.SRV{}, records...)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Priority < records[j].Priority
+	})
+
+	ordered := make([]*net.SRV, 0, len(records))
+	for i := 0; i < len(records); {
+		j := i
+		for j < len(records) && records[j].Priority == records[i].Priority {
+			j++
+		}
+		ordered = append(ordered, shuffleByWeight(records[i:j])...)
+		i = j
+	}
+
+	return ordered
+}
+
+func shuffleByWeight(records []*net.SRV) []*net.SRV {
+	records = append([]*net.SRV{}, records...)
+	ordered := make([]*net.SRV, 0, len(records))
+	for len(records) > 0 {
+		sum := 0
+		for _, record := range records {
+			sum += int(record.Weight)
+		}
+		n := 0
+		if sum > 0 {
+			n = rand.Intn(sum + 1)
+		}
+		idx := 0
+		for i, record := range records {
+			n -= int(record.Weight)
+			if n <= 0 {
+				idx = i
+				break
+			}
+		}
+		ordered = append(ordered, records[idx])
+		records = append(records[:idx], records[idx+1:]...)
+	}
+
+	return ordered
+}
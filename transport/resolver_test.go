@@ -0,0 +1,161 @@
+package transport_test
+
+import (
+	"net"
+
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/sip/parser"
+	"github.com/masterclock/gosip/testutils"
+	"github.com/masterclock/gosip/transport"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Resolver", func() {
+	var (
+		dns      *testutils.FakeDNS
+		resolver transport.Resolver
+	)
+
+	resolve := func(rawUri string) ([]string, error) {
+		uri, err := parser.ParseUri(rawUri)
+		Expect(err).ToNot(HaveOccurred())
+		targets, err := resolver.Resolve(uri)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]string, 0, len(targets))
+		for _, target := range targets {
+			result = append(result, target.Transport+" "+target.Addr())
+		}
+		return result, nil
+	}
+
+	BeforeEach(func() {
+		dns = testutils.NewFakeDNS().
+			AddHost("example.com", "192.0.2.1").
+			AddHost("sip1.example.com", "192.0.2.11").
+			AddHost("sip2.example.com", "192.0.2.12", "192.0.2.13").
+			AddHost("sip3.example.com", "192.0.2.14")
+		resolver = transport.NewResolver(dns)
+	})
+
+	Context("when URI host is numeric IP", func() {
+		It("should use UDP for SIP URI and default port", func() {
+			Expect(resolve("sip:bob@192.0.2.100")).To(Equal([]string{"UDP 192.0.2.100:5060"}))
+		})
+		It("should use TLS for SIPS URI and explicit port", func() {
+			Expect(resolve("sips:bob@192.0.2.100:5071")).To(Equal([]string{"TLS 192.0.2.100:5071"}))
+		})
+		It("should use transport param", func() {
+			Expect(resolve("sip:bob@192.0.2.100;transport=tcp")).To(Equal([]string{"TCP 192.0.2.100:5060"}))
+		})
+	})
+
+	Context("when URI has explicit port", func() {
+		BeforeEach(func() {
+			dns.AddSRV("_sip._udp.example.com", &net.SRV{Target: "sip1.example.com", Port: 5070, Priority: 10})
+		})
+		It("should skip SRV and lookup addresses", func() {
+			Expect(resolve("sip:bob@example.com:5080")).To(Equal([]string{"UDP 192.0.2.1:5080"}))
+		})
+	})
+
+	Context("when URI has transport param", func() {
+		BeforeEach(func() {
+			dns.AddSRV("_sip._tcp.example.com",
+				&net.SRV{Target: "sip2.example.com.", Port: 5080, Priority: 20},
+				&net.SRV{Target: "sip1.example.com.", Port: 5070, Priority: 10},
+			)
+		})
+		It("should query SRV of the transport ordered by priority", func() {
+			Expect(resolve("sip:bob@example.com;transport=tcp")).To(Equal([]string{
+				"TCP 192.0.2.11:5070",
+				"TCP 192.0.2.12:5080",
+				"TCP 192.0.2.13:5080",
+			}))
+		})
+		It("should fall back to address records with default port", func() {
+			Expect(resolve("sip:bob@example.com;transport=udp")).To(Equal([]string{"UDP 192.0.2.1:5060"}))
+		})
+	})
+
+	Context("when domain has NAPTR records", func() {
+		BeforeEach(func() {
+			dns.AddNAPTR("example.com",
+				&transport.NAPTR{Order: 50, Preference: 50, Flags: "s", Service: "SIP+D2U",
+					Replacement: "_sip._udp.example.com"},
+				&transport.NAPTR{Order: 50, Preference: 10, Flags: "s", Service: "SIP+D2T",
+					Replacement: "_sip._tcp.example.com"},
+				&transport.NAPTR{Order: 90, Preference: 50, Flags: "s", Service: "SIPS+D2T",
+					Replacement: "_sips._tcp.example.com"},
+				&transport.NAPTR{Order: 10, Preference: 10, Flags: "s", Service: "SIP+D2X",
+					Replacement: "_sip._x.example.com"},
+			).
+				AddSRV("_sip._udp.example.com", &net.SRV{Target: "sip1.example.com", Port: 5060}).
+				AddSRV("_sip._tcp.example.com", &net.SRV{Target: "sip2.example.com", Port: 5060}).
+				AddSRV("_sips._tcp.example.com", &net.SRV{Target: "sip3.example.com", Port: 5061})
+		})
+		It("should order supported services by order and preference", func() {
+			Expect(resolve("sip:bob@example.com")).To(Equal([]string{
+				"TCP 192.0.2.12:5060",
+				"TCP 192.0.2.13:5060",
+				"UDP 192.0.2.11:5060",
+				"TLS 192.0.2.14:5061",
+			}))
+		})
+		It("should select only secure services for SIPS URI", func() {
+			Expect(resolve("sips:bob@example.com")).To(Equal([]string{"TLS 192.0.2.14:5061"}))
+		})
+	})
+
+	Context("when domain has only SRV records", func() {
+		BeforeEach(func() {
+			dns.AddSRV("_sip._tcp.example.com", &net.SRV{Target: "sip2.example.com", Port: 5090}).
+				AddSRV("_sip._udp.example.com", &net.SRV{Target: "sip1.example.com", Port: 5070})
+		})
+		It("should query SRV of supported transports", func() {
+			Expect(resolve("sip:bob@example.com")).To(Equal([]string{
+				"UDP 192.0.2.11:5070",
+				"TCP 192.0.2.12:5090",
+				"TCP 192.0.2.13:5090",
+			}))
+		})
+	})
+
+	Context("when domain has no NAPTR and SRV records", func() {
+		It("should use address records with default port", func() {
+			Expect(resolve("sips:bob@example.com")).To(Equal([]string{"TLS 192.0.2.1:5061"}))
+		})
+		It("should fail for unknown domain", func() {
+			_, err := resolve("sip:bob@unknown.com")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("should fail to resolve non SIP URI", func() {
+		_, err := resolver.Resolve(&sip.WildcardUri{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should start with SRV queries if NAPTR lookup is not supported", func() {
+		records, err := transport.DefaultDNS.LookupNAPTR("example.com")
+		Expect(err).To(Equal(transport.ErrNAPTRUnsupported))
+		Expect(records).To(BeEmpty())
+
+		dns.AddNAPTR("example.com", &transport.NAPTR{Order: 10, Preference: 10, Flags: "s", Service: "SIP+D2T",
+			Replacement: "_sip._tcp.example.com"}).
+			AddSRV("_sip._udp.example.com", &net.SRV{Target: "sip1.example.com", Port: 5070})
+		resolver = transport.NewResolver(noNAPTR{dns})
+		Expect(resolve("sip:bob@example.com")).To(Equal([]string{"UDP 192.0.2.11:5070"}))
+	})
+})
+
+// noNAPTR is DNS client that can not query NAPTR records.
+type noNAPTR struct {
+	*testutils.FakeDNS
+}
+
+func (dns noNAPTR) LookupNAPTR(name string) ([]*transport.NAPTR, error) {
+	return nil, transport.ErrNAPTRUnsupported
+}
@@ -122,7 +122,11 @@ func (tcp *tcpProtocol) Send(target *Target, msg sip.Message) error {
 		return err
 	}
 	// send message
-	_, err = conn.Write([]byte(msg.String()))
+	data, err := messageData(msg)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(data)
 
 	return err
 }
@@ -1,31 +1,54 @@
 package transport
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net"
+	"strings"
+	"time"
 
 	"github.com/masterclock/gosip/log"
 	"github.com/masterclock/gosip/sip"
 )
 
+// tlsDialTimeout limits establishing of outgoing TLS connection including the handshake.
+var tlsDialTimeout = 10 * time.Second
+
+// TLS protocol implementation
 type tlsProtocol struct {
 	protocol
+	config      *tls.Config
 	listeners   ListenerPool
 	connections ConnectionPool
 	conns       chan Connection
+	done        chan struct{}
 }
 
-func NewTlsProtocol(output chan<- sip.Message, errs chan<- error, cancel <-chan struct{}) Protocol {
+// NewTlsProtocol creates TLS protocol that uses provided config
+// for both incoming (server certificates, client certificates verification, SNI)
+// and outgoing connections.
+func NewTlsProtocol(
+	output chan<- sip.Message,
+	errs chan<- error,
+	cancel <-chan struct{},
+	config *tls.Config,
+) Protocol {
 	tls := new(tlsProtocol)
 	tls.network = "tls"
 	tls.reliable = true
 	tls.streamed = true
+	tls.config = config
 	tls.conns = make(chan Connection)
+	tls.done = make(chan struct{})
+	tls.logger = log.NewSafeLocalLogger()
 	// TODO: add separate errs chan to listen errors from pool for reconnection?
 	tls.listeners = NewListenerPool(tls.conns, errs, cancel)
+	tls.listeners.SetLog(tls.Log())
 	tls.connections = NewConnectionPool(output, errs, cancel)
-	tls.logger = log.NewSafeLocalLogger()
+	tls.connections.SetLog(tls.Log())
 	// pipe listener and connection pools
 	go tls.pipePools()
+	go tls.serveDone()
 
 	return tls
 }
@@ -41,24 +64,29 @@ func (tls *tlsProtocol) SetLog(logger log.Logger) {
 }
 
 func (tls *tlsProtocol) Done() <-chan struct{} {
-	tls.Log().Fatalf("not implemented method in %s", tls)
-	return nil
+	return tls.done
+}
+
+// done when both listeners and connections are released
+func (tls *tlsProtocol) serveDone() {
+	<-tls.listeners.Done()
+	<-tls.connections.Done()
+	close(tls.done)
 }
 
 // piping new connections to connection pool for serving
 func (tls *tlsProtocol) pipePools() {
 	defer func() {
-		tls.Log().Debugf("stop %s managing", tls)
-		tls.dispose()
+		tls.Log().Infof("%s stops pipe pools", tls)
+		close(tls.conns)
 	}()
-	tls.Log().Debugf("start %s managing", tls)
+	tls.Log().Infof("%s starts pipe pools", tls)
 
 	for {
 		select {
-		case conn, ok := <-tls.conns:
-			if !ok {
-				return
-			}
+		case <-tls.listeners.Done():
+			return
+		case conn := <-tls.conns:
 			if err := tls.connections.Put(ConnectionKey(conn.RemoteAddr().String()), conn, sockTTL); err != nil {
 				// TODO should it be passed up to UA?
 				tls.Log().Errorf("%s failed to put new %s to %s: %s", tls, conn, tls.connections, err)
@@ -68,108 +96,144 @@ func (tls *tlsProtocol) pipePools() {
 	}
 }
 
-func (tls *tlsProtocol) dispose() {
-	tls.Log().Debugf("dispose %s", tls)
-	close(tls.conns)
-}
-
 func (tls *tlsProtocol) Listen(target *Target) error {
-	tls.Log().Fatalf("not implemented method in %s", tls)
-	return fmt.Errorf("not implemented method in %s", tls)
-	//target = FillTargetHostAndPort(tls.Network(), target)
-	//network := strings.ToLower(tls.Network())
-	//// resolve local TCP endpoint
-	//laddr, err := tls.resolveTarget(target)
-	//if err != nil {
-	//	return err
-	//}
-	//// create listener
-	//listener, err := net.ListenTCP(network, laddr)
-	//if err != nil {
-	//	return &ProtocolError{
-	//		fmt.Errorf("failed to listen address %s: %s", laddr, err),
-	//		fmt.Sprintf("create %s listener", tls.Network()),
-	//		tls,
-	//	}
-	//}
-	//// index listeners by local address
-	//tls.listeners.Put(listener.Addr(), listener)
-	//
-	//return err // should be nil here
+	target = FillTargetHostAndPort(tls.Network(), target)
+	// server side requires at least one certificate
+	if tls.config == nil ||
+		(len(tls.config.Certificates) == 0 && tls.config.GetCertificate == nil && tls.config.GetConfigForClient == nil) {
+		return &ProtocolError{
+			fmt.Errorf("TLS config with server certificates is required"),
+			fmt.Sprintf("create %s listener", tls.Network()),
+			tls.String(),
+		}
+	}
+	// resolve local TCP endpoint
+	laddr, err := tls.resolveTarget(target)
+	if err != nil {
+		return err
+	}
+	// create listener
+	listener, err := net.ListenTCP("tcp", laddr)
+	if err != nil {
+		return &ProtocolError{
+			fmt.Errorf("failed to listen address %s: %s", laddr, err),
+			fmt.Sprintf("create %s listener", tls.Network()),
+			tls.String(),
+		}
+	}
+	tls.Log().Infof("%s begins listening on %s", tls, target)
+	// index listeners by local address
+	// should live infinitely
+	err = tls.listeners.Put(ListenerKey(laddr.String()), newTlsListener(listener, tls.config))
+
+	return err // should be nil here
 }
 
 func (tls *tlsProtocol) Send(target *Target, msg sip.Message) error {
-	tls.Log().Fatalf("not implemented method in %s", tls)
-	return fmt.Errorf("not implemented method in %s", tls)
-	//target = FillTargetHostAndPort(tls.Network(), target)
-	//
-	//tls.Log().Infof("sending message '%s' to %s", msg.Short(), target.Addr())
-	//tls.Log().Debugf("sending message '%s' to %s:\r\n%s", msg.Short(), target.Addr(), msg)
-	//
-	//// validate remote address
-	//if target.Host == "" || target.Host == DefaultHost {
-	//	return &ProtocolError{
-	//		fmt.Errorf("invalid remote host resolved %s", target.Host),
-	//		"resolve destination address",
-	//		tls,
-	//	}
-	//}
-	//// resolve remote address
-	//raddr, err := tls.resolveTarget(target)
-	//if err != nil {
-	//	return err
-	//}
-	//// find or create connection
-	//conn, err := tls.getOrCreateConnection(raddr)
-	//if err != nil {
-	//	return err
-	//}
-	//// send message
-	//_, err = conn.Write([]byte(msg.String()))
-	//
-	//return err
-}
-
-//func (tls *tlsProtocol) resolveTarget(target *Target) (*net.TCPAddr, error) {
-//	addr := target.Addr()
-//	network := strings.ToLower(tls.Network())
-//	// resolve remote address
-//	raddr, err := net.ResolveTCPAddr(network, addr)
-//	if err != nil {
-//		return nil, &ProtocolError{
-//			fmt.Errorf("failed to resolve address %s: %s", addr, err),
-//			fmt.Sprintf("resolve %s address", addr),
-//			tls,
-//		}
-//	}
-//
-//	return raddr, nil
-//}
-//
-//func (tls *tlsProtocol) getOrCreateConnection(raddr *net.TCPAddr) (Connection, error) {
-//	network := strings.ToLower(tls.Network())
-//	laddr := &net.TCPAddr{
-//		IP:   net.IP(DefaultHost),
-//		Port: int(DefaultUdpPort),
-//		Zone: "",
-//	}
-//
-//	conn, ok := tls.connections.Get(raddr)
-//	if !ok {
-//		tls.Log().Debugf("connection for address %s not found; create a new one", raddr)
-//		tcpConn, err := net.DialTCP(network, laddr, raddr)
-//		if err != nil {
-//			return nil, &ProtocolError{
-//				fmt.Errorf("failed to create connection to remote address %s: %s", raddr, err),
-//				fmt.Sprintf("create %s connection", tls.Network()),
-//				tls,
-//			}
-//		}
-//
-//		conn = NewConnection(tcpConn)
-//		conn.SetLog(tls.Log())
-//		tls.connections.Put(conn.RemoteAddr(), conn, sockTTL)
-//	}
-//
-//	return conn, nil
-//}
+	target = FillTargetHostAndPort(tls.Network(), target)
+
+	tls.Log().Infof("%s sends message '%s' to %s", tls, msg.Short(), target.Addr())
+	tls.Log().Debugf("message:\n%s", msg)
+
+	// validate remote address
+	if target.Host == "" {
+		return &ProtocolError{
+			fmt.Errorf("invalid remote host resolved %s", target.Host),
+			"resolve destination address",
+			tls.String(),
+		}
+	}
+	// resolve remote address
+	raddr, err := tls.resolveTarget(target)
+	if err != nil {
+		return err
+	}
+
+	// find or create connection
+	conn, err := tls.getOrCreateConnection(target.Host, raddr)
+	if err != nil {
+		return err
+	}
+	// send message
+	data, err := messageData(msg)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(data)
+
+	return err
+}
+
+func (tls *tlsProtocol) resolveTarget(target *Target) (*net.TCPAddr, error) {
+	addr := target.Addr()
+	// resolve remote address
+	raddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, &ProtocolError{
+			fmt.Errorf("failed to resolve address %s: %s", addr, err),
+			fmt.Sprintf("resolve %s address", addr),
+			tls.String(),
+		}
+	}
+
+	return raddr, nil
+}
+
+func (tls *tlsProtocol) getOrCreateConnection(serverName string, raddr *net.TCPAddr) (Connection, error) {
+	conn, err := tls.connections.Get(ConnectionKey(raddr.String()))
+	if err != nil {
+		tls.Log().Debugf("connection for address %s not found; create a new one", raddr)
+
+		tlsConn, err := dialTls(raddr, tls.config, serverName)
+		if err != nil {
+			return nil, &ProtocolError{
+				fmt.Errorf("failed to create TLS connection to remote address %s: %s", raddr, err),
+				fmt.Sprintf("create %s connection", tls.Network()),
+				tls.String(),
+			}
+		}
+
+		conn = NewConnection(tlsConn)
+		conn.SetLog(tls.Log())
+		tls.connections.Put(ConnectionKey(conn.RemoteAddr().String()), conn, sockTTL)
+	}
+
+	return conn, nil
+}
+
+// tlsListener wraps TLS listener to distinguish it from plain TCP listeners.
+type tlsListener struct {
+	net.Listener
+}
+
+func newTlsListener(listener net.Listener, config *tls.Config) net.Listener {
+	return &tlsListener{tls.NewListener(listener, config)}
+}
+
+// dialTls connects to the remote address and runs client side TLS handshake.
+// Dialer timeout is the deadline of the handshake as well, so unresponsive peer does not hang the sender.
+func dialTls(raddr *net.TCPAddr, config *tls.Config, serverName string) (*tls.Conn, error) {
+	dialer := &net.Dialer{Timeout: tlsDialTimeout}
+	return tls.DialWithDialer(dialer, "tcp", raddr.String(), tlsClientConfig(config, serverName))
+}
+
+// newTlsClientConn wraps TCP connection with client side TLS.
+func newTlsClientConn(conn net.Conn, config *tls.Config, serverName string) *tls.Conn {
+	return tls.Client(conn, tlsClientConfig(config, serverName))
+}
+
+// tlsClientConfig returns config of client side TLS. Server name for verification and SNI
+// is taken from the target host unless it is already defined in the config.
+func tlsClientConfig(config *tls.Config, serverName string) *tls.Config {
+	var cfg *tls.Config
+	if config == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = config.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = strings.Trim(serverName, "[]")
+	}
+
+	return cfg
+}
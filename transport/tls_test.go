@@ -0,0 +1,292 @@
+package transport_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/testutils"
+	"github.com/masterclock/gosip/timing"
+	"github.com/masterclock/gosip/transport"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// testPKI is a self-signed CA with certificates issued by it.
+type testPKI struct {
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	pool   *x509.CertPool
+}
+
+func newTestPKI() *testPKI {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gosip test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &testPKI{cert, key, pool}
+}
+
+func (pki *testPKI) issue(serial int64, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, pki.caCert, &key.PublicKey, pki.caKey)
+	Expect(err).ToNot(HaveOccurred())
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+var _ = Describe("TlsProtocol", func() {
+	var (
+		output   chan sip.Message
+		errs     chan error
+		cancel   chan struct{}
+		protocol transport.Protocol
+		config   *tls.Config
+		client1  net.Conn
+		wg       *sync.WaitGroup
+	)
+
+	port1 := 9070
+	port2 := port1 + 1
+	localTarget1 := transport.NewTarget(transport.DefaultHost, port1)
+	msg1 := "INVITE sips:bob@far-far-away.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/TLS pc33.far-far-away.com;branch=z9hG4bK776asdhds\r\n" +
+		"To: \"Bob\" <sips:bob@far-far-away.com>\r\n" +
+		"From: \"Alice\" <sips:alice@wonderland.com>;tag=1928301774\r\n" +
+		"Content-Length: 12\r\n" +
+		"\r\n" +
+		"Hello world!"
+	expectedMsg1 := "INVITE sips:bob@far-far-away.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/TLS pc33.far-far-away.com;branch=z9hG4bK776asdhds;received=%s\r\n" +
+		"To: \"Bob\" <sips:bob@far-far-away.com>\r\n" +
+		"From: \"Alice\" <sips:alice@wonderland.com>;tag=1928301774\r\n" +
+		"Content-Length: 12\r\n" +
+		"\r\n" +
+		"Hello world!"
+
+	timing.MockMode = true
+
+	pki := newTestPKI()
+	serverCert := pki.issue(2, "localhost", x509.ExtKeyUsageServerAuth)
+	clientCert := pki.issue(3, "client.localhost", x509.ExtKeyUsageClientAuth)
+
+	dial := func(certs []tls.Certificate) net.Conn {
+		conn, err := tls.Dial("tcp", localTarget1.Addr(), &tls.Config{
+			RootCAs:      pki.pool,
+			ServerName:   "localhost",
+			Certificates: certs,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return conn
+	}
+
+	BeforeEach(func() {
+		wg = new(sync.WaitGroup)
+		output = make(chan sip.Message)
+		errs = make(chan error)
+		cancel = make(chan struct{})
+		config = &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			RootCAs:      pki.pool,
+		}
+	})
+	JustBeforeEach(func() {
+		protocol = transport.NewTlsProtocol(output, errs, cancel, config)
+	})
+	AfterEach(func(done Done) {
+		wg.Wait()
+		select {
+		case <-cancel:
+		default:
+			close(cancel)
+		}
+		<-protocol.Done()
+		if client1 != nil {
+			client1.Close()
+			client1 = nil
+		}
+		close(output)
+		close(errs)
+		close(done)
+	}, 3)
+
+	Context("just initialized", func() {
+		It("should has Network = TLS", func() {
+			Expect(protocol.Network()).To(Equal("TLS"))
+		})
+		It("should be reliable", func() {
+			Expect(protocol.Reliable()).To(BeTrue())
+		})
+		It("should be streamed", func() {
+			Expect(protocol.Streamed()).To(BeTrue())
+		})
+	})
+
+	Context("without server certificates", func() {
+		BeforeEach(func() {
+			config = &tls.Config{RootCAs: pki.pool}
+		})
+		It("should fail to listen", func() {
+			Expect(protocol.Listen(localTarget1)).ToNot(Succeed())
+		})
+	})
+
+	Context(fmt.Sprintf("listens target %s", localTarget1), func() {
+		JustBeforeEach(func() {
+			Expect(protocol.Listen(localTarget1)).To(Succeed())
+			time.Sleep(time.Millisecond)
+		})
+
+		Context("when client sends invite request", func() {
+			JustBeforeEach(func() {
+				client1 = dial(nil)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					time.Sleep(100 * time.Millisecond)
+					testutils.WriteToConn(client1, []byte(msg1))
+				}()
+			})
+			It("should receive message and response with 200 OK over the same connection", func(done Done) {
+				By("msg1 arrives")
+				testutils.AssertMessageArrived(output, fmt.Sprintf(expectedMsg1, client1.LocalAddr().(*net.TCPAddr).IP), client1.LocalAddr().String(), "far-far-away.com:5061")
+
+				By("sends response 200 OK")
+				clientTarget, err := transport.NewTargetFromAddr(client1.LocalAddr().String())
+				Expect(err).ToNot(HaveOccurred())
+				msg := sip.NewResponse(
+					"SIP/2.0",
+					200,
+					"OK",
+					[]sip.Header{
+						&sip.CSeq{SeqNo: 2, MethodName: sip.INVITE},
+					},
+					"")
+				Expect(protocol.Send(clientTarget, msg)).To(Succeed())
+
+				By("client receives 200 OK")
+				buf := make([]byte, 65535)
+				num, err := client1.Read(buf)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(buf[:num])).To(Equal(msg.String()))
+				close(done)
+			}, 3)
+		})
+
+		Context("with required client certificate", func() {
+			BeforeEach(func() {
+				config.ClientCAs = pki.pool
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			})
+			It("should accept client with valid certificate", func(done Done) {
+				client1 = dial([]tls.Certificate{clientCert})
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					testutils.WriteToConn(client1, []byte(msg1))
+				}()
+				testutils.AssertMessageArrived(output, fmt.Sprintf(expectedMsg1, client1.LocalAddr().(*net.TCPAddr).IP), client1.LocalAddr().String(), "far-far-away.com:5061")
+				close(done)
+			}, 3)
+			It("should reject client without certificate", func(done Done) {
+				client1 = dial(nil)
+				client1.Write([]byte(msg1))
+				buf := make([]byte, 65535)
+				_, err := client1.Read(buf)
+				Expect(err).To(HaveOccurred())
+				Consistently(output, 100*time.Millisecond).ShouldNot(Receive())
+				close(done)
+			}, 3)
+		})
+	})
+
+	Context("sends request to remote server", func() {
+		var (
+			server     net.Listener
+			serverName chan string
+		)
+
+		BeforeEach(func() {
+			serverName = make(chan string, 1)
+			var err error
+			server, err = tls.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port2), &tls.Config{
+				Certificates: []tls.Certificate{serverCert},
+				GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+					serverName <- hello.ServerName
+					return nil, nil
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should verify server certificate and use SNI", func(done Done) {
+			received := make(chan string, 1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn, err := server.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				buf := make([]byte, 65535)
+				num, err := conn.Read(buf)
+				if err != nil {
+					return
+				}
+				received <- string(buf[:num])
+			}()
+
+			msg := testutils.Message([]string{
+				"INVITE sips:bob@localhost SIP/2.0",
+				"Via: SIP/2.0/TLS pc33.far-far-away.com;branch=z9hG4bK776asdhds",
+				"To: \"Bob\" <sips:bob@localhost>",
+				"From: \"Alice\" <sips:alice@wonderland.com>;tag=1928301774",
+				"Content-Length: 0",
+				"",
+				"",
+			})
+			Expect(protocol.Send(transport.NewTarget("localhost", port2), msg)).To(Succeed())
+			Expect(<-serverName).To(Equal("localhost"))
+			Expect(<-received).To(Equal(msg.String()))
+			close(done)
+		}, 3)
+	})
+})
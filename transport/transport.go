@@ -21,12 +21,16 @@ const (
 	DefaultUdpPort sip.Port = 5060
 	DefaultTcpPort sip.Port = 5060
 	DefaultTlsPort sip.Port = 5061
+	DefaultWsPort  sip.Port = 80
+	DefaultWssPort sip.Port = 443
 )
 
 // Target endpoint
 type Target struct {
 	Host string
 	Port *sip.Port
+	// Transport is an optional network to reach the target, e.g. resolved by the Resolver.
+	Transport string
 }
 
 func (trg *Target) Addr() string {
@@ -45,6 +49,11 @@ func (trg *Target) Addr() string {
 		port = *trg.Port
 	}
 
+	if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+		// IPv6 address
+		return fmt.Sprintf("[%v]:%v", host, port)
+	}
+
 	return fmt.Sprintf("%v:%v", host, port)
 }
 
@@ -52,6 +61,9 @@ func (trg *Target) String() string {
 	if trg == nil {
 		return "Target <nil>"
 	}
+	if trg.Transport != "" {
+		return fmt.Sprintf("Target %s %s", trg.Transport, trg.Addr())
+	}
 	return fmt.Sprintf("Target %s", trg.Addr())
 }
 
@@ -82,6 +94,10 @@ func DefaultPort(protocol string) sip.Port {
 		return DefaultTcpPort
 	case "udp":
 		return DefaultUdpPort
+	case "ws":
+		return DefaultWsPort
+	case "wss":
+		return DefaultWssPort
 	default:
 		return DefaultTcpPort
 	}
@@ -98,7 +98,8 @@ func (udp *udpProtocol) Send(target *Target, msg sip.Message) error {
 	conn, err := udp.connections.Get(ConnectionKey(msg.Source()))
 	if err != nil {
 		// todo change this bloody patch
-		if len(udp.connections.All()) == 0 {
+		conns := udp.connections.All()
+		if len(conns) == 0 {
 			return &ProtocolError{
 				fmt.Errorf("connection for send not found: %s", err),
 				"resolve connection",
@@ -106,10 +107,13 @@ func (udp *udpProtocol) Send(target *Target, msg sip.Message) error {
 			}
 		}
 
-		conn = udp.connections.All()[0]
+		conn = conns[0]
 	}
 
-	data := []byte(msg.String())
+	data, err := messageData(msg)
+	if err != nil {
+		return err
+	}
 	_, err = conn.WriteTo(data, raddr)
 
 	return err // should be nil
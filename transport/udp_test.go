@@ -238,6 +238,43 @@ var _ = Describe("UdpProtocol", func() {
 			}, 3)
 		})
 
+		Context("when client sends request with empty 'rport' param", func() {
+			var client net.Conn
+			BeforeEach(func() {
+				var err error
+				client, err = net.Dial(network, localTarget1.Addr())
+				Expect(err).ToNot(HaveOccurred())
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					time.Sleep(100 * time.Millisecond)
+					testutils.WriteToConn(client, []byte(
+						"OPTIONS sip:bob@far-far-away.com SIP/2.0\r\n"+
+							"Via: SIP/2.0/UDP 127.0.0.1:5060;branch=z9hG4bK776asdhds;rport\r\n"+
+							"CSeq: 1 OPTIONS\r\n"+
+							"Content-Length: 0\r\n"+
+							"\r\n"))
+				}()
+			})
+			AfterEach(func() {
+				client.Close()
+			})
+			It("should stamp 'received' and 'rport' with the actual source address", func(done Done) {
+				laddr := client.LocalAddr().(*net.UDPAddr)
+				testutils.AssertMessageArrived(
+					output,
+					fmt.Sprintf("OPTIONS sip:bob@far-far-away.com SIP/2.0\r\n"+
+						"Via: SIP/2.0/UDP 127.0.0.1:5060;branch=z9hG4bK776asdhds;rport=%d;received=%s\r\n"+
+						"CSeq: 1 OPTIONS\r\n"+
+						"Content-Length: 0\r\n"+
+						"\r\n", laddr.Port, laddr.IP),
+					laddr.String(),
+					"far-far-away.com:5060",
+				)
+				close(done)
+			}, 3)
+		})
+
 		Context("after cancel signal received", func() {
 			BeforeEach(func() {
 				time.Sleep(time.Millisecond)
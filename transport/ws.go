@@ -0,0 +1,366 @@
+package transport
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/masterclock/gosip/log"
+	"github.com/masterclock/gosip/sip"
+)
+
+// WebSocket protocol implementation (RFC 7118), serves both WS and WSS.
+type wsProtocol struct {
+	protocol
+	config      *tls.Config
+	listeners   ListenerPool
+	connections ConnectionPool
+	conns       chan Connection
+	done        chan struct{}
+	msgs        chan sip.Message
+	output      chan<- sip.Message
+	cancel      <-chan struct{}
+	// aliases of the remote endpoints (Via sent-by, Contact) to connections
+	// that carried inbound requests from them
+	aliases map[string]ConnectionKey
+	mu      *sync.RWMutex
+}
+
+// NewWsProtocol creates WS protocol.
+func NewWsProtocol(output chan<- sip.Message, errs chan<- error, cancel <-chan struct{}) Protocol {
+	return newWsProtocol("ws", output, errs, cancel, nil)
+}
+
+// NewWssProtocol creates secure WS protocol that uses provided TLS config
+// for both incoming and outgoing connections.
+func NewWssProtocol(
+	output chan<- sip.Message,
+	errs chan<- error,
+	cancel <-chan struct{},
+	config *tls.Config,
+) Protocol {
+	return newWsProtocol("wss", output, errs, cancel, config)
+}
+
+func newWsProtocol(
+	network string,
+	output chan<- sip.Message,
+	errs chan<- error,
+	cancel <-chan struct{},
+	config *tls.Config,
+) Protocol {
+	ws := new(wsProtocol)
+	ws.network = network
+	ws.reliable = true
+	// each WebSocket message carries exactly one SIP message
+	ws.streamed = false
+	ws.config = config
+	ws.conns = make(chan Connection)
+	ws.done = make(chan struct{})
+	ws.msgs = make(chan sip.Message)
+	ws.output = output
+	ws.cancel = cancel
+	ws.aliases = make(map[string]ConnectionKey)
+	ws.mu = new(sync.RWMutex)
+	ws.logger = log.NewSafeLocalLogger()
+	// TODO: add separate errs chan to listen errors from pool for reconnection?
+	ws.listeners = NewListenerPool(ws.conns, errs, cancel)
+	ws.listeners.SetLog(ws.Log())
+	ws.connections = NewConnectionPool(ws.msgs, errs, cancel)
+	ws.connections.SetLog(ws.Log())
+	// pipe listener and connection pools
+	go ws.pipePools()
+	go ws.serveDone()
+	go ws.pipeMessages()
+
+	return ws
+}
+
+func (ws *wsProtocol) String() string {
+	return fmt.Sprintf("Ws%s", ws.protocol.String())
+}
+
+func (ws *wsProtocol) SetLog(logger log.Logger) {
+	ws.protocol.SetLog(logger)
+	ws.listeners.SetLog(ws.Log())
+	ws.connections.SetLog(ws.Log())
+}
+
+func (ws *wsProtocol) Done() <-chan struct{} {
+	return ws.done
+}
+
+func (ws *wsProtocol) secure() bool {
+	return ws.network == "wss"
+}
+
+// done when both listeners and connections are released
+func (ws *wsProtocol) serveDone() {
+	<-ws.listeners.Done()
+	<-ws.connections.Done()
+	close(ws.done)
+}
+
+// piping new connections to connection pool for serving
+func (ws *wsProtocol) pipePools() {
+	defer func() {
+		ws.Log().Infof("%s stops pipe pools", ws)
+		close(ws.conns)
+	}()
+	ws.Log().Infof("%s starts pipe pools", ws)
+
+	for {
+		select {
+		case <-ws.listeners.Done():
+			return
+		case conn := <-ws.conns:
+			if err := ws.connections.Put(ConnectionKey(conn.RemoteAddr().String()), conn, sockTTL); err != nil {
+				// TODO should it be passed up to UA?
+				ws.Log().Errorf("%s failed to put new %s to %s: %s", ws, conn, ws.connections, err)
+				continue
+			}
+		}
+	}
+}
+
+// piping incoming messages up, remembers remote endpoints of the incoming requests
+func (ws *wsProtocol) pipeMessages() {
+	defer ws.Log().Infof("%s stops pipe messages", ws)
+	ws.Log().Infof("%s starts pipe messages", ws)
+
+	for {
+		select {
+		case <-ws.cancel:
+			return
+		case msg := <-ws.msgs:
+			if req, ok := msg.(sip.Request); ok {
+				ws.rememberAliases(req)
+			}
+			select {
+			case <-ws.cancel:
+				return
+			case ws.output <- msg:
+			}
+		}
+	}
+}
+
+// RFC 7118 - 5. - browser clients can not accept new connections,
+// so requests to them must be sent over the connection they opened.
+// Remote endpoint is identified by an invalid domain name in Via and Contact.
+// Only such names are remembered, since Via and Contact are set by the peer:
+// real addresses and aliases of other connections are never taken over.
+func (ws *wsProtocol) rememberAliases(req sip.Request) {
+	key := ConnectionKey(req.Source())
+	if key == "" {
+		return
+	}
+
+	aliases := make([]string, 0, 2)
+	if viaHop, ok := req.ViaHop(); ok && isInvalidHost(viaHop.Host) {
+		aliases = append(aliases, ws.aliasAddr(viaHop.Host, viaHop.Port))
+	}
+	if contact, ok := req.Contact(); ok {
+		if uri, ok := contact.Address.(*sip.SipUri); ok && isInvalidHost(uri.Host) {
+			aliases = append(aliases, ws.aliasAddr(uri.Host, uri.Port))
+		}
+	}
+
+	ws.mu.Lock()
+	for _, alias := range aliases {
+		if aliasKey, ok := ws.aliases[alias]; ok && aliasKey != key {
+			if _, err := ws.connections.Get(aliasKey); err == nil {
+				ws.Log().Warnf("%s ignores alias %s claimed by %s, it is used by %s", ws, alias, key, aliasKey)
+				continue
+			}
+		}
+		ws.aliases[alias] = key
+	}
+	ws.mu.Unlock()
+}
+
+// isInvalidHost checks for the reserved domain name (RFC 2606) used by clients
+// that can not accept connections.
+func isInvalidHost(host string) bool {
+	host = strings.ToLower(host)
+	return host == "invalid" || strings.HasSuffix(host, ".invalid")
+}
+
+// watchClose forgets aliases of the connection when it is closed.
+func (ws *wsProtocol) watchClose(wc *wsConn) {
+	key := ConnectionKey(wc.RemoteAddr().String())
+	wc.onClose = func() {
+		ws.forgetAliases(key)
+	}
+}
+
+func (ws *wsProtocol) forgetAliases(key ConnectionKey) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for alias, aliasKey := range ws.aliases {
+		if aliasKey == key {
+			delete(ws.aliases, alias)
+		}
+	}
+}
+
+func (ws *wsProtocol) aliasAddr(host string, port *sip.Port) string {
+	target := FillTargetHostAndPort(ws.Network(), &Target{Host: strings.ToLower(host), Port: port})
+	return target.Addr()
+}
+
+func (ws *wsProtocol) Listen(target *Target) error {
+	target = FillTargetHostAndPort(ws.Network(), target)
+	// secure server side requires at least one certificate
+	if ws.secure() && (ws.config == nil ||
+		(len(ws.config.Certificates) == 0 && ws.config.GetCertificate == nil && ws.config.GetConfigForClient == nil)) {
+		return &ProtocolError{
+			fmt.Errorf("TLS config with server certificates is required"),
+			fmt.Sprintf("create %s listener", ws.Network()),
+			ws.String(),
+		}
+	}
+	// resolve local TCP endpoint
+	laddr, err := ws.resolveTarget(target)
+	if err != nil {
+		return err
+	}
+	// create listener
+	var listener net.Listener
+	listener, err = net.ListenTCP("tcp", laddr)
+	if err != nil {
+		return &ProtocolError{
+			fmt.Errorf("failed to listen address %s: %s", laddr, err),
+			fmt.Sprintf("create %s listener", ws.Network()),
+			ws.String(),
+		}
+	}
+	if ws.secure() {
+		listener = tls.NewListener(listener, ws.config)
+	}
+	ws.Log().Infof("%s begins listening on %s", ws, target)
+	// index listeners by local address
+	// should live infinitely
+	err = ws.listeners.Put(ListenerKey(laddr.String()), newWsListener(listener, ws.network, ws.watchClose))
+
+	return err // should be nil here
+}
+
+func (ws *wsProtocol) Send(target *Target, msg sip.Message) error {
+	target = FillTargetHostAndPort(ws.Network(), target)
+
+	ws.Log().Infof("%s sends message '%s' to %s", ws, msg.Short(), target.Addr())
+	ws.Log().Debugf("message:\n%s", msg)
+
+	// validate remote address
+	if target.Host == "" {
+		return &ProtocolError{
+			fmt.Errorf("invalid remote host resolved %s", target.Host),
+			"resolve destination address",
+			ws.String(),
+		}
+	}
+
+	// find or create connection
+	conn, err := ws.getOrCreateConnection(target)
+	if err != nil {
+		return err
+	}
+	// send message
+	data, err := messageData(msg)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(data)
+
+	return err
+}
+
+func (ws *wsProtocol) resolveTarget(target *Target) (*net.TCPAddr, error) {
+	addr := target.Addr()
+	// resolve remote address
+	raddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, &ProtocolError{
+			fmt.Errorf("failed to resolve address %s: %s", addr, err),
+			fmt.Sprintf("resolve %s address", addr),
+			ws.String(),
+		}
+	}
+
+	return raddr, nil
+}
+
+func (ws *wsProtocol) getOrCreateConnection(target *Target) (Connection, error) {
+	// connection to the remote address
+	if conn, err := ws.connections.Get(ConnectionKey(target.Addr())); err == nil {
+		return conn, nil
+	}
+	// invalid domain names are used by clients that can not accept connections,
+	// so only the connection that carried requests from the remote endpoint is used
+	if isInvalidHost(target.Host) {
+		alias := ws.aliasAddr(target.Host, target.Port)
+		ws.mu.RLock()
+		key, ok := ws.aliases[alias]
+		ws.mu.RUnlock()
+		if ok {
+			if conn, err := ws.connections.Get(key); err == nil {
+				return conn, nil
+			}
+			ws.mu.Lock()
+			if ws.aliases[alias] == key {
+				delete(ws.aliases, alias)
+			}
+			ws.mu.Unlock()
+		}
+
+		return nil, &ProtocolError{
+			fmt.Errorf("connection to %s not found", target.Addr()),
+			fmt.Sprintf("get %s connection", ws.Network()),
+			ws.String(),
+		}
+	}
+
+	raddr, err := ws.resolveTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	if conn, err := ws.connections.Get(ConnectionKey(raddr.String())); err == nil {
+		return conn, nil
+	}
+
+	ws.Log().Debugf("connection for address %s not found; create a new one", raddr)
+
+	tcpConn, err := net.DialTCP("tcp", nil, raddr)
+	if err != nil {
+		return nil, &ProtocolError{
+			fmt.Errorf("failed to create connection to remote address %s: %s", raddr, err),
+			fmt.Sprintf("create %s connection", ws.Network()),
+			ws.String(),
+		}
+	}
+
+	var baseConn net.Conn = tcpConn
+	if ws.secure() {
+		baseConn = newTlsClientConn(tcpConn, ws.config, target.Host)
+	}
+	wc := newWsClientConn(baseConn, ws.network, target.Addr())
+	ws.watchClose(wc)
+	if err := wc.Handshake(); err != nil {
+		tcpConn.Close()
+		return nil, &ProtocolError{
+			fmt.Errorf("failed WebSocket handshake with remote address %s: %s", raddr, err),
+			fmt.Sprintf("create %s connection", ws.Network()),
+			ws.String(),
+		}
+	}
+
+	conn := NewConnection(wc)
+	conn.SetLog(ws.Log())
+	ws.connections.Put(ConnectionKey(conn.RemoteAddr().String()), conn, sockTTL)
+
+	return conn, nil
+}
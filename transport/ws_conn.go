@@ -0,0 +1,485 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocket constants (RFC 6455, RFC 7118)
+const (
+	wsGUID        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsVersion     = "13"
+	wsSubprotocol = "sip"
+
+	wsOpContinuation byte = 0x0
+	wsOpText         byte = 0x1
+	wsOpBinary       byte = 0x2
+	wsOpClose        byte = 0x8
+	wsOpPing         byte = 0x9
+	wsOpPong         byte = 0xa
+
+	wsFinBit  byte = 0x80
+	wsRsvBits byte = 0x70
+	wsMaskBit byte = 0x80
+	// RFC 6455 - 5.5. - control frames carry at most 125 bytes
+	wsMaxControlPayload = 125
+	// RFC 6455 - 7.4.1. - close status codes
+	wsCloseNormal        uint16 = 1000
+	wsCloseProtocolError uint16 = 1002
+)
+
+var wsHandshakeTimeout = 10 * time.Second
+
+// wsConn is a WebSocket connection that carries SIP messages (RFC 7118).
+// Each WebSocket message holds exactly one SIP message, so wsConn implements
+// net.PacketConn interface and served as non-streamed connection.
+// Opening handshake is performed on the first Read or Write (like tls.Conn).
+type wsConn struct {
+	net.Conn
+	network string
+	// client side connection masks outgoing frames and initiates handshake
+	client bool
+	host   string
+	reader *bufio.Reader
+
+	hmu    sync.Mutex
+	hdone  bool
+	herr   error
+	wmu    sync.Mutex
+	closed bool
+	// onClose is called once when the connection is closed
+	onClose   func()
+	closeOnce sync.Once
+}
+
+func newWsServerConn(conn net.Conn, network string) *wsConn {
+	return &wsConn{
+		Conn:    conn,
+		network: network,
+		reader:  bufio.NewReader(conn),
+	}
+}
+
+func newWsClientConn(conn net.Conn, network string, host string) *wsConn {
+	return &wsConn{
+		Conn:    conn,
+		network: network,
+		client:  true,
+		host:    host,
+		reader:  bufio.NewReader(conn),
+	}
+}
+
+// Handshake runs WebSocket opening handshake if it has not yet been run.
+func (c *wsConn) Handshake() error {
+	c.hmu.Lock()
+	defer c.hmu.Unlock()
+
+	if c.hdone {
+		return c.herr
+	}
+	c.hdone = true
+
+	if err := c.Conn.SetDeadline(time.Now().Add(wsHandshakeTimeout)); err != nil {
+		c.herr = err
+		return c.herr
+	}
+	if c.client {
+		c.herr = c.clientHandshake()
+	} else {
+		c.herr = c.serverHandshake()
+	}
+	if err := c.Conn.SetDeadline(time.Time{}); err != nil && c.herr == nil {
+		c.herr = err
+	}
+
+	return c.herr
+}
+
+func (c *wsConn) serverHandshake() error {
+	req, err := http.ReadRequest(c.reader)
+	if err != nil {
+		return fmt.Errorf("failed to read WebSocket handshake request: %s", err)
+	}
+
+	reject := func(status int, reason string) error {
+		fmt.Fprintf(
+			c.Conn,
+			"HTTP/1.1 %d %s\r\nSec-WebSocket-Version: %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n",
+			status,
+			http.StatusText(status),
+			wsVersion,
+		)
+		return fmt.Errorf("WebSocket handshake rejected: %s", reason)
+	}
+
+	switch {
+	case req.Method != http.MethodGet:
+		return reject(http.StatusMethodNotAllowed, fmt.Sprintf("unexpected method %s", req.Method))
+	case !wsHeaderHasToken(req.Header, "Upgrade", "websocket"):
+		return reject(http.StatusBadRequest, "missing 'Upgrade: websocket' header")
+	case !wsHeaderHasToken(req.Header, "Connection", "upgrade"):
+		return reject(http.StatusBadRequest, "missing 'Connection: upgrade' header")
+	case req.Header.Get("Sec-WebSocket-Version") != wsVersion:
+		return reject(http.StatusUpgradeRequired, "unsupported WebSocket version")
+	case req.Header.Get("Sec-WebSocket-Key") == "":
+		return reject(http.StatusBadRequest, "missing 'Sec-WebSocket-Key' header")
+	// RFC 7118 - 4.1. - client must negotiate 'sip' subprotocol
+	case !wsHeaderHasToken(req.Header, "Sec-WebSocket-Protocol", wsSubprotocol):
+		return reject(http.StatusBadRequest, "'sip' subprotocol is not requested")
+	}
+
+	_, err = fmt.Fprintf(
+		c.Conn,
+		"HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: websocket\r\n"+
+			"Connection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: %s\r\n"+
+			"Sec-WebSocket-Protocol: %s\r\n"+
+			"\r\n",
+		wsAcceptKey(req.Header.Get("Sec-WebSocket-Key")),
+		wsSubprotocol,
+	)
+
+	return err
+}
+
+func (c *wsConn) clientHandshake() error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	_, err := fmt.Fprintf(
+		c.Conn,
+		"GET / HTTP/1.1\r\n"+
+			"Host: %s\r\n"+
+			"Upgrade: websocket\r\n"+
+			"Connection: Upgrade\r\n"+
+			"Sec-WebSocket-Key: %s\r\n"+
+			"Sec-WebSocket-Version: %s\r\n"+
+			"Sec-WebSocket-Protocol: %s\r\n"+
+			"\r\n",
+		c.host,
+		key,
+		wsVersion,
+		wsSubprotocol,
+	)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.ReadResponse(c.reader, nil)
+	if err != nil {
+		return fmt.Errorf("failed to read WebSocket handshake response: %s", err)
+	}
+
+	switch {
+	case res.StatusCode != http.StatusSwitchingProtocols:
+		return fmt.Errorf("WebSocket handshake rejected with status %s", res.Status)
+	case res.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key):
+		return fmt.Errorf("WebSocket handshake failed: invalid 'Sec-WebSocket-Accept' header")
+	case !wsHeaderHasToken(res.Header, "Sec-WebSocket-Protocol", wsSubprotocol):
+		return fmt.Errorf("WebSocket handshake failed: 'sip' subprotocol is not accepted")
+	}
+
+	return nil
+}
+
+// Read reads exactly one WebSocket message.
+func (c *wsConn) Read(buf []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+
+	data, err := c.readMessage()
+	if err != nil {
+		return 0, err
+	}
+	if len(data) > len(buf) {
+		return 0, fmt.Errorf("WebSocket message of %d bytes exceeds read buffer", len(data))
+	}
+
+	return copy(buf, data), nil
+}
+
+func (c *wsConn) ReadFrom(buf []byte) (int, net.Addr, error) {
+	num, err := c.Read(buf)
+	return num, c.RemoteAddr(), err
+}
+
+// Write sends buf as a single WebSocket message. Text frames must carry valid UTF-8,
+// so messages with binary bodies are sent in binary frames (RFC 7118 - 5.).
+func (c *wsConn) Write(buf []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+
+	opcode := wsOpText
+	if !utf8.Valid(buf) {
+		opcode = wsOpBinary
+	}
+	if err := c.writeFrame(opcode, buf); err != nil {
+		return 0, err
+	}
+
+	return len(buf), nil
+}
+
+func (c *wsConn) WriteTo(buf []byte, addr net.Addr) (int, error) {
+	return c.Write(buf)
+}
+
+func (c *wsConn) Close() error {
+	c.hmu.Lock()
+	established := c.hdone && c.herr == nil
+	c.hmu.Unlock()
+
+	if established {
+		// best effort closing handshake
+		c.writeClose(wsCloseNormal)
+	}
+	if c.onClose != nil {
+		c.closeOnce.Do(c.onClose)
+	}
+
+	return c.Conn.Close()
+}
+
+func (c *wsConn) writeClose(code uint16) error {
+	var payload [2]byte
+	binary.BigEndian.PutUint16(payload[:], code)
+	return c.writeFrame(wsOpClose, payload[:])
+}
+
+// failProtocol starts closing handshake with protocol error status, the connection
+// must not be used after invalid frame (RFC 6455 - 7.1.7.).
+func (c *wsConn) failProtocol(reason string) error {
+	c.writeClose(wsCloseProtocolError)
+	return fmt.Errorf("WebSocket protocol error: %s", reason)
+}
+
+// readMessage reads data frames until the final one, answering control frames on the way.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, opcode, payload, err := c.readFrame(!started)
+		if err != nil {
+			return nil, err
+		}
+		if opcode&0x8 != 0 && (!fin || len(payload) > wsMaxControlPayload) {
+			// RFC 6455 - 5.5. - control frames must not be fragmented
+			return nil, c.failProtocol(fmt.Sprintf("invalid control frame %#x", opcode))
+		}
+
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			c.writeFrame(wsOpClose, payload)
+			return nil, io.EOF
+		case wsOpText, wsOpBinary:
+			if started {
+				return nil, c.failProtocol("unexpected data frame inside fragmented message")
+			}
+			started = true
+			msg = payload
+		case wsOpContinuation:
+			if !started {
+				return nil, c.failProtocol("unexpected continuation frame")
+			}
+			msg = append(msg, payload...)
+		default:
+			return nil, c.failProtocol(fmt.Sprintf("unknown opcode %#x", opcode))
+		}
+
+		if len(msg) > int(bufferSize) {
+			return nil, fmt.Errorf("WebSocket message exceeds %d bytes", bufferSize)
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// readFrame reads single WebSocket frame.
+// Errors in the middle of the frame break the stream, so only errors that occurred
+// before the first byte of the first message frame are returned as is
+// (read timeouts are recoverable).
+func (c *wsConn) readFrame(first bool) (fin bool, opcode byte, payload []byte, err error) {
+	broken := func(err error) error {
+		return fmt.Errorf("broken WebSocket frame: %s", err)
+	}
+
+	var head [2]byte
+	if num, err := io.ReadFull(c.reader, head[:]); err != nil {
+		if first && num == 0 {
+			return false, 0, nil, err
+		}
+		return false, 0, nil, broken(err)
+	}
+
+	fin = head[0]&wsFinBit != 0
+	opcode = head[0] & 0x0f
+	masked := head[1]&wsMaskBit != 0
+	if head[0]&wsRsvBits != 0 {
+		// no extensions are negotiated
+		return false, 0, nil, c.failProtocol("reserved bits are set")
+	}
+	// RFC 6455 - 5.1. - client frames are masked, server frames are not
+	if masked == c.client {
+		if c.client {
+			return false, 0, nil, c.failProtocol("masked server frame")
+		}
+		return false, 0, nil, c.failProtocol("unmasked client frame")
+	}
+
+	length := uint64(head[1] &^ wsMaskBit)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, broken(err)
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, broken(err)
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > uint64(bufferSize) {
+		return false, 0, nil, fmt.Errorf("WebSocket frame of %d bytes is too large", length)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return false, 0, nil, broken(err)
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, broken(err)
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return fin, opcode, payload, nil
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	if opcode == wsOpClose {
+		c.closed = true
+	}
+
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, wsFinBit|opcode)
+
+	var maskBit byte
+	if c.client {
+		maskBit = wsMaskBit
+	}
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xffff:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+
+	if c.client {
+		// RFC 6455 - 5.3. - client must mask all frames
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	_, err := c.Conn.Write(frame)
+
+	return err
+}
+
+// wsListener accepts WebSocket connections.
+// Opening handshake is performed lazily by the connection itself,
+// so slow clients do not block accepting of other connections.
+type wsListener struct {
+	net.Listener
+	network string
+	// accepted is called for each accepted connection
+	accepted func(conn *wsConn)
+}
+
+func newWsListener(listener net.Listener, network string, accepted func(conn *wsConn)) net.Listener {
+	return &wsListener{listener, network, accepted}
+}
+
+func (ls *wsListener) Accept() (net.Conn, error) {
+	conn, err := ls.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	wc := newWsServerConn(conn, ls.network)
+	if ls.accepted != nil {
+		ls.accepted(wc)
+	}
+
+	return wc, nil
+}
+
+func wsAcceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func wsHeaderHasToken(header http.Header, name string, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
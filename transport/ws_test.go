@@ -0,0 +1,324 @@
+package transport_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/masterclock/gosip/sip"
+	"github.com/masterclock/gosip/testutils"
+	"github.com/masterclock/gosip/timing"
+	"github.com/masterclock/gosip/transport"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// wsDial opens raw WebSocket client connection, returns connection and handshake response.
+func wsDial(addr string, subprotocol string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", addr)
+	Expect(err).ToNot(HaveOccurred())
+	req := "GET / HTTP/1.1\r\n" +
+		"Host: " + addr + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n"
+	if subprotocol != "" {
+		req += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	_, err = conn.Write([]byte(req + "\r\n"))
+	Expect(err).ToNot(HaveOccurred())
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	Expect(err).ToNot(HaveOccurred())
+
+	return conn, reader, res
+}
+
+// wsWriteFrame writes masked client text frame.
+func wsWriteFrame(conn net.Conn, payload []byte) {
+	wsWriteRawFrame(conn, 0x81, true, payload)
+}
+
+// wsWriteRawFrame writes client frame with the first byte (FIN, RSV, opcode) as is.
+func wsWriteRawFrame(conn net.Conn, head byte, masked bool, payload []byte) {
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	frame := []byte{head}
+	if len(payload) < 126 {
+		frame = append(frame, maskBit|byte(len(payload)))
+	} else {
+		frame = append(frame, maskBit|126, byte(len(payload)>>8), byte(len(payload)))
+	}
+	if masked {
+		mask := []byte{0x1, 0x2, 0x3, 0x4}
+		frame = append(frame, mask...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := conn.Write(frame)
+	Expect(err).ToNot(HaveOccurred())
+}
+
+// wsReadFrame reads unmasked server frame.
+func wsReadFrame(reader *bufio.Reader) (byte, []byte) {
+	head := make([]byte, 2)
+	_, err := io.ReadFull(reader, head)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(head[1] & 0x80).To(BeZero())
+	length := int(head[1] & 0x7f)
+	if length == 126 {
+		ext := make([]byte, 2)
+		_, err = io.ReadFull(reader, ext)
+		Expect(err).ToNot(HaveOccurred())
+		length = int(binary.BigEndian.Uint16(ext))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(reader, payload)
+	Expect(err).ToNot(HaveOccurred())
+
+	return head[0], payload
+}
+
+var _ = Describe("WsProtocol", func() {
+	var (
+		output   chan sip.Message
+		errs     chan error
+		cancel   chan struct{}
+		protocol transport.Protocol
+		client   net.Conn
+	)
+
+	port1 := 9080
+	port2 := port1 + 1
+	localTarget1 := transport.NewTarget(transport.DefaultHost, port1)
+	msg1 := "REGISTER sip:wonderland.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/WS df7jal23ls0d.invalid;branch=z9hG4bK776asdhds\r\n" +
+		"To: \"Alice\" <sip:alice@wonderland.com>\r\n" +
+		"From: \"Alice\" <sip:alice@wonderland.com>;tag=1928301774\r\n" +
+		"Contact: <sip:alice@df7jal23ls0d.invalid;transport=ws>\r\n" +
+		"Call-ID: a84b4c76e66710\r\n" +
+		"CSeq: 1 REGISTER\r\n" +
+		"\r\n"
+
+	timing.MockMode = true
+
+	BeforeEach(func() {
+		output = make(chan sip.Message)
+		errs = make(chan error)
+		cancel = make(chan struct{})
+		protocol = transport.NewWsProtocol(output, errs, cancel)
+	})
+	AfterEach(func(done Done) {
+		select {
+		case <-cancel:
+		default:
+			close(cancel)
+		}
+		<-protocol.Done()
+		if client != nil {
+			client.Close()
+			client = nil
+		}
+		close(output)
+		close(errs)
+		close(done)
+	}, 3)
+
+	Context("just initialized", func() {
+		It("should has Network = WS", func() {
+			Expect(protocol.Network()).To(Equal("WS"))
+		})
+		It("should be reliable", func() {
+			Expect(protocol.Reliable()).To(BeTrue())
+		})
+		It("should not be streamed", func() {
+			Expect(protocol.Streamed()).To(BeFalse())
+		})
+	})
+
+	Context(fmt.Sprintf("listens target %s", localTarget1), func() {
+		BeforeEach(func() {
+			Expect(protocol.Listen(localTarget1)).To(Succeed())
+			time.Sleep(time.Millisecond)
+		})
+
+		It("should reject handshake without 'sip' subprotocol", func(done Done) {
+			var res *http.Response
+			client, _, res = wsDial(localTarget1.Addr(), "chat")
+			Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+			close(done)
+		}, 3)
+
+		Context("when client sends register request", func() {
+			var reader *bufio.Reader
+
+			BeforeEach(func() {
+				var res *http.Response
+				client, reader, res = wsDial(localTarget1.Addr(), "sip")
+				Expect(res.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+				Expect(res.Header.Get("Sec-WebSocket-Accept")).To(Equal("s3pPLMBiTxaQ9kYGzzhZRbK+xOo="))
+				Expect(res.Header.Get("Sec-WebSocket-Protocol")).To(Equal("sip"))
+				go wsWriteFrame(client, []byte(msg1))
+			})
+
+			It("should receive message, respond and send requests over the same connection", func(done Done) {
+				By("msg1 arrives as a single frame")
+				msg := <-output
+				req, ok := msg.(sip.Request)
+				Expect(ok).To(BeTrue())
+				Expect(req.Method()).To(Equal(sip.REGISTER))
+				Expect(req.Source()).To(Equal(client.LocalAddr().String()))
+
+				By("sends response 200 OK")
+				res := sip.NewResponseFromRequest(req, 200, "OK", "")
+				target, err := transport.NewTargetFromAddr(res.Destination())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(protocol.Send(target, res)).To(Succeed())
+				opcode, payload := wsReadFrame(reader)
+				Expect(opcode).To(Equal(byte(0x81)))
+				Expect(string(payload)).To(Equal(res.String()))
+
+				By("sends request to the client Contact")
+				options := testutils.Request([]string{
+					"OPTIONS sip:alice@df7jal23ls0d.invalid;transport=ws SIP/2.0",
+					"Via: SIP/2.0/WS wonderland.com;branch=z9hG4bK776asdhds",
+					"CSeq: 1 OPTIONS",
+					"",
+					"",
+				})
+				Expect(protocol.Send(&transport.Target{Host: "df7jal23ls0d.invalid"}, options)).To(Succeed())
+				_, payload = wsReadFrame(reader)
+				Expect(strings.HasPrefix(string(payload), "OPTIONS sip:alice@df7jal23ls0d.invalid")).To(BeTrue())
+				close(done)
+			}, 3)
+
+			It("should not let another client take over real address or alias in use", func(done Done) {
+				Expect((<-output).Source()).To(Equal(client.LocalAddr().String()))
+
+				other, otherReader, res := wsDial(localTarget1.Addr(), "sip")
+				defer other.Close()
+				Expect(res.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+				realAddr := transport.NewTarget(transport.DefaultHost, port2)
+				go wsWriteFrame(other, []byte(strings.NewReplacer(
+					"Via: SIP/2.0/WS df7jal23ls0d.invalid", "Via: SIP/2.0/WS "+realAddr.Addr(),
+					"CSeq: 1", "CSeq: 2",
+				).Replace(msg1)))
+				Expect((<-output).Source()).To(Equal(other.LocalAddr().String()))
+
+				options := testutils.Request([]string{
+					"OPTIONS sip:alice@df7jal23ls0d.invalid;transport=ws SIP/2.0",
+					"Via: SIP/2.0/WS wonderland.com;branch=z9hG4bK776asdhds",
+					"CSeq: 1 OPTIONS",
+					"",
+					"",
+				})
+				By("real address is not an alias")
+				Expect(protocol.Send(realAddr, options)).ToNot(Succeed())
+				By("alias stays with the first client")
+				Expect(protocol.Send(&transport.Target{Host: "df7jal23ls0d.invalid"}, options)).To(Succeed())
+				_, payload := wsReadFrame(reader)
+				Expect(strings.HasPrefix(string(payload), "OPTIONS sip:alice@df7jal23ls0d.invalid")).To(BeTrue())
+				other.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+				_, err := otherReader.ReadByte()
+				Expect(err).To(HaveOccurred())
+				close(done)
+			}, 3)
+		})
+
+		Context("when client sends frames", func() {
+			var reader *bufio.Reader
+
+			BeforeEach(func() {
+				var res *http.Response
+				client, reader, res = wsDial(localTarget1.Addr(), "sip")
+				Expect(res.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+			})
+
+			expectProtocolError := func() {
+				opcode, payload := wsReadFrame(reader)
+				Expect(opcode).To(Equal(byte(0x88)))
+				// 1002 - protocol error
+				Expect(payload).To(Equal([]byte{0x03, 0xea}))
+				Expect(<-errs).To(HaveOccurred())
+			}
+
+			It("should receive message in binary frame and answer binary body in binary frame", func(done Done) {
+				go wsWriteRawFrame(client, 0x82, true, []byte(msg1))
+				req, ok := (<-output).(sip.Request)
+				Expect(ok).To(BeTrue())
+				Expect(req.Method()).To(Equal(sip.REGISTER))
+
+				res := sip.NewResponseFromRequest(req, 200, "OK", "")
+				res.SetBodyBytes([]byte{0xff, 0xfe, 0x00}, true)
+				target, err := transport.NewTargetFromAddr(res.Destination())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(protocol.Send(target, res)).To(Succeed())
+				opcode, payload := wsReadFrame(reader)
+				Expect(opcode).To(Equal(byte(0x82)))
+				Expect(payload).To(Equal([]byte(res.String())))
+				close(done)
+			}, 3)
+
+			It("should reject unmasked client frame", func(done Done) {
+				go wsWriteRawFrame(client, 0x81, false, []byte(msg1))
+				expectProtocolError()
+				close(done)
+			}, 3)
+
+			It("should reject fragmented control frame", func(done Done) {
+				go wsWriteRawFrame(client, 0x09, true, []byte("ping"))
+				expectProtocolError()
+				close(done)
+			}, 3)
+
+			It("should reject control frame longer than 125 bytes", func(done Done) {
+				go wsWriteRawFrame(client, 0x89, true, make([]byte, 126))
+				expectProtocolError()
+				close(done)
+			}, 3)
+		})
+
+		Context("when another WS protocol sends request", func() {
+			var (
+				output2 chan sip.Message
+				cancel2 chan struct{}
+				client2 transport.Protocol
+			)
+
+			BeforeEach(func() {
+				output2 = make(chan sip.Message)
+				cancel2 = make(chan struct{})
+				client2 = transport.NewWsProtocol(output2, errs, cancel2)
+			})
+			AfterEach(func() {
+				close(cancel2)
+				<-client2.Done()
+			})
+
+			It("should dial, complete handshake and deliver the message", func(done Done) {
+				req := testutils.Request([]string{
+					fmt.Sprintf("OPTIONS sip:bob@%s;transport=ws SIP/2.0", localTarget1.Addr()),
+					fmt.Sprintf("Via: SIP/2.0/WS 127.0.0.1:%d;branch=z9hG4bK776asdhds", port2),
+					"CSeq: 1 OPTIONS",
+					"",
+					"",
+				})
+				Expect(client2.Send(localTarget1, req)).To(Succeed())
+				msg := <-output
+				Expect(msg.String()).To(ContainSubstring("OPTIONS sip:bob@"))
+				close(done)
+			}, 3)
+		})
+	})
+})